@@ -2,7 +2,8 @@ package storydevs
 
 import (
 	crypto "crypto/rand"
-	"errors"
+	"crypto/sha256"
+	"encoding/hex"
 
 	"github.com/eknkc/basex"
 )
@@ -62,6 +63,20 @@ func (sp SettingsPrivacy) GetName() string {
 	return "Privacy"
 }
 
+type SettingsNotifications struct {
+	ResourceBase
+	Reply   string
+	Mention string
+	Event   string
+}
+
+func (sn SettingsNotifications) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (sn SettingsNotifications) GetName() string {
+	return "Notifications"
+}
+
 type Settings struct {
 	ResourceBase
 	Field
@@ -101,22 +116,55 @@ func Base62(bytes int) (string, error) {
 	return enc.Encode(p), nil
 }
 
-var ErrInvalidLoginAttempt = errors.New("invalid login attempt")
+/*
+HashToken returns the hex encoded SHA-256 hash of an
+authentication token or confirmation code. Only hashes
+are stored so that a copy of the database can't be used
+to log in as anyone or confirm anything.
+*/
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
+// Algorithms that may be chosen for PasswordHash in Config.
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+/*
+Password hashes passwords with the algorithm chosen in Config.
+Compare accepts hashes made by any supported algorithm and reports
+whether hash should be replaced with a new one from Hash because
+it was made with another algorithm or other parameters.
+*/
 type Password interface {
 	Hash(pass string) (hash string, err error)
-	Compare(pass, hash string) (ok bool, err error)
+	Compare(pass, hash string) (ok, rehash bool, err error)
 	SetCost(cost int)
 }
 
+/*
+AuthedUser is the result of logging in. If Challenge is set the
+password was correct but the account uses two-factor
+authentication and Token is empty until a code is given.
+*/
 type AuthedUser struct {
-	Token   string
-	Account *Account
+	Token     string
+	Challenge string
+	Account   *Account
 }
 
 type Account struct {
 	Id int64
 
+	// Id of the login the account was retrieved by, if any.
+	SessionId int64
+
+	// Whether logging in requires a TOTP code.
+	TwoFactor bool
+
 	Email string
 	Pass  string
 
@@ -139,8 +187,97 @@ type Privileges struct {
 	Tags       NullBool // can manage resource tags (i.e., merge, re-name, etc)
 	Categories NullBool // can add/remove resource categories (effectively "move")
 	Logs       NullBool // can view moderator action logs
-	GivePriv   NullBool // can give privileges to users
-	TakePriv   NullBool // can take away privileges from users
+	GivePriv   NullBool `db:"give_priv"` // can give privileges to users
+	TakePriv   NullBool `db:"take_priv"` // can take away privileges from users
+}
+
+/*
+Privilege names as they appear in URLs and
+as columns of the privileges table.
+*/
+const (
+	PrivApprove    = "approve"
+	PrivLock       = "lock"
+	PrivEdit       = "edit"
+	PrivHide       = "hide"
+	PrivDestroy    = "destroy"
+	PrivMute       = "mute"
+	PrivBan        = "ban"
+	PrivTags       = "tags"
+	PrivCategories = "categories"
+	PrivLogs       = "logs"
+	PrivGivePriv   = "give_priv"
+	PrivTakePriv   = "take_priv"
+)
+
+// PrivNames lists every privilege in display order.
+var PrivNames = []string{
+	PrivApprove,
+	PrivLock,
+	PrivEdit,
+	PrivHide,
+	PrivDestroy,
+	PrivMute,
+	PrivBan,
+	PrivTags,
+	PrivCategories,
+	PrivLogs,
+	PrivGivePriv,
+	PrivTakePriv,
+}
+
+func (p Privileges) field(priv string) *NullBool {
+	switch priv {
+	case PrivApprove:
+		return &p.Approve
+	case PrivLock:
+		return &p.Lock
+	case PrivEdit:
+		return &p.Edit
+	case PrivHide:
+		return &p.Hide
+	case PrivDestroy:
+		return &p.Destroy
+	case PrivMute:
+		return &p.Mute
+	case PrivBan:
+		return &p.Ban
+	case PrivTags:
+		return &p.Tags
+	case PrivCategories:
+		return &p.Categories
+	case PrivLogs:
+		return &p.Logs
+	case PrivGivePriv:
+		return &p.GivePriv
+	case PrivTakePriv:
+		return &p.TakePriv
+	}
+	return nil
+}
+
+/*
+Has reports whether p includes the named privilege.
+Unknown names are never held.
+*/
+func (p Privileges) Has(priv string) bool {
+	f := p.field(priv)
+	return f != nil && f.Bool
+}
+
+// Any reports whether p includes at least one privilege.
+func (p Privileges) Any() bool {
+	for _, priv := range PrivNames {
+		if p.Has(priv) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPriv reports whether priv names a privilege.
+func IsPriv(priv string) bool {
+	return Privileges{}.field(priv) != nil
 }
 
 type Persona struct {
@@ -158,10 +295,56 @@ type Persona struct {
 
 	Admin   NullBool
 	Deleted NullBool
+	Muted   NullBool
+	Banned  NullBool
 	Active  bool
 	Default bool `db:"default_p"`
 
-	// Privileges
+	// Number of unread notifications.
+	Unread int
+
+	// How each kind of notification is emailed.
+	EmailReply   string `db:"email_reply"`
+	EmailMention string `db:"email_mention"`
+	EmailEvent   string `db:"email_event"`
+
+	Privileges
+}
+
+/*
+Can reports whether p may exercise priv. Admins
+implicitly hold every privilege.
+*/
+func (p Persona) Can(priv string) bool {
+	return p.Admin.Bool || p.Has(priv)
+}
+
+/*
+Staff reports whether p is an admin or holds any
+privilege, i.e., whether p may enter the admin mode.
+*/
+func (p Persona) Staff() bool {
+	return p.Admin.Bool || p.Any()
+}
+
+/*
+Delivery is how p has chosen to receive notifications of the
+given kind by email.
+*/
+func (p Persona) Delivery(kind string) string {
+	var d string
+	switch kind {
+	case NotifyReply:
+		d = p.EmailReply
+	case NotifyMention:
+		d = p.EmailMention
+	case NotifyEvent:
+		d = p.EmailEvent
+	}
+	if d == "" {
+		return DeliverOff
+	}
+	return d
 }
 
 func (a Account) Handles() (handles []string) {
@@ -171,6 +354,14 @@ func (a Account) Handles() (handles []string) {
 	return handles
 }
 
+/*
+Unread is the number of unread notifications of the active
+persona, shown in the sidebar.
+*/
+func (a Account) Unread() int {
+	return a.ActivePersona().Unread
+}
+
 func (a Account) ActivePersona() Persona {
 	if len(a.Personas) == 0 {
 		return Persona{}
@@ -190,6 +381,7 @@ func (a Account) ActivePersona() Persona {
 const (
 	InviteRequired = iota
 	InviteOptional
+	InviteOff
 )
 const (
 	ConfirmManual = iota
@@ -215,9 +407,13 @@ type Accounts interface {
 
 	ForgotPassword(reqId string, forgot *ForgotPassword) (Feedback, error)
 
-	ChangePassword(reqId string, c *ChangePassword, accId int64) (Feedback, error)
+	ChangePassword(reqId string, c *ChangePassword, accId, sessionId int64) (Feedback, error)
 	ConfirmPassword(reqId, code string) (bool, error)
 
+	BeginTwoFactor(reqId string, accId int64, pass string) (secret, uri string, fb Feedback, err error)
+	EnableTwoFactor(reqId string, accId int64, code string) (recovery []string, fb Feedback, err error)
+	DisableTwoFactor(reqId string, accId int64, pass, code string) (Feedback, error)
+
 	NewPersona(reqId string, accId int64, np *NewPersona) (Feedback, error)
 	DeletePersona(reqId, token, slug string, accId int64) (string, error)
 
@@ -231,6 +427,7 @@ type Accounts interface {
 	RetrieveByHandle(reqId, handle string, o AccOptRetrieve) (*Account, error)
 
 	Switch(reqId, token, slug string) (err error)
-	Login(reqId, identity, pass string) (auth *AuthedUser, err error)
+	Login(reqId, identity, ip, userAgent, pass string) (auth *AuthedUser, fb Feedback, err error)
+	LoginTwoFactor(reqId, challenge, ip, userAgent, code string) (auth *AuthedUser, fb Feedback, err error)
 	Logout(reqId, token string) (ok bool, err error)
 }
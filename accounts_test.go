@@ -0,0 +1,76 @@
+package storydevs
+
+import "testing"
+
+func TestPrivileges(t *testing.T) {
+
+	yes := NullBool{Bool: true}
+	mod := Privileges{Hide: yes, GivePriv: yes}
+
+	tests := []struct {
+		name  string
+		p     Persona
+		priv  string
+		can   bool
+		staff bool
+	}{
+		{"nobody", Persona{}, PrivHide, false, false},
+		{"held", Persona{Privileges: mod}, PrivHide, true, true},
+		{"not held", Persona{Privileges: mod}, PrivBan, false, true},
+		{"column name", Persona{Privileges: mod}, PrivGivePriv, true, true},
+		{"unknown", Persona{Privileges: mod}, "Hide", false, true},
+		{"admin", Persona{Admin: yes}, PrivBan, true, true},
+		{"admin, unknown", Persona{Admin: yes}, "nope", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Can(tt.priv); got != tt.can {
+				t.Errorf("Can(%q) = %v, want %v", tt.priv, got, tt.can)
+			}
+			if got := tt.p.Staff(); got != tt.staff {
+				t.Errorf("Staff() = %v, want %v", got, tt.staff)
+			}
+			pp := PersonaPrivileges{Privileges: tt.p.Privileges}
+			pp.Admin = tt.p.Admin
+			if got := pp.Holds(tt.priv); got != tt.can {
+				t.Errorf("Holds(%q) = %v, want %v", tt.priv, got, tt.can)
+			}
+		})
+	}
+}
+
+func TestIsPriv(t *testing.T) {
+	for _, priv := range PrivNames {
+		if !IsPriv(priv) {
+			t.Errorf("IsPriv(%q) = false", priv)
+		}
+		if (Privileges{}).Has(priv) {
+			t.Errorf("the zero value has %q", priv)
+		}
+	}
+	for _, priv := range []string{"", "admin", "GivePriv", "give-priv"} {
+		if IsPriv(priv) {
+			t.Errorf("IsPriv(%q) = true", priv)
+		}
+	}
+}
+
+func TestPrivilegeList(t *testing.T) {
+	pp := PersonaPrivileges{Privileges: Privileges{Logs: NullBool{Bool: true}}}
+	ii := pp.List()
+	if len(ii) != len(PrivNames) {
+		t.Fatalf("got %d privileges, want %d", len(ii), len(PrivNames))
+	}
+	for i, item := range ii {
+		if item.Name != PrivNames[i] {
+			t.Errorf("privilege %d is %q, want %q", i, item.Name, PrivNames[i])
+		}
+		if item.Desc == "" {
+			t.Errorf("%q has no description", item.Name)
+		}
+		if item.Held != (item.Name == PrivLogs) {
+			t.Errorf("%q held is %v", item.Name, item.Held)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package storydevs
+
+/*
+Calendars keeps the secret tokens of private calendar feeds. Each
+persona has at most one token, which lists all of its events in
+the feed at /api/calendar/:token regardless of their visibility.
+*/
+type Calendars interface {
+	NewFeed(reqId string, persId int64) (token string, err error)
+	RevokeFeed(reqId string, persId int64) (ok bool, err error)
+	FeedPersona(reqId, token string) (persId int64, err error)
+}
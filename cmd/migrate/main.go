@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jakebowkett/storydevs/postgres/migrate"
+	"github.com/jakebowkett/storydevs/setup"
+)
+
+const usage = `Usage: migrate [flags] <command> [n]
+
+Paths in the config are relative to the working directory
+so this should be run from the server's directory, e.g.
+
+	cd cmd/server && go run ../migrate status
+
+Commands:
+	status    List migrations and whether they've been applied.
+	up [n]    Apply pending migrations up to and including version n.
+	          Applies all pending migrations if n is omitted.
+	down [n]  Revert the n most recently applied migrations.
+	          Reverts one migration if n is omitted.
+
+Flags:
+`
+
+func main() {
+
+	path := flag.String("config", "./config.default.toml", "path to the default config")
+	dryRun := flag.Bool("dry-run", false, "print migrations instead of running them")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 || len(args) > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	n := 0
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			fail(fmt.Errorf("expected a positive integer, got %q", args[1]))
+		}
+	}
+
+	m, handles := setup.Migrator(*path)
+	defer handles.Close()
+	m.DryRun = *dryRun
+	m.Out = os.Stdout
+
+	switch args[0] {
+	case "status":
+		status(m)
+	case "up":
+		done, err := m.Up(n)
+		report("Applied", done, m.DryRun)
+		if err != nil {
+			fail(err)
+		}
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		done, err := m.Down(n)
+		report("Reverted", done, m.DryRun)
+		if err != nil {
+			fail(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func status(m *migrate.Migrator) {
+	ss, err := m.Status()
+	if err != nil {
+		fail(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range ss {
+		applied := "pending"
+		if s.Applied != 0 {
+			applied = time.Unix(s.Applied, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+}
+
+func report(verb string, done []int, dryRun bool) {
+	if dryRun {
+		verb = "Would have " + strings.ToLower(verb)
+	}
+	if len(done) == 0 {
+		fmt.Println("Nothing to do.")
+		return
+	}
+	for _, v := range done {
+		fmt.Printf("%s %04d.\n", verb, v)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -2,6 +2,8 @@ package storydevs
 
 import (
 	"errors"
+	"math"
+	"math/rand"
 	"time"
 )
 
@@ -48,6 +50,9 @@ type Config struct {
 
 	MaxViewRequest int64
 
+	// Number of results per page in the browse column.
+	BrowseLimit int
+
 	//
 	ClampImageKiB int
 	ThumbMaxAxis  int
@@ -62,6 +67,37 @@ type Config struct {
 	// Mode specifc config.
 	Thread ThreadConfig
 
+	Report ReportConfig
+
+	Login LoginConfig
+
+	Session SessionConfig
+
+	TwoFactor TwoFactorConfig
+
+	Invite InviteConfig
+
+	Calendar CalendarConfig
+
+	Event EventConfig
+
+	// Identity providers that may be logged in with, by name.
+	OIDC map[string]OIDCConfig
+
+	// Rate limits of route groups, see handler/limit.
+	RateLimit map[string]RateLimitConfig
+
+	// Headers of every response, see handler/security.
+	Security SecurityConfig
+
+	Digest DigestConfig
+
+	Outbox OutboxConfig
+
+	Jobs JobsConfig
+
+	Reminder ReminderConfig
+
 	SlugLen int
 
 	URIReserved string
@@ -84,6 +120,8 @@ type Config struct {
 	DirSVG       string
 	DirIcons     string
 	DirTemplates string
+	DirEmail     string
+	DirMaildir   string
 	DirMode      string
 	DirModal     string
 	DirPage      string
@@ -91,29 +129,43 @@ type Config struct {
 	DirShared    string
 	DirReplace   string
 
+	DirMigrations string
+
 	PathConfigLocal     string
 	PathCredentials     string
 	PathRobots          string
 	PathFavIcon         string
 	PathHyphenate       string
 	PathHyphenateCustom string
-	PathDbTypes         string
-	PathDbTables        string
 	PathTimezone        string
 
-	BcryptCost int
+	PasswordHash string
+	BcryptCost   int
+	Argon2       Argon2Config
 
 	Port string
 
 	Retry map[string]RetryConfig
 
 	EmailEnabled bool
+	EmailBackend string
 	EmailTimeout int
 	EmailOnError []string
 
 	Credentials Credentials
 }
 
+/*
+SiteURL is the origin the site is served from, for links that
+leave it such as those in emails and calendars.
+*/
+func (c *Config) SiteURL() string {
+	if c.Dev {
+		return "http://localhost:" + c.Port
+	}
+	return "https://storydevs.com"
+}
+
 type Credentials struct {
 	InitHandle string
 	InitEmail  string
@@ -125,6 +177,12 @@ type Credentials struct {
 	EmailFrom  string
 	EmailName  string
 	DbConn     string
+
+	// Key for signing links in emails, such as to unsubscribe.
+	SigningKey string
+
+	// Client secrets of the providers in Config.OIDC, by name.
+	OIDCSecrets map[string]string
 }
 
 type RetryConfig struct {
@@ -138,6 +196,286 @@ type RetryConfig struct {
 	MaxWait     int
 }
 
+/*
+Backoff is how long to wait after the given number of failed
+attempts, following the same curve as a Tryer made from rc.
+It's used where the wait outlives the process, such as for
+emails in the outbox.
+*/
+func (rc RetryConfig) Backoff(failed int) time.Duration {
+	ms := float64(rc.Base) * math.Pow(rc.Exponent, float64(failed-1))
+	ms = math.Min(float64(rc.MaxInterval), ms)
+	ms *= 1 - rand.Float64()*rc.Jitter
+	return time.Duration(ms) * time.Millisecond
+}
+
+/*
+ReportConfig limits how many reports each account may file
+within a window of time.
+*/
+type ReportConfig struct {
+	Limit int
+
+	// In minutes.
+	Window int
+}
+
+/*
+LoginConfig limits failed log in attempts. Each account, or
+identity that doesn't match an account, and each IP address
+must wait Backoff seconds after its first failure, doubling
+after each further failure up to MaxBackoff. Once an account
+reaches Lockout failures, or an IP address IPLockout failures,
+it's locked out for LockoutFor minutes. Failures are forgotten
+Window minutes after the most recent one.
+*/
+type LoginConfig struct {
+
+	// In seconds.
+	Backoff    int
+	MaxBackoff int
+
+	Lockout   int
+	IPLockout int
+
+	// In minutes.
+	LockoutFor int
+	Window     int
+}
+
+/*
+Wait returns how long must pass after the last of failed
+attempts before another is allowed.
+*/
+func (lc LoginConfig) Wait(failed int) time.Duration {
+	if failed <= 0 || lc.Backoff <= 0 {
+		return 0
+	}
+	max := time.Second * time.Duration(lc.MaxBackoff)
+	d := time.Second * time.Duration(lc.Backoff)
+	for i := 1; i < failed; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	return d
+}
+
+/*
+SessionConfig is how long a login lasts. A login ends once it
+hasn't been used for Idle or once Absolute has passed since it
+began, whichever is sooner. Either is ignored when zero.
+*/
+type SessionConfig struct {
+	Idle     days
+	Absolute days
+}
+
+/*
+Cutoffs returns the times before which a login is expired by
+having begun or by having been last seen. A cutoff is zero if
+its lifetime is disabled.
+*/
+func (c SessionConfig) Cutoffs(now time.Time) (began, seen int64) {
+	if c.Absolute.Duration > 0 {
+		began = now.Add(-c.Absolute.Duration).Unix()
+	}
+	if c.Idle.Duration > 0 {
+		seen = now.Add(-c.Idle.Duration).Unix()
+	}
+	return began, seen
+}
+
+/*
+Argon2Config are the parameters of new Argon2id password hashes.
+Memory is in KiB and is allocated by every login attempt.
+*/
+type Argon2Config struct {
+	Time    int
+	Memory  int
+	Threads int
+}
+
+/*
+TwoFactorConfig configures TOTP two-factor authentication. Codes
+are accepted up to Skew steps either side of the server's clock.
+Accounts using it have Challenge minutes to enter a code after
+their password when logging in. Each account is given Recovery
+single-use codes for when they can't use their authenticator.
+*/
+type TwoFactorConfig struct {
+	Issuer   string
+	Skew     int
+	Recovery int
+
+	// In minutes.
+	Challenge int
+}
+
+// Values for the Mode of InviteConfig.
+const (
+	InviteModeRequired = "required"
+	InviteModeOptional = "optional"
+	InviteModeOff      = "off"
+)
+
+/*
+InviteConfig is whether registering needs an invite code. Each
+persona may have Limit invites active at once, which may be used
+Uses times each and expire after Days. Invites don't expire when
+Days is zero and personas may have any number when Limit is.
+*/
+type InviteConfig struct {
+	Mode  string
+	Uses  int
+	Days  int
+	Limit int
+}
+
+/*
+Gate is InviteRequired, InviteOptional, or InviteOff depending on
+Mode. It's -1 if Mode isn't one of those.
+*/
+func (ic InviteConfig) Gate() int {
+	switch ic.Mode {
+	case InviteModeRequired:
+		return InviteRequired
+	case InviteModeOptional:
+		return InviteOptional
+	case InviteModeOff:
+		return InviteOff
+	}
+	return -1
+}
+
+/*
+CalendarConfig limits the events in calendar feeds. Feeds include
+at most Limit events, none of which finished more than Past days
+ago unless the feed asks for them.
+*/
+type CalendarConfig struct {
+	Past  int
+	Limit int
+}
+
+/*
+EventConfig is how far ahead the occurrences of events that recur
+forever are worked out. They're kept at least half of Horizon
+ahead and checked for needing more every Interval.
+*/
+type EventConfig struct {
+
+	// In days.
+	Horizon int
+
+	// In hours.
+	Interval int
+}
+
+/*
+OIDCConfig is an OpenID Connect identity provider. Its endpoints
+are discovered from Issuer and we're registered with it as
+ClientId with a redirect URL of /oidc/<name>/callback. The scopes
+"openid", "email", and "profile" are requested if Scopes is empty.
+Title is the provider's name as shown to users.
+*/
+type OIDCConfig struct {
+	Title    string
+	Issuer   string
+	ClientId string
+	Scopes   []string
+}
+
+/*
+SecurityConfig holds the headers sent with every response. The
+directives of Policy make up the Content-Security-Policy, in
+which "{nonce}" is replaced by each response's nonce. The policy
+isn't enforced when ReportOnly. HSTS is the max-age in seconds
+of Strict-Transport-Security, which only covers subdomains when
+HSTSSubdomains. Headers that are empty or zero aren't sent.
+*/
+type SecurityConfig struct {
+	Policy         []string
+	ReportOnly     bool
+	HSTS           int
+	HSTSSubdomains bool
+	ReferrerPolicy string
+	FrameOptions   string
+}
+
+// Values for the Key of RateLimitConfig.
+const (
+	LimitIP      = "ip"
+	LimitAccount = "account"
+	LimitRoute   = "route"
+)
+
+/*
+RateLimitConfig is a token bucket limiting requests to a group
+of routes. Requests share a bucket by the client's IP address,
+by their account (or IP address if they're logged out), or by
+the group itself so that all clients share one. Each bucket
+holds Burst requests and regains PerMinute of them a minute.
+Only requests using one of Methods count or all of them if it's
+empty.
+*/
+type RateLimitConfig struct {
+	Key       string
+	Methods   []string
+	PerMinute int
+	Burst     int
+}
+
+/*
+DigestConfig is how often notifications are checked for being
+due to be emailed. A zero interval disables emailing them.
+*/
+type DigestConfig struct {
+
+	// In minutes.
+	Interval int
+}
+
+/*
+OutboxConfig is how often the outbox is checked for emails that
+are due and how many are attempted each time. How long failed
+emails wait and how many times they're attempted follows the
+"email" entry of Config.Retry.
+*/
+type OutboxConfig struct {
+
+	// In seconds.
+	Interval int
+
+	Batch int
+}
+
+/*
+JobsConfig is how often jobs are scheduled and checked for being
+due and how many are attempted each time. How long failed jobs
+wait and how many times they're attempted follows the "email"
+entry of Config.Retry.
+*/
+type JobsConfig struct {
+
+	// In seconds.
+	Interval int
+
+	Batch int
+}
+
+/*
+ReminderConfig is how long before each occurrence of an event
+the personas going to or interested in it are reminded of it.
+Reminders aren't sent if Before is empty.
+*/
+type ReminderConfig struct {
+
+	// In minutes.
+	Before []int
+}
+
 type ThreadConfig struct {
 	MinTitle         int
 	MaxTitle         int
@@ -0,0 +1,36 @@
+package storydevs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+
+	rc := RetryConfig{Exponent: 2, Base: 1000, MaxInterval: 60000}
+
+	tests := []struct {
+		failed int
+		want   time.Duration
+	}{
+		{1, time.Second},
+		{2, time.Second * 2},
+		{3, time.Second * 4},
+		{6, time.Second * 32},
+		{7, time.Minute},
+		{30, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := rc.Backoff(tt.failed); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.failed, got, tt.want)
+		}
+	}
+
+	rc.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := rc.Backoff(3)
+		if got <= time.Second*2 || got > time.Second*4 {
+			t.Fatalf("Backoff(3) with jitter = %s, want between 2s and 4s", got)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package storydevs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// CSRFHeader carries the CSRF token of requests that change state.
+	CSRFHeader = "X-CSRF-Token"
+
+	// CSRFCookie identifies logged out clients for their CSRF token.
+	CSRFCookie = "csrf"
+)
+
+/*
+CSRFToken returns the token a client must send with requests that
+change state. The session is the value of the client's token cookie
+when it's logged in or of its CSRF cookie otherwise, so the token
+changes when the client logs in or out.
+*/
+func CSRFToken(key, session string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("csrf/" + session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidCSRF reports whether token was made by CSRFToken for session.
+func ValidCSRF(key, session, token string) bool {
+	if session == "" {
+		return false
+	}
+	want := CSRFToken(key, session)
+	return hmac.Equal([]byte(token), []byte(want))
+}
@@ -301,6 +301,13 @@ func (ni *NullInt64) UnmarshalJSON(src []byte) error {
 		ni.Int64 = 0
 		return nil
 	}
+	/*
+		Numbers chosen from dropdowns or typed into text
+		fields are sent as strings.
+	*/
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
 	i, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
 		return fmt.Errorf("src cannot be unmarshaled to type int64 or nil: %w", err)
@@ -6,8 +6,6 @@ require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/davecgh/go-spew v1.1.1
 	github.com/eknkc/basex v1.0.0
-	github.com/jakebowkett/go-cache v0.0.0-20190622005149-43df8cc0a7a6
-	github.com/jakebowkett/go-emailer v0.0.0-20200131073431-95e1c55e8ad1
 	github.com/jakebowkett/go-gen/gen v0.0.0-20200318053407-84cc6689545e
 	github.com/jakebowkett/go-hyphenate v0.0.0-20200222031023-0f421bee6483
 	github.com/jakebowkett/go-jpegutil/jpegutil v0.0.0-20200301052927-37c3738b49ab
@@ -15,7 +13,6 @@ require (
 	github.com/jakebowkett/go-num v0.0.0-20200131075144-32de50b661e5
 	github.com/jakebowkett/go-pngutil/pngutil v0.0.0-20200301052842-1c4b45b6692c
 	github.com/jakebowkett/go-retry/retry v0.0.0-20200301053032-c971419b8bf5
-	github.com/jakebowkett/go-router/router v0.0.0-20200403090019-6515d559cf0f
 	github.com/jakebowkett/go-view v0.0.0-20200201085224-67eccece19d2
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/lib/pq v1.10.2-0.20210517034428-ad47bab1aa0f
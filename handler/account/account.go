@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler"
 )
 
 var errNoAccount = errors.New("no account attached to request")
@@ -37,6 +39,17 @@ func Add(dep *sd.Dependencies) sd.Handler {
 			return
 		}
 
+		/*
+			Banned accounts are treated as though they're
+			logged out. Personas share their account so a
+			ban on any of them applies to all.
+		*/
+		for _, p := range account.Personas {
+			if p.Banned.Bool {
+				return
+			}
+		}
+
 		r.User = *account
 	}
 }
@@ -61,6 +74,53 @@ func NotAdmin(r *sd.Request) bool {
 	return !p.Admin.Bool
 }
 
+/*
+NotStaff is a skip Guard for the admin route grouping. It
+returns true unless the active persona is an admin or holds
+at least one privilege.
+*/
+func NotStaff(r *sd.Request) bool {
+	account, ok := r.User.(sd.Account)
+	if !ok {
+		return true
+	}
+	return !account.ActivePersona().Staff()
+}
+
+/*
+Lacks returns an unauthorized Guard that is true unless the
+active persona holds at least one of privs. Admins hold
+every privilege.
+*/
+func Lacks(privs ...string) sd.Guard {
+	return func(r *sd.Request) bool {
+		account, ok := r.User.(sd.Account)
+		if !ok {
+			return true
+		}
+		p := account.ActivePersona()
+		for _, priv := range privs {
+			if p.Can(priv) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+/*
+IsMuted is an unauthorized Guard for routes that write
+resources. Muted personas may still browse and manage
+their account.
+*/
+func IsMuted(r *sd.Request) bool {
+	account, ok := r.User.(sd.Account)
+	if !ok {
+		return true
+	}
+	return account.ActivePersona().Muted.Bool
+}
+
 func Email(w http.ResponseWriter, r *sd.Request) {
 	account, ok := r.User.(sd.Account)
 	if !ok {
@@ -92,6 +152,131 @@ func Switch(dep *sd.Dependencies) sd.Handler {
 	}
 }
 
+/*
+ReadNotifications marks the notification named by the "resource"
+route variable as read. If there isn't one every notification of
+the active persona is marked.
+*/
+func ReadNotifications(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	ns := dep.Notifications
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		p := r.User.(sd.Account).ActivePersona()
+
+		var slugs []string
+		if slug, ok := r.Vars["resource"]; ok {
+			slugs = append(slugs, slug)
+		}
+
+		if err := ns.MarkRead(r.Id, p.Id, slugs...); err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+	}
+}
+
+/*
+RevokeSessions signs out the session named by the "resource"
+route variable. If there isn't one every session of the account
+is signed out. The token cookie is removed if the session making
+the request is among them.
+*/
+func RevokeSessions(dep *sd.Dependencies) sd.Handler {
+
+	c := dep.Config
+	log := dep.Logger
+	ss := dep.Sessions
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		acc := r.User.(sd.Account)
+		slug, one := r.Vars["resource"]
+
+		if one {
+			ok, err := ss.Revoke(r.Id, acc.Id, slug)
+			if err != nil {
+				log.BadRequest(r.Id, w, err.Error())
+				return
+			}
+			if !ok {
+				log.BadRequest(r.Id, w, "unable to sign out session")
+				return
+			}
+		} else {
+			if _, err := ss.RevokeAll(r.Id, acc.Id); err != nil {
+				log.BadRequest(r.Id, w, err.Error())
+				return
+			}
+		}
+
+		if !one || slug == strconv.FormatInt(acc.SessionId, 10) {
+			http.SetCookie(w, handler.Cookie(c, "token", "", -1))
+			handler.SetCSRF(w, c, r, "")
+		}
+	}
+}
+
+/*
+RevokeInvite stops the invite named by the "resource" route
+variable from being registered with.
+*/
+func RevokeInvite(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	is := dep.Invites
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		acc := r.User.(sd.Account)
+
+		ok, err := is.Revoke(r.Id, acc.Id, r.Vars["resource"])
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+		if !ok {
+			log.BadRequest(r.Id, w, "unable to revoke invite")
+			return
+		}
+	}
+}
+
+/*
+RSVP sets how the active persona responds to the event named by
+the "resource" route variable to the "status" route variable and
+writes a description of its RSVP. If there isn't a status its
+response is withdrawn instead.
+*/
+func RSVP(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	rs := dep.RSVPs
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		p := r.User.(sd.Account).ActivePersona()
+		slug := r.Vars["resource"]
+
+		status, ok := r.Vars["status"]
+		if !ok {
+			if _, err := rs.Withdraw(r.Id, p.Id, slug); err != nil {
+				log.BadRequest(r.Id, w, err.Error())
+			}
+			return
+		}
+
+		rsvp, err := rs.Respond(r.Id, p.Id, slug, status)
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+		w.Write([]byte(rsvp.Text()))
+	}
+}
+
 func DeletePersona(dep *sd.Dependencies) sd.Handler {
 
 	log := dep.Logger
@@ -121,6 +306,7 @@ func DeletePersona(dep *sd.Dependencies) sd.Handler {
 
 func Logout(dep *sd.Dependencies) sd.Handler {
 
+	c := dep.Config
 	log := dep.Logger
 	as := dep.Accounts
 
@@ -147,9 +333,7 @@ func Logout(dep *sd.Dependencies) sd.Handler {
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:   "token",
-			MaxAge: -1,
-		})
+		http.SetCookie(w, handler.Cookie(c, "token", "", -1))
+		handler.SetCSRF(w, c, r, "")
 	}
 }
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -14,6 +16,7 @@ type event struct {
 	Start    int64    `json:"start"`
 	Finish   int64    `json:"finish,omitempty"`
 	Weekly   bool     `json:"weekly,omitempty"`
+	RRule    string   `json:"rrule,omitempty"`
 	Local    bool     `json:"local,omitempty"`
 	Category []string `json:"category,omitempty"`
 	Setting  []string `json:"setting,omitempty"`
@@ -21,24 +24,21 @@ type event struct {
 
 func Event(dep *sd.Dependencies) sd.Handler {
 	return func(w http.ResponseWriter, r *sd.Request) {
-		handler(dep, w, r)
+		eventJSON(dep, w, r)
 	}
 }
 
-func handler(dep *sd.Dependencies, w http.ResponseWriter, r *sd.Request) {
+func eventJSON(dep *sd.Dependencies, w http.ResponseWriter, r *sd.Request) {
 
 	log := dep.Logger
-	db := dep.Resources["event"]
 
-	res, err := db.Retrieve(r.Id, r.Vars["resource"], sd.ResOpts{})
-	if err != nil {
-		log.BadRequest(r.Id, w, err.Error())
+	e, err := publicEvent(dep, r.Id, r.Vars["resource"])
+	if errors.Is(err, sql.ErrNoRows) {
+		log.NotFound(r.Id, w)
 		return
 	}
-
-	e, ok := res.(*sd.Event)
-	if !ok {
-		log.BadRequest(r.Id, w, "expected resource to be of type *sd.Event")
+	if err != nil {
+		log.BadRequest(r.Id, w, err.Error())
 		return
 	}
 
@@ -60,12 +60,27 @@ func handler(dep *sd.Dependencies, w http.ResponseWriter, r *sd.Request) {
 		return
 	}
 
+	/*
+		Weekly is kept for clients that don't understand RRule,
+		which is how the event recurs from its first occurrence.
+	*/
+	var rrule string
+	rule, ok, err := e.Rule(e.FirstStart)
+	if err != nil {
+		log.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if ok {
+		rrule = rule.RRule(e.Timezone == "local")
+	}
+
 	bb, err := json.Marshal(event{
 		Name:     e.Name.String,
 		Summary:  summary,
 		Start:    e.Start.DateTime,
 		Finish:   e.Finish.DateTime,
-		Weekly:   e.Weekly.Bool,
+		Weekly:   e.Recur == sd.RecurWeekly && e.Every.Int64 <= 1,
+		RRule:    rrule,
 		Local:    e.Timezone == "local",
 		Category: category,
 		Setting:  setting,
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	L "github.com/jakebowkett/go-logger/logger"
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+testEvents is a stand-in for the event service that holds a
+single event, which is returned whatever options are given so
+that handlers can't rely on the service to leave it out.
+*/
+type testEvents struct {
+	sd.ResourceService
+	event *sd.Event
+}
+
+func (te testEvents) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	if te.event == nil || te.event.Slug != slug {
+		return nil, sql.ErrNoRows
+	}
+	return te.event, nil
+}
+
+func testDeps(e *sd.Event) *sd.Dependencies {
+	return &sd.Dependencies{
+		Logger:    &L.Logger{OnLog: func(L.Thread) {}},
+		Resources: sd.Resources{"event": testEvents{event: e}},
+	}
+}
+
+func TestHiddenEvents(t *testing.T) {
+
+	hidden := &sd.Event{}
+	hidden.Slug = "hidden"
+	hidden.Hidden = sd.NullBool{Bool: true}
+
+	handlers := map[string]func(*sd.Dependencies) sd.Handler{
+		"Event":    Event,
+		"EventICS": EventICS,
+	}
+	tests := []struct {
+		name  string
+		event *sd.Event
+		slug  string
+	}{
+		{"hidden", hidden, "hidden"},
+		{"missing", nil, "missing"},
+	}
+
+	for hName, h := range handlers {
+		for _, tt := range tests {
+			t.Run(hName+"/"+tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				h(testDeps(tt.event))(w, &sd.Request{
+					Id:      "1",
+					Request: httptest.NewRequest(http.MethodGet, "/", nil),
+					Vars:    sd.Vars{"resource": tt.slug},
+				})
+				if w.Code != http.StatusNotFound {
+					t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+				}
+			})
+		}
+	}
+}
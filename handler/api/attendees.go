@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler"
+)
+
+/*
+EventAttendees responds with everyone who responded to the event
+named by the "resource" route variable as a CSV file. Only the
+event's owner may download it.
+*/
+func EventAttendees(dep *sd.Dependencies) sd.Handler {
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		log := dep.Logger
+		slug := r.Vars["resource"]
+		p := r.User.(sd.Account).ActivePersona()
+
+		aa, err := dep.RSVPs.Attendees(r.Id, p.Id, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.NotFound(r.Id, w)
+			return
+		}
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		cw := csv.NewWriter(buf)
+		cw.Write([]string{"Handle", "Name", "Status", "Waitlist", "Since"})
+		for _, a := range aa {
+			status := a.Status
+			switch {
+			case a.Status == sd.RSVPGoing && a.Waitlist > 0:
+				status = "waitlisted"
+			case a.Status == sd.RSVPNotGoing:
+				status = "not going"
+			}
+			var waitlist string
+			if a.Waitlist > 0 {
+				waitlist = strconv.Itoa(a.Waitlist)
+			}
+			cw.Write([]string{
+				csvSafe(a.Handle),
+				csvSafe(a.Name),
+				status,
+				waitlist,
+				time.Unix(a.Since, 0).UTC().Format(time.RFC3339),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+slug+`-attendees.csv"`)
+		handler.Gzip(w, r, buf.Bytes(), http.StatusOK, log)
+	}
+}
+
+/*
+csvSafe stops spreadsheet apps treating s as a formula when the
+file is opened in one.
+*/
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsAny(s[:1], "=+-@\t\r") {
+		return "'" + s
+	}
+	return s
+}
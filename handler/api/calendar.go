@@ -0,0 +1,249 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler"
+	"github.com/jakebowkett/storydevs/handler/mode/query"
+	"github.com/jakebowkett/storydevs/internal/ical"
+)
+
+const prodId = "-//StoryDevs//Events//EN"
+
+/*
+EventICS responds with the event named by the "resource" route
+variable as an iCalendar file to be added to calendar apps.
+*/
+func EventICS(dep *sd.Dependencies) sd.Handler {
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		log := dep.Logger
+		slug := r.Vars["resource"]
+
+		e, err := publicEvent(dep, r.Id, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.NotFound(r.Id, w)
+			return
+		}
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+
+		ie, err := icalEvent(dep, e)
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+		cal := ical.Calendar{
+			ProdID: prodId,
+			Events: []ical.Event{ie},
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="`+slug+`.ics"`)
+		writeCalendar(dep, w, r, cal)
+	}
+}
+
+/*
+publicEvent retrieves the event with the given slug for those
+who needn't own it. Events hidden by moderators are treated as
+though they don't exist, as they are when browsing.
+*/
+func publicEvent(dep *sd.Dependencies, reqId, slug string) (*sd.Event, error) {
+	res, err := dep.Resources["event"].Retrieve(reqId, slug, sd.ResOpts{})
+	if err != nil {
+		return nil, err
+	}
+	e, ok := res.(*sd.Event)
+	if !ok {
+		return nil, errors.New("expected resource to be of type *sd.Event")
+	}
+	if e.IsHidden() {
+		return nil, sql.ErrNoRows
+	}
+	return e, nil
+}
+
+/*
+Calendar responds with a feed of the public events matching the
+same query as browsing events does. Unless the query says when
+they're from, events that finished more than Config.Calendar.Past
+days ago are left out.
+*/
+func Calendar(dep *sd.Dependencies) sd.Handler {
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		log := dep.Logger
+		c := dep.Config
+
+		filter, err := query.Parse(r, dep.ViewData.Mode["event"].Search, dep)
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+		if filter == nil {
+			filter = make(map[string][]string)
+		}
+		if _, ok := filter["start"]; !ok {
+			past := time.Now().AddDate(0, 0, -c.Calendar.Past).Unix()
+			filter["start"] = []string{strconv.FormatInt(past, 10)}
+			if _, ok := filter["overlap"]; !ok {
+				filter["overlap"] = []string{"overlap"}
+			}
+		}
+		filter["thread"] = []string{"true"}
+		filter["visibility"] = []string{sd.VisibilityPublic}
+		filter["persona_visibility"] = []string{sd.VisibilityPublic}
+		filter["deleted"] = []string{"false"}
+		filter["hidden"] = []string{"false"}
+
+		feed(dep, w, r, "StoryDevs Events", filter)
+	}
+}
+
+/*
+PersonaCalendar responds with a feed of every event of the
+persona whose feed token is the "token" route variable.
+*/
+func PersonaCalendar(dep *sd.Dependencies) sd.Handler {
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		persId, err := dep.Calendars.FeedPersona(r.Id, r.Vars["token"])
+		if errors.Is(err, sql.ErrNoRows) {
+			dep.Logger.NotFound(r.Id, w)
+			return
+		}
+		if err != nil {
+			dep.Logger.BadRequest(r.Id, w, err.Error())
+			return
+		}
+
+		feed(dep, w, r, "My StoryDevs Events", map[string][]string{
+			"persona": {strconv.FormatInt(persId, 10)},
+		})
+	}
+}
+
+func feed(
+	dep *sd.Dependencies,
+	w http.ResponseWriter,
+	r *sd.Request,
+	name string,
+	filter map[string][]string,
+) {
+	log := dep.Logger
+
+	page := sd.PageOpts{Limit: dep.Config.Calendar.Limit}
+	results, _, err := dep.Resources["event"].Filter(r.Id, false, filter, page)
+	if err != nil {
+		log.BadRequest(r.Id, w, err.Error())
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: prodId,
+		Name:   name,
+	}
+	for _, res := range results {
+		e, ok := res.(*sd.Event)
+		if !ok {
+			log.BadRequest(r.Id, w, "expected resource to be of type *sd.Event")
+			return
+		}
+		ie, err := icalEvent(dep, e)
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+		cal.Events = append(cal.Events, ie)
+	}
+
+	writeCalendar(dep, w, r, cal)
+}
+
+func writeCalendar(dep *sd.Dependencies, w http.ResponseWriter, r *sd.Request, cal ical.Calendar) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	handler.Gzip(w, r, cal.Bytes(time.Now()), http.StatusOK, dep.Logger)
+}
+
+/*
+icalEvent converts e to an ical.Event. Recurring events start at
+their first occurrence rather than their next so that calendar
+apps show the ones that have passed too.
+*/
+func icalEvent(dep *sd.Dependencies, e *sd.Event) (ical.Event, error) {
+
+	start, err := icalTime(e.FirstStart, e.Timezone)
+	if err != nil {
+		return ical.Event{}, err
+	}
+	var end ical.Time
+	if !e.Finish.Null {
+		end, err = icalTime(e.FirstFinish, e.Timezone)
+		if err != nil {
+			return ical.Event{}, err
+		}
+	}
+
+	category, err := mapValues(dep, "kind.category", e.Category)
+	if err != nil {
+		return ical.Event{}, err
+	}
+
+	rule, recurs, err := e.Rule(e.FirstStart)
+	if err != nil {
+		return ical.Event{}, err
+	}
+
+	desc := e.Body.Text()
+	if desc == "" {
+		desc = e.Summary.String
+	}
+
+	ie := ical.Event{
+		UID:         e.Slug + "@storydevs.com",
+		Summary:     e.Name.String,
+		Description: desc,
+		URL:         dep.Config.SiteURL() + "/event/" + e.Slug,
+		Categories:  category,
+		Start:       start,
+		End:         end,
+		Created:     time.Unix(e.Created, 0),
+	}
+	if recurs {
+		ie.RRule = rule.RRule(start.Floating)
+		for _, t := range rule.Exceptions() {
+			ie.ExDates = append(ie.ExDates, ical.Time{Time: t, Floating: start.Floating})
+		}
+	}
+	if e.Updated != 0 {
+		ie.Modified = time.Unix(e.Updated, 0)
+	}
+	return ie, nil
+}
+
+/*
+icalTime converts a time stored in UTC to one in tz. Events in
+the "local" timezone happen at the same time on the clock
+wherever they're seen and are stored as though that time were
+in UTC.
+*/
+func icalTime(t int64, tz string) (ical.Time, error) {
+	switch tz {
+	case "utc":
+		return ical.Time{Time: time.Unix(t, 0).UTC()}, nil
+	case "local":
+		return ical.Time{Time: time.Unix(t, 0).UTC(), Floating: true}, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return ical.Time{}, err
+	}
+	return ical.Time{Time: time.Unix(t, 0).In(loc)}, nil
+}
@@ -16,7 +16,7 @@ func CacheControl(c *sd.Config, w http.ResponseWriter, sec float64, privacy stri
 	if !c.CacheControl {
 		return
 	}
-	val := fmt.Sprintf("%s, max-age=%d", privacy, sec)
+	val := fmt.Sprintf("%s, max-age=%.0f", privacy, sec)
 	w.Header().Add("Cache-Control", val)
 }
 
@@ -95,6 +95,9 @@ func Base(c *sd.Config, cache sd.Cache, r *sd.Request) (*sd.Base, error) {
 		JavaScript: js.JS(),
 
 		PresentThreshold: sd.PresentThreshold,
+
+		CSRF:  CSRFToken(c, r),
+		Nonce: r.Nonce,
 	}
 
 	account, ok := r.User.(sd.Account)
@@ -105,6 +108,80 @@ func Base(c *sd.Config, cache sd.Cache, r *sd.Request) (*sd.Base, error) {
 	return base, nil
 }
 
+/*
+Cookie returns an HttpOnly cookie for the whole site. Outside of
+dev it's only sent over HTTPS and with same-site requests. A
+negative maxAge deletes the cookie.
+*/
+func Cookie(c *sd.Config, name, value string, maxAge int) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		HttpOnly: true,
+		MaxAge:   maxAge,
+		Path:     "/",
+	}
+	if !c.Dev {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteStrictMode
+	}
+	return cookie
+}
+
+/*
+TokenCookie returns the cookie holding the token of a login. It
+lasts as long as the login may, or roughly six months if logins
+don't end by themselves.
+*/
+func TokenCookie(c *sd.Config, token string) *http.Cookie {
+	maxAge := 60 * 60 * 24 * 30 * 6 // roughly 6 months
+	if d := c.Session.Absolute.Duration; d > 0 {
+		maxAge = int(d.Seconds())
+	}
+	return Cookie(c, "token", token, maxAge)
+}
+
+// CSRFToken returns the CSRF token of r's client.
+func CSRFToken(c *sd.Config, r *sd.Request) string {
+	return sd.CSRFToken(c.Credentials.SigningKey, csrfSession(r))
+}
+
+// ValidCSRF reports whether token is the CSRF token of r's client.
+func ValidCSRF(c *sd.Config, r *sd.Request, token string) bool {
+	return sd.ValidCSRF(c.Credentials.SigningKey, csrfSession(r), token)
+}
+
+/*
+SetCSRF tells the client its new CSRF token after logging in
+with token or, if token is empty, after logging out.
+*/
+func SetCSRF(w http.ResponseWriter, c *sd.Config, r *sd.Request, token string) {
+	if token == "" {
+		if cookie, err := r.Request.Cookie(sd.CSRFCookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	w.Header().Set(sd.CSRFHeader, sd.CSRFToken(c.Credentials.SigningKey, token))
+}
+
+/*
+csrfSession returns the session r's CSRF token is made for. Only
+clients the account middleware recognised use their token cookie
+so that banned accounts, which are treated as logged out, use
+their CSRF cookie like anyone else who is logged out.
+*/
+func csrfSession(r *sd.Request) string {
+	if _, ok := r.User.(sd.Account); ok {
+		if cookie, err := r.Request.Cookie("token"); err == nil {
+			return cookie.Value
+		}
+	}
+	if cookie, err := r.Request.Cookie(sd.CSRFCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
 func ExtractJson(w http.ResponseWriter, r *http.Request, body interface{}, maxLen int64) error {
 
 	r.Body = http.MaxBytesReader(w, r.Body, maxLen)
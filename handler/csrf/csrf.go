@@ -0,0 +1,79 @@
+package csrf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler"
+	"github.com/jakebowkett/storydevs/handler/httperr"
+)
+
+/*
+Check returns middleware refusing requests that change state
+unless they carry the client's CSRF token in sd.CSRFHeader. It's
+meant to be given to Use after the account middleware so that
+logged in clients are checked against their login. Clients
+without a CSRF cookie are given one and every response carries
+the current token so that scripts can keep theirs up to date.
+Refused requests get 403 Forbidden.
+*/
+func Check(dep *sd.Dependencies) sd.Handler {
+
+	c := dep.Config
+	log := dep.Logger
+	onError := httperr.Error(dep)
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		if _, err := r.Request.Cookie(sd.CSRFCookie); err != nil {
+			session, err := newSession()
+			if err != nil {
+				r.Error = err
+				r.Status = http.StatusInternalServerError
+				onError(w, r)
+				return
+			}
+
+			/*
+				Unlike the token cookie this one is sent when
+				following links from other sites. Otherwise
+				those visits would replace it, leaving any
+				other open tabs with a token that's stale.
+			*/
+			cookie := handler.Cookie(c, sd.CSRFCookie, session, 0)
+			if !c.Dev {
+				cookie.SameSite = http.SameSiteLaxMode
+			}
+			http.SetCookie(w, cookie)
+			r.Request.AddCookie(cookie)
+		}
+
+		token := handler.CSRFToken(c, r)
+		w.Header().Set(sd.CSRFHeader, token)
+
+		switch r.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+
+		if handler.ValidCSRF(c, r, r.Request.Header.Get(sd.CSRFHeader)) {
+			return
+		}
+
+		log.Info(r.Id, "Refused request without a valid CSRF token.").
+			Data(sd.LK_IP, sd.ClientIP(r.Request))
+
+		r.Status = http.StatusForbidden
+		onError(w, r)
+	}
+}
+
+func newSession() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
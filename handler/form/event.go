@@ -0,0 +1,67 @@
+package form
+
+import (
+	"fmt"
+	"strconv"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+// The most occurrences a recurring event may be limited to.
+const maxTimes = 500
+
+func event(e *sd.Event, ff sd.Fields) error {
+
+	if n := e.Capacity.Int64; n < 0 {
+		return fmt.Errorf("event capacity must not be negative, got %d", n)
+	}
+
+	if e.Recur == sd.RecurNever {
+		return nil
+	}
+
+	// Check the interval is one of those offered.
+	if n := e.Every.Int64; n > 1 {
+		every, err := ff.Field("every")
+		if err != nil {
+			return err
+		}
+		if _, err := every.ValueByName(strconv.FormatInt(n, 10)); err != nil {
+			return fmt.Errorf("event interval %d not allowed", n)
+		}
+	} else if n < 0 {
+		return fmt.Errorf("event interval %d not allowed", n)
+	}
+
+	if e.Recur == sd.RecurMonthly {
+		switch e.MonthBy.String {
+		case "", sd.MonthByDay, sd.MonthByWeekday:
+		default:
+			return fmt.Errorf("monthly event recurs by unknown %q", e.MonthBy.String)
+		}
+	}
+
+	if e.Until.Int64 != 0 && e.Times.Int64 != 0 {
+		return fmt.Errorf("event may recur until a date or a number of times, not both")
+	}
+	if n := e.Times.Int64; n < 0 || n > maxTimes {
+		return fmt.Errorf("event occurrences must be between 1 and %d, got %d", maxTimes, n)
+	}
+
+	/*
+		Dates are midnight of the day, start is the date
+		and time, so compare against the start's date.
+	*/
+	day := int64(60 * 60 * 24)
+	startDate := e.Start.DateTime - e.Start.DateTime%day
+	if e.Until.Int64 != 0 && e.Until.Int64 < startDate {
+		return fmt.Errorf("event recurs until before it starts")
+	}
+	for _, d := range e.Skip {
+		if d < startDate {
+			return fmt.Errorf("event skips a date before it starts")
+		}
+	}
+
+	return nil
+}
@@ -60,6 +60,8 @@ func Validate(
 	switch view {
 	case "talent":
 		err = talent(r.(*sd.Profile), ff)
+	case "event":
+		err = event(r.(*sd.Event), ff)
 	}
 	if err != nil {
 		return v, err
@@ -355,7 +357,7 @@ func (v *validator) validateString(rv reflect.Value, tbl *sd.DbTable, ignore ign
 		}
 	}
 	if v.sf.AdminOnly && !v.admin {
-		return fmt.Errorf("Admin only field %q set by non-admin account.")
+		return fmt.Errorf("Admin only field %q set by non-admin account.", v.src)
 	}
 	if v.sf.Type == "textarea" {
 		if ctrlCharSansNewline.MatchString(s) {
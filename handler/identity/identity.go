@@ -0,0 +1,327 @@
+/*
+Package identity logs in with and links identities at the OpenID
+Connect providers in Config.OIDC.
+*/
+package identity
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler"
+	"github.com/jakebowkett/storydevs/handler/modal"
+	"github.com/jakebowkett/storydevs/internal/oidc"
+)
+
+// Name of the cookie tying a login with a provider to its client.
+const stateCookie = "oidc_state"
+
+var defaultScopes = []string{"openid", "email", "profile"}
+
+/*
+providers holds a Provider for each entry of Config.OIDC so that
+their discovered endpoints and keys are kept between requests.
+They're made again if their entry changes.
+*/
+type providers struct {
+	mu sync.Mutex
+	m  map[string]*oidc.Provider
+}
+
+func (pp *providers) get(c *sd.Config, name string) (*oidc.Provider, bool) {
+
+	pc, ok := c.OIDC[name]
+	if !ok {
+		return nil, false
+	}
+	scopes := pc.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	want := oidc.Provider{
+		Issuer:       pc.Issuer,
+		ClientID:     pc.ClientId,
+		ClientSecret: c.Credentials.OIDCSecrets[name],
+		RedirectURL:  c.SiteURL() + "/oidc/" + name + "/callback",
+		Scopes:       scopes,
+	}
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	p, ok := pp.m[name]
+	if ok &&
+		p.Issuer == want.Issuer &&
+		p.ClientID == want.ClientID &&
+		p.ClientSecret == want.ClientSecret &&
+		p.RedirectURL == want.RedirectURL &&
+		strings.Join(p.Scopes, " ") == strings.Join(want.Scopes, " ") {
+		return p, true
+	}
+	p = &oidc.Provider{
+		Issuer:       want.Issuer,
+		ClientID:     want.ClientID,
+		ClientSecret: want.ClientSecret,
+		RedirectURL:  want.RedirectURL,
+		Scopes:       want.Scopes,
+	}
+	if pp.m == nil {
+		pp.m = make(map[string]*oidc.Provider)
+	}
+	pp.m[name] = p
+	return p, true
+}
+
+/*
+Service starts logins with providers and finishes them when the
+client returns.
+*/
+type Service struct {
+	*sd.Dependencies
+	providers providers
+}
+
+/*
+Login sends the client to the provider named by the "provider"
+route variable to log in with it.
+*/
+func (s *Service) Login(w http.ResponseWriter, r *sd.Request) {
+	if r.User != nil {
+		s.Logger.BadRequest(r.Id, w, "Client already logged in.")
+		return
+	}
+	s.begin(w, r, 0)
+}
+
+/*
+Link sends the client to the provider named by the "provider"
+route variable to link the identity they log in with to their
+account.
+*/
+func (s *Service) Link(w http.ResponseWriter, r *sd.Request) {
+	s.begin(w, r, r.User.(sd.Account).Id)
+}
+
+func (s *Service) begin(w http.ResponseWriter, r *sd.Request, accId int64) {
+
+	name := r.Vars["provider"]
+	p, ok := s.providers.get(s.Config, name)
+	if !ok {
+		s.Logger.BadRequest(r.Id, w, "unknown identity provider")
+		return
+	}
+
+	var ss [3]string
+	for i := range ss {
+		v, err := oidc.NewState()
+		if err != nil {
+			s.Logger.BadRequest(r.Id, w, err.Error())
+			return
+		}
+		ss[i] = v
+	}
+	state, nonce, verifier := ss[0], ss[1], ss[2]
+
+	u, err := p.AuthURL(state, nonce, verifier)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+
+	err = s.Identities.Begin(r.Id, state, sd.IdentityFlow{
+		Provider: name,
+		Verifier: verifier,
+		Nonce:    nonce,
+		AccId:    accId,
+	})
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+
+	http.SetCookie(w, cookie(s.Config, state, 0))
+	http.Redirect(w, r.Request, u, http.StatusSeeOther)
+}
+
+/*
+Callback is where providers send clients back to. Clients that
+logged in are sent home, or asked for a code if their account
+uses two-factor authentication, and those that linked an identity
+are sent to the list of them. Anything else is shown in a modal.
+*/
+func (s *Service) Callback(w http.ResponseWriter, r *sd.Request) {
+
+	name := r.Vars["provider"]
+	q := r.Request.URL.Query()
+	state := q.Get("state")
+
+	// The state is only accepted once and from the client given it.
+	http.SetCookie(w, cookie(s.Config, "", -1))
+	var cookieState string
+	if c, err := r.Request.Cookie(stateCookie); err == nil {
+		cookieState = c.Value
+	}
+	if !oidc.ValidState(state, cookieState) {
+		s.fail(w, r, "")
+		return
+	}
+
+	flow, err := s.Identities.End(r.Id, name, state)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.fail(w, r, "")
+		return
+	}
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+
+	if e := q.Get("error"); e != "" {
+		s.Logger.Info(r.Id, "Identity provider refused log in.").
+			Data(sd.LK_IdentityProvider, name).
+			Data(sd.LK_Err, e)
+		s.fail(w, r, "")
+		return
+	}
+
+	p, ok := s.providers.get(s.Config, name)
+	if !ok {
+		s.fail(w, r, "")
+		return
+	}
+	claims, err := p.Exchange(q.Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		s.Logger.Error(r.Id, err.Error()).
+			Data(sd.LK_IdentityProvider, name)
+		s.fail(w, r, "")
+		return
+	}
+
+	ei := sd.ExternalIdentity{
+		Provider:      name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Handle:        claims.PreferredUsername,
+	}
+	if ei.Handle == "" {
+		ei.Handle = claims.Name
+	}
+	if ei.Handle == "" {
+		ei.Handle = strings.Split(claims.Email, "@")[0]
+	}
+
+	if flow.AccId != 0 {
+		s.link(w, r, flow.AccId, ei)
+		return
+	}
+	s.login(w, r, ei)
+}
+
+func (s *Service) login(w http.ResponseWriter, r *sd.Request, ei sd.ExternalIdentity) {
+
+	ip := sd.ClientIP(r.Request)
+	authedUser, fb, err := s.Identities.Login(r.Id, ei, ip, r.Request.UserAgent())
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
+		s.fail(w, r, strings.Join(fb["general"], " "))
+		return
+	}
+
+	// As when logging in with a password, see modal.Service.Login.
+	if authedUser.Challenge != "" {
+		c := handler.Cookie(s.Config, "challenge", authedUser.Challenge, 60*s.Config.TwoFactor.Challenge)
+		c.Path = "/login"
+		http.SetCookie(w, c)
+		r.Vars["modal"] = "login_totp"
+		modal.Full(s.Dependencies)(w, r)
+		return
+	}
+
+	http.SetCookie(w, handler.TokenCookie(s.Config, authedUser.Token))
+	s.redirect(w, r, "/")
+}
+
+/*
+link links ei to accId. The account isn't known from the request
+since its token cookie isn't sent when the provider sends the
+client back. The state cookie stands in for it instead as it was
+only given to the client that began linking while logged in.
+*/
+func (s *Service) link(w http.ResponseWriter, r *sd.Request, accId int64, ei sd.ExternalIdentity) {
+
+	fb, err := s.Identities.Link(r.Id, accId, ei)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
+		s.fail(w, r, strings.Join(fb["general"], " "))
+		return
+	}
+
+	s.redirect(w, r, "/account/identities")
+}
+
+/*
+redirect sends the client to path with a page that refreshes to
+it. Redirecting with a status would continue the navigation the
+provider began, which browsers don't send same-site cookies such
+as the token cookie with.
+*/
+func (s *Service) redirect(w http.ResponseWriter, r *sd.Request, path string) {
+	p := []byte(`<!DOCTYPE html><meta http-equiv="refresh" content="0; url=` + html.EscapeString(path) + `">`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	handler.Gzip(w, r, p, http.StatusOK, s.Logger)
+}
+
+// fail shows the oidc_fail modal with msg or its default message.
+func (s *Service) fail(w http.ResponseWriter, r *sd.Request, msg string) {
+	r.Vars["modal"] = "oidc_fail"
+	modal.Message(s.Dependencies, msg)(w, r)
+}
+
+/*
+Unlink unlinks the identity named by the "resource" route
+variable from the client's account.
+*/
+func (s *Service) Unlink(w http.ResponseWriter, r *sd.Request) {
+
+	acc := r.User.(sd.Account)
+
+	ok, fb, err := s.Identities.Unlink(r.Id, acc.Id, r.Vars["resource"])
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
+		handler.JSONResponse(w, r, s.Logger, map[string]interface{}{"feedback": fb})
+		return
+	}
+	if !ok {
+		s.Logger.BadRequest(r.Id, w, "unable to unlink identity")
+		return
+	}
+}
+
+/*
+cookie returns the cookie holding the state of a login with a
+provider. Unlike other cookies it's sent when the provider sends
+the client back, which is a cross-site navigation.
+*/
+func cookie(c *sd.Config, state string, maxAge int) *http.Cookie {
+	ck := handler.Cookie(c, stateCookie, state, maxAge)
+	ck.Path = "/oidc"
+	if !c.Dev {
+		ck.SameSite = http.SameSiteLaxMode
+	}
+	return ck
+}
@@ -0,0 +1,78 @@
+package limit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler/httperr"
+)
+
+/*
+Limit returns middleware enforcing the rate limit called name
+in the config. It's meant to be given to Use on the router or a
+group so that it applies to the routes after it. Requests over
+the limit are refused with 429 Too Many Requests.
+*/
+func Limit(dep *sd.Dependencies, name string) sd.Handler {
+
+	c, ok := dep.Config.RateLimit[name]
+	if !ok {
+		panic(fmt.Sprintf("limit: config.RateLimit[%q] doesn't exist", name))
+	}
+	switch c.Key {
+	case sd.LimitIP, sd.LimitAccount, sd.LimitRoute:
+	default:
+		panic(fmt.Sprintf("limit: config.RateLimit[%q] has unknown Key %q", name, c.Key))
+	}
+	if c.PerMinute <= 0 || c.Burst <= 0 {
+		panic(fmt.Sprintf("limit: config.RateLimit[%q] must have PerMinute and Burst greater than 0", name))
+	}
+
+	log := dep.Logger
+	rl := sd.NewRateLimiter(c.PerMinute, c.Burst)
+	onError := httperr.Error(dep)
+
+	methods := make(map[string]bool)
+	for _, m := range c.Methods {
+		methods[m] = true
+	}
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		if len(methods) > 0 && !methods[r.Request.Method] {
+			return
+		}
+
+		key := key(c.Key, name, r)
+		ok, retry := rl.Allow(key)
+		if ok {
+			return
+		}
+
+		secs := int(math.Ceil(retry.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		log.Info(r.Id, "Rate limited request.").
+			Data(sd.LK_RateLimit, name).
+			Data(sd.LK_RateLimitKey, key).
+			Data(sd.LK_RateLimitRetry, retry.String())
+
+		r.Status = http.StatusTooManyRequests
+		onError(w, r)
+	}
+}
+
+// Accounts are keyed by id and logged out clients by IP address.
+func key(kind, name string, r *sd.Request) string {
+	switch kind {
+	case sd.LimitAccount:
+		if acc, ok := r.User.(sd.Account); ok {
+			return "account:" + strconv.FormatInt(acc.Id, 10)
+		}
+	case sd.LimitRoute:
+		return "route:" + name
+	}
+	return "ip:" + sd.ClientIP(r.Request)
+}
@@ -9,6 +9,15 @@ import (
 )
 
 func Full(dep *sd.Dependencies) sd.Handler {
+	return Message(dep, "")
+}
+
+/*
+Message is like Full but replaces the modal's message with msg
+unless it's empty. It's used to show the outcome of requests not
+made from a modal, such as returning from an identity provider.
+*/
+func Message(dep *sd.Dependencies, msg string) sd.Handler {
 
 	c := dep.Config
 	log := dep.Logger
@@ -18,7 +27,7 @@ func Full(dep *sd.Dependencies) sd.Handler {
 
 	return func(w http.ResponseWriter, r *sd.Request) {
 
-		modal, name, err := renderPartial(log, view, r, vd)
+		modal, name, err := renderPartial(log, view, r, vd, msg)
 		if err != nil {
 			log.BadRequest(r.Id, w, err.Error())
 			return
@@ -55,7 +64,7 @@ func Partial(dep *sd.Dependencies) sd.Handler {
 
 	return func(w http.ResponseWriter, r *sd.Request) {
 
-		modal, _, err := renderPartial(log, view, r, vd)
+		modal, _, err := renderPartial(log, view, r, vd, "")
 		if err != nil {
 			log.BadRequest(r.Id, w, err.Error())
 			return
@@ -71,6 +80,7 @@ func renderPartial(
 	view sd.View,
 	r *sd.Request,
 	vd *sd.ViewData,
+	msg string,
 ) (
 	[]byte,
 	string,
@@ -79,6 +89,9 @@ func renderPartial(
 
 	name := r.Vars["modal"]
 	data := vd.Modal[name]
+	if msg != "" {
+		data.Msg = msg
+	}
 	account, ok := r.User.(sd.Account)
 	if ok {
 		p := account.ActivePersona()
@@ -1,6 +1,7 @@
 package modal
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,6 +25,8 @@ func (s *Service) DeleteAccount(w http.ResponseWriter, r *sd.Request) {
 		s.Logger.BadRequest(r.Id, w, err.Error())
 		return
 	}
+	http.SetCookie(w, handler.Cookie(s.Config, "token", "", -1))
+	handler.SetCSRF(w, s.Config, r, "")
 }
 
 func (s *Service) Persona(w http.ResponseWriter, r *sd.Request) {
@@ -136,7 +139,7 @@ func (s *Service) Password(w http.ResponseWriter, r *sd.Request) {
 		s.Logger.BadRequest(r.Id, w, err.Error())
 		return
 	}
-	fb, err := s.Accounts.ChangePassword(r.Id, body, acc.Id)
+	fb, err := s.Accounts.ChangePassword(r.Id, body, acc.Id, acc.SessionId)
 	if err != nil {
 		s.Logger.BadRequest(r.Id, w, err.Error())
 		return
@@ -145,6 +148,85 @@ func (s *Service) Password(w http.ResponseWriter, r *sd.Request) {
 	s.modalResponse(w, r, "password_confirm", fb, acc.Email)
 }
 
+/*
+TwoFactor begins enrolling in two-factor authentication by
+showing the account's new secret once its password is given.
+*/
+func (s *Service) TwoFactor(w http.ResponseWriter, r *sd.Request) {
+
+	body := &sd.TwoFactor{}
+	name := "totp"
+	acc := r.User.(sd.Account)
+
+	if err := s.extractAndValidate(w, r, name, body); err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	secret, uri, fb, err := s.Accounts.BeginTwoFactor(r.Id, acc.Id, body.Password)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
+		s.jsonResponse(w, r, map[string]interface{}{"feedback": fb})
+		return
+	}
+
+	s.modalDefaults(w, r, "totp_confirm", map[string]string{
+		"key": secret,
+		"uri": uri,
+	})
+}
+
+/*
+ConfirmTwoFactor enables two-factor authentication once a code
+from the account's authenticator is given and shows its
+recovery codes.
+*/
+func (s *Service) ConfirmTwoFactor(w http.ResponseWriter, r *sd.Request) {
+
+	body := &sd.TwoFactorCode{}
+	name := "totp_confirm"
+	acc := r.User.(sd.Account)
+
+	if err := s.extractAndValidate(w, r, name, body); err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	recovery, fb, err := s.Accounts.EnableTwoFactor(r.Id, acc.Id, body.Code)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
+		s.jsonResponse(w, r, map[string]interface{}{"feedback": fb})
+		return
+	}
+
+	s.modalDefaults(w, r, "totp_success", map[string]string{
+		"recovery": strings.Join(recovery, " "),
+	})
+}
+
+func (s *Service) DisableTwoFactor(w http.ResponseWriter, r *sd.Request) {
+
+	body := &sd.TwoFactorDisable{}
+	name := "totp_disable"
+	acc := r.User.(sd.Account)
+
+	if err := s.extractAndValidate(w, r, name, body); err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	fb, err := s.Accounts.DisableTwoFactor(r.Id, acc.Id, body.Password, body.Code)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+
+	s.modalResponse(w, r, "totp_disable_success", fb)
+}
+
 func (s *Service) Mailing(w http.ResponseWriter, r *sd.Request) {
 
 	body := &sd.Subscription{}
@@ -163,6 +245,84 @@ func (s *Service) Mailing(w http.ResponseWriter, r *sd.Request) {
 	s.modalResponse(w, r, "mailing_confirm", fb, body.Email)
 }
 
+/*
+Report files a report against the resource named by the "mode"
+and "resource" route variables.
+*/
+func (s *Service) Report(w http.ResponseWriter, r *sd.Request) {
+
+	body := &sd.ReportForm{}
+	name := "report"
+
+	if err := s.extractAndValidate(w, r, name, body); err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	p := r.User.(sd.Account).ActivePersona()
+	fb, err := s.Reports.Report(r.Id, p, r.Vars["mode"], r.Vars["resource"], body)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.Logger.HttpStatus(r.Id, w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+
+	s.modalResponse(w, r, "report_success", fb)
+}
+
+/*
+Invite makes an invite code for the client's active persona and
+shows it to them.
+*/
+func (s *Service) Invite(w http.ResponseWriter, r *sd.Request) {
+
+	body := &sd.NewInvite{}
+	name := "invite"
+
+	if err := s.extractAndValidate(w, r, name, body); err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	p := r.User.(sd.Account).ActivePersona()
+	inv, fb, err := s.Invites.New(r.Id, p.Id, p.Admin.Bool, body.Note)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
+		s.jsonResponse(w, r, map[string]interface{}{"feedback": fb})
+		return
+	}
+
+	s.modalDefaults(w, r, "invite_success", map[string]string{
+		"code": inv.Code,
+	})
+}
+
+// Calendar makes a new calendar feed token for the active persona.
+func (s *Service) Calendar(w http.ResponseWriter, r *sd.Request) {
+	p := r.User.(sd.Account).ActivePersona()
+	token, err := s.Calendars.NewFeed(r.Id, p.Id)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	s.modalDefaults(w, r, "calendar_success", map[string]string{
+		"url": s.Config.SiteURL() + "/api/calendar/" + token,
+	})
+}
+
+func (s *Service) RevokeCalendar(w http.ResponseWriter, r *sd.Request) {
+	p := r.User.(sd.Account).ActivePersona()
+	if _, err := s.Calendars.RevokeFeed(r.Id, p.Id); err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	s.modalResponse(w, r, "calendar_revoked", nil)
+}
+
 func (s *Service) Reserve(w http.ResponseWriter, r *sd.Request) {
 
 	body := &sd.Reservation{}
@@ -196,34 +356,85 @@ func (s *Service) Login(w http.ResponseWriter, r *sd.Request) {
 		return
 	}
 
-	authedUser, err := s.Accounts.Login(r.Id, body.Identity, body.Password)
-	if errors.Is(err, sd.ErrInvalidLoginAttempt) {
-		fb := make(sd.Feedback)
-		fb.Add("general", "Unable to log in. Please check your details.")
+	ip := sd.ClientIP(r.Request)
+	authedUser, fb, err := s.Accounts.Login(r.Id, body.Identity, ip, r.Request.UserAgent(), body.Password)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
 		s.jsonResponse(w, r, map[string]interface{}{"feedback": fb})
 		return
 	}
-	if err != nil {
+
+	/*
+		Accounts using two-factor authentication are asked for
+		a code next. The challenge standing in for the password
+		is only sent back with it.
+	*/
+	if authedUser.Challenge != "" {
+		cookie := handler.Cookie(s.Config, "challenge", authedUser.Challenge, 60*s.Config.TwoFactor.Challenge)
+		cookie.Path = "/login"
+		http.SetCookie(w, cookie)
+		s.modalResponse(w, r, "login_totp", nil)
+		return
+	}
+
+	s.loggedIn(w, r, authedUser)
+}
+
+/*
+LoginTwoFactor finishes logging in with the code asked for
+after the password of an account using two-factor
+authentication.
+*/
+func (s *Service) LoginTwoFactor(w http.ResponseWriter, r *sd.Request) {
+
+	if r.User != nil {
+		s.Logger.BadRequest(r.Id, w, "Client already logged in.")
+		return
+	}
+
+	body := &sd.TwoFactorCode{}
+	name := "login_totp"
+
+	if err := s.extractAndValidate(w, r, name, body); err != nil {
 		s.Logger.BadRequest(r.Id, w, err.Error())
 		return
 	}
 
-	var secure bool
-	var sameSite http.SameSite
-	if !s.Config.Dev {
-		secure = true
-		sameSite = http.SameSiteStrictMode
+	// A missing challenge is treated the same as an expired one.
+	var challenge string
+	if cookie, err := r.Request.Cookie("challenge"); err == nil {
+		challenge = cookie.Value
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    authedUser.Token,
-		HttpOnly: true,
-		SameSite: sameSite,
-		Secure:   secure,
-		MaxAge:   60 * 60 * 24 * 30 * 6, // roughly 6 months
-		Path:     "/",
-	})
+	ip := sd.ClientIP(r.Request)
+	authedUser, fb, err := s.Accounts.LoginTwoFactor(r.Id, challenge, ip, r.Request.UserAgent(), body.Code)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	if len(fb) > 0 {
+		s.jsonResponse(w, r, map[string]interface{}{"feedback": fb})
+		return
+	}
+
+	cookie := handler.Cookie(s.Config, "challenge", "", -1)
+	cookie.Path = "/login"
+	http.SetCookie(w, cookie)
+	s.loggedIn(w, r, authedUser)
+}
+
+/*
+loggedIn sets the token cookie of authedUser and responds with
+the parts of the page that change once the client is logged in.
+The client's CSRF token changes along with its session.
+*/
+func (s *Service) loggedIn(w http.ResponseWriter, r *sd.Request, authedUser *sd.AuthedUser) {
+
+	http.SetCookie(w, handler.TokenCookie(s.Config, authedUser.Token))
+	handler.SetCSRF(w, s.Config, r, authedUser.Token)
 
 	m := make(map[string]interface{})
 
@@ -294,10 +505,8 @@ func (s *Service) Logout(w http.ResponseWriter, r *sd.Request) {
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:   "token",
-		MaxAge: -1,
-	})
+	http.SetCookie(w, handler.Cookie(s.Config, "token", "", -1))
+	handler.SetCSRF(w, s.Config, r, "")
 }
 
 func (s *Service) ConfirmFull(w http.ResponseWriter, r *sd.Request) {
@@ -321,6 +530,31 @@ func (s *Service) ConfirmFull(w http.ResponseWriter, r *sd.Request) {
 	Full(s.Dependencies)(w, r)
 }
 
+/*
+Unsubscribe follows the link at the bottom of notification
+emails. It works without logging in since the link is signed.
+*/
+func (s *Service) Unsubscribe(w http.ResponseWriter, r *sd.Request) {
+
+	ok, err := s.Digests.Unsubscribe(
+		r.Id,
+		r.Vars["persona"],
+		r.Vars["kind"],
+		r.Vars["sig"],
+	)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, "Error while attempting to unsubscribe.")
+		return
+	}
+	if ok {
+		r.Vars["modal"] = "unsubscribe_success"
+	} else {
+		r.Vars["modal"] = "unsubscribe_fail"
+	}
+
+	Full(s.Dependencies)(w, r)
+}
+
 func (s *Service) ConfirmPartial(w http.ResponseWriter, r *sd.Request) {
 
 	kind := r.Vars["kind"]
@@ -381,6 +615,33 @@ func (s *Service) confirmPartial(reqId, kind, code string) (bool, error) {
 	return ok, nil
 }
 
+/*
+modalDefaults responds with the modal called name after setting
+the Default of its fields named by the keys of defaults. It's
+used to show values made for the user, such as secrets, which
+can't be part of the modal's template.
+*/
+func (s *Service) modalDefaults(w rw, r *sd.Request, name string, defaults map[string]string) {
+
+	md := s.ViewData.Modal[name]
+
+	var ff sd.Fields
+	for _, f := range md.Field {
+		if v, ok := defaults[f.Name]; ok {
+			f.Default = v
+		}
+		ff = append(ff, f)
+	}
+	md.Field = ff
+
+	v, err := s.Templates.Render("modal.html", md)
+	if err != nil {
+		s.Logger.BadRequest(r.Id, w, err.Error())
+		return
+	}
+	s.jsonResponse(w, r, map[string]interface{}{"modal": string(v)})
+}
+
 func (s *Service) modalResponse(
 	w rw,
 	r *sd.Request,
@@ -107,6 +107,10 @@ func Create(dep *sd.Dependencies) sd.Handler {
 			return
 		}
 
+		if r.Vars["mode"] == "admin" {
+			logAction(dep, r, persona, sd.ModCreate, metaName, resource.GetSlug(), nil, resource)
+		}
+
 		/*
 			If the user submitted a reply to a thread we return
 			the whole thread not just the user's reply. Therefore
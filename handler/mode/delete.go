@@ -41,6 +41,21 @@ func Delete(dep *sd.Dependencies) sd.Handler {
 		user := r.User.(sd.Account)
 		p := user.ActivePersona()
 
+		/*
+			Keep a copy of the resource for the moderator log.
+			If it can't be retrieved it isn't deleted since the
+			deletion couldn't be recorded.
+		*/
+		var before sd.Resource
+		if moderated(r, metaName, p) {
+			var err error
+			before, err = rs[metaName].Retrieve(r.Id, rSlug, sd.ResOpts{GetPrivate: true})
+			if err != nil {
+				log.BadRequest(r.Id, w, err.Error())
+				return
+			}
+		}
+
 		// Delete associated database entries.
 		fb, toRemove, err := rs[metaName].Delete(r.Id, rSlug, p.Id)
 		if err != nil {
@@ -55,6 +70,10 @@ func Delete(dep *sd.Dependencies) sd.Handler {
 			return
 		}
 
+		if before != nil && (r.Vars["mode"] == "admin" || before.OwnerId() != p.Id) {
+			logAction(dep, r, p, sd.ModDelete, metaName, rSlug, before, nil)
+		}
+
 		/*
 			Remove files that are no longer needed by the
 			resource. We log any error but we don't return
@@ -62,7 +81,7 @@ func Delete(dep *sd.Dependencies) sd.Handler {
 			wrong. It's a server problem that redundant files
 			remain on disk.
 		*/
-		if err := removeFiles(c.DirUser, toRemove); err != nil {
+		if err := RemoveFiles(c.DirUser, toRemove); err != nil {
 			log.Error(r.Id, err.Error())
 		}
 
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
@@ -277,7 +278,18 @@ func renderPartial(
 	if err != nil {
 		return nil, nil, err
 	}
+	/*
+		The search column of a submode only reflects which one
+		is selected but the submode itself may be browsed with
+		the terms in the query, e.g., filtering the modlog.
+	*/
+	var subQuery query.Mapped
 	if inSubMode {
+		subQuery = mappedQuery
+		if subQuery == nil {
+			subQuery = make(query.Mapped)
+		}
+		subQuery["menu"] = []string{mode}
 		mappedQuery = make(map[string][]string)
 		mappedQuery["menu"] = []string{mode}
 	}
@@ -299,6 +311,9 @@ func renderPartial(
 			if err != nil {
 				return nil, nil, err
 			}
+			if err := populate.Admin(search, activePersona); err != nil {
+				return nil, nil, err
+			}
 			if err := queryToSearch(search, mappedQuery); err != nil {
 				return nil, nil, err
 			}
@@ -338,11 +353,11 @@ func renderPartial(
 
 	if need.Browse {
 
-		var results []sd.Resource
-
 		switch {
 		case inAdmin:
-			break
+			if inSubMode {
+				mappedQuery = subQuery
+			}
 		case inAccount:
 			persId := activePersona.Id
 			mappedQuery = make(map[string][]string)
@@ -357,14 +372,22 @@ func renderPartial(
 				mappedQuery["visibility"] = []string{"public"}
 				mappedQuery["persona_visibility"] = []string{"public"}
 				mappedQuery["deleted"] = []string{"false"}
+				mappedQuery["hidden"] = []string{"false"}
 			}
 		}
 
-		results, err = rs[mode].Filter(r.Id, admin, mappedQuery)
+		page := sd.PageOpts{
+			Limit:  c.BrowseLimit,
+			Cursor: r.Request.URL.Query().Get("cursor"),
+		}
+		results, next, err := rs[mode].Filter(r.Id, admin, mappedQuery, page)
 		if err != nil {
 			return nil, nil, err
 		}
 		data.Results = results
+		if next != "" {
+			data.More = moreURL(path, r.Request.URL.Query(), next)
+		}
 		cols["browse"] = ""
 	}
 
@@ -380,10 +403,21 @@ func renderPartial(
 			return nil, nil, err
 		}
 
-		if !admin && !mayAccessResource(resource, account) {
+		/*
+			Routes into the admin mode are guarded by the
+			privileges their submodes require.
+		*/
+		if !admin && !inAdmin && !mayAccessResource(resource, account) {
 			return nil, nil, errors.New("resource access denied")
 		}
 
+		// Events show who's attending and how the persona responded.
+		if e, ok := resource.(*sd.Event); ok {
+			if err := dep.RSVPs.Attendance(r.Id, e, activePersona.Id); err != nil {
+				return nil, nil, err
+			}
+		}
+
 		data.Resource = resource
 		cols["detail"] = ""
 	}
@@ -522,6 +556,9 @@ func mayEditResource(r sd.Resource, a sd.Account) bool {
 }
 
 func mayAccessResource(r sd.Resource, a sd.Account) bool {
+	if h, ok := r.(sd.Hider); ok && h.IsHidden() {
+		return r.IsOwner(a) || a.ActivePersona().Can(sd.PrivHide)
+	}
 	if r.GetVisibility() != sd.VisibilityPrivate {
 		return true
 	}
@@ -615,6 +652,16 @@ func neededColumn(r *sd.Request) columns {
 	return need
 }
 
+/*
+moreURL returns the URL for the page of results following
+the current one. It keeps the current query so the search
+that produced the results is preserved.
+*/
+func moreURL(path string, q url.Values, cursor string) string {
+	q.Set("cursor", cursor)
+	return path + "?" + q.Encode()
+}
+
 func idFromSlug(slug string) string {
 	parts := strings.Split(slug, "-")
 	return parts[len(parts)-1]
@@ -0,0 +1,84 @@
+package mode
+
+import (
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+moderated reports whether writes by p to resources of the
+given mode belong in the moderator log. Anything done through
+the admin mode does. Otherwise only admins may write to
+resources they don't own so theirs are the only writes that
+need checking against the owner of the resource.
+*/
+func moderated(r *sd.Request, metaName string, p sd.Persona) bool {
+	if metaName == "settings" {
+		return false
+	}
+	return r.Vars["mode"] == "admin" || p.Admin.Bool
+}
+
+/*
+logAction records action in the moderator log. Either of
+before and after may be nil. Failing to record is logged as
+an error but doesn't undo the action.
+*/
+func logAction(
+	dep *sd.Dependencies,
+	r *sd.Request,
+	p sd.Persona,
+	action, kind, slug string,
+	before, after sd.Resource,
+) {
+
+	log := dep.Logger
+
+	e := &sd.ModLogEntry{
+		ActorId:     p.Id,
+		ActorHandle: p.Handle,
+		Action:      action,
+		TargetKind:  kind,
+		TargetSlug:  slug,
+		Reason:      sd.NullString{String: r.Request.URL.Query().Get("reason")},
+	}
+
+	var err error
+	if e.Before, err = snapshot(before); err != nil {
+		log.Error(r.Id, err.Error())
+		return
+	}
+	if e.After, err = snapshot(after); err != nil {
+		log.Error(r.Id, err.Error())
+		return
+	}
+
+	// Errors are logged by Record.
+	dep.ModLog.Record(r.Id, e)
+}
+
+/*
+snapshot avoids passing a nil sd.Resource holding a nil
+pointer to sd.Snapshot, which would encode it as "null"
+rather than recording NULL.
+*/
+func snapshot(r sd.Resource) (sd.NullString, error) {
+	if r == nil {
+		return sd.NullString{Null: true}, nil
+	}
+	return sd.Snapshot(r)
+}
+
+/*
+updateAction describes an update by p to a resource that
+was previously before. Visibility changes made by anyone
+other than the owner are recorded as such.
+*/
+func updateAction(p sd.Persona, before, after sd.Resource) string {
+	if before == nil || after == nil {
+		return sd.ModUpdate
+	}
+	if before.OwnerId() != p.Id && before.GetVisibility() != after.GetVisibility() {
+		return sd.ModVisibility
+	}
+	return sd.ModUpdate
+}
@@ -32,6 +32,15 @@ func Account(c *sd.Config, as sd.Fields, acc sd.Account) error {
 	}
 	f.Default = acc.Email
 
+	// Set two-factor authentication.
+	f, err = as.Field("account.twofactor")
+	if err != nil {
+		return fmt.Errorf("populate: %w", err)
+	}
+	if acc.TwoFactor {
+		f.Default = "Enabled"
+	}
+
 	// Set personas.
 	f, err = as.Field("account.personas")
 	if err != nil {
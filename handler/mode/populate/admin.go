@@ -0,0 +1,68 @@
+package populate
+
+import (
+	"fmt"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+adminPrivs lists the privileges that grant access to each
+submode of the admin mode. Any one of them is sufficient.
+Submodes absent from this map are reserved for admins.
+*/
+var adminPrivs = map[string][]string{
+	"privileges": PrivilegesAccess,
+	"modlog":     {sd.PrivLogs},
+	"reports":    ReportsAccess,
+}
+
+/*
+PrivilegesAccess lists the privileges that grant access to
+the privileges submode. Besides managing privileges it's
+where personas are muted and banned.
+*/
+var PrivilegesAccess = []string{
+	sd.PrivGivePriv,
+	sd.PrivTakePriv,
+	sd.PrivMute,
+	sd.PrivBan,
+}
+
+/*
+ReportsAccess lists the privileges that grant access to the
+reports submode. Either is enough to dismiss reports.
+*/
+var ReportsAccess = []string{
+	sd.PrivHide,
+	sd.PrivDestroy,
+}
+
+/*
+Admin removes the entries of the admin menu that p may not
+visit. Admins may visit them all.
+*/
+func Admin(as sd.Fields, p sd.Persona) error {
+
+	if p.Admin.Bool {
+		return nil
+	}
+
+	f, err := as.Field("admin.menu")
+	if err != nil {
+		return fmt.Errorf("populate: %w", err)
+	}
+
+	var vv []sd.Value
+	for _, v := range f.Value {
+		for _, priv := range adminPrivs[v.Name] {
+			if p.Can(priv) {
+				vv = append(vv, v)
+				break
+			}
+		}
+	}
+	f.Value = vv
+
+	return nil
+}
@@ -238,6 +238,20 @@ func (p *populater) populateSlice(rv reflect.Value) error {
 		return nil
 	}
 
+	// Integer slices, e.g., dates.
+	if kind == reflect.Int64 {
+		for i := 0; i < rv.Len(); i++ {
+			if err := p.mg.AddFieldInstance(p.current()); err != nil {
+				return err
+			}
+			idx := p.current() + "." + strconv.Itoa(i)
+			if err := p.mg.SetWithInt(idx, rv.Index(i).Int()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	// Struct slices.
 	for i := 0; i < rv.Len(); i++ {
 
@@ -288,7 +302,11 @@ func (p *populater) populateInt(rv reflect.Value) error {
 	case int64:
 		n = itf.(int64)
 	case sd.NullInt64:
-		n = itf.(sd.NullInt64).Int64
+		ni := itf.(sd.NullInt64)
+		if ni.Null {
+			return nil
+		}
+		n = ni.Int64
 	default:
 		return errors.New("unknown int type")
 	}
@@ -59,6 +59,10 @@ func Settings(category string, ed sd.Fields, p sd.Persona) (sd.Fields, error) {
 				ff[i].Default = p.Visibility
 			}
 		}
+	case "notifications":
+		for i, f := range ff {
+			ff[i].Default = p.Delivery(f.Name)
+		}
 	default:
 		return nil, fmt.Errorf("populate: unknown persona settings category %q", category)
 	}
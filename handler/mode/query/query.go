@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	sd "github.com/jakebowkett/storydevs"
 )
@@ -16,6 +17,12 @@ func Parse(r *sd.Request, form sd.Fields, dep *sd.Dependencies) (map[string][]st
 
 	query := r.Request.URL.Query()
 
+	/*
+		The cursor is only used for paging through results
+		and would otherwise be mistaken for a search term.
+	*/
+	delete(query, "cursor")
+
 	if len(query) == 0 {
 		return nil, nil
 	}
@@ -30,6 +37,16 @@ func Parse(r *sd.Request, form sd.Fields, dep *sd.Dependencies) (map[string][]st
 	}
 	delete(query, "s")
 
+	/*
+		Full-text queries are free text and may contain
+		commas so they aren't split like other terms.
+	*/
+	text, err := parseText(query)
+	if err != nil {
+		return nil, err
+	}
+	delete(query, "q")
+
 	searchTerms, err := parseSearchTerms(query)
 	if err != nil {
 		return nil, err
@@ -43,6 +60,9 @@ func Parse(r *sd.Request, form sd.Fields, dep *sd.Dependencies) (map[string][]st
 	for k, v := range searchTerms {
 		mappedQuery[k] = v
 	}
+	if text != "" {
+		mappedQuery["q"] = []string{text}
+	}
 
 	if dep.Config.Dev {
 		if err := printMappedQuery(r, mappedQuery, form); err != nil {
@@ -194,6 +214,30 @@ func in(nn []int, n int) bool {
 	return false
 }
 
+/*
+Full-text queries longer than this are truncated rather than
+rejected since they'll have come from a text input.
+*/
+const maxTextLen = 256
+
+func parseText(query url.Values) (string, error) {
+	vv, ok := query["q"]
+	if !ok {
+		return "", nil
+	}
+	if len(vv) != 1 {
+		return "", errors.New(`expected 1 element for query key "q"`)
+	}
+	s := strings.TrimSpace(vv[0])
+	if !utf8.ValidString(s) {
+		return "", errors.New(`query key "q" is not valid UTF-8`)
+	}
+	if r := []rune(s); len(r) > maxTextLen {
+		s = string(r[:maxTextLen])
+	}
+	return s, nil
+}
+
 func parseSearchTerms(query url.Values) (map[string][]string, error) {
 	m := make(map[string][]string)
 	for k, v := range query {
@@ -303,6 +347,10 @@ func printMappedQuery(r *sd.Request, mq Mapped, form sd.Fields) error {
 			vv = append(vv, origVal)
 		}
 		k := re.ReplaceAllString(origKey, "")
+		if k == "q" {
+			fmt.Printf("%24s: %s\n", origKey, strings.Join(vv, ", "))
+			continue
+		}
 		f, err := form.Field(k)
 		if err != nil {
 			return err
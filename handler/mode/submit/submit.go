@@ -34,6 +34,8 @@ func ResourceInstance(metaName string) (resource sd.Resource, err error) {
 		resource = &sd.SettingsIdentity{}
 	case "privacy":
 		resource = &sd.SettingsPrivacy{}
+	case "notifications":
+		resource = &sd.SettingsNotifications{}
 	default:
 		return nil, errors.New("submit: unhandled ResourceInstance case")
 	}
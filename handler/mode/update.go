@@ -109,6 +109,23 @@ func Update(dep *sd.Dependencies) sd.Handler {
 		*/
 		result.TableTree.Slug = rSlug
 
+		/*
+			Keep a copy of the resource as it was for the moderator
+			log. If it can't be retrieved the update doesn't go ahead
+			since it couldn't be recorded.
+		*/
+		var before sd.Resource
+		if moderated(r, metaName, persona) {
+			before, err = rs[metaName].Retrieve(r.Id, rSlug, sd.ResOpts{GetPrivate: true})
+			if err != nil {
+				log.BadRequest(r.Id, w, err.Error())
+				if err := submit.RemoveNewFiles(result.TableTree.Written); err != nil {
+					log.Error(r.Id, err.Error())
+				}
+				return
+			}
+		}
+
 		/*
 			Commit the updated resource to database. If
 			the DB commit fails we remove the new files.
@@ -136,10 +153,19 @@ func Update(dep *sd.Dependencies) sd.Handler {
 			wrong. It's a server problem that redundant files
 			remain on disk.
 		*/
-		if err := removeFiles(c.DirUser, toRemove); err != nil {
+		if err := RemoveFiles(c.DirUser, toRemove); err != nil {
 			log.Error(r.Id, err.Error())
 		}
 
+		if before != nil && (r.Vars["mode"] == "admin" || before.OwnerId() != persona.Id) {
+			after, err := rs[metaName].Retrieve(r.Id, rSlug, sd.ResOpts{GetPrivate: true})
+			if err != nil {
+				log.Error(r.Id, err.Error())
+			}
+			action := updateAction(persona, before, after)
+			logAction(dep, r, persona, action, metaName, rSlug, before, after)
+		}
+
 		/*
 			If the user submitted a reply to a thread we return
 			the whole thread not just the user's reply. Therefore
@@ -194,7 +220,11 @@ func Update(dep *sd.Dependencies) sd.Handler {
 	}
 }
 
-func removeFiles(dir string, toRemove []string) error {
+/*
+RemoveFiles removes the files a resource service reported as
+no longer needed. Their paths are relative to dir.
+*/
+func RemoveFiles(dir string, toRemove []string) error {
 	for i, f := range toRemove {
 		f, err := filepath.Abs(filepath.Join(dir, f))
 		if err != nil {
@@ -0,0 +1,296 @@
+/*
+Package moderate handles actions reserved for staff. The
+privilege each requires is checked by the route grouping
+it's placed in so handlers assume the active persona holds
+it. Every action is recorded in the moderator log along with
+the optional "reason" query parameter.
+*/
+package moderate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler"
+	"github.com/jakebowkett/storydevs/handler/mode"
+)
+
+type flagFunc = func(reqId string, mod sd.Persona, slug string, on bool, reason string) error
+
+/*
+Post returns a handler that sets the flag on the post named by
+the "resource" route variable for PUT requests and clears it
+for DELETE requests. The flag is chosen by the "flag" route
+variable and must be one of "hidden", "locked" or "pinned".
+*/
+func Post(dep *sd.Dependencies) sd.Handler {
+	m := dep.Moderation
+	return flag(dep, "resource", map[string]flagFunc{
+		"hidden": m.HidePost,
+		"locked": m.LockThread,
+		"pinned": m.PinThread,
+	})
+}
+
+/*
+Persona is like Post but for the "muted" and "banned" flags
+of the persona named by the "persona" route variable.
+*/
+func Persona(dep *sd.Dependencies) sd.Handler {
+	m := dep.Moderation
+	return flag(dep, "persona", map[string]flagFunc{
+		"muted":  m.MutePersona,
+		"banned": m.BanPersona,
+	})
+}
+
+/*
+Hide hides the resource named by the "mode" and "resource"
+route variables for PUT requests and reveals it for DELETE
+requests. Unlike Post it applies to resources of any mode.
+*/
+func Hide(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	m := dep.Moderation
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		mod := r.User.(sd.Account).ActivePersona()
+		on := r.Request.Method == http.MethodPut
+
+		err := m.HideResource(r.Id, mod, r.Vars["mode"], r.Vars["resource"], on, reason(r))
+		if err != nil {
+			status(w, r, log, err)
+			return
+		}
+	}
+}
+
+/*
+Requeue makes the email in the outbox named by the "resource"
+route variable due again, whether it's dead or still pending.
+*/
+func Requeue(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	ob := dep.Outbox
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+		mod := r.User.(sd.Account).ActivePersona()
+		if err := ob.Requeue(r.Id, mod, r.Vars["resource"], reason(r)); err != nil {
+			status(w, r, log, err)
+			return
+		}
+	}
+}
+
+func flag(dep *sd.Dependencies, target string, ff map[string]flagFunc) sd.Handler {
+
+	log := dep.Logger
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		fn, ok := ff[r.Vars["flag"]]
+		if !ok {
+			log.BadRequest(r.Id, w, "unknown moderation flag")
+			return
+		}
+		mod := r.User.(sd.Account).ActivePersona()
+		on := r.Request.Method == http.MethodPut
+
+		err := fn(r.Id, mod, r.Vars[target], on, reason(r))
+		if err != nil {
+			status(w, r, log, err)
+			return
+		}
+	}
+}
+
+/*
+Privilege grants the privilege named by the "priv" route
+variable to the persona named by "persona" for PUT requests
+and revokes it for DELETE requests.
+*/
+func Privilege(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	m := dep.Moderation
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		mod := r.User.(sd.Account).ActivePersona()
+		slug := r.Vars["persona"]
+		priv := r.Vars["priv"]
+
+		var err error
+		if r.Request.Method == http.MethodPut {
+			err = m.Grant(r.Id, mod, slug, priv, reason(r))
+		} else {
+			err = m.Revoke(r.Id, mod, slug, priv, reason(r))
+		}
+		if err != nil {
+			status(w, r, log, err)
+			return
+		}
+	}
+}
+
+/*
+MergeTags replaces the tags listed in the request body with a
+single tag across every resource in the mode named by the
+"mode" route variable. It responds with how many resources
+were changed.
+*/
+func MergeTags(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	m := dep.Moderation
+	c := dep.Config
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		body := struct {
+			From []string `json:"from"`
+			To   string   `json:"to"`
+		}{}
+		err := handler.ExtractJson(w, r.Request, &body, c.MaxForm["tags"])
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+
+		var from []string
+		for _, tag := range body.From {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				from = append(from, tag)
+			}
+		}
+
+		mod := r.User.(sd.Account).ActivePersona()
+		n, err := m.MergeTags(r.Id, mod, r.Vars["mode"], from, body.To, reason(r))
+		if err != nil {
+			log.BadRequest(r.Id, w, err.Error())
+			return
+		}
+
+		handler.JSONResponse(w, r, log, map[string]int64{"merged": n})
+	}
+}
+
+/*
+Resolve closes the open reports of the resource named by the
+"mode" and "resource" route variables. The "resolution" route
+variable decides what happens to the resource beforehand: it
+is left alone when "dismissed", hidden when "hidden" and
+deleted when "destroyed".
+*/
+func Resolve(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+	m := dep.Moderation
+	rep := dep.Reports
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		mod := r.User.(sd.Account).ActivePersona()
+		kind := r.Vars["mode"]
+		slug := r.Vars["resource"]
+		resolution := r.Vars["resolution"]
+		why := reason(r)
+
+		var err error
+		switch resolution {
+		case sd.ResolveHide:
+			err = m.HideResource(r.Id, mod, kind, slug, true, why)
+		case sd.ResolveDestroy:
+			err = destroy(dep, r, mod, kind, slug, why)
+		}
+		if err != nil {
+			status(w, r, log, err)
+			return
+		}
+
+		if err := rep.Resolve(r.Id, mod, kind, slug, resolution, why); err != nil {
+			status(w, r, log, err)
+			return
+		}
+	}
+}
+
+/*
+destroy deletes a reported resource on behalf of its owner,
+since resource services only let owners and admins delete,
+and records the deletion in the moderator log.
+*/
+func destroy(dep *sd.Dependencies, r *sd.Request, mod sd.Persona, kind, slug, why string) error {
+
+	c := dep.Config
+	log := dep.Logger
+	rs := dep.Resources
+
+	before, err := rs[kind].Retrieve(r.Id, slug, sd.ResOpts{GetPrivate: true})
+	if err != nil {
+		return err
+	}
+
+	fb, toRemove, err := rs[kind].Delete(r.Id, slug, before.OwnerId())
+	if err != nil {
+		return err
+	}
+	if len(fb) > 0 {
+		return fmt.Errorf("couldn't destroy %s: %v", kind, fb)
+	}
+
+	// As in mode.Delete, leftover files aren't the moderator's problem.
+	if err := mode.RemoveFiles(c.DirUser, toRemove); err != nil {
+		log.Error(r.Id, err.Error())
+	}
+	if persSlug := before.GetPersSlug(); persSlug != "" {
+		dir := filepath.Join(c.DirUser, persSlug, kind, slug)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Error(r.Id, err.Error())
+		}
+	}
+
+	snapshot, err := sd.Snapshot(before)
+	if err != nil {
+		log.Error(r.Id, err.Error())
+		return nil
+	}
+
+	// Errors are logged by Record.
+	dep.ModLog.Record(r.Id, &sd.ModLogEntry{
+		ActorId:     mod.Id,
+		ActorHandle: mod.Handle,
+		Action:      sd.ModDelete,
+		TargetKind:  kind,
+		TargetSlug:  slug,
+		Before:      snapshot,
+		After:       sd.NullString{Null: true},
+		Reason:      sd.NullString{String: why},
+	})
+
+	return nil
+}
+
+func status(w http.ResponseWriter, r *sd.Request, log sd.Logger, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		log.HttpStatus(r.Id, w, http.StatusNotFound)
+	case errors.Is(err, sd.ErrPrivilege):
+		log.HttpStatus(r.Id, w, http.StatusForbidden)
+	default:
+		log.BadRequest(r.Id, w, err.Error())
+	}
+}
+
+func reason(r *sd.Request) string {
+	return strings.TrimSpace(r.Request.URL.Query().Get("reason"))
+}
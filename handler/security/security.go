@@ -0,0 +1,159 @@
+package security
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/handler/httperr"
+)
+
+// Reports larger than this are cut off rather than logged.
+const maxReport = 1 << 14
+
+/*
+Headers returns middleware setting the headers in the Security
+section of the config on every response. Each request is given a
+nonce for the Content-Security-Policy which handler.Base passes
+to the templates for their inline scripts and styles.
+*/
+func Headers(dep *sd.Dependencies) sd.Handler {
+
+	c := dep.Config
+	sc := c.Security
+	onError := httperr.Error(dep)
+	policy := strings.Join(sc.Policy, "; ")
+
+	cspHeader := "Content-Security-Policy"
+	if sc.ReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	var hsts string
+	if sc.HSTS > 0 && !c.Dev {
+		hsts = "max-age=" + strconv.Itoa(sc.HSTS)
+		if sc.HSTSSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if sc.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", sc.ReferrerPolicy)
+		}
+		if sc.FrameOptions != "" {
+			h.Set("X-Frame-Options", sc.FrameOptions)
+		}
+		if hsts != "" {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+
+		if policy == "" {
+			return
+		}
+		nonce, err := newNonce()
+		if err != nil {
+			r.Error = err
+			r.Status = http.StatusInternalServerError
+			onError(w, r)
+			return
+		}
+		r.Nonce = nonce
+		h.Set(cspHeader, strings.Replace(policy, "{nonce}", nonce, -1))
+	}
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+/*
+violation holds the fields of a report we log. Browsers send
+either a "csp-report" object with hyphenated keys, as asked for
+by report-uri, or a list of reports using the Reporting API.
+*/
+type violation struct {
+	Document  string `json:"document-uri"`
+	Directive string `json:"effective-directive"`
+	Blocked   string `json:"blocked-uri"`
+	Source    string `json:"source-file"`
+	Line      int    `json:"line-number"`
+	Sample    string `json:"script-sample"`
+	Mode      string `json:"disposition"`
+}
+
+type reportingViolation struct {
+	Document  string `json:"documentURL"`
+	Directive string `json:"effectiveDirective"`
+	Blocked   string `json:"blockedURL"`
+	Source    string `json:"sourceFile"`
+	Line      int    `json:"lineNumber"`
+	Sample    string `json:"sample"`
+	Mode      string `json:"disposition"`
+}
+
+/*
+Report logs the Content-Security-Policy violations browsers
+send to /csp-report. Malformed reports are ignored since
+anyone may send them.
+*/
+func Report(dep *sd.Dependencies) sd.Handler {
+
+	log := dep.Logger
+
+	return func(w http.ResponseWriter, r *sd.Request) {
+
+		body := http.MaxBytesReader(w, r.Request.Body, maxReport)
+		data, err := ioutil.ReadAll(body)
+		if err == nil {
+			for _, v := range violations(data) {
+				log.Info(r.Id, "Content-Security-Policy violation.").
+					Data(sd.LK_CSPDocument, v.Document).
+					Data(sd.LK_CSPDirective, v.Directive).
+					Data(sd.LK_CSPBlocked, v.Blocked).
+					Data(sd.LK_CSPSource, v.Source+":"+strconv.Itoa(v.Line)).
+					Data(sd.LK_CSPSample, v.Sample).
+					Data(sd.LK_CSPMode, v.Mode)
+			}
+		}
+
+		log.HttpStatus(r.Id, w, http.StatusNoContent)
+	}
+}
+
+func violations(data []byte) []violation {
+
+	var legacy struct {
+		Report *violation `json:"csp-report"`
+	}
+	if json.Unmarshal(data, &legacy) == nil && legacy.Report != nil {
+		return []violation{*legacy.Report}
+	}
+
+	var reports []struct {
+		Type string             `json:"type"`
+		Body reportingViolation `json:"body"`
+	}
+	if json.Unmarshal(data, &reports) != nil {
+		return nil
+	}
+	var vv []violation
+	for _, rep := range reports {
+		if rep.Type == "csp-violation" {
+			vv = append(vv, violation(rep.Body))
+		}
+	}
+	return vv
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	sd "github.com/jakebowkett/storydevs"
 	"github.com/jakebowkett/storydevs/handler"
@@ -29,11 +30,14 @@ func User(dep *sd.Dependencies) sd.Handler {
 
 		// Cookies aren't necessarily required to
 		// retrieve files so we ignore the error.
+		// Logins are looked up by the token's hash
+		// and ignored once they've expired.
 		var token string
 		cookie, _ := r.Request.Cookie("token")
 		if cookie != nil {
-			token = cookie.Value
+			token = sd.HashToken(cookie.Value)
 		}
+		began, seen := c.Session.Cutoffs(time.Now())
 
 		if !isFileName.MatchString(file) {
 			log.NotFound(r.Id, w)
@@ -66,6 +70,8 @@ func User(dep *sd.Dependencies) sd.Handler {
 			    (
 					(
 						logins.token = $2 AND
+						logins.since > $3 AND
+						logins.last_seen > $4 AND
 						logins.acc_id = personas.acc_id AND
 						personas.admin = true
 					) OR (
@@ -75,7 +81,9 @@ func User(dep *sd.Dependencies) sd.Handler {
 				    			personas.visibility != 'private' OR
 				    			(
 						    		personas.acc_id = logins.acc_id AND
-						    		logins.token = $2
+						    		logins.token = $2 AND
+						    		logins.since > $3 AND
+						    		logins.last_seen > $4
 				    			)
 				    		)
 				    	)
@@ -89,7 +97,9 @@ func User(dep *sd.Dependencies) sd.Handler {
 				    			(
 						    		profile.ref_id = personas.id AND
 						    		personas.acc_id = logins.acc_id AND
-						    		logins.token = $2
+						    		logins.token = $2 AND
+						    		logins.since > $3 AND
+						    		logins.last_seen > $4
 				    			)
 				    		)
 				    	)
@@ -103,7 +113,9 @@ func User(dep *sd.Dependencies) sd.Handler {
 				    			(
 						    		post.ref_id = personas.id AND
 						    		personas.acc_id = logins.acc_id AND
-						    		logins.token = $2
+						    		logins.token = $2 AND
+						    		logins.since > $3 AND
+						    		logins.last_seen > $4
 				    			)
 				    		)
 				    	)
@@ -117,13 +129,15 @@ func User(dep *sd.Dependencies) sd.Handler {
 				    			(
 						    		event.ref_id = personas.id AND
 						    		personas.acc_id = logins.acc_id AND
-						    		logins.token = $2
+						    		logins.token = $2 AND
+						    		logins.since > $3 AND
+						    		logins.last_seen > $4
 				    			)
 				    		)
 				    	)
 		    		)
 				)`,
-			file, token)
+			file, token, began, seen)
 		if err != nil {
 			log.BadRequest(r.Id, w, err.Error())
 			return
@@ -0,0 +1,58 @@
+package storydevs
+
+/*
+Identity is an account's identity at an OpenID Connect provider,
+which it may log in with. Its slug is its id.
+*/
+type Identity struct {
+	ResourceBase
+	Provider string
+	Subject  string
+	Email    string
+}
+
+func (i Identity) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (i Identity) GetName() string {
+	return i.Provider
+}
+
+/*
+ExternalIdentity is who a provider says logged in with it. Handle
+is what the persona of an account made for them is called, before
+it's made unique.
+*/
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Handle        string
+}
+
+/*
+IdentityFlow is a login with a provider that hasn't returned from
+it yet. If AccId is set the identity is being linked to that
+account rather than logged in with.
+*/
+type IdentityFlow struct {
+	Provider string
+	Verifier string
+	Nonce    string
+	AccId    int64
+}
+
+/*
+Identities logs in with and links identities at OpenID Connect
+providers. It also backs the identities submode of the account
+mode which lists them.
+*/
+type Identities interface {
+	Begin(reqId, state string, f IdentityFlow) error
+	End(reqId, provider, state string) (*IdentityFlow, error)
+
+	Login(reqId string, ei ExternalIdentity, ip, userAgent string) (auth *AuthedUser, fb Feedback, err error)
+	Link(reqId string, accId int64, ei ExternalIdentity) (Feedback, error)
+	Unlink(reqId string, accId int64, slug string) (ok bool, fb Feedback, err error)
+}
@@ -0,0 +1,125 @@
+/*
+Package email sends emails through one of several backends
+and renders their bodies from templates.
+
+	// Deliver over SMTP.
+	em := &email.SMTP{Host: "smtp.domain.com", Port: "587", ...}
+
+	// Write each email to a maildir for reading locally.
+	em := &email.Maildir{Dir: "./mail"}
+
+	// Keep emails in memory to be inspected later.
+	em := &email.Memory{}
+	em.Send(e)
+	sent := em.Sent()
+
+Emails with both a plaintext and an HTML body are sent as
+multipart/alternative messages. Those with only a plaintext
+body are sent as text/plain.
+*/
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+Message returns e as an RFC 5322 message from the sender with
+name and address from.
+*/
+func Message(e sd.Email, name, from string) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	sender := mail.Address{Name: name, Address: from}
+	id, err := messageId(from)
+	if err != nil {
+		return nil, err
+	}
+
+	header := func(k, v string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	header("From", sender.String())
+	header("To", e.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", id)
+	header("MIME-Version", "1.0")
+
+	if e.HTML == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuoted(&buf, e.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{
+		"boundary": mw.Boundary(),
+	}))
+	buf.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{`text/plain; charset="utf-8"`, e.Text},
+		{`text/html; charset="utf-8"`, e.HTML},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuoted(w, p.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeQuoted writes s to w with CRLF line endings.
+func writeQuoted(w interface{ Write([]byte) (int, error) }, s string) error {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\n", "\r\n")
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func messageId(from string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i != -1 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain), nil
+}
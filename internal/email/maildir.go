@@ -0,0 +1,62 @@
+package email
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+Maildir writes each email it's sent to the maildir at Dir
+instead of delivering it. Dir and its tmp, new and cur
+subdirectories are created if they don't exist. Files end in
+.eml so they can also be opened directly by most mail clients.
+*/
+type Maildir struct {
+	Dir  string
+	From string
+	Name string
+
+	count uint64
+}
+
+func (md *Maildir) Send(e sd.Email) error {
+
+	msg, err := Message(e, md.Name, md.From)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(md.Dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	name := fmt.Sprintf("%d.P%d_%d.%s.eml",
+		time.Now().UnixNano(),
+		os.Getpid(),
+		atomic.AddUint64(&md.count, 1),
+		host,
+	)
+
+	/*
+		Per the maildir convention the email is written to tmp
+		first so that readers never see a partially written file
+		in new.
+	*/
+	tmp := filepath.Join(md.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, msg, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(md.Dir, "new", name))
+}
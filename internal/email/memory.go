@@ -0,0 +1,34 @@
+package email
+
+import (
+	"sync"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+// Memory records the emails it's sent instead of delivering them.
+type Memory struct {
+	mu   sync.Mutex
+	sent []sd.Email
+}
+
+func (m *Memory) Send(e sd.Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, e)
+	return nil
+}
+
+// Sent returns the emails sent so far, oldest first.
+func (m *Memory) Sent() []sd.Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sd.Email(nil), m.sent...)
+}
+
+// Reset forgets all emails sent so far.
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = nil
+}
@@ -0,0 +1,89 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+// SMTP sends emails through an SMTP server that supports AUTH.
+type SMTP struct {
+	Host    string
+	Port    string
+	User    string
+	Pass    string
+	From    string
+	Name    string
+	Timeout int // seconds
+}
+
+func (em *SMTP) Send(e sd.Email) (err error) {
+
+	msg, err := Message(e, em.Name, em.From)
+	if err != nil {
+		return err
+	}
+
+	addr := em.Host + ":" + em.Port
+	auth := smtp.PlainAuth("", em.User, em.Pass, em.Host)
+
+	// Below is adapted from the standard library's
+	// smtp.SendMail — we need it to have a timeout. The
+	// deadline covers the whole exchange so that a server
+	// that stalls after accepting the connection can't hang
+	// the outbox.
+	timeout := time.Second * time.Duration(em.Timeout)
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, em.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		config := &tls.Config{ServerName: em.Host}
+		if err = c.StartTLS(config); err != nil {
+			return err
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); !ok {
+		return errors.New("server doesn't support AUTH")
+	}
+	if err := c.Auth(auth); err != nil {
+		return err
+	}
+	if err := c.Mail(em.User); err != nil {
+		return err
+	}
+	if err := c.Rcpt(e.To); err != nil {
+		return err
+	}
+
+	// Issue DATA command to server. The writer returned
+	// must be closed before calling any more methods on
+	// the client.
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,107 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"sync"
+	texttemplate "text/template"
+)
+
+/*
+Templates renders email bodies from the templates in a directory.
+Each email has a plaintext template ending in .txt and may have
+an HTML template of the same name ending in .html. The .html
+templates are escaped as HTML while the .txt ones are not.
+*/
+type Templates struct {
+	mu   sync.RWMutex
+	dir  string
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+func MustTemplates(dir string) *Templates {
+	t, err := NewTemplates(dir)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func NewTemplates(dir string) (*Templates, error) {
+	t := &Templates{dir: dir}
+	if err := t.Refresh(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Refresh parses the templates again from disk.
+func (t *Templates) Refresh() error {
+
+	text := texttemplate.New("")
+	html := htmltemplate.New("")
+
+	txtPaths, err := filepath.Glob(filepath.Join(t.dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	if len(txtPaths) == 0 {
+		return fmt.Errorf("no email templates found in %s", t.dir)
+	}
+	if text, err = text.ParseFiles(txtPaths...); err != nil {
+		return err
+	}
+
+	htmlPaths, err := filepath.Glob(filepath.Join(t.dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	if len(htmlPaths) > 0 {
+		if html, err = html.ParseFiles(htmlPaths...); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.text = text
+	t.html = html
+
+	return nil
+}
+
+/*
+Render executes the templates for the email called name with
+data. The html body is empty if there's no HTML template.
+*/
+func (t *Templates) Render(name string, data interface{}) (text, html string, err error) {
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tt := t.text.Lookup(name + ".txt")
+	if tt == nil {
+		return "", "", errors.New(fmt.Sprintf("couldn't find email template %q", name))
+	}
+	var buf bytes.Buffer
+	if err := tt.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	text = buf.String()
+
+	ht := t.html.Lookup(name + ".html")
+	if ht == nil {
+		return text, "", nil
+	}
+	buf.Reset()
+	if err := ht.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	html = buf.String()
+
+	return text, html, nil
+}
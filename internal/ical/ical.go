@@ -0,0 +1,333 @@
+/*
+Package ical writes calendars of events in the iCalendar format
+described by RFC 5545.
+*/
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	localFormat = "20060102T150405"
+	utcFormat   = "20060102T150405Z"
+
+	// Lines longer than this many octets are folded.
+	lineLen = 75
+)
+
+/*
+Time is when an event starts or finishes. Times in UTC are written
+as such, times elsewhere are written with the name of their
+location and floating times happen at the same time on the clock
+wherever they're seen, so their location is ignored.
+*/
+type Time struct {
+	time.Time
+	Floating bool
+}
+
+/*
+Event is a VEVENT. End is optional. Events with an RRule, the
+value of the property described by RFC 5545, recur from Start
+except at the starts in ExDates.
+*/
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	URL         string
+	Categories  []string
+	Start       Time
+	End         Time
+	RRule       string
+	ExDates     []Time
+	Created     time.Time
+	Modified    time.Time
+}
+
+/*
+Calendar is a VCALENDAR of Events. Name is what calendar apps
+call it when it's subscribed to.
+*/
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+/*
+Bytes returns c in the iCalendar format as of now. A VTIMEZONE is
+included for each location the events take place in.
+*/
+func (c Calendar) Bytes(now time.Time) []byte {
+
+	w := &writer{}
+
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.prop("PRODID", c.ProdID)
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:PUBLISH")
+	if c.Name != "" {
+		w.text("X-WR-CALNAME", c.Name)
+	}
+
+	for _, z := range zones(c.Events, now) {
+		z.write(w)
+	}
+
+	stamp := now.UTC().Format(utcFormat)
+	for _, e := range c.Events {
+		w.line("BEGIN:VEVENT")
+		w.prop("UID", e.UID)
+		w.prop("DTSTAMP", stamp)
+		w.time("DTSTART", e.Start)
+		if !e.End.IsZero() {
+			w.time("DTEND", e.End)
+		}
+		if e.RRule != "" {
+			w.prop("RRULE", e.RRule)
+			for _, t := range e.ExDates {
+				w.time("EXDATE", t)
+			}
+		}
+		w.text("SUMMARY", e.Summary)
+		if e.Description != "" {
+			w.text("DESCRIPTION", e.Description)
+		}
+		if e.URL != "" {
+			w.prop("URL", e.URL)
+		}
+		if len(e.Categories) > 0 {
+			cc := make([]string, len(e.Categories))
+			for i, cat := range e.Categories {
+				cc[i] = escape(cat)
+			}
+			w.prop("CATEGORIES", strings.Join(cc, ","))
+		}
+		if !e.Created.IsZero() {
+			w.prop("CREATED", e.Created.UTC().Format(utcFormat))
+		}
+		if !e.Modified.IsZero() {
+			w.prop("LAST-MODIFIED", e.Modified.UTC().Format(utcFormat))
+		}
+		w.line("END:VEVENT")
+	}
+
+	w.line("END:VCALENDAR")
+
+	return w.buf.Bytes()
+}
+
+type writer struct {
+	buf bytes.Buffer
+}
+
+// line writes s folded into lines of at most lineLen octets.
+func (w *writer) line(s string) {
+	n := lineLen
+	for len(s) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		w.buf.WriteString(s[:i])
+		w.buf.WriteString("\r\n ")
+		s = s[i:]
+		// Continuation lines begin with a space.
+		n = lineLen - 1
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+func (w *writer) prop(name, value string) {
+	w.line(name + ":" + value)
+}
+
+func (w *writer) text(name, value string) {
+	w.prop(name, escape(value))
+}
+
+func (w *writer) time(name string, t Time) {
+	switch {
+	case t.Floating:
+		w.prop(name, t.Format(localFormat))
+	case t.Location() == time.UTC:
+		w.prop(name, t.Format(utcFormat))
+	default:
+		w.prop(name+";TZID="+t.Location().String(), t.Format(localFormat))
+	}
+}
+
+var escaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// escape escapes s as a TEXT value.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+/*
+zone is a VTIMEZONE for loc covering from until to. Rather than
+describe its rules it lists each change of offset in that time,
+which is all that's known of it from the time zone database.
+*/
+type zone struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+/*
+zones returns a zone for each location ee take place in other
+than UTC. Each covers a year either side of its events so that
+calendar apps know the offset around them. Those of recurring
+events cover until two years from now since they may recur
+forever.
+*/
+func zones(ee []Event, now time.Time) []zone {
+
+	m := make(map[string]*zone)
+	add := func(t Time, recurs bool) {
+		if t.IsZero() || t.Floating || t.Location() == time.UTC {
+			return
+		}
+		name := t.Location().String()
+		from := t.AddDate(-1, 0, 0)
+		to := t.AddDate(1, 0, 0)
+		if recurs && now.AddDate(2, 0, 0).After(to) {
+			to = now.AddDate(2, 0, 0)
+		}
+		z, ok := m[name]
+		if !ok {
+			m[name] = &zone{loc: t.Location(), from: from, to: to}
+			return
+		}
+		if from.Before(z.from) {
+			z.from = from
+		}
+		if to.After(z.to) {
+			z.to = to
+		}
+	}
+	for _, e := range ee {
+		add(e.Start, e.RRule != "")
+		add(e.End, e.RRule != "")
+	}
+
+	zz := make([]zone, 0, len(m))
+	for _, z := range m {
+		zz = append(zz, *z)
+	}
+	sort.Slice(zz, func(i, j int) bool {
+		return zz[i].loc.String() < zz[j].loc.String()
+	})
+	return zz
+}
+
+type observance struct {
+	at       time.Time
+	name     string
+	from, to int
+}
+
+func (z zone) write(w *writer) {
+
+	from := z.from.In(z.loc)
+	name, off := from.Zone()
+
+	// The first observance is whatever is in effect at z.from.
+	oo := []observance{{at: from, name: name, from: off, to: off}}
+	for _, t := range transitions(from, z.to.In(z.loc)) {
+		n, o := t.Zone()
+		prev := oo[len(oo)-1]
+		oo = append(oo, observance{at: t, name: n, from: prev.to, to: o})
+	}
+
+	// Offsets above the least are taken to be daylight saving time.
+	least := oo[0].to
+	for _, o := range oo {
+		if o.to < least {
+			least = o.to
+		}
+	}
+
+	w.line("BEGIN:VTIMEZONE")
+	w.prop("TZID", z.loc.String())
+	for _, o := range oo {
+		kind := "STANDARD"
+		if o.to > least {
+			kind = "DAYLIGHT"
+		}
+		w.line("BEGIN:" + kind)
+		// DTSTART is the time on the clock before the change.
+		w.prop("DTSTART", o.at.UTC().Add(time.Duration(o.from)*time.Second).Format(localFormat))
+		w.prop("TZOFFSETFROM", offset(o.from))
+		w.prop("TZOFFSETTO", offset(o.to))
+		w.text("TZNAME", o.name)
+		w.line("END:" + kind)
+	}
+	w.line("END:VTIMEZONE")
+}
+
+/*
+transitions returns the moments between from and to at which the
+offset or name of their location changes. Changes are assumed to
+be at least a day apart.
+*/
+func transitions(from, to time.Time) []time.Time {
+
+	var tt []time.Time
+	const step = 24 * time.Hour
+
+	for t := from; t.Before(to); t = t.Add(step) {
+		next := t.Add(step)
+		if sameZone(t, next) {
+			continue
+		}
+		// Find the first second in the new zone.
+		lo, hi := t.Unix(), next.Unix()
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if sameZone(t, time.Unix(mid, 0).In(t.Location())) {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		tt = append(tt, time.Unix(hi, 0).In(t.Location()))
+	}
+
+	return tt
+}
+
+func sameZone(a, b time.Time) bool {
+	an, ao := a.Zone()
+	bn, bo := b.Zone()
+	return an == bn && ao == bo
+}
+
+// offset formats a UTC offset in seconds as ±hhmm[ss].
+func offset(sec int) string {
+	sign := "+"
+	if sec < 0 {
+		sign = "-"
+		sec = -sec
+	}
+	h, m, s := sec/3600, sec/60%60, sec%60
+	if s != 0 {
+		return fmt.Sprintf("%s%02d%02d%02d", sign, h, m, s)
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, h, m)
+}
@@ -0,0 +1,231 @@
+/*
+Package oidc implements the parts of OpenID Connect needed to log
+in with an external identity provider: discovery, the authorization
+code flow with PKCE, and verifying the ID token it returns.
+*/
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var encoding = base64.RawURLEncoding
+
+/*
+Provider is an identity provider a client is registered with.
+Its endpoints are discovered from Issuer the first time they're
+needed.
+*/
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Used for requests to the provider. If nil a client with
+	// a ten second timeout is used.
+	Client *http.Client
+
+	mu      sync.Mutex
+	meta    *metadata
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+type metadata struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+// Claims are the claims of a verified ID token that we use.
+type Claims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     flag   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// Some providers send email_verified as a string.
+type flag bool
+
+func (f *flag) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	*f = flag(s == "true")
+	return nil
+}
+
+/*
+NewState returns a random string suitable for the state, nonce,
+or PKCE code verifier of a login.
+*/
+func NewState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+/*
+ValidState reports whether state, which the provider sent the
+client back with, is the one given to the client when it was sent
+to the provider. Empty states are never valid.
+*/
+func ValidState(state, given string) bool {
+	return state != "" && subtle.ConstantTimeCompare([]byte(state), []byte(given)) == 1
+}
+
+/*
+AuthURL returns the provider's URL to send the client to in order
+to log in. The provider sends it back to RedirectURL with state and
+a code to give to Exchange along with nonce and verifier, which
+must be kept from the client.
+*/
+func (p *Provider) AuthURL(state, nonce, verifier string) (string, error) {
+
+	meta, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.scopes(), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", encoding.EncodeToString(sum[:]))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthURL, "?") {
+		sep = "&"
+	}
+	return meta.AuthURL + sep + v.Encode(), nil
+}
+
+func (p *Provider) scopes() []string {
+	for _, s := range p.Scopes {
+		if s == "openid" {
+			return p.Scopes
+		}
+	}
+	return append([]string{"openid"}, p.Scopes...)
+}
+
+/*
+Exchange trades code for an ID token and returns its claims once
+it's verified to be signed by the provider for this client and to
+contain nonce.
+*/
+func (p *Provider) Exchange(code, verifier, nonce string) (Claims, error) {
+
+	meta, err := p.metadata()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.ClientID)
+
+	req, err := http.NewRequest("POST", meta.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := p.client().Do(req)
+	if err != nil {
+		return Claims{}, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return Claims{}, fmt.Errorf("oidc: decoding token response: %w", err)
+	}
+	if body.Error != "" {
+		return Claims{}, fmt.Errorf("oidc: token request failed: %s: %s", body.Error, body.Description)
+	}
+	if res.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("oidc: token request failed with status %d", res.StatusCode)
+	}
+	if body.IDToken == "" {
+		return Claims{}, errors.New("oidc: token response has no id_token")
+	}
+
+	return p.verify(meta, body.IDToken, nonce, time.Now())
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (p *Provider) metadata() (*metadata, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	wellKnown := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(wellKnown, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: discovered issuer %q doesn't match %q", meta.Issuer, p.Issuer)
+	}
+	if meta.AuthURL == "" || meta.TokenURL == "" || meta.JWKSURL == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+func (p *Provider) getJSON(u string, v interface{}) error {
+	res, err := p.client().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s failed with status %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,398 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClient = "storydevs"
+	testSecret = "secret"
+	testKid    = "k1"
+)
+
+/*
+testIdP is a stand-in identity provider. Like cmd/idp it approves
+every authorization request straight away. Tokens are signed with
+signer, which is the key it publishes unless a test swaps it, and
+claims are added to those of each token, replacing any of the same
+name; nil removes a claim.
+*/
+type testIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	signer *rsa.PrivateKey
+	claims map[string]interface{}
+
+	mu     sync.Mutex
+	grants map[string]testGrant
+}
+
+type testGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{
+		key:    key,
+		signer: key,
+		claims: make(map[string]interface{}),
+		grants: make(map[string]testGrant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIdP) provider() *Provider {
+	return &Provider{
+		Issuer:       idp.URL,
+		ClientID:     testClient,
+		ClientSecret: testSecret,
+		RedirectURL:  "http://localhost/oidc/test/callback",
+		Client:       idp.Client(),
+	}
+}
+
+func (idp *testIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/jwks",
+	})
+}
+
+func (idp *testIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := idp.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKid,
+			"use": "sig",
+			"n":   encoding.EncodeToString(pub.N.Bytes()),
+			"e":   encoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *testIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClient || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	code, err := NewState()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idp.mu.Lock()
+	idp.grants[code] = testGrant{q.Get("code_challenge"), q.Get("nonce")}
+	idp.mu.Unlock()
+
+	v := url.Values{}
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+v.Encode(), http.StatusFound)
+}
+
+func (idp *testIdP) token(w http.ResponseWriter, r *http.Request) {
+
+	id, secret, _ := r.BasicAuth()
+	if id != testClient || secret != testSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	code := r.PostFormValue("code")
+	idp.mu.Lock()
+	g, ok := idp.grants[code]
+	delete(idp.grants, code)
+	idp.mu.Unlock()
+	if !ok {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if encoding.EncodeToString(sum[:]) != g.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            idp.URL,
+		"aud":            testClient,
+		"sub":            "someone",
+		"email":          "someone@example.com",
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+	}
+	for k, v := range idp.claims {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": testKid})
+	payload, _ := json.Marshal(claims)
+	signed := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     signed + "." + encoding.EncodeToString(sig),
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+/*
+login follows the provider's authorization URL as a browser would
+and returns the code and state it's sent back to the client with.
+*/
+func login(t *testing.T, p *Provider, state, nonce, verifier string) (code, returned string) {
+	t.Helper()
+	u, err := p.AuthURL(state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := *p.Client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	res, err := c.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorization request failed with status %d", res.StatusCode)
+	}
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), p.RedirectURL) {
+		t.Fatalf("sent back to %q rather than %q", loc, p.RedirectURL)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestExchange(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider()
+
+	code, state := login(t, p, "state", "nonce", "verifier")
+	if !ValidState(state, "state") {
+		t.Fatalf("state %q doesn't match", state)
+	}
+	claims, err := p.Exchange(code, "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "someone" || claims.Email != "someone@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider()
+
+	u, err := p.AuthURL("state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, idp.URL+"/authorize?") {
+		t.Errorf("AuthURL %q doesn't use the discovered endpoint", u)
+	}
+	q, _ := url.ParseQuery(u[strings.Index(u, "?")+1:])
+	sum := sha256.Sum256([]byte("verifier"))
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClient,
+		"redirect_uri":          p.RedirectURL,
+		"scope":                 "openid",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        encoding.EncodeToString(sum[:]),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if got := q.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	// The issuer must match the discovery document exactly.
+	other := idp.provider()
+	other.Issuer = idp.URL + "/"
+	if _, err := other.AuthURL("state", "nonce", "verifier"); err == nil {
+		t.Error("discovered a mismatched issuer")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider()
+	meta, err := p.metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	k, err := p.key(meta, testKid, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, ok := k.(*rsa.PublicKey)
+	if !ok || pub.N.Cmp(idp.key.N) != 0 || pub.E != idp.key.E {
+		t.Error("published key wasn't decoded")
+	}
+	if _, err := p.key(meta, "unknown", now); err == nil {
+		t.Error("found a key that wasn't published")
+	}
+}
+
+func TestValidState(t *testing.T) {
+	tests := []struct {
+		state, given string
+		valid        bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"abc", "", false},
+		{"", "abc", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := ValidState(tt.state, tt.given); got != tt.valid {
+			t.Errorf("ValidState(%q, %q) = %v", tt.state, tt.given, got)
+		}
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		err      string
+		setup    func(idp *testIdP)
+		verifier string
+		nonce    string
+	}{
+		{
+			name:     "PKCE verifier mismatch",
+			err:      "invalid_grant",
+			verifier: "another verifier",
+		},
+		{
+			name:  "nonce mismatch",
+			err:   "nonce",
+			nonce: "another nonce",
+		},
+		{
+			name:  "bad signature",
+			err:   "invalid id_token signature",
+			setup: func(idp *testIdP) { idp.signer = other },
+		},
+		{
+			name: "expired",
+			err:  "expired",
+			setup: func(idp *testIdP) {
+				idp.claims["exp"] = time.Now().Add(-2 * leeway).Unix()
+			},
+		},
+		{
+			name:  "wrong audience",
+			err:   "isn't for this client",
+			setup: func(idp *testIdP) { idp.claims["aud"] = "someone-else" },
+		},
+		{
+			name: "audience for another party",
+			err:  "another party",
+			setup: func(idp *testIdP) {
+				idp.claims["aud"] = []string{testClient, "someone-else"}
+				idp.claims["azp"] = "someone-else"
+			},
+		},
+		{
+			name:  "wrong issuer",
+			err:   "issued by",
+			setup: func(idp *testIdP) { idp.claims["iss"] = "https://evil.example.com" },
+		},
+		{
+			name:  "no subject",
+			err:   "no subject",
+			setup: func(idp *testIdP) { idp.claims["sub"] = nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newTestIdP(t)
+			if tt.setup != nil {
+				tt.setup(idp)
+			}
+			p := idp.provider()
+			code, _ := login(t, p, "state", "nonce", "verifier")
+
+			verifier, nonce := "verifier", "nonce"
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			claims, err := p.Exchange(code, verifier, nonce)
+			if err == nil {
+				t.Fatalf("accepted id_token with claims %+v", claims)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %q, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// Allowance for the provider's clock differing from ours.
+	leeway = time.Minute
+
+	// Unknown key ids cause the keys to be fetched again at most this often.
+	refetchAfter = 5 * time.Minute
+)
+
+// audience is either a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+type idToken struct {
+	Claims
+	Issuer   string   `json:"iss"`
+	Audience audience `json:"aud"`
+	Party    string   `json:"azp"`
+	Expires  int64    `json:"exp"`
+	Issued   int64    `json:"iat"`
+	Nonce    string   `json:"nonce"`
+}
+
+func (p *Provider) verify(meta *metadata, token, nonce string, now time.Time) (Claims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("oidc: malformed id_token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, errors.New("oidc: malformed id_token signature")
+	}
+
+	key, err := p.key(meta, header.Kid, now)
+	if err != nil {
+		return Claims{}, err
+	}
+	signed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := checkSignature(header.Alg, key, signed[:], sig); err != nil {
+		return Claims{}, err
+	}
+
+	var t idToken
+	if err := decodeSegment(parts[1], &t); err != nil {
+		return Claims{}, err
+	}
+
+	switch {
+	case t.Issuer != meta.Issuer:
+		return Claims{}, fmt.Errorf("oidc: id_token issued by %q", t.Issuer)
+	case !t.Audience.has(p.ClientID):
+		return Claims{}, errors.New("oidc: id_token isn't for this client")
+	case len(t.Audience) > 1 && t.Party != p.ClientID:
+		return Claims{}, errors.New("oidc: id_token authorised for another party")
+	case now.Add(-leeway).Unix() >= t.Expires:
+		return Claims{}, errors.New("oidc: id_token has expired")
+	case t.Nonce != nonce:
+		return Claims{}, errors.New("oidc: id_token nonce doesn't match")
+	case t.Subject == "":
+		return Claims{}, errors.New("oidc: id_token has no subject")
+	}
+
+	return t.Claims, nil
+}
+
+func (a audience) has(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := encoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("oidc: malformed id_token")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("oidc: malformed id_token: %w", err)
+	}
+	return nil
+}
+
+// Only the algorithms providers commonly sign ID tokens with are accepted.
+func checkSignature(alg string, key interface{}, digest, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("oidc: RS256 id_token signed with a non-RSA key")
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) != nil {
+			return errors.New("oidc: invalid id_token signature")
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return errors.New("oidc: invalid ES256 id_token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("oidc: invalid id_token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("oidc: unsupported id_token algorithm %q", alg)
+}
+
+/*
+key returns the provider's signing key with the id kid. Providers
+rotate their keys so they're fetched again if kid isn't known,
+though not more often than refetchAfter.
+*/
+func (p *Provider) key(meta *metadata, kid string, now time.Time) (interface{}, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if p.keys != nil && now.Sub(p.fetched) < refetchAfter {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(meta.JWKSURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.public()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	p.keys = keys
+	p.fetched = now
+
+	k, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+	return k, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) public() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := encoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := encoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Crv)
+		}
+		x, err := encoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := encoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("oidc: EC key isn't on its curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("oidc: unsupported key type %q", k.Kty)
+}
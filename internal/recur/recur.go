@@ -0,0 +1,243 @@
+/*
+Package recur works out when recurring events occur. Occurrences
+keep the time on the clock of the first in its location, so they
+move across changes to daylight saving time as people expect.
+*/
+package recur
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How often a Rule recurs.
+const (
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+/*
+Rule is how an event recurs from its first occurrence at Start,
+every Every days, weeks, or months depending on Freq. Monthly
+rules recur on the same day of the month as Start, skipping
+months without it, unless Weekday is set in which case they recur
+on the same weekday of the month, e.g., the second Tuesday. Start
+being on the fifth of its weekday in a month means the last.
+
+Rules end after Count occurrences or with the last occurring on
+Until, whichever is sooner. Occurrences on the dates in Except
+are skipped though still counted. Only the year, month, and day
+of Until and Except are used and the zero Time and Count mean
+no end.
+*/
+type Rule struct {
+	Start   time.Time
+	Freq    string
+	Every   int
+	Weekday bool
+	Until   time.Time
+	Count   int
+	Except  []time.Time
+}
+
+/*
+Between returns the starts of the occurrences of r from from up
+until but not including to.
+*/
+func (r Rule) Between(from, to time.Time) []time.Time {
+	var tt []time.Time
+	r.each(func(t time.Time) bool {
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			tt = append(tt, t)
+		}
+		return true
+	})
+	return tt
+}
+
+/*
+Next returns the start of the first occurrence of r at or after
+after. It's false if r has ended by then.
+*/
+func (r Rule) Next(after time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	r.each(func(t time.Time) bool {
+		if t.Before(after) {
+			return true
+		}
+		next = t
+		found = true
+		return false
+	})
+	return next, found
+}
+
+/*
+Ends reports whether r has a last occurrence and returns its
+start, or the zero Time if every occurrence is an exception.
+*/
+func (r Rule) Ends() (time.Time, bool) {
+	if r.Count <= 0 && r.Until.IsZero() {
+		return time.Time{}, false
+	}
+	var last time.Time
+	r.each(func(t time.Time) bool {
+		last = t
+		return true
+	})
+	return last, true
+}
+
+/*
+each calls fn with the start of each occurrence of r in order
+until fn returns false or r ends.
+*/
+func (r Rule) each(fn func(t time.Time) bool) {
+
+	every := r.Every
+	if every < 1 {
+		every = 1
+	}
+
+	count := 0
+	for n := 0; ; n++ {
+
+		t, ok := r.nth(n * every)
+		if !ok {
+			continue
+		}
+		if !r.Until.IsZero() && afterDate(t, r.Until) {
+			return
+		}
+		if r.Count > 0 && count == r.Count {
+			return
+		}
+		count++
+
+		if r.except(t) {
+			continue
+		}
+		if !fn(t) {
+			return
+		}
+	}
+}
+
+/*
+nth returns the start of the occurrence n days, weeks, or months
+after r.Start. It's false if that month has no such occurrence.
+*/
+func (r Rule) nth(n int) (time.Time, bool) {
+
+	s := r.Start
+	y, m, d := s.Date()
+	hh, mm, ss := s.Clock()
+	loc := s.Location()
+
+	switch r.Freq {
+	case Daily:
+		return time.Date(y, m, d+n, hh, mm, ss, 0, loc), true
+	case Weekly:
+		return time.Date(y, m, d+n*7, hh, mm, ss, 0, loc), true
+	}
+
+	if !r.Weekday {
+		t := time.Date(y, m+time.Month(n), d, hh, mm, ss, 0, loc)
+		return t, t.Day() == d
+	}
+
+	// The first of the month n months on.
+	first := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, loc)
+	wd := s.Weekday()
+	ord := (d-1)/7 + 1
+	day := 1 + (int(wd)-int(first.Weekday())+7)%7 + (ord-1)*7
+	if ord == 5 {
+		last := time.Date(first.Year(), first.Month()+1, 0, 0, 0, 0, 0, loc).Day()
+		for day > last {
+			day -= 7
+		}
+	}
+	return time.Date(first.Year(), first.Month(), day, hh, mm, ss, 0, loc), true
+}
+
+func (r Rule) except(t time.Time) bool {
+	for _, e := range r.Except {
+		if sameDate(t, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameDate(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// afterDate reports whether t is on a later date than date.
+func afterDate(t, date time.Time) bool {
+	y, m, d := date.Date()
+	return !t.Before(time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()))
+}
+
+var byDay = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+/*
+RRule returns r as the value of an RRULE property as described by
+RFC 5545. Floating rules, whose Start is at the same time on the
+clock wherever it's seen, end at a floating time rather than one
+in UTC.
+*/
+func (r Rule) RRule(floating bool) string {
+
+	ss := []string{"FREQ=" + strings.ToUpper(r.Freq)}
+	if r.Every > 1 {
+		ss = append(ss, fmt.Sprintf("INTERVAL=%d", r.Every))
+	}
+	if r.Freq == Monthly {
+		_, _, d := r.Start.Date()
+		if r.Weekday {
+			ord := (d-1)/7 + 1
+			if ord == 5 {
+				ord = -1
+			}
+			ss = append(ss, fmt.Sprintf("BYDAY=%d%s", ord, byDay[r.Start.Weekday()]))
+		} else {
+			ss = append(ss, fmt.Sprintf("BYMONTHDAY=%d", d))
+		}
+	}
+	if r.Count > 0 {
+		ss = append(ss, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if !r.Until.IsZero() {
+		y, m, d := r.Until.Date()
+		end := time.Date(y, m, d, 23, 59, 59, 0, r.Start.Location())
+		if floating {
+			ss = append(ss, "UNTIL="+end.Format("20060102T150405"))
+		} else {
+			ss = append(ss, "UNTIL="+end.UTC().Format("20060102T150405Z"))
+		}
+	}
+	return strings.Join(ss, ";")
+}
+
+/*
+Exceptions returns when the occurrences skipped because of Except
+would have started.
+*/
+func (r Rule) Exceptions() []time.Time {
+	var tt []time.Time
+	hh, mm, ss := r.Start.Clock()
+	for _, e := range r.Except {
+		y, m, d := e.Date()
+		tt = append(tt, time.Date(y, m, d, hh, mm, ss, 0, r.Start.Location()))
+	}
+	return tt
+}
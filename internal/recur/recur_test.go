@@ -0,0 +1,278 @@
+package recur
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func load(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestBetween(t *testing.T) {
+
+	ny := load(t, "America/New_York")
+	london := load(t, "Europe/London")
+
+	at := func(loc *time.Location, y int, m time.Month, d, hh, mm int) time.Time {
+		return time.Date(y, m, d, hh, mm, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name string
+		rule Rule
+		from time.Time
+		to   time.Time
+		want []time.Time
+	}{
+		{
+			name: "weekly into daylight saving time",
+			rule: Rule{Start: at(ny, 2021, 3, 7, 10, 0), Freq: Weekly},
+			from: at(ny, 2021, 3, 1, 0, 0),
+			to:   at(ny, 2021, 3, 22, 0, 0),
+			want: []time.Time{
+				at(ny, 2021, 3, 7, 10, 0),
+				at(ny, 2021, 3, 14, 10, 0),
+				at(ny, 2021, 3, 21, 10, 0),
+			},
+		},
+		{
+			name: "daily out of daylight saving time",
+			rule: Rule{Start: at(london, 2021, 10, 30, 9, 30), Freq: Daily},
+			from: at(london, 2021, 10, 30, 0, 0),
+			to:   at(london, 2021, 11, 2, 0, 0),
+			want: []time.Time{
+				at(london, 2021, 10, 30, 9, 30),
+				at(london, 2021, 10, 31, 9, 30),
+				at(london, 2021, 11, 1, 9, 30),
+			},
+		},
+		{
+			name: "every other day",
+			rule: Rule{Start: at(ny, 2021, 1, 1, 12, 0), Freq: Daily, Every: 2},
+			from: at(ny, 2021, 1, 2, 0, 0),
+			to:   at(ny, 2021, 1, 8, 0, 0),
+			want: []time.Time{
+				at(ny, 2021, 1, 3, 12, 0),
+				at(ny, 2021, 1, 5, 12, 0),
+				at(ny, 2021, 1, 7, 12, 0),
+			},
+		},
+		{
+			name: "monthly skips months without the day",
+			rule: Rule{Start: at(ny, 2021, 1, 31, 18, 0), Freq: Monthly},
+			from: at(ny, 2021, 1, 1, 0, 0),
+			to:   at(ny, 2021, 6, 1, 0, 0),
+			want: []time.Time{
+				at(ny, 2021, 1, 31, 18, 0),
+				at(ny, 2021, 3, 31, 18, 0),
+				at(ny, 2021, 5, 31, 18, 0),
+			},
+		},
+		{
+			name: "monthly on the second Tuesday",
+			rule: Rule{Start: at(ny, 2021, 2, 9, 19, 0), Freq: Monthly, Weekday: true},
+			from: at(ny, 2021, 2, 1, 0, 0),
+			to:   at(ny, 2021, 5, 1, 0, 0),
+			want: []time.Time{
+				at(ny, 2021, 2, 9, 19, 0),
+				at(ny, 2021, 3, 9, 19, 0),
+				at(ny, 2021, 4, 13, 19, 0),
+			},
+		},
+		{
+			name: "monthly on the fifth Friday means the last",
+			rule: Rule{Start: at(ny, 2021, 1, 29, 20, 0), Freq: Monthly, Weekday: true},
+			from: at(ny, 2021, 1, 1, 0, 0),
+			to:   at(ny, 2021, 5, 1, 0, 0),
+			want: []time.Time{
+				at(ny, 2021, 1, 29, 20, 0),
+				at(ny, 2021, 2, 26, 20, 0),
+				at(ny, 2021, 3, 26, 20, 0),
+				at(ny, 2021, 4, 30, 20, 0),
+			},
+		},
+		{
+			name: "exceptions are counted",
+			rule: Rule{
+				Start:  at(ny, 2021, 3, 1, 10, 0),
+				Freq:   Weekly,
+				Count:  3,
+				Except: []time.Time{date(2021, 3, 8)},
+			},
+			from: at(ny, 2021, 1, 1, 0, 0),
+			to:   at(ny, 2022, 1, 1, 0, 0),
+			want: []time.Time{
+				at(ny, 2021, 3, 1, 10, 0),
+				at(ny, 2021, 3, 15, 10, 0),
+			},
+		},
+		{
+			name: "until includes its date",
+			rule: Rule{
+				Start: at(ny, 2021, 3, 1, 23, 0),
+				Freq:  Daily,
+				Until: date(2021, 3, 3),
+			},
+			from: at(ny, 2021, 1, 1, 0, 0),
+			to:   at(ny, 2022, 1, 1, 0, 0),
+			want: []time.Time{
+				at(ny, 2021, 3, 1, 23, 0),
+				at(ny, 2021, 3, 2, 23, 0),
+				at(ny, 2021, 3, 3, 23, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rule.Between(tt.from, tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Occurrences keep their time on the clock, not their time in UTC.
+func TestDaylightSavingTime(t *testing.T) {
+	ny := load(t, "America/New_York")
+	r := Rule{Start: time.Date(2021, 3, 7, 10, 0, 0, 0, ny), Freq: Weekly}
+	tt := r.Between(r.Start, r.Start.AddDate(0, 0, 8))
+	if len(tt) != 2 {
+		t.Fatalf("got %d occurrences, want 2", len(tt))
+	}
+	if got := tt[1].Sub(tt[0]); got != time.Hour*24*7-time.Hour {
+		t.Errorf("occurrences are %s apart, want an hour less than a week", got)
+	}
+	if tt[0].UTC().Hour() != 15 || tt[1].UTC().Hour() != 14 {
+		t.Errorf("got %s and %s in UTC", tt[0].UTC(), tt[1].UTC())
+	}
+}
+
+func TestNext(t *testing.T) {
+
+	start := time.Date(2021, 1, 4, 9, 0, 0, 0, time.UTC)
+	r := Rule{Start: start, Freq: Weekly, Count: 3}
+
+	tests := []struct {
+		after time.Time
+		want  time.Time
+		ok    bool
+	}{
+		{start.AddDate(0, 0, -1), start, true},
+		{start, start, true},
+		{start.Add(time.Second), start.AddDate(0, 0, 7), true},
+		{start.AddDate(0, 0, 14), start.AddDate(0, 0, 14), true},
+		{start.AddDate(0, 0, 15), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := r.Next(tt.after)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("Next(%s) = %s, %v, want %s, %v", tt.after, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEnds(t *testing.T) {
+
+	start := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		rule Rule
+		want time.Time
+		ok   bool
+	}{
+		{"forever", Rule{Start: start, Freq: Daily}, time.Time{}, false},
+		{"count", Rule{Start: start, Freq: Daily, Count: 3}, start.AddDate(0, 0, 2), true},
+		{"until", Rule{Start: start, Freq: Weekly, Until: date(2021, 1, 20)}, start.AddDate(0, 0, 14), true},
+		{"sooner of both", Rule{Start: start, Freq: Daily, Count: 10, Until: date(2021, 1, 2)}, start.AddDate(0, 0, 1), true},
+		{"every one skipped", Rule{
+			Start:  start,
+			Freq:   Daily,
+			Count:  1,
+			Except: []time.Time{date(2021, 1, 1)},
+		}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.rule.Ends()
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("got %s, %v, want %s, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRRule(t *testing.T) {
+
+	ny := load(t, "America/New_York")
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		floating bool
+		want     string
+	}{
+		{"daily", Rule{Freq: Daily, Start: time.Date(2021, 3, 1, 10, 0, 0, 0, ny)}, false, "FREQ=DAILY"},
+		{"interval and count", Rule{
+			Freq:  Weekly,
+			Every: 2,
+			Count: 5,
+			Start: time.Date(2021, 3, 1, 10, 0, 0, 0, ny),
+		}, false, "FREQ=WEEKLY;INTERVAL=2;COUNT=5"},
+		{"month day", Rule{Freq: Monthly, Start: time.Date(2021, 3, 31, 10, 0, 0, 0, ny)}, false, "FREQ=MONTHLY;BYMONTHDAY=31"},
+		{"second Tuesday", Rule{
+			Freq:    Monthly,
+			Weekday: true,
+			Start:   time.Date(2021, 2, 9, 10, 0, 0, 0, ny),
+		}, false, "FREQ=MONTHLY;BYDAY=2TU"},
+		{"last Friday", Rule{
+			Freq:    Monthly,
+			Weekday: true,
+			Start:   time.Date(2021, 1, 29, 10, 0, 0, 0, ny),
+		}, false, "FREQ=MONTHLY;BYDAY=-1FR"},
+		{"until in UTC", Rule{
+			Freq:  Daily,
+			Start: time.Date(2021, 3, 1, 10, 0, 0, 0, ny),
+			Until: date(2021, 3, 20),
+		}, false, "FREQ=DAILY;UNTIL=20210321T035959Z"},
+		{"floating until", Rule{
+			Freq:  Daily,
+			Start: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+			Until: date(2021, 3, 20),
+		}, true, "FREQ=DAILY;UNTIL=20210320T235959"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.RRule(tt.floating); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	ny := load(t, "America/New_York")
+	r := Rule{
+		Start:  time.Date(2021, 3, 7, 10, 30, 0, 0, ny),
+		Freq:   Weekly,
+		Except: []time.Time{date(2021, 3, 14)},
+	}
+	want := []time.Time{time.Date(2021, 3, 14, 10, 30, 0, 0, ny)}
+	if got := r.Exceptions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -73,6 +73,7 @@ type Request = struct {
 	Request *http.Request
 	Vars    Vars
 	User    interface{}
+	Nonce   string
 	Status  int
 	Error   error
 	Began   time.Time
@@ -0,0 +1,103 @@
+/*
+Package totp implements time-based one-time passwords as described
+in RFC 6238 using the defaults authenticator apps expect: SHA-1,
+six digits and a thirty second step.
+*/
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	digits = 6
+	step   = 30
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random 160 bit secret encoded as base32.
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the time step t falls within.
+func Step(t time.Time) int64 {
+	return t.Unix() / step
+}
+
+// Code returns the code for secret at time step n.
+func Code(secret string, n int64) (string, error) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(n))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, see RFC 4226 section 5.3.
+	off := sum[len(sum)-1] & 0xf
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, bin%mod), nil
+}
+
+/*
+Verify reports whether code is correct for secret at time t,
+allowing for clocks that are up to skew steps apart. It returns
+the step code was correct for so that callers can refuse codes
+that were already used.
+*/
+func Verify(secret, code string, t time.Time, skew int) (n int64, ok bool, err error) {
+	if len(code) != digits {
+		return 0, false, nil
+	}
+	now := Step(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		want, err := Code(secret, now+i)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return now + i, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+/*
+URI returns an otpauth URI that authenticator apps accept, either
+as a link or as the contents of a QR code, to add secret under
+the name account.
+*/
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(digits))
+	v.Set("period", fmt.Sprint(step))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
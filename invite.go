@@ -0,0 +1,44 @@
+package storydevs
+
+/*
+Invite is a code made by a persona that others may register
+with. Its slug is its id so that the code is only shown to its
+owner. Active is whether it may still be registered with.
+*/
+type Invite struct {
+	ResourceBase
+	Code     string
+	Note     NullString
+	Uses     int
+	MaxUses  int `db:"max_uses"`
+	Expires  NullInt64
+	Revoked  NullInt64
+	Active   bool
+	Accounts []InviteAccount `db:"-"`
+}
+
+func (i Invite) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (i Invite) GetName() string {
+	return i.Code
+}
+
+/*
+InviteAccount is an account that registered with an invite.
+Handles are those of its personas that haven't been deleted.
+*/
+type InviteAccount struct {
+	Handles  []string
+	Redeemed int64
+}
+
+/*
+Invites makes and revokes the invite codes needed to register
+when Config.Invite requires them. It also backs the invites
+submode of the account mode which lists them.
+*/
+type Invites interface {
+	New(reqId string, persId int64, admin bool, note string) (inv *Invite, fb Feedback, err error)
+	Revoke(reqId string, accId int64, slug string) (ok bool, err error)
+}
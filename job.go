@@ -0,0 +1,37 @@
+package storydevs
+
+// Kinds of job.
+const (
+	JobReminder = "reminder"
+)
+
+// Statuses of jobs.
+const (
+	JobPending = "pending"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+/*
+Jobs runs work that's due at a later time, such as reminding
+personas of the events they're attending. Jobs are stored so
+that none are lost if the server stops and more than one server
+may run them without any being done twice.
+*/
+type Jobs interface {
+
+	/*
+		Schedule adds the jobs that will be due before it's
+		next called, such as reminders of occurrences starting
+		soon, and returns the number added. Only one server
+		schedules at a time; the others return zero.
+	*/
+	Schedule(reqId string) (n int, err error)
+
+	/*
+		Run attempts each job that's due and returns the number
+		done and the number that failed. Failed jobs are
+		attempted again later until they're dead.
+	*/
+	Run(reqId string) (done, failed int, err error)
+}
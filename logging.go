@@ -80,6 +80,28 @@ const (
 	LK_AccPassCode   = "Account Password Change Code"
 	LK_AccForgotCode = "Account Password Forgot Code"
 
+	LK_LoginFailures = "Login Failures"
+	LK_LoginWait     = "Login Wait"
+	LK_IP            = "IP Address"
+
+	LK_SessionId = "Session Id"
+	LK_Sessions  = "Sessions"
+
+	LK_IdentityId       = "Identity Id"
+	LK_IdentityProvider = "Identity Provider"
+	LK_IdentitySubject  = "Identity Subject"
+
+	LK_CSPDocument  = "CSP Document"
+	LK_CSPDirective = "CSP Directive"
+	LK_CSPBlocked   = "CSP Blocked"
+	LK_CSPSource    = "CSP Source"
+	LK_CSPSample    = "CSP Sample"
+	LK_CSPMode      = "CSP Disposition"
+
+	LK_RateLimit      = "Rate Limit"
+	LK_RateLimitKey   = "Rate Limit Key"
+	LK_RateLimitRetry = "Rate Limit Retry"
+
 	LK_PersSlug   = "Persona Slug"
 	LK_PersHandle = "Persona Handle"
 	LK_PersId     = "Persona Id"
@@ -105,11 +127,39 @@ const (
 	LK_EventName = "Event Name"
 	LK_EventSlug = "Event Slug"
 
+	LK_RSVPStatus = "RSVP Status"
+
+	LK_EventsExtended = "Events Extended"
+
 	LK_ProfileId   = "Profile Id"
 	LK_ProfileName = "Profile Name"
 	LK_ProfileSlug = "Profile Slug"
 
+	LK_ModId     = "Moderator Id"
+	LK_ModAction = "Moderator Action"
+	LK_Privilege = "Privilege"
+	LK_TagsFrom  = "Tags Merged"
+	LK_TagsTo    = "Tag Merged Into"
+
+	LK_ReportId       = "Report Id"
+	LK_ReportCategory = "Report Category"
+	LK_ReportCount    = "Report Count"
+	LK_Resolution     = "Report Resolution"
+
+	LK_NotificationCount = "Notification Count"
+	LK_NotificationKind  = "Notification Kind"
+	LK_EmailsQueued      = "Emails Queued"
+
 	LK_Emailer      = "Emailer State"
 	LK_EmailRcpt    = "Email Recipient"
 	LK_EmailSubject = "Email Subject"
+	LK_EmailsSent   = "Emails Sent"
+	LK_EmailsFailed = "Emails Failed"
+
+	LK_JobId         = "Job Id"
+	LK_JobKind       = "Job Kind"
+	LK_JobAttempt    = "Job Attempt"
+	LK_JobsScheduled = "Jobs Scheduled"
+	LK_JobsDone      = "Jobs Done"
+	LK_JobsFailed    = "Jobs Failed"
 )
@@ -1,5 +1,88 @@
 package storydevs
 
+/*
+Email is a message to a single recipient. Text is its plaintext
+body. HTML is its HTML body and may be empty.
+*/
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
 type Emailer interface {
-	Send(recipient string, subject, body string) (err error)
+	Send(e Email) (err error)
+}
+
+/*
+EmailView renders the bodies of emails from the templates in
+DirEmail. Each email has a plaintext template, name.txt, and
+may have an HTML one, name.html.
+*/
+type EmailView interface {
+	Render(name string, data interface{}) (text, html string, err error)
+	Refresh() error
+}
+
+// Backends that may be chosen for EmailBackend in Config.
+const (
+	EmailSMTP    = "smtp"
+	EmailMaildir = "maildir"
+	EmailMemory  = "memory"
+)
+
+// Statuses of emails in the outbox.
+const (
+	OutboxPending = "pending"
+	OutboxDead    = "dead"
+)
+
+/*
+OutboxEmail is an email waiting to be sent, or one that failed
+too many times and is dead. Sent emails are removed from the
+outbox. Its slug is its id. The body is left out since it often
+holds confirmation codes.
+*/
+type OutboxEmail struct {
+	ResourceBase
+	Recipient   string
+	Subject     string
+	Status      string
+	Attempts    int
+	NextAttempt int64
+	LastError   NullString
+}
+
+func (e OutboxEmail) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (e OutboxEmail) GetName() string {
+	return e.Subject
+}
+
+func (e OutboxEmail) Dead() bool {
+	return e.Status == OutboxDead
+}
+
+/*
+Outbox sends the emails services queue within their own
+transactions so that a slow or failing mail server doesn't
+hold up requests and no email is lost if the server stops.
+*/
+type Outbox interface {
+
+	/*
+		Send attempts each email that's due and returns the
+		number sent and the number that failed. Failed emails
+		are attempted again later until they're dead.
+	*/
+	Send(reqId string) (sent, failed int, err error)
+
+	/*
+		Requeue makes the email with slug due again as though
+		it had never been attempted. It's recorded in the
+		moderator log along with the reason given.
+	*/
+	Requeue(reqId string, mod Persona, slug, reason string) error
 }
@@ -34,6 +34,7 @@ type Registration struct {
 	Email    string
 	Password string
 	Pronouns []string
+	Invite   string
 }
 
 func (r Registration) GetVisibility() string {
@@ -81,6 +82,61 @@ func (l Login) GetName() string {
 	return ""
 }
 
+// TwoFactor begins enrolling in two-factor authentication.
+type TwoFactor struct {
+	ResourceBase
+	Password string
+}
+
+func (tf TwoFactor) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (tf TwoFactor) GetName() string {
+	return ""
+}
+
+/*
+TwoFactorCode is a TOTP code, or a recovery code when logging
+in, for finishing enrollment or logging in.
+*/
+type TwoFactorCode struct {
+	ResourceBase
+	Code string
+}
+
+func (tc TwoFactorCode) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (tc TwoFactorCode) GetName() string {
+	return ""
+}
+
+type TwoFactorDisable struct {
+	ResourceBase
+	Password string
+	Code     string
+}
+
+func (td TwoFactorDisable) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (td TwoFactorDisable) GetName() string {
+	return ""
+}
+
+// NewInvite is a request for an invite code, see Invites.
+type NewInvite struct {
+	ResourceBase
+	Note string
+}
+
+func (ni NewInvite) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (ni NewInvite) GetName() string {
+	return ""
+}
+
 type Modals interface {
 	DeleteAccount(w http.ResponseWriter, r *Request)
 	Persona(w http.ResponseWriter, r *Request)
@@ -89,8 +145,17 @@ type Modals interface {
 	Register(w http.ResponseWriter, r *Request)
 	ForgotPassword(w http.ResponseWriter, r *Request)
 	Login(w http.ResponseWriter, r *Request)
+	LoginTwoFactor(w http.ResponseWriter, r *Request)
+	TwoFactor(w http.ResponseWriter, r *Request)
+	ConfirmTwoFactor(w http.ResponseWriter, r *Request)
+	DisableTwoFactor(w http.ResponseWriter, r *Request)
 	Email(w http.ResponseWriter, r *Request)
 	Password(w http.ResponseWriter, r *Request)
+	Report(w http.ResponseWriter, r *Request)
+	Invite(w http.ResponseWriter, r *Request)
+	Calendar(w http.ResponseWriter, r *Request)
+	RevokeCalendar(w http.ResponseWriter, r *Request)
 	ConfirmFull(w http.ResponseWriter, r *Request)
 	ConfirmPartial(w http.ResponseWriter, r *Request)
+	Unsubscribe(w http.ResponseWriter, r *Request)
 }
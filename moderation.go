@@ -0,0 +1,96 @@
+package storydevs
+
+import "errors"
+
+var ErrPrivilege = errors.New("insufficient privileges")
+
+/*
+Moderation carries out actions reserved for staff. Each
+method takes the persona performing the action so that it
+can be checked against the target and recorded in the
+moderator log along with the reason given. Callers are
+expected to have already checked mod holds the privilege
+the action requires.
+*/
+type Moderation interface {
+	HidePost(reqId string, mod Persona, slug string, hide bool, reason string) error
+	LockThread(reqId string, mod Persona, slug string, lock bool, reason string) error
+	PinThread(reqId string, mod Persona, slug string, pin bool, reason string) error
+
+	// HideResource is like HidePost for resources of any mode.
+	HideResource(reqId string, mod Persona, mode, slug string, hide bool, reason string) error
+
+	MutePersona(reqId string, mod Persona, slug string, mute bool, reason string) error
+	BanPersona(reqId string, mod Persona, slug string, ban bool, reason string) error
+
+	/*
+		MergeTags replaces each tag in from with to on every
+		resource of the given mode, returning the number of
+		resources affected.
+	*/
+	MergeTags(reqId string, mod Persona, mode string, from []string, to, reason string) (n int64, err error)
+
+	Grant(reqId string, mod Persona, slug, priv, reason string) error
+	Revoke(reqId string, mod Persona, slug, priv, reason string) error
+}
+
+/*
+PersonaPrivileges is what the privileges submode of the
+admin mode browses and displays. It is keyed by the slug
+of the persona it describes.
+*/
+type PersonaPrivileges struct {
+	ResourceBase
+	Muted  NullBool
+	Banned NullBool
+	Privileges
+}
+
+func (pp PersonaPrivileges) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (pp PersonaPrivileges) GetName() string {
+	return "@" + pp.PersHandle
+}
+
+/*
+Holds reports whether the persona holds priv, counting
+admins as holding every privilege.
+*/
+func (pp PersonaPrivileges) Holds(priv string) bool {
+	return pp.Admin.Bool || pp.Has(priv)
+}
+
+type PrivilegeItem struct {
+	Name string
+	Desc string
+	Held bool
+}
+
+var privDesc = map[string]string{
+	PrivApprove:    "Approve resources",
+	PrivLock:       "Lock and pin threads",
+	PrivEdit:       "Edit resources",
+	PrivHide:       "Hide resources",
+	PrivDestroy:    "Delete resources",
+	PrivMute:       "Mute personas",
+	PrivBan:        "Ban personas",
+	PrivTags:       "Manage tags",
+	PrivCategories: "Manage categories",
+	PrivLogs:       "View moderation logs",
+	PrivGivePriv:   "Give privileges",
+	PrivTakePriv:   "Take privileges",
+}
+
+// List is used in privileges.html to display each privilege.
+func (pp PersonaPrivileges) List() []PrivilegeItem {
+	ii := make([]PrivilegeItem, len(PrivNames))
+	for i, priv := range PrivNames {
+		ii[i] = PrivilegeItem{
+			Name: priv,
+			Desc: privDesc[priv],
+			Held: pp.Holds(priv),
+		}
+	}
+	return ii
+}
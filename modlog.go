@@ -0,0 +1,81 @@
+package storydevs
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+/*
+Actions recorded in the moderator log besides the flags set
+by Moderation, which are recorded by their column name, e.g.,
+"hidden" and "unhidden".
+*/
+const (
+	ModCreate     = "create"
+	ModUpdate     = "update"
+	ModDelete     = "delete"
+	ModVisibility = "visibility"
+	ModGrant      = "grant"
+	ModRevoke     = "revoke"
+	ModMergeTags  = "merge tags"
+	ModRequeue    = "requeue"
+)
+
+/*
+ModLogEntry is a single row of the moderator log. Its slug is
+its id. Before and After hold JSON snapshots of the target and
+either may be null, e.g., there's nothing before a creation.
+*/
+type ModLogEntry struct {
+	ResourceBase
+	ActorId     int64
+	ActorHandle string
+	Action      string
+	TargetKind  string
+	TargetSlug  string
+	Before      NullString
+	After       NullString
+	Reason      NullString
+}
+
+func (e ModLogEntry) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (e ModLogEntry) GetName() string {
+	return e.Action + " " + e.TargetKind
+}
+
+/*
+Indent is used in modlog.html to display snapshots. Invalid
+JSON is returned as is.
+*/
+func (e ModLogEntry) Indent(ns NullString) string {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(ns.String), "", "    "); err != nil {
+		return ns.String
+	}
+	return b.String()
+}
+
+/*
+ModLog records moderator actions. Entries can't be changed
+or removed once recorded.
+*/
+type ModLog interface {
+	Record(reqId string, e *ModLogEntry) error
+}
+
+/*
+Snapshot encodes v as JSON for a ModLogEntry. A nil v is
+recorded as null.
+*/
+func Snapshot(v interface{}) (NullString, error) {
+	if v == nil {
+		return NullString{Null: true}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return NullString{}, err
+	}
+	return NullString{String: string(b)}, nil
+}
@@ -0,0 +1,47 @@
+package storydevs
+
+import "testing"
+
+func TestSnapshot(t *testing.T) {
+
+	tests := []struct {
+		name string
+		v    interface{}
+		want NullString
+		fail bool
+	}{
+		{"nil", nil, NullString{Null: true}, false},
+		{"struct", struct{ Hidden bool }{true}, NullString{String: `{"Hidden":true}`}, false},
+		{"string", "ab", NullString{String: `"ab"`}, false},
+		{"unencodable", make(chan int), NullString{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Snapshot(tt.v)
+			if (err != nil) != tt.fail {
+				t.Fatalf("got error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{`{"a":1}`, "{\n    \"a\": 1\n}"},
+		{`[1,2]`, "[\n    1,\n    2\n]"},
+		{`{"a":`, `{"a":`},
+	}
+	for _, tt := range tests {
+		if got := (ModLogEntry{}).Indent(NullString{String: tt.in}); got != tt.want {
+			t.Errorf("Indent(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
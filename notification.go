@@ -0,0 +1,202 @@
+package storydevs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Kinds of notification.
+const (
+	NotifyReply   = "reply"
+	NotifyMention = "mention"
+	NotifyEvent   = "event"
+	NotifyReport  = "report"
+)
+
+/*
+EmailKinds lists the kinds of notification personas may choose
+to receive by email. Reports are only shown on the site.
+*/
+var EmailKinds = []string{
+	NotifyReply,
+	NotifyMention,
+	NotifyEvent,
+}
+
+/*
+How notifications of a given kind are emailed. Digests gather
+every notification since the last one.
+*/
+const (
+	DeliverOff       = "off"
+	DeliverImmediate = "immediate"
+	DeliverDaily     = "daily"
+	DeliverWeekly    = "weekly"
+)
+
+/*
+Details of event notifications that aren't sent to the event's
+owner. EventAttending tells those going or interested that it
+was updated, EventPromoted tells those who were waitlisted that
+a place opened up for them, and EventReminder reminds those going
+or interested that an occurrence of it is starting soon.
+*/
+const (
+	EventAttending = "attending"
+	EventPromoted  = "promoted"
+	EventReminder  = "reminder"
+)
+
+// UnsubscribeAll unsubscribes from every kind at once.
+const UnsubscribeAll = "all"
+
+/*
+Notification lets a persona know something happened that
+concerns them. Its slug is its id and its PersId is that of
+the recipient. Like the moderator log the actor and target are
+recorded by value so notifications outlive what they describe.
+TargetPost is set when the target is a post within the thread
+named by TargetSlug.
+*/
+type Notification struct {
+	ResourceBase
+	Kind        string
+	ActorId     NullInt64
+	ActorHandle NullString
+	TargetKind  string
+	TargetSlug  string
+	TargetName  NullString
+	TargetPost  NullString
+	Detail      NullString
+	Read        NullInt64
+}
+
+func (n Notification) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (n Notification) GetName() string {
+	return n.Text()
+}
+
+func (n Notification) Unread() bool {
+	return n.Read.Null
+}
+
+/*
+Text describes n in a sentence for the notifications submode
+of the account mode.
+*/
+func (n Notification) Text() string {
+
+	actor := "Someone"
+	if n.ActorHandle.String != "" {
+		actor = "@" + n.ActorHandle.String
+	}
+	name := "a " + n.TargetKind + " post"
+	if n.TargetName.String != "" {
+		name = fmt.Sprintf("%q", n.TargetName.String)
+	}
+
+	switch n.Kind {
+	case NotifyReply:
+		return fmt.Sprintf("%s replied to %s.", actor, name)
+	case NotifyMention:
+		return fmt.Sprintf("%s mentioned you in %s.", actor, name)
+	case NotifyEvent:
+		switch n.Detail.String {
+		case EventAttending:
+			return fmt.Sprintf("%s updated %s, an event you're attending.", actor, name)
+		case EventPromoted:
+			return fmt.Sprintf("A place opened up at %s so you're going.", name)
+		case EventReminder:
+			return fmt.Sprintf("%s, an event you're attending, starts soon.", name)
+		}
+		return fmt.Sprintf("%s updated your event %s.", actor, name)
+	case NotifyReport:
+		return fmt.Sprintf("Your report about %s was reviewed and %s.", name, n.Detail.String)
+	}
+	return fmt.Sprintf("Something happened to %s.", name)
+}
+
+/*
+TargetPath is where the subject of the notification can be
+viewed. Posts are linked to by their anchor in the thread.
+*/
+func (n Notification) TargetPath() string {
+	path := "/" + n.TargetKind + "/" + n.TargetSlug
+	if n.TargetPost.String != "" {
+		path += "#post-" + n.TargetPost.String
+	}
+	return path
+}
+
+/*
+NotificationService creates notifications and lists them for
+their recipients. Services that notify as a consequence of a
+write do so in the same transaction and don't go through Create.
+*/
+type NotificationService interface {
+
+	/*
+		Create adds each of nn. Notifications addressed to the
+		persona that caused them are skipped.
+	*/
+	Create(reqId string, nn ...Notification) error
+
+	/*
+		List returns the notifications of the persona recipient,
+		newest first, along with the cursor for the next page. If
+		unread is true read notifications are left out.
+	*/
+	List(reqId string, recipient int64, unread bool, p PageOpts) ([]Notification, string, error)
+
+	/*
+		MarkRead marks the notifications of recipient with the
+		given slugs as read. Every notification is marked if
+		none are given.
+	*/
+	MarkRead(reqId string, recipient int64, slugs ...string) error
+}
+
+/*
+Digests emails notifications to the personas who opted in to
+receiving them.
+*/
+type Digests interface {
+
+	/*
+		Send queues an email to each persona of the notifications
+		that are due according to their preferences and returns
+		the number of emails queued.
+	*/
+	Send(reqId string) (sent int, err error)
+
+	/*
+		Unsubscribe stops emails of the given kind being sent to
+		the persona with persSlug, or of every kind if kind is
+		UnsubscribeAll. It reports false if sig isn't valid.
+	*/
+	Unsubscribe(reqId, persSlug, kind, sig string) (bool, error)
+}
+
+/*
+UnsubscribeSig signs the unsubscribe link for the given persona
+and kind of notification so that it can be followed without
+logging in.
+*/
+func UnsubscribeSig(key, persSlug, kind string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(persSlug + "/" + kind))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidUnsubscribe reports whether sig was made by UnsubscribeSig.
+func ValidUnsubscribe(key, persSlug, kind, sig string) bool {
+	if key == "" {
+		return false
+	}
+	want := UnsubscribeSig(key, persSlug, kind)
+	return hmac.Equal([]byte(sig), []byte(want))
+}
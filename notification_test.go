@@ -0,0 +1,87 @@
+package storydevs
+
+import "testing"
+
+func TestNotificationText(t *testing.T) {
+
+	str := func(s string) NullString { return NullString{String: s} }
+
+	tests := []struct {
+		name string
+		n    Notification
+		want string
+	}{
+		{
+			"reply",
+			Notification{Kind: NotifyReply, ActorHandle: str("ada"), TargetKind: "forum", TargetName: str("Hello")},
+			`@ada replied to "Hello".`,
+		},
+		{
+			"mention without a name",
+			Notification{Kind: NotifyMention, ActorHandle: str("ada"), TargetKind: "forum"},
+			"@ada mentioned you in a forum post.",
+		},
+		{
+			"event without an actor",
+			Notification{Kind: NotifyEvent, TargetKind: "event", TargetName: str("Jam")},
+			`Someone updated your event "Jam".`,
+		},
+		{
+			"event attending",
+			Notification{Kind: NotifyEvent, ActorHandle: str("ada"), TargetName: str("Jam"), Detail: str(EventAttending)},
+			`@ada updated "Jam", an event you're attending.`,
+		},
+		{
+			"event promoted",
+			Notification{Kind: NotifyEvent, ActorHandle: str("ada"), TargetName: str("Jam"), Detail: str(EventPromoted)},
+			`A place opened up at "Jam" so you're going.`,
+		},
+		{
+			"event reminder",
+			Notification{Kind: NotifyEvent, TargetName: str("Jam"), Detail: str(EventReminder)},
+			`"Jam", an event you're attending, starts soon.`,
+		},
+		{
+			"report",
+			Notification{Kind: NotifyReport, TargetName: str("Spam"), Detail: str("removed")},
+			`Your report about "Spam" was reviewed and removed.`,
+		},
+		{
+			"unknown kind",
+			Notification{Kind: "nope", TargetKind: "event"},
+			"Something happened to a event post.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.n.Text(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetPath(t *testing.T) {
+	tests := []struct {
+		n    Notification
+		want string
+	}{
+		{Notification{TargetKind: "event", TargetSlug: "abc"}, "/event/abc"},
+		{Notification{TargetKind: "forum", TargetSlug: "abc", TargetPost: NullString{String: "7"}}, "/forum/abc#post-7"},
+	}
+	for _, tt := range tests {
+		if got := tt.n.TargetPath(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestUnread(t *testing.T) {
+	if !(Notification{Read: NullInt64{Null: true}}).Unread() {
+		t.Error("notification without a read time is read")
+	}
+	if (Notification{Read: NullInt64{Int64: 1588000000}}).Unread() {
+		t.Error("notification with a read time is unread")
+	}
+}
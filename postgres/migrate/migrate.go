@@ -0,0 +1,367 @@
+/*
+Package migrate applies numbered schema migrations to the
+database. Each migration is a pair of files in a directory:
+
+	0001_init.up.sql
+	0001_init.down.sql
+
+Versions are applied in ascending order and reverted in
+descending order. Every migration runs in its own transaction
+alongside the row recording it in schema_migrations, so a
+failing migration leaves the schema as it was.
+*/
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+Arbitrary key passed to pg_advisory_xact_lock so that servers
+starting at the same time don't race to apply migrations.
+*/
+const lockKey = 7146325
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version int
+	Name    string
+	Applied int64 // Unix time, zero if pending.
+}
+
+type Migrator struct {
+	Db         sd.DB
+	Migrations []Migration
+
+	/*
+		When DryRun is true migrations are written to Out
+		instead of being executed.
+	*/
+	DryRun bool
+	Out    io.Writer
+}
+
+/*
+Load reads every migration in dir and returns them sorted by
+version. Each version must have both an up and down file.
+*/
+func Load(dir string) ([]Migration, error) {
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	ff, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, info := range ff {
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		m := fileName.FindStringSubmatch(info.Name())
+		if m == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, err
+		}
+		if version < 1 {
+			return nil, fmt.Errorf("migration %q must have a version of at least 1", info.Name())
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mg
+		}
+		if mg.Name != m[2] {
+			return nil, fmt.Errorf("migration version %d has conflicting names %q and %q", version, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.Up = string(b)
+		} else {
+			mg.Down = string(b)
+		}
+	}
+
+	var mm []Migration
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both an up and down file", mg.Version, mg.Name)
+		}
+		mm = append(mm, *mg)
+	}
+	sort.Slice(mm, func(i, j int) bool {
+		return mm[i].Version < mm[j].Version
+	})
+
+	return mm, nil
+}
+
+/*
+Init creates the schema_migrations table if it doesn't
+exist. It is safe to call more than once.
+*/
+func (m *Migrator) Init() error {
+	_, err := m.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version  int     PRIMARY KEY,
+			name     text    NOT NULL,
+			applied  bigint  NOT NULL
+		)`)
+	return err
+}
+
+/*
+Status returns every known migration, applied or not, along
+with any applied versions that no longer have files on disk.
+*/
+func (m *Migrator) Status() ([]Status, error) {
+
+	/*
+		A dry run shouldn't write anything, including the
+		table itself, so we treat it as empty if missing.
+	*/
+	if m.DryRun {
+		var exists bool
+		err := m.Db.Get(&exists, `SELECT to_regclass('schema_migrations') IS NOT NULL`)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return m.merge(nil), nil
+		}
+	} else if err := m.Init(); err != nil {
+		return nil, err
+	}
+
+	var applied []Status
+	err := m.Db.Select(&applied, `
+		SELECT
+			version,
+			name,
+			applied
+		FROM
+			schema_migrations
+		ORDER BY
+			version ASC`)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.merge(applied), nil
+}
+
+func (m *Migrator) merge(applied []Status) []Status {
+
+	seen := make(map[int]Status)
+	for _, s := range applied {
+		seen[s.Version] = s
+	}
+
+	var ss []Status
+	for _, mg := range m.Migrations {
+		s := Status{Version: mg.Version, Name: mg.Name}
+		if a, ok := seen[mg.Version]; ok {
+			s.Applied = a.Applied
+			delete(seen, mg.Version)
+		}
+		ss = append(ss, s)
+	}
+	for _, s := range seen {
+		ss = append(ss, s)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		return ss[i].Version < ss[j].Version
+	})
+
+	return ss
+}
+
+/*
+Up applies pending migrations in ascending order up to and
+including target. A target of zero applies all of them. It
+returns the versions that were applied (or would have been
+if DryRun is set).
+*/
+func (m *Migrator) Up(target int) (done []int, err error) {
+
+	ss, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range ss {
+		if target > 0 && s.Version > target {
+			break
+		}
+		if s.Applied != 0 {
+			continue
+		}
+		mg, ok := m.find(s.Version)
+		if !ok {
+			continue
+		}
+		if err := m.run(mg, true); err != nil {
+			return done, err
+		}
+		done = append(done, s.Version)
+	}
+
+	return done, nil
+}
+
+/*
+Down reverts the given number of applied migrations in
+descending order. It returns the versions that were
+reverted (or would have been if DryRun is set).
+*/
+func (m *Migrator) Down(steps int) (done []int, err error) {
+
+	ss, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(ss) - 1; i >= 0 && len(done) < steps; i-- {
+		if ss[i].Applied == 0 {
+			continue
+		}
+		mg, ok := m.find(ss[i].Version)
+		if !ok {
+			return done, fmt.Errorf(
+				"migration %04d_%s is applied but has no files to revert it with",
+				ss[i].Version, ss[i].Name)
+		}
+		if err := m.run(mg, false); err != nil {
+			return done, err
+		}
+		done = append(done, mg.Version)
+	}
+
+	return done, nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, mg := range m.Migrations {
+		if mg.Version == version {
+			return mg, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) run(mg Migration, up bool) error {
+
+	direction := "down"
+	script := mg.Down
+	if up {
+		direction = "up"
+		script = mg.Up
+	}
+
+	if m.DryRun {
+		if m.Out == nil {
+			return errors.New("dry run requires an output writer")
+		}
+		_, err := fmt.Fprintf(m.Out, "-- %04d_%s.%s.sql\n%s\n", mg.Version, mg.Name, direction, script)
+		return err
+	}
+
+	tx, err := m.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+		return tx.Rollback(err)
+	}
+
+	/*
+		Another process may have applied or reverted this
+		migration while we were waiting on the lock. Since
+		the transaction's snapshot is taken before the lock
+		is acquired this check won't always see that. When
+		it doesn't, recording the version below fails with
+		a unique or serialization error, which the caller
+		can retry.
+	*/
+	applied, err := tx.Exists(`
+		FROM
+			schema_migrations
+		WHERE
+			version = $1`,
+		mg.Version)
+	if err != nil {
+		return tx.Rollback(err)
+	}
+	if applied == up {
+		return tx.Rollback(nil)
+	}
+
+	/*
+		The script is executed without arguments so that
+		it's sent using the simple query protocol, which
+		permits multiple statements in the one string.
+	*/
+	if _, err = tx.Exec(script); err != nil {
+		err = fmt.Errorf("migration %04d_%s (%s): %w", mg.Version, mg.Name, direction, err)
+		return tx.Rollback(err)
+	}
+
+	if up {
+		_, err = tx.Exec(`
+			INSERT INTO schema_migrations (
+				version,
+				name,
+				applied
+			) VALUES ($1, $2, $3)`,
+			mg.Version,
+			mg.Name,
+			time.Now().Unix(),
+		)
+	} else {
+		_, err = tx.Exec(`
+			DELETE FROM
+				schema_migrations
+			WHERE
+				version = $1`,
+			mg.Version,
+		)
+	}
+	if err != nil {
+		return tx.Rollback(err)
+	}
+
+	return tx.Commit()
+}
@@ -137,6 +137,27 @@ redeemHandle:
 	return nil
 }
 
+/*
+personasFull reports whether accId already has as many personas
+as Config.MaxPersonas allows. Deleted personas don't count.
+*/
+func (as *Account) personasFull(tx sd.Tx, accId int64) (bool, error) {
+	var count int
+	err := tx.Get(&count, `
+		SELECT
+			COUNT(*)
+		FROM
+			personas
+		WHERE
+			acc_id = $1 AND
+			deleted IS NULL`,
+		accId)
+	if err != nil {
+		return false, err
+	}
+	return count >= as.Config.MaxPersonas, nil
+}
+
 func (as *Account) NewPersona(
 	reqId string,
 	accId int64,
@@ -160,22 +181,11 @@ func (as *Account) NewPersona(
 			return err
 		}
 
-		var count int
-		err = tx.Get(&count, `
-			SELECT
-				COUNT(*)
-			FROM
-				accounts,
-				personas
-			WHERE
-				accounts.id = $1 AND
-				accounts.id = personas.acc_id AND
-				personas.deleted IS NULL`,
-			accId)
+		full, err := as.personasFull(tx, accId)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-		if count >= as.Config.MaxPersonas {
+		if full {
 			msg := "account already has maximum allowed personas"
 			return tx.Rollback(errors.New(msg))
 		}
@@ -304,6 +314,18 @@ func (as *Account) Create(
 		admin = func(b bool) *bool { return &b }(true)
 	}
 
+	// The first account is made at start up without an invite.
+	gate := as.Config.Invite.Gate()
+	if isAdmin {
+		gate = sd.InviteOff
+	}
+	invite := strings.TrimSpace(r.Invite)
+	if gate == sd.InviteRequired && invite == "" {
+		fb.Add("invite", fbInviteRequired)
+		return fb, nil
+	}
+	var inviteId int64
+
 	errs, err := as.TryerTx.Try(func() error {
 
 		tx, err := as.Db.Begin()
@@ -320,6 +342,23 @@ func (as *Account) Create(
 		}
 
 		now := time.Now().Unix()
+
+		/*
+			The invite is used up in the same transaction as the
+			account is made so that it's given back if anything
+			after this fails.
+		*/
+		inviteId = 0
+		if gate != sd.InviteOff && invite != "" {
+			inviteId, err = redeemInvite(tx, invite, now)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+			if inviteId == 0 {
+				fb.Add("invite", fbInviteInvalid)
+				return tx.Rollback(nil)
+			}
+		}
 		if code == nil && confirmManual == sd.ConfirmManual {
 			c, err := newCode()
 			if err != nil {
@@ -341,6 +380,12 @@ func (as *Account) Create(
 			}
 		}
 
+		var codeHash *string
+		if code != nil {
+			h := sd.HashToken(*code)
+			codeHash = &h
+		}
+
 		err = tx.Get(&accId, `
 			INSERT INTO accounts (
 				email,
@@ -357,7 +402,7 @@ func (as *Account) Create(
 			hash,
 			now,
 			now,
-			code,
+			codeHash,
 		)
 		if err != nil {
 			return tx.Rollback(err)
@@ -402,6 +447,31 @@ func (as *Account) Create(
 			}
 		}
 
+		if inviteId != 0 {
+			_, err = tx.Exec(`
+				INSERT INTO invite_redemptions (
+					invite_id,
+					acc_id,
+					redeemed
+				)
+				VALUES
+					($1, $2, $3)`,
+				inviteId,
+				accId,
+				now)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		if code != nil {
+			subject := "Confirm your StoryDevs account"
+			err := as.queueConfirmationEmail(tx, "register", email, subject, *code)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
 		return tx.Commit()
 	})
 
@@ -420,30 +490,6 @@ func (as *Account) Create(
 		return fb, nil
 	}
 
-	if confirmManual == sd.ConfirmManual {
-		errs, err = as.TryerEmail.Try(func() error {
-			subject := "Confirm your StoryDevs account"
-			c := ""
-			if code != nil {
-				c = *code
-			}
-			return as.sendConfirmationEmail("register", email, subject, c)
-		})
-		if err != nil {
-			log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
-				Data(sd.LK_RetryAttemptsEmail, len(errs)).
-				Data(sd.LK_AccId, accId).
-				Data(sd.LK_AccEmail, email).
-				Data(sd.LK_PersId, pId).
-				Data(sd.LK_PersSlug, pSlug).
-				Data(sd.LK_PersHandle, handle)
-			if err := as.Delete(reqId, accId); err != nil {
-				return nil, err
-			}
-			return nil, errors.New("Unable to send account confirmation email.")
-		}
-	}
-
 	logEntry := log.Info(reqId, "Created account.").
 		Data(sd.LK_AccId, accId).
 		Data(sd.LK_AccEmail, email).
@@ -453,6 +499,9 @@ func (as *Account) Create(
 	if code != nil {
 		logEntry.Data(sd.LK_AccCode, *code)
 	}
+	if inviteId != 0 {
+		logEntry.Data(sd.LK_InviteId, inviteId)
+	}
 
 	return fb, nil
 }
@@ -533,12 +582,18 @@ func (as *Account) ForgotPassword(
 				($1, $2, $3)`,
 			acc.Id,
 			hash,
-			code,
+			sd.HashToken(code),
 		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
+		subject := "Forgotten Password on StoryDevs"
+		err = as.queueConfirmationEmail(tx, "forgot", acc.Email, subject, code)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
 		return tx.Commit()
 	})
 	if err != nil {
@@ -551,19 +606,7 @@ func (as *Account) ForgotPassword(
 		return nil, errors.New("Unable to complete forgot password transaction.")
 	}
 
-	errs, err = as.TryerEmail.Try(func() error {
-		subject := "Forgotten Password on StoryDevs"
-		return as.sendConfirmationEmail("forgot", acc.Email, subject, code)
-	})
-	if err != nil {
-		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
-			Data(sd.LK_RetryAttemptsEmail, len(errs)).
-			Data(sd.LK_AccId, acc.Id).
-			Data(sd.LK_AccEmail, acc.Email)
-		return nil, errors.New("Unable to send forgot password email.")
-	}
-
-	log.Info(reqId, "Sent forgotten password email.").
+	log.Info(reqId, "Queued forgotten password email.").
 		Data(sd.LK_AccId, acc.Id).
 		Data(sd.LK_AccEmail, acc.Email).
 		Data(sd.LK_AccForgotCode, code)
@@ -636,7 +679,7 @@ func (as *Account) ChangeEmail(
 		if err != nil {
 			return tx.Rollback(err)
 		}
-		ok, err := as.Password.Compare(change.Password, current.Pass)
+		ok, _, err := as.Password.Compare(change.Password, current.Pass)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -660,12 +703,18 @@ func (as *Account) ChangeEmail(
 				($1, $2, $3)`,
 			accId,
 			change.New,
-			code,
+			sd.HashToken(code),
 		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
+		subject := "Confirm your new address"
+		err = as.queueConfirmationEmail(tx, "email", change.New, subject, code)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
 		return tx.Commit()
 	})
 
@@ -680,16 +729,6 @@ func (as *Account) ChangeEmail(
 		return nil, errors.New("Unable to add email change entry.")
 	}
 
-	subject := "Confirm your new address"
-	err = as.sendConfirmationEmail("email", change.New, subject, code)
-	if err != nil {
-		log.Error(reqId, err.Error()).
-			Data(sd.LK_AccId, accId).
-			Data(sd.LK_AccEmail, current.Email).
-			Data(sd.LK_AccEmailNew, change.New)
-		return nil, errors.New("Unable to send change email confirmation email.")
-	}
-
 	log.Info(reqId, "Created change email entry.").
 		Data(sd.LK_AccId, accId).
 		Data(sd.LK_AccEmail, current.Email).
@@ -701,6 +740,8 @@ func (as *Account) ChangeEmail(
 
 func (as *Account) ConfirmEmail(reqId, code string) (bool, error) {
 
+	codeHash := sd.HashToken(code)
+
 	data := struct {
 		Id       int64
 		OldEmail string
@@ -725,7 +766,7 @@ func (as *Account) ConfirmEmail(reqId, code string) (bool, error) {
 			WHERE
 				accounts.id = change_email.ref_id AND
 				change_email.code = $1
-			`, code)
+			`, codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -740,7 +781,7 @@ func (as *Account) ConfirmEmail(reqId, code string) (bool, error) {
 			WHERE
 				accounts.id = change_email.ref_id AND
 				change_email.code = $1
-			`, code)
+			`, codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -779,6 +820,7 @@ func (as *Account) ChangePassword(
 	reqId string,
 	change *sd.ChangePassword,
 	accId int64,
+	sessionId int64,
 ) (sd.Feedback, error) {
 
 	log := as.Logger
@@ -820,7 +862,7 @@ func (as *Account) ChangePassword(
 		if err != nil {
 			return tx.Rollback(err)
 		}
-		ok, err := as.Password.Compare(change.Password, current.Pass)
+		ok, _, err := as.Password.Compare(change.Password, current.Pass)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -839,22 +881,34 @@ func (as *Account) ChangePassword(
 		if err != nil {
 			return tx.Rollback(err)
 		}
+		var loginId interface{}
+		if sessionId != 0 {
+			loginId = sessionId
+		}
 		_, err = tx.Exec(`
 			INSERT INTO change_password (
 				ref_id,
 				pass,
-				code
+				code,
+				login_id
 			)
 			VALUES
-				($1, $2, $3)`,
+				($1, $2, $3, $4)`,
 			accId,
 			newHash,
-			code,
+			sd.HashToken(code),
+			loginId,
 		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
+		subject := "Confirm your new password"
+		err = as.queueConfirmationEmail(tx, "password", current.Email, subject, code)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
 		return tx.Commit()
 	})
 
@@ -869,15 +923,6 @@ func (as *Account) ChangePassword(
 		return nil, errors.New("Unable to add password change entry.")
 	}
 
-	subject := "Confirm your new password"
-	err = as.sendConfirmationEmail("password", current.Email, subject, code)
-	if err != nil {
-		log.Error(reqId, err.Error()).
-			Data(sd.LK_AccId, accId).
-			Data(sd.LK_AccEmail, current.Email)
-		return nil, errors.New("Unable to send change password confirmation email.")
-	}
-
 	log.Info(reqId, "Created change password entry.").
 		Data(sd.LK_AccId, accId).
 		Data(sd.LK_AccEmail, current.Email).
@@ -888,10 +933,14 @@ func (as *Account) ChangePassword(
 
 func (as *Account) ConfirmPassword(reqId, code string) (bool, error) {
 
+	codeHash := sd.HashToken(code)
+
 	data := struct {
-		Id    int64
-		Email string
+		Id      int64
+		Email   string
+		LoginId sd.NullInt64
 	}{}
+	var signedOut int64
 
 	errs, err := as.TryerTx.Try(func() error {
 
@@ -912,8 +961,34 @@ func (as *Account) ConfirmPassword(reqId, code string) (bool, error) {
 				change_password.code = $1
 			RETURNING
 				accounts.id,
-				accounts.email`,
-			code)
+				accounts.email,
+				change_password.login_id AS loginid`,
+			codeHash)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		/*
+			Anyone logged in with the old password is signed
+			out except for the login the change was requested
+			from, if it's still around.
+		*/
+		var keep interface{}
+		if !data.LoginId.Null {
+			keep = data.LoginId.Int64
+		}
+		result, err := tx.Exec(`
+			DELETE FROM
+				logins
+			WHERE
+				acc_id = $1 AND
+				id IS DISTINCT FROM $2`,
+			data.Id,
+			keep)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		signedOut, err = result.RowsAffected()
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -942,7 +1017,8 @@ func (as *Account) ConfirmPassword(reqId, code string) (bool, error) {
 
 	log.Info(reqId, "Changed password.").
 		Data(sd.LK_AccId, data.Id).
-		Data(sd.LK_AccEmail, data.Email)
+		Data(sd.LK_AccEmail, data.Email).
+		Data(sd.LK_Sessions, signedOut)
 
 	return true, nil
 }
@@ -991,12 +1067,18 @@ func (as *Account) Mailing(reqId, email string) (sd.Feedback, error) {
 				($1, $2)
 			RETURNING
 				id`,
-			email, code,
+			email, sd.HashToken(code),
 		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
+		subject := "Confirm your StoryDevs mailing list subscription"
+		err = as.queueConfirmationEmail(tx, "mailing", email, subject, code)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
 		return tx.Commit()
 	})
 
@@ -1010,18 +1092,6 @@ func (as *Account) Mailing(reqId, email string) (sd.Feedback, error) {
 		return fb, nil
 	}
 
-	errs, err = as.TryerEmail.Try(func() error {
-		subject := "Confirm your StoryDevs mailing list subscription"
-		return as.sendConfirmationEmail("mailing", email, subject, code)
-	})
-	if err != nil {
-		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
-			Data(sd.LK_RetryAttemptsEmail, len(errs)).
-			Data(sd.LK_SubscriptionId, id).
-			Data(sd.LK_SubscriptionEmail, email)
-		return nil, errors.New("Unable to send mailing list confirmation email.")
-	}
-
 	log.Info(reqId, "Created subscription.").
 		Data(sd.LK_SubscriptionId, id).
 		Data(sd.LK_SubscriptionEmail, email).
@@ -1070,12 +1140,18 @@ func (as *Account) Reserve(reqId, handle, email string) (sd.Feedback, error) {
 				id`,
 			handle,
 			email,
-			code,
+			sd.HashToken(code),
 		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
+		subject := "Confirm your StoryDevs handle"
+		err = as.queueConfirmationEmail(tx, "reserve", email, subject, code)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
 		return tx.Commit()
 	})
 
@@ -1090,19 +1166,6 @@ func (as *Account) Reserve(reqId, handle, email string) (sd.Feedback, error) {
 		return fb, nil
 	}
 
-	errs, err = as.TryerEmail.Try(func() error {
-		subject := "Confirm your StoryDevs handle"
-		return as.sendConfirmationEmail("reserve", email, subject, code)
-	})
-	if err != nil {
-		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
-			Data(sd.LK_RetryAttemptsEmail, len(errs)).
-			Data(sd.LK_ReserveId, id).
-			Data(sd.LK_ReserveHandle, handle).
-			Data(sd.LK_ReserveEmail, email)
-		return nil, errors.New("Unable to send mailing list confirmation email.")
-	}
-
 	log.Info(reqId, "Created reservation.").
 		Data(sd.LK_ReserveId, id).
 		Data(sd.LK_ReserveHandle, handle).
@@ -1114,6 +1177,8 @@ func (as *Account) Reserve(reqId, handle, email string) (sd.Feedback, error) {
 
 func (as *Account) ConfirmSubscription(reqId, code string) (bool, error) {
 
+	codeHash := sd.HashToken(code)
+
 	tmp := struct {
 		Id    int64
 		Email string
@@ -1134,7 +1199,7 @@ func (as *Account) ConfirmSubscription(reqId, code string) (bool, error) {
 				mailing
 			WHERE
 				code = $1`,
-			code)
+			codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1146,7 +1211,7 @@ func (as *Account) ConfirmSubscription(reqId, code string) (bool, error) {
 				code = NULL
 			WHERE
 				code = $1`,
-			code)
+			codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1175,6 +1240,8 @@ func (as *Account) ConfirmSubscription(reqId, code string) (bool, error) {
 
 func (as *Account) ConfirmReservation(reqId, code string) (bool, error) {
 
+	codeHash := sd.HashToken(code)
+
 	tmp := struct {
 		Id     string
 		Handle string
@@ -1197,7 +1264,7 @@ func (as *Account) ConfirmReservation(reqId, code string) (bool, error) {
 				reserved
 			WHERE
 				code = $1`,
-			code)
+			codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1209,7 +1276,7 @@ func (as *Account) ConfirmReservation(reqId, code string) (bool, error) {
 				code = NULL
 			WHERE
 				code = $1`,
-			code)
+			codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1246,6 +1313,8 @@ func lastErrSqlNoRows(errs []error) bool {
 
 func (as *Account) ConfirmAccount(reqId, code string) (bool, error) {
 
+	codeHash := sd.HashToken(code)
+
 	tmp := struct {
 		AccId  string
 		PId    string
@@ -1272,7 +1341,7 @@ func (as *Account) ConfirmAccount(reqId, code string) (bool, error) {
 			WHERE
 				accounts.code = $1 AND
 				accounts.id = personas.acc_id`,
-			code)
+			codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1298,7 +1367,7 @@ func (as *Account) ConfirmAccount(reqId, code string) (bool, error) {
 				code = NULL
 			WHERE
 				code = $1`,
-			code)
+			codeHash)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1365,6 +1434,17 @@ func (as *Account) Delete(reqId string, id int64) error {
 			return tx.Rollback(err)
 		}
 
+		// Identities may be used to sign up again.
+		_, err = tx.Exec(`
+			DELETE FROM
+				identities
+			WHERE
+				acc_id = $1`,
+			id)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
 		return tx.Commit()
 	})
 
@@ -1480,7 +1560,7 @@ func (as *Account) DeletePersona(reqId, token, slug string, accId int64) (newDef
 				logins.token  = $1 AND
 				logins.p_id   = personas.id AND
 				personas.slug = $2`,
-			token, slug, accId)
+			sd.HashToken(token), slug, accId)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1507,7 +1587,7 @@ func (as *Account) DeletePersona(reqId, token, slug string, accId int64) (newDef
 			WHERE
 				logins.token = $1 AND
 				logins.p_id = personas.id`,
-			token)
+			sd.HashToken(token))
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1557,7 +1637,7 @@ func (as *Account) Switch(reqId, token, slug string) error {
 				p_id = $1
 			WHERE
 				token = $2`,
-			pId, token)
+			pId, sd.HashToken(token))
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1576,11 +1656,17 @@ func (as *Account) Switch(reqId, token, slug string) error {
 	return nil
 }
 
-func (as *Account) Login(reqId, identity, pass string) (*sd.AuthedUser, error) {
+/*
+Login returns feedback rather than an error when identity and
+pass don't match an account or when too many failed attempts
+have been made for the account or from ip recently.
+*/
+func (as *Account) Login(reqId, identity, ip, userAgent, pass string) (*sd.AuthedUser, sd.Feedback, error) {
 
 	var account *sd.Account
 	var err error
 	var isEmail = strings.Contains(identity, "@")
+	fb := make(sd.Feedback)
 
 	o := sd.AccOptRetrieve{
 		Confirmed: true,
@@ -1592,20 +1678,116 @@ func (as *Account) Login(reqId, identity, pass string) (*sd.AuthedUser, error) {
 		account, err = as.RetrieveByHandle(reqId, identity, o)
 	}
 	if err == sql.ErrNoRows {
-		return nil, sd.ErrInvalidLoginAttempt
+		account = nil
+	} else if err != nil {
+		return nil, nil, err
 	}
+
+	keys := as.loginKeys(account, identity, ip)
+	wait, err := as.loginWait(reqId, keys)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if wait > 0 {
+		as.Logger.Info(reqId, "Throttled log in attempt.").
+			Data(sd.LK_IP, ip).
+			Data(sd.LK_LoginWait, wait.String())
+		fb.Add("general", fmt.Sprintf(fbLoginWait, loginWaitText(wait)))
+		return nil, fb, nil
 	}
 
-	ok, err := as.Password.Compare(pass, account.Pass)
-	if err != nil {
-		return nil, err
+	var ok, rehash bool
+	if account != nil {
+		ok, rehash, err = as.Password.Compare(pass, account.Pass)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 	if !ok {
-		return nil, sd.ErrInvalidLoginAttempt
+		if err := as.loginFailed(reqId, ip, keys); err != nil {
+			return nil, nil, err
+		}
+		fb.Add("general", fbLoginInvalid)
+		return nil, fb, nil
+	}
+	if rehash {
+		as.rehashPassword(reqId, account, pass)
+	}
+
+	if account.TwoFactor {
+		challenge, err := as.loginChallenge(reqId, account.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &sd.AuthedUser{
+			Challenge: challenge,
+			Account:   account,
+		}, nil, nil
+	}
+
+	auth, err := as.createLogin(reqId, account, keys, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return auth, nil, nil
+}
+
+/*
+rehashPassword replaces the password hash of account, whose
+password was just confirmed to be pass, with one made by the
+configured algorithm and parameters. It's not an error for this
+to fail; the old hash keeps working and it's tried again next
+time. The hash is only replaced if it hasn't changed meanwhile.
+*/
+func (as *Account) rehashPassword(reqId string, account *sd.Account, pass string) {
+
+	hash, err := as.Password.Hash(pass)
+	if err != nil {
+		as.Logger.Error(reqId, err.Error()).
+			Data(sd.LK_AccId, account.Id)
+		return
+	}
+
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE
+				accounts
+			SET
+				pass = $1
+			WHERE
+				id = $2 AND
+				pass = $3`,
+			hash,
+			account.Id,
+			account.Pass)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, account.Id)
+		return
 	}
 
+	as.Logger.Info(reqId, "Upgraded password hash.").
+		Data(sd.LK_AccId, account.Id)
+}
+
+/*
+createLogin logs account in once its password, and code if it
+uses two-factor authentication, have been checked. Failed
+attempts counted against the account are forgotten.
+*/
+func (as *Account) createLogin(reqId string, account *sd.Account, keys []loginKey, ip, userAgent string) (*sd.AuthedUser, error) {
+
 	activePersona := account.ActivePersona()
 
 	token, err := newToken()
@@ -1620,16 +1802,20 @@ func (as *Account) Login(reqId, identity, pass string) (*sd.AuthedUser, error) {
 			return err
 		}
 
+		now := time.Now().Unix()
 		result, err := tx.Exec(`
 			INSERT INTO logins (
 				acc_id,
 				p_id,
 				token,
-				since
+				since,
+				last_seen,
+				user_agent,
+				ip
 			)
 			VALUES
-				($1, $2, $3, $4)`,
-			account.Id, activePersona.Id, token, time.Now().Unix())
+				($1, $2, $3, $4, $4, $5, $6)`,
+			account.Id, activePersona.Id, sd.HashToken(token), now, userAgent, ip)
 		if err != nil {
 			return tx.Rollback(err)
 		}
@@ -1641,6 +1827,39 @@ func (as *Account) Login(reqId, identity, pass string) (*sd.AuthedUser, error) {
 			return tx.Rollback(errors.New("unable to insert into logins"))
 		}
 
+		// Expired logins are cleared out when logging in.
+		began, seen := as.Config.Session.Cutoffs(time.Now())
+		_, err = tx.Exec(`
+			DELETE FROM
+				logins
+			WHERE
+				acc_id = $1 AND (
+					since <= $2 OR
+					last_seen <= $3
+				)`,
+			account.Id,
+			began,
+			seen)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		// Failures from the IP address aren't forgotten.
+		for _, k := range keys {
+			if k.acc == nil {
+				continue
+			}
+			_, err = tx.Exec(`
+				DELETE FROM
+					login_failures
+				WHERE
+					key = $1`,
+				k.key)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
 		if err := tx.Commit(); err != nil {
 			return err
 		}
@@ -1654,6 +1873,7 @@ func (as *Account) Login(reqId, identity, pass string) (*sd.AuthedUser, error) {
 	}
 
 	as.Logger.Info(reqId, "Logged in user.").
+		Data(sd.LK_IP, ip).
 		Data(sd.LK_AccId, account.Id).
 		Data(sd.LK_AccEmail, account.Email).
 		Data(sd.LK_PersId, activePersona.Id).
@@ -1669,6 +1889,7 @@ func (as *Account) Logout(reqId, token string) (ok bool, err error) {
 
 	log := as.Logger
 	db := as.Db
+	hash := sd.HashToken(token)
 
 	user := struct {
 		AccId  int64
@@ -1689,12 +1910,12 @@ func (as *Account) Logout(reqId, token string) (ok bool, err error) {
 			token=$1 AND
 			logins.acc_id=accounts.id AND
 			logins.p_id=personas.id`,
-		token)
+		hash)
 	if err != nil {
 		return false, err
 	}
 
-	_, err = db.Exec(`DELETE FROM logins WHERE token=$1`, token)
+	_, err = db.Exec(`DELETE FROM logins WHERE token=$1`, hash)
 	if err != nil {
 		return false, err
 	}
@@ -1708,7 +1929,31 @@ func (as *Account) Logout(reqId, token string) (ok bool, err error) {
 }
 
 func (as *Account) RetrieveByToken(reqId, token string, o sd.AccOptRetrieve) (*sd.Account, error) {
-	return as.retrieve(reqId, "token", token, o)
+	acc, err := as.retrieve(reqId, "token", sd.HashToken(token), o)
+	if err != nil {
+		return nil, err
+	}
+	/*
+		Logins are only marked as seen once a minute so that
+		every request doesn't result in a write.
+	*/
+	now := time.Now()
+	_, err = as.Db.Exec(`
+		UPDATE
+			logins
+		SET
+			last_seen = $2
+		WHERE
+			id = $1 AND
+			last_seen < $3`,
+		acc.SessionId,
+		now.Unix(),
+		now.Add(-time.Minute).Unix())
+	if err != nil {
+		as.Logger.Error(reqId, err.Error()).
+			Data(sd.LK_SessionId, acc.SessionId)
+	}
+	return acc, nil
 }
 
 func (as *Account) RetrieveById(reqId string, id int64, o sd.AccOptRetrieve) (*sd.Account, error) {
@@ -1733,15 +1978,20 @@ func (as *Account) retrieve(reqId, kind string, arg interface{}, o sd.AccOptRetr
 	fromWhere := ""
 	switch kind {
 	case "token":
+		began, seen := as.Config.Session.Cutoffs(time.Now())
 		fromWhere = fmt.Sprintf(`,
-				logins.p_id
+				logins.p_id,
+				logins.id AS sessionid
 			FROM
 				logins,
 				accounts
 			WHERE
 				logins.token = $1 AND
-				logins.acc_id = accounts.id
+				logins.acc_id = accounts.id AND
+				logins.since > %d AND
+				logins.last_seen > %d
 				%s`,
+			began, seen,
 			deleted)
 	case "id":
 		fromWhere = fmt.Sprintf(`
@@ -1801,7 +2051,8 @@ func (as *Account) retrieve(reqId, kind string, arg interface{}, o sd.AccOptRetr
 				
 				accounts.id,
 				accounts.email,
-				accounts.created
+				accounts.created,
+				accounts.totp_enabled IS NOT NULL AS twofactor
 				
 				%s
 			`, selectPass, fromWhere), arg)
@@ -1814,28 +2065,45 @@ func (as *Account) retrieve(reqId, kind string, arg interface{}, o sd.AccOptRetr
 
 		deleted := ""
 		if !o.DeletedPersona {
-			deleted += ` AND deleted IS NULL`
+			deleted += ` AND personas.deleted IS NULL`
 		}
 
 		err = tx.Select(&personas, fmt.Sprintf(`
 			SELECT
-				id,
-				handle,
-				name,
-				created,
-				default_p,
-				slug,
-				avatar,
-				deleted,
-				visibility,
-				admin
+				personas.id,
+				personas.handle,
+				personas.name,
+				personas.created,
+				personas.default_p,
+				personas.slug,
+				personas.avatar,
+				personas.deleted,
+				personas.visibility,
+				personas.admin,
+				personas.muted,
+				personas.banned,
+				(
+					SELECT
+						count(*)
+					FROM
+						notifications
+					WHERE
+						recipient = personas.id AND
+						read IS NULL
+				) AS unread,
+				personas.email_reply,
+				personas.email_mention,
+				personas.email_event,
+				%s
 			FROM
 				personas
+			LEFT JOIN
+				privileges ON privileges.ref_id = personas.id
 			WHERE
-				acc_id=$1
+				personas.acc_id=$1
 				%s
 			ORDER BY
-				created ASC`, deleted),
+				personas.created ASC`, privColumns, deleted),
 			tmp.Account.Id)
 		if err != nil {
 			return tx.Rollback(err)
@@ -1904,23 +2172,22 @@ func borderedBySpace(s string) bool {
 	return false
 }
 
-func (as *Account) sendConfirmationEmail(kind, email, subject, code string) error {
-
-	var url string
-	if as.Config.Dev {
-		url = "http://localhost:" + as.Config.Port
-	} else {
-		url = "https://storydevs.com"
+/*
+queueConfirmationEmail adds the email containing code to the
+outbox within tx so that it's only sent if tx commits.
+*/
+func (as *Account) queueConfirmationEmail(tx sd.Tx, kind, email, subject, code string) error {
+	e, err := renderEmail(as.EmailView, "confirm", email, subject, struct {
+		Subject string
+		Code    string
+		Link    string
+	}{
+		Subject: subject,
+		Code:    code,
+		Link:    as.Config.SiteURL() + "/" + kind + "/" + code,
+	})
+	if err != nil {
+		return err
 	}
-
-	msg := fmt.Sprintf(`This is your confirmation code:
-
-%s
-
-Alternatively, follow this link:
-
-%s/%s/%s`,
-		code, url, kind, code)
-
-	return as.Emailer.Send(email, subject, msg)
+	return queueEmail(tx, e)
 }
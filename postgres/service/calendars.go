@@ -0,0 +1,140 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+Calendars keeps the tokens of private calendar feeds. Only their
+hashes are stored, see sd.HashToken.
+*/
+type Calendars struct {
+	*sd.Dependencies
+}
+
+/*
+NewFeed returns a new token for the feed of persId's events. Any
+token it had before stops working.
+*/
+func (cs Calendars) NewFeed(reqId string, persId int64) (string, error) {
+
+	token, err := sd.Base62(32)
+	if err != nil {
+		return "", err
+	}
+
+	errs, err := cs.TryerTx.Try(func() error {
+
+		tx, err := cs.Db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO calendar_feeds (p_id, token, created)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (p_id) DO UPDATE SET
+				token = EXCLUDED.token,
+				created = EXCLUDED.created`,
+			persId,
+			sd.HashToken(token),
+			time.Now().Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		cs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId)
+		return "", err
+	}
+
+	cs.Logger.Info(reqId, "Made calendar feed.").
+		Data(sd.LK_PersId, persId)
+
+	return token, nil
+}
+
+// RevokeFeed reports whether persId had a feed token to revoke.
+func (cs Calendars) RevokeFeed(reqId string, persId int64) (bool, error) {
+
+	var n int64
+
+	errs, err := cs.TryerTx.Try(func() error {
+
+		tx, err := cs.Db.Begin()
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`
+			DELETE FROM calendar_feeds WHERE p_id = $1`,
+			persId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		cs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId)
+		return false, err
+	}
+
+	if n > 0 {
+		cs.Logger.Info(reqId, "Revoked calendar feed.").
+			Data(sd.LK_PersId, persId)
+	}
+
+	return n > 0, nil
+}
+
+/*
+FeedPersona returns the persona whose feed token is token. It
+returns sql.ErrNoRows if there is none or the persona has been
+deleted.
+*/
+func (cs Calendars) FeedPersona(reqId, token string) (int64, error) {
+
+	var persId int64
+
+	errs, err := cs.TryerTx.Try(func() error {
+
+		tx, err := cs.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		err = tx.Get(&persId, `
+			SELECT
+				f.p_id
+			FROM
+				calendar_feeds f
+			JOIN
+				personas p ON p.id = f.p_id
+			WHERE
+				f.token = $1 AND
+				p.deleted IS NULL`,
+			sd.HashToken(token))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		if lastErrSqlNoRows(errs) {
+			return 0, sql.ErrNoRows
+		}
+		cs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return 0, err
+	}
+
+	return persId, nil
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -11,18 +13,94 @@ import (
 	"time"
 
 	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
 )
 
-func populateOwnerPersProfile(tx sd.Tx, r sd.Resource) error {
-	tx.Get(r, `
+/*
+ownerProfiles returns the slug of the talent profile owned by
+each persona in persIds that has one, keyed by persona id.
+*/
+func ownerProfiles(tx sd.Tx, persIds []int64) (map[int64]string, error) {
+	var rows []struct {
+		RefId int64 `db:"ref_id"`
+		Slug  string
+	}
+	err := tx.Select(&rows, `
 		SELECT
-			slug as PersProfile
+			ref_id,
+			slug
 		FROM
 			profile
 		WHERE
-			profile.ref_id = $1
-	`, r.OwnerId())
-	return nil
+			ref_id = ANY($1)`,
+		pq.Array(persIds),
+	)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int64]string, len(rows))
+	for _, r := range rows {
+		m[r.RefId] = r.Slug
+	}
+	return m, nil
+}
+
+/*
+childStrings selects the text column col of the child table
+tblName for every parent in refIds with one query. Results
+are keyed by the parent's id.
+*/
+func childStrings(tx sd.Tx, tblName, col string, refIds []int64) (map[int64][]string, error) {
+	var rows []struct {
+		RefId int64 `db:"ref_id"`
+		Val   string
+	}
+	err := tx.Select(&rows, fmt.Sprintf(`
+		SELECT
+			ref_id,
+			%s AS val
+		FROM
+			%s
+		WHERE
+			ref_id = ANY($1)`, col, tblName),
+		pq.Array(refIds),
+	)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int64][]string)
+	for _, r := range rows {
+		m[r.RefId] = append(m[r.RefId], r.Val)
+	}
+	return m, nil
+}
+
+// childInts is childStrings for columns of integers.
+func childInts(tx sd.Tx, tblName, col string, refIds []int64) (map[int64][]int64, error) {
+	var rows []struct {
+		RefId int64 `db:"ref_id"`
+		Val   int64
+	}
+	err := tx.Select(&rows, fmt.Sprintf(`
+		SELECT
+			ref_id,
+			%s AS val
+		FROM
+			%s
+		WHERE
+			ref_id = ANY($1)
+		ORDER BY
+			%s`, col, tblName, col),
+		pq.Array(refIds),
+	)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int64][]int64)
+	for _, r := range rows {
+		m[r.RefId] = append(m[r.RefId], r.Val)
+	}
+	return m, nil
 }
 
 func personaOwnsResource(tx sd.Tx, tblName, slug string, persId int64) error {
@@ -53,20 +131,26 @@ func personaOwnsResource(tx sd.Tx, tblName, slug string, persId int64) error {
 }
 
 type tmpSpan struct {
-	P    int
-	Kind string
-	Text string
-	Link sd.NullString
-	B    sd.NullBool
-	I    sd.NullBool
-	U    sd.NullBool
+	RefId int64 `db:"ref_id"`
+	P     int
+	Kind  string
+	Text  string
+	Link  sd.NullString
+	B     sd.NullBool
+	I     sd.NullBool
+	U     sd.NullBool
 }
 
-func retrieveSpans(tx sd.Tx, tblName string, refId int64) (sd.RichText, error) {
+/*
+retrieveSpans loads the rich text of every row in refIds from
+the table tblName_span and returns it keyed by the row's id.
+*/
+func retrieveSpans(tx sd.Tx, tblName string, refIds []int64) (map[int64]sd.RichText, error) {
 
 	var ss []tmpSpan
 	err := tx.Select(&ss, fmt.Sprintf(`
 		SELECT
+			ref_id,
 			p,
 			kind,
 			text,
@@ -77,18 +161,29 @@ func retrieveSpans(tx sd.Tx, tblName string, refId int64) (sd.RichText, error) {
 		FROM
 			%s_span
 		WHERE
-			ref_id = $1
+			ref_id = ANY($1)
 		ORDER BY
+			ref_id ASC,
 			span ASC`, tblName),
-		refId,
+		pq.Array(refIds),
 	)
 	if err != nil {
-		return nil, tx.Rollback(err)
+		return nil, err
 	}
 
+	m := make(map[int64]sd.RichText)
+	var refId int64
 	var rt sd.RichText
 	pIdx := -1
-	for _, s := range ss {
+	for i, s := range ss {
+		if i == 0 || s.RefId != refId {
+			if i > 0 {
+				m[refId] = rt
+			}
+			refId = s.RefId
+			rt = nil
+			pIdx = -1
+		}
 		if pIdx != s.P {
 			pIdx++
 			rt = append(rt, sd.Paragraph{Kind: s.Kind})
@@ -109,8 +204,25 @@ func retrieveSpans(tx sd.Tx, tblName string, refId int64) (sd.RichText, error) {
 			Format: ff,
 		})
 	}
+	if len(ss) > 0 {
+		m[refId] = rt
+	}
 
-	return rt, nil
+	return m, nil
+}
+
+/*
+first adapts the results of a RetrieveMany call for use
+by Retrieve, which expects exactly one resource.
+*/
+func first(rr []sd.Resource, err error) (sd.Resource, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(rr) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return rr[0], nil
 }
 
 func length(s string) int {
@@ -176,6 +288,97 @@ func (ac *argCount) Next() string {
 	return "$" + strconv.Itoa(int(*ac))
 }
 
+/*
+A cursor holds the sort key of the last result on a page of
+Filter results. The final element of the key should always
+be a unique id so that rows sharing the preceding values
+aren't skipped or repeated between pages.
+*/
+type cursor []int64
+
+var errMalformedCursor = errors.New("malformed cursor")
+
+func (c cursor) String() string {
+	ss := make([]string, len(c))
+	for i, n := range c {
+		ss[i] = strconv.FormatInt(n, 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(ss, ".")))
+}
+
+/*
+parseCursor decodes s, which must hold a key of n elements.
+An empty s returns a nil cursor, meaning the first page.
+*/
+func parseCursor(s string, n int) (cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errMalformedCursor
+	}
+	ss := strings.Split(string(b), ".")
+	if len(ss) != n {
+		return nil, errMalformedCursor
+	}
+	c := make(cursor, n)
+	for i := range ss {
+		c[i], err = strconv.ParseInt(ss[i], 10, 64)
+		if err != nil {
+			return nil, errMalformedCursor
+		}
+	}
+	return c, nil
+}
+
+/*
+after returns a condition that selects rows following the
+cursor when ordered by cols. Every column must be ordered in
+the same direction, descending if desc is true.
+*/
+func (c cursor) after(arg *argCount, desc bool, cols ...string) (string, []interface{}) {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	var pp []string
+	var args []interface{}
+	for _, n := range c {
+		pp = append(pp, arg.Next())
+		args = append(args, n)
+	}
+	return fmt.Sprintf(
+		"(%s) %s (%s)",
+		strings.Join(cols, ", "),
+		op,
+		strings.Join(pp, ", "),
+	), args
+}
+
+/*
+limit returns a LIMIT clause for p. We fetch one more than
+the limit so we know whether there's a next page without
+needing a separate query.
+*/
+func limit(p sd.PageOpts) string {
+	if p.Limit < 1 {
+		return ""
+	}
+	return "\nLIMIT " + strconv.Itoa(p.Limit+1)
+}
+
+/*
+nextPage reports how many of n fetched rows belong on the
+page and whether there is another page after it.
+*/
+func nextPage(p sd.PageOpts, n int) (keep int, more bool) {
+	if p.Limit < 1 || n <= p.Limit {
+		return n, false
+	}
+	return p.Limit, true
+}
+
 func buildExistsOr(arg *argCount, alias string, n int, names ...string) string {
 	return varBuildExistsOr("id", arg, alias, n, names...)
 }
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+func TestCursor(t *testing.T) {
+	for _, c := range []cursor{
+		{0, 0},
+		{1588000000, 42},
+		{-1, 9223372036854775807},
+		{7},
+	} {
+		got, err := parseCursor(c.String(), len(c))
+		if err != nil {
+			t.Errorf("parseCursor(%v) failed: %s", c, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("parseCursor(%v) = %v", c, got)
+		}
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+
+	enc := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		n    int
+		want cursor
+		err  error
+	}{
+		{"empty is the first page", "", 2, nil, nil},
+		{"valid", enc("10.20"), 2, cursor{10, 20}, nil},
+		{"negative", enc("-10.20"), 2, cursor{-10, 20}, nil},
+		{"not base64", "!!!", 2, nil, errMalformedCursor},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("10.20")), 2, nil, errMalformedCursor},
+		{"too few elements", enc("10"), 2, nil, errMalformedCursor},
+		{"too many elements", enc("10.20.30"), 2, nil, errMalformedCursor},
+		{"not a number", enc("10.abc"), 2, nil, errMalformedCursor},
+		{"empty element", enc("10."), 2, nil, errMalformedCursor},
+		{"overflows int64", enc("10.9223372036854775808"), 2, nil, errMalformedCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCursor(tt.in, tt.n)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorAfter(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		c     cursor
+		start argCount
+		desc  bool
+		cols  []string
+		want  string
+		args  []interface{}
+	}{
+		{
+			name: "ascending",
+			c:    cursor{5, 6},
+			cols: []string{"start", "id"},
+			want: "(start, id) > ($1, $2)",
+			args: []interface{}{int64(5), int64(6)},
+		},
+		{
+			name:  "descending after other arguments",
+			c:     cursor{5, 6},
+			start: 3,
+			desc:  true,
+			cols:  []string{"created", "id"},
+			want:  "(created, id) < ($4, $5)",
+			args:  []interface{}{int64(5), int64(6)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arg := tt.start
+			got, args := tt.c.after(&arg, tt.desc, tt.cols...)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("got args %v, want %v", args, tt.args)
+			}
+			if int(arg) != int(tt.start)+len(tt.c) {
+				t.Errorf("argument count is %d, want %d", arg, int(tt.start)+len(tt.c))
+			}
+		})
+	}
+}
+
+func TestPaging(t *testing.T) {
+
+	tests := []struct {
+		limit   int
+		fetched int
+		clause  string
+		keep    int
+		more    bool
+	}{
+		{0, 30, "", 30, false},
+		{-1, 30, "", 30, false},
+		{10, 0, "\nLIMIT 11", 0, false},
+		{10, 9, "\nLIMIT 11", 9, false},
+		{10, 10, "\nLIMIT 11", 10, false},
+		{10, 11, "\nLIMIT 11", 10, true},
+	}
+
+	for _, tt := range tests {
+		p := sd.PageOpts{Limit: tt.limit}
+		if got := limit(p); got != tt.clause {
+			t.Errorf("limit(%d) = %q, want %q", tt.limit, got, tt.clause)
+		}
+		keep, more := nextPage(p, tt.fetched)
+		if keep != tt.keep || more != tt.more {
+			t.Errorf("nextPage(%d, %d) = %d, %v, want %d, %v",
+				tt.limit, tt.fetched, keep, more, tt.keep, tt.more)
+		}
+	}
+}
+
+func TestFirst(t *testing.T) {
+
+	a := &sd.Settings{ResourceBase: sd.ResourceBase{Slug: "a"}}
+	b := &sd.Settings{ResourceBase: sd.ResourceBase{Slug: "b"}}
+	failed := errors.New("connection lost")
+
+	tests := []struct {
+		name string
+		rr   []sd.Resource
+		err  error
+		want sd.Resource
+		fail error
+	}{
+		{"first of many", []sd.Resource{a, b}, nil, a, nil},
+		{"none", nil, nil, nil, sql.ErrNoRows},
+		{"failed", []sd.Resource{a}, failed, nil, failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := first(tt.rr, tt.err)
+			if got != tt.want || err != tt.fail {
+				t.Errorf("got %v, %v, want %v, %v", got, err, tt.want, tt.fail)
+			}
+		})
+	}
+}
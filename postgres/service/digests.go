@@ -0,0 +1,355 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+/*
+Digests queues emails of notifications to their recipients
+according to the delivery each persona chose for each kind in
+their settings. See Outbox for how they're sent.
+*/
+type Digests struct {
+	*sd.Dependencies
+}
+
+/*
+How long the oldest notification of a kind may wait before a
+digest of it is sent.
+*/
+var digestPeriod = map[string]time.Duration{
+	sd.DeliverImmediate: 0,
+	sd.DeliverDaily:     time.Hour * 24,
+	sd.DeliverWeekly:    time.Hour * 24 * 7,
+}
+
+// Plural names of each kind of notification for unsubscribe links.
+var digestKinds = map[string]string{
+	sd.NotifyReply:   "replies",
+	sd.NotifyMention: "mentions",
+	sd.NotifyEvent:   "events",
+}
+
+type pendingEmail struct {
+	sd.Notification
+	Email    string
+	Delivery string
+}
+
+func (dg Digests) Send(reqId string) (int, error) {
+
+	var pending []pendingEmail
+
+	/*
+		Notifications of personas whose account or persona was
+		deleted, and those of kinds that are never emailed, are
+		treated as though their delivery was turned off.
+	*/
+	errs, err := dg.TryerTx.Try(func() error {
+
+		tx, err := dg.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		pending = nil
+		err = tx.Select(&pending, `
+			SELECT
+				notifications.id,
+				notifications.created,
+				notifications.recipient    AS persid,
+				notifications.kind,
+				notifications.actor_handle AS actorhandle,
+				notifications.target_kind  AS targetkind,
+				notifications.target_slug  AS targetslug,
+				notifications.target_name  AS targetname,
+				notifications.target_post  AS targetpost,
+				notifications.detail,
+				notifications.read,
+				personas.slug   AS persslug,
+				personas.handle AS pershandle,
+				accounts.email,
+				CASE
+					WHEN accounts.deleted IS NOT NULL THEN 'off'
+					WHEN personas.deleted IS NOT NULL THEN 'off'
+					WHEN notifications.kind = $1 THEN personas.email_reply
+					WHEN notifications.kind = $2 THEN personas.email_mention
+					WHEN notifications.kind = $3 THEN personas.email_event
+					ELSE 'off'
+				END AS delivery
+			FROM
+				notifications
+			JOIN
+				personas ON personas.id = notifications.recipient
+			JOIN
+				accounts ON accounts.id = personas.acc_id
+			WHERE
+				notifications.emailed IS NULL
+			ORDER BY
+				notifications.recipient,
+				notifications.created,
+				notifications.id`,
+			sd.NotifyReply,
+			sd.NotifyMention,
+			sd.NotifyEvent,
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		dg.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return 0, err
+	}
+
+	now := time.Now()
+	var skip []int64
+	var recipients []int64
+	due := make(map[int64][]pendingEmail)
+	oldest := make(map[int64]map[string]time.Time)
+
+	/*
+		Notifications that were read on the site before they
+		could be emailed are never emailed. Reminders are no use
+		late so they don't wait for the rest of a digest.
+	*/
+	for _, p := range pending {
+		period, ok := digestPeriod[p.Delivery]
+		if !ok || !p.Read.Null {
+			skip = append(skip, p.Id)
+			continue
+		}
+		if p.Detail.String == sd.EventReminder {
+			period = 0
+		}
+		if _, ok := due[p.PersId]; !ok {
+			recipients = append(recipients, p.PersId)
+			due[p.PersId] = nil
+			oldest[p.PersId] = make(map[string]time.Time)
+		}
+		if _, ok := oldest[p.PersId][p.Delivery]; !ok {
+			oldest[p.PersId][p.Delivery] = time.Unix(p.Created, 0)
+		}
+		if now.Sub(oldest[p.PersId][p.Delivery]) >= period {
+			due[p.PersId] = append(due[p.PersId], p)
+		}
+	}
+
+	if err := dg.queue(reqId, now, nil, skip); err != nil {
+		return 0, err
+	}
+
+	/*
+		Each persona's digest is queued in its own transaction so
+		that a failure for one doesn't stop the others. Failed
+		notifications remain pending and are tried again later.
+	*/
+	queued := 0
+	for _, id := range recipients {
+		pp := due[id]
+		if len(pp) == 0 {
+			continue
+		}
+		if err := dg.queue(reqId, now, pp, nil); err != nil {
+			continue
+		}
+		queued++
+	}
+
+	return queued, nil
+}
+
+/*
+queue adds the digest of pp, which all share a recipient, to
+the outbox and marks them as emailed. The notifications in skip
+are only marked.
+*/
+func (dg Digests) queue(reqId string, now time.Time, pp []pendingEmail, skip []int64) error {
+
+	ids := skip
+	for _, p := range pp {
+		ids = append(ids, p.Id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var e sd.Email
+	if len(pp) > 0 {
+		var err error
+		if e, err = dg.digest(pp); err != nil {
+			dg.Logger.Error(reqId, err.Error()).
+				Data(sd.LK_PersId, pp[0].PersId)
+			return err
+		}
+	}
+
+	errs, err := dg.TryerTx.Try(func() error {
+
+		tx, err := dg.Db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE
+				notifications
+			SET
+				emailed = $1
+			WHERE
+				id = ANY($2)`,
+			now.Unix(),
+			pq.Array(ids),
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if len(pp) > 0 {
+			if err := queueEmail(tx, e); err != nil {
+				return tx.Rollback(err)
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		entry := dg.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_NotificationCount, len(ids))
+		if len(pp) > 0 {
+			entry.Data(sd.LK_PersId, pp[0].PersId).
+				Data(sd.LK_EmailRcpt, pp[0].Email).
+				Data(sd.LK_EmailSubject, e.Subject)
+		}
+		return err
+	}
+
+	return nil
+}
+
+type digestLink struct {
+	Text string
+	Link string
+}
+
+/*
+digest renders the email for pp, which all share a recipient.
+Each kind included gets its own unsubscribe link.
+*/
+func (dg Digests) digest(pp []pendingEmail) (sd.Email, error) {
+
+	url := dg.Config.SiteURL()
+	key := dg.Config.Credentials.SigningKey
+	persona := pp[0].PersSlug
+
+	var subject string
+	if len(pp) == 1 {
+		subject = "StoryDevs: " + pp[0].Text()
+	} else {
+		subject = fmt.Sprintf("You have %d new notifications on StoryDevs", len(pp))
+	}
+
+	unsubscribe := func(kind string) string {
+		sig := sd.UnsubscribeSig(key, persona, kind)
+		return fmt.Sprintf("%s/unsubscribe/%s/%s/%s", url, persona, kind, sig)
+	}
+
+	var items, kinds []digestLink
+	seen := make(map[string]bool)
+	for _, p := range pp {
+		items = append(items, digestLink{p.Text(), url + p.TargetPath()})
+		if !seen[p.Kind] {
+			seen[p.Kind] = true
+			kinds = append(kinds, digestLink{digestKinds[p.Kind], unsubscribe(p.Kind)})
+		}
+	}
+
+	return renderEmail(dg.EmailView, "digest", pp[0].Email, subject, struct {
+		Subject        string
+		Handle         string
+		Items          []digestLink
+		Unsubscribe    []digestLink
+		UnsubscribeAll string
+		Settings       string
+	}{
+		Subject:        subject,
+		Handle:         pp[0].PersHandle,
+		Items:          items,
+		Unsubscribe:    kinds,
+		UnsubscribeAll: unsubscribe(sd.UnsubscribeAll),
+		Settings:       url + "/account/settings/notifications",
+	})
+}
+
+func (dg Digests) Unsubscribe(reqId, persSlug, kind, sig string) (bool, error) {
+
+	key := dg.Config.Credentials.SigningKey
+	if !sd.ValidUnsubscribe(key, persSlug, kind, sig) {
+		return false, nil
+	}
+
+	cols := map[string]string{
+		sd.NotifyReply:   "email_reply",
+		sd.NotifyMention: "email_mention",
+		sd.NotifyEvent:   "email_event",
+	}
+	var set []string
+	if kind == sd.UnsubscribeAll {
+		for _, k := range sd.EmailKinds {
+			set = append(set, cols[k]+" = $1")
+		}
+	} else if col, ok := cols[kind]; ok {
+		set = append(set, col+" = $1")
+	} else {
+		return false, nil
+	}
+
+	var found bool
+
+	errs, err := dg.TryerTx.Try(func() error {
+
+		tx, err := dg.Db.Begin()
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`
+			UPDATE
+				personas
+			SET
+				`+strings.Join(set, ",\n\t\t\t\t")+`
+			WHERE
+				slug = $2`,
+			sd.DeliverOff,
+			persSlug,
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		found = n > 0
+		return tx.Commit()
+	})
+	if err != nil {
+		dg.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersSlug, persSlug)
+		return false, err
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	dg.Logger.Info(reqId, "Unsubscribed from notification emails.").
+		Data(sd.LK_PersSlug, persSlug).
+		Data(sd.LK_NotificationKind, kind)
+
+	return true, nil
+}
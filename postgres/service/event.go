@@ -9,6 +9,7 @@ import (
 	"time"
 
 	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
 )
 
 const (
@@ -32,9 +33,12 @@ func (es Event) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 	if e == nil {
 		return nil, fmt.Errorf("supplied event is nil")
 	}
-	if err := dateTimeToUTC(e, tbl); err != nil {
+	start, finish, err := dateTimeToUTC(e, tbl)
+	if err != nil {
 		return nil, err
 	}
+	recurToTable(e, tbl)
+	nullIntToTable(tbl, "capacity", e.Capacity)
 
 	var rId int64
 	errs, err := es.TryerTx.Try(func() error {
@@ -46,6 +50,12 @@ func (es Event) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 		if rId, err = insertTables(tx, tbl, tbl.Refs, false); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = es.storeOccurrences(tx, *e, rId, start, finish); err != nil {
+			return tx.Rollback(err)
+		}
+		if err = updateSearch(tx, "event", rId); err != nil {
+			return tx.Rollback(err)
+		}
 		if err = addFiles(tx, tbl, rId, "event"); err != nil {
 			return tx.Rollback(err)
 		}
@@ -79,9 +89,12 @@ func (es Event) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 	if e == nil {
 		return nil, nil, fmt.Errorf("supplied event is nil")
 	}
-	if err := dateTimeToUTC(e, tbl); err != nil {
+	start, finish, err := dateTimeToUTC(e, tbl)
+	if err != nil {
 		return nil, nil, err
 	}
+	recurToTable(e, tbl)
+	nullIntToTable(tbl, "capacity", e.Capacity)
 
 	slug := r.GetSlug()
 	var rId int64
@@ -109,6 +122,16 @@ func (es Event) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 		if err = tx.Get(&rId, q, slug); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = notifyEventUpdate(tx, e, rId); err != nil {
+			return tx.Rollback(err)
+		}
+
+		// Changing the capacity may give those waitlisted a place.
+		placedBefore, err := lockPlaces(tx, rId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
 		for _, t := range tbl.Tables {
 			q := fmt.Sprintf(`DELETE FROM %s WHERE ref_id = $1`, t.Name)
 			_, err := tx.Exec(q, rId)
@@ -117,9 +140,28 @@ func (es Event) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 			}
 		}
 
+		/*
+			Skipped dates are optional so there's no table for
+			them when they've all been removed.
+		*/
+		if _, err = tx.Exec(`DELETE FROM event_skip WHERE ref_id = $1`, rId); err != nil {
+			return tx.Rollback(err)
+		}
+
 		if _, err = insertTables(tx, tbl, tbl.Refs, true); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = es.storeOccurrences(tx, *e, rId, start, finish); err != nil {
+			return tx.Rollback(err)
+		}
+		updated := *e
+		updated.Id = rId
+		if err = notifyPromoted(tx, updated, placedBefore, 0); err != nil {
+			return tx.Rollback(err)
+		}
+		if err = updateSearch(tx, "event", rId); err != nil {
+			return tx.Rollback(err)
+		}
 
 		/*
 			Delete entries in the 'file' table that reference
@@ -155,14 +197,30 @@ func (es Event) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 	return nil, toRemove, nil
 }
 
+/*
+eventAccess returns the conditions events must meet to be
+retrieved with o. Private events and those hidden by moderators
+are only retrieved for callers that check who may see them.
+*/
+func eventAccess(o sd.ResOpts) string {
+	if o.GetPrivate {
+		return ""
+	}
+	return `event.visibility != 'private' AND
+				event.hidden IS NULL AND`
+}
+
 func (es Event) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(es.RetrieveMany(reqId, []string{slug}, o))
+}
 
-	var e sd.Event
+/*
+RetrieveMany returns the events matching slugs in the same
+order. Slugs that don't match an event are skipped.
+*/
+func (es Event) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
 
-	var private string
-	if !o.GetPrivate {
-		private = "event.visibility != 'private' AND"
-	}
+	var ee []sd.Event
 
 	errs, err := es.TryerTx.Try(func() error {
 
@@ -171,25 +229,34 @@ func (es Event) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error)
 			return err
 		}
 
-		err = tx.Get(&e, fmt.Sprintf(`
+		ee = nil
+		err = tx.Select(&ee, fmt.Sprintf(`
 			SELECT
 				personas.id     AS persId,
 				personas.name   AS persName,
 				personas.handle AS persHandle,
+				personas.slug   AS persSlug,
 
 				event.id,
 				event.slug,
 				event.created,
 				event.updated,
+				event.hidden,
 
 				event.name,
 				event.summary,
 				event.visibility,
 
 				event.timezone,
-				event.weekly,
 				event.start,
-				event.finish
+				event.finish,
+				event.recur,
+				event.every,
+				event.monthby,
+				event.until,
+				event.times,
+
+				event.capacity
 
 			FROM
 				event,
@@ -197,65 +264,57 @@ func (es Event) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error)
 			WHERE
 				%s
 				personas.id = event.ref_id AND
-				event.slug = $1`, private),
-			slug,
+				event.slug = ANY($1)`, eventAccess(o)),
+			pq.Array(slugs),
 		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
+		if len(ee) == 0 {
+			return tx.Commit()
+		}
 
-		if err := populateOwnerPersProfile(tx, &e); err != nil {
-			return tx.Rollback(err)
+		ids := make([]int64, len(ee))
+		persIds := make([]int64, len(ee))
+		for i := range ee {
+			ids[i] = ee[i].Id
+			persIds[i] = ee[i].PersId
 		}
 
-		err = tx.Select(&e.Tag, `
-			SELECT
-				tag
-			FROM
-				event_tag
-			WHERE
-				ref_id = $1`,
-			e.Id,
-		)
+		profiles, err := ownerProfiles(tx, persIds)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		err = tx.Select(&e.Category, `
-			SELECT
-				category
-			FROM
-				event_category
-			WHERE
-				ref_id = $1`,
-			e.Id,
-		)
+		tags, err := childStrings(tx, "event_tag", "tag", ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		err = tx.Select(&e.Setting, `
-			SELECT
-				setting
-			FROM
-				event_setting
-			WHERE
-				ref_id = $1`,
-			e.Id,
-		)
+		categories, err := childStrings(tx, "event_category", "category", ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		/*
-			Transaction is rolled back inside
-			retrieveSpans if there's an error.
-		*/
-		rt, err := retrieveSpans(tx, "event", e.Id)
+		settings, err := childStrings(tx, "event_setting", "setting", ids)
 		if err != nil {
-			return err
+			return tx.Rollback(err)
+		}
+		skips, err := childInts(tx, "event_skip", "skip", ids)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		bodies, err := retrieveSpans(tx, "event", ids)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		for i := range ee {
+			e := &ee[i]
+			e.PersProfile = profiles[e.PersId]
+			e.Tag = tags[e.Id]
+			e.Category = categories[e.Id]
+			e.Setting = settings[e.Id]
+			e.Skip = skips[e.Id]
+			e.Body = bodies[e.Id]
 		}
-		e.Body = rt
 
 		return tx.Commit()
 	})
@@ -265,32 +324,73 @@ func (es Event) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error)
 	if err != nil {
 		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
 			Data(sd.LK_RetryAttemptsTx, len(errs)).
-			Data(sd.LK_EventSlug, slug)
+			Data(sd.LK_EventSlug, strings.Join(slugs, ", "))
 		return nil, err
 	}
 
+	bySlug := make(map[string]*sd.Event, len(ee))
+	for i := range ee {
+		if err := adjustEventTimes(&ee[i]); err != nil {
+			return nil, err
+		}
+		bySlug[ee[i].Slug] = &ee[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		e, ok := bySlug[slug]
+		if !ok {
+			continue
+		}
+		log.Info(reqId, "Retrieved event.").
+			Data(sd.LK_EventSlug, slug).
+			Data(sd.LK_EventId, e.Id).
+			Data(sd.LK_EventName, e.Name.String).
+			Data(sd.LK_PersId, e.PersId).
+			Data(sd.LK_PersHandle, e.PersHandle)
+		rr = append(rr, e)
+	}
+
+	return rr, nil
+}
+
+/*
+adjustEventTimes moves recurring events that have already happened
+to their next occurrence, or their last if they've ended, and sets
+the timezone offset of the event's start and finish.
+*/
+func adjustEventTimes(e *sd.Event) error {
+
+	e.FirstStart = e.Start.DateTime
+	e.FirstFinish = e.Finish.DateTime
+
+	loc, err := e.Location()
+	if err != nil {
+		return err
+	}
+
 	/*
 		We do this part first while the dates are
 		still in UTC time.
 	*/
-	now := time.Now().Unix()
-	if e.Weekly.Bool {
-		var end int64
-		s := e.Start.DateTime
-		f := e.Finish.DateTime
-		if e.Finish.Null {
-			end = s
-		} else {
-			end = f
+	r, ok, err := e.Rule(e.Start.DateTime)
+	if err != nil {
+		return err
+	}
+	if ok {
+		var dur int64
+		if !e.Finish.Null {
+			dur = e.Finish.DateTime - e.Start.DateTime
 		}
-		if now > end {
-			s, f, err := nextOccurrence(s, f, e.Timezone)
-			if err != nil {
-				return nil, err
-			}
-			e.Start.DateTime = s
+		now := time.Now().Unix()
+		next, found := r.Next(time.Unix(now-dur, 0))
+		if !found {
+			next, _ = r.Ends()
+		}
+		if !next.IsZero() {
+			e.Start.DateTime = next.Unix()
 			if !e.Finish.Null {
-				e.Finish.DateTime = f
+				e.Finish.DateTime = next.Unix() + dur
 			}
 		}
 	}
@@ -300,57 +400,12 @@ func (es Event) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error)
 		in isolation when populating the UI; the additional context
 		of the Event struct and its Timezone field is not available.
 	*/
-	off := 0
-	if !in(sd.TzSkip, e.Timezone) {
-		tz, err := time.LoadLocation(e.Timezone)
-		if err != nil {
-			return nil, err
-		}
-		_, off = time.Now().In(tz).Zone()
-	}
-	e.Start.TZOff = off
+	_, e.Start.TZOff = time.Unix(e.Start.DateTime, 0).In(loc).Zone()
 	if !e.Finish.Null {
-		e.Finish.TZOff = off
+		_, e.Finish.TZOff = time.Unix(e.Finish.DateTime, 0).In(loc).Zone()
 	}
 
-	log.Info(reqId, "Retrieved event.").
-		Data(sd.LK_EventSlug, slug).
-		Data(sd.LK_EventId, e.Id).
-		Data(sd.LK_EventName, e.Name.String).
-		Data(sd.LK_PersId, e.PersId).
-		Data(sd.LK_PersHandle, e.PersHandle)
-
-	return &e, nil
-}
-
-func nextOccurrence(s, f int64, tz string) (sAdj, fAdj int64, err error) {
-	dates := [2]int64{s, f}
-	now := time.Now()
-	today := now.Weekday()
-	loc, err := time.LoadLocation(tz)
-	if err != nil {
-		return 0, 0, err
-	}
-	for i, d := range dates {
-		t := time.Unix(d, 0).In(loc)
-		day := t.Weekday()
-		delta := int(day - today)
-		if delta < 0 {
-			delta += 7
-		}
-		next := time.Date(
-			now.Year(),
-			now.Month(),
-			now.Day()+delta,
-			t.Hour(),
-			t.Minute(),
-			0,
-			0,
-			time.UTC,
-		)
-		dates[i] = next.Unix()
-	}
-	return dates[0], dates[1], nil
+	return nil
 }
 
 /*
@@ -386,11 +441,14 @@ func filterDateTimeToUTC(filter map[string][]string, which string) (int64, error
 	return n - int64(off), nil
 }
 
-func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]sd.Resource, error) {
+func (es Event) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
 
 	var where []string
 	var args []interface{}
 	now := time.Now().Unix()
+
+	// Conditions on the occurrences of events, see below.
+	var occ []string
 	arg := new(argCount)
 	from := make(map[string]bool)
 
@@ -402,7 +460,10 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 			)
 		)
 		SELECT
-			e.slug
+			e.slug,
+			e.start,
+			e.id,
+			%s AS rank
 		FROM
 			v,
 			event AS e
@@ -413,6 +474,7 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 		if err is already declared.
 	*/
 	var err error
+	var attending int64
 	overlaps := false
 	offClient := 0
 
@@ -440,14 +502,46 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 		where = append(where, "("+strings.Join(w, " OR ")+")")
 	}
 
+	if _, ok := filter["hidden"]; ok {
+		where = append(where, "e.hidden IS NULL")
+	}
+
+	/*
+		Events the persona is going to or interested in, other
+		than private events belonging to someone else.
+	*/
+	if vv, ok := filter["attending"]; ok {
+		if len(vv) < 1 {
+			return nil, "", errors.New("expected persona id while filtering event db")
+		}
+		attending, err = strconv.ParseInt(vv[0], 10, 64)
+		if err != nil {
+			return nil, "", err
+		}
+		ph := arg.Next()
+		args = append(args, attending, sd.RSVPGoing, sd.RSVPInterested)
+		where = append(where, `
+(e.visibility != 'private' OR e.ref_id = `+ph+`) AND
+EXISTS (
+	SELECT
+		1
+	FROM
+		event_rsvp AS r
+	WHERE
+		r.e_id = e.id AND
+		r.p_id = `+ph+` AND
+		r.status IN (`+arg.Next()+`, `+arg.Next()+`)
+)`)
+	}
+
 	if vv, ok := filter["persona"]; ok {
 		where = append(where, "e.ref_id = "+arg.Next())
 		if len(vv) < 1 {
-			return nil, errors.New("expected persona id while filtering talent db")
+			return nil, "", errors.New("expected persona id while filtering event db")
 		}
 		persId, err := strconv.ParseInt(vv[0], 10, 64)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		args = append(args, persId)
 		goto skip
@@ -460,7 +554,7 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 	if tzName, ok := filter["timezone"]; ok {
 		offClient, err = offFromUTC(tzName[0], now)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	}
 
@@ -478,13 +572,13 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 		if okS {
 			s, err = filterDateTimeToUTC(filter, "start")
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 		}
 		if okF {
 			f, err = filterDateTimeToUTC(filter, "finish")
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 			/*
 				We add one day to make the end of the range inclusive.
@@ -508,38 +602,20 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 	*/
 	` + opening + ` AND
 	(
-		e.start ` + off + ` >= %s AND e.start ` + off + ` < %s
-	)
-	OR
-	(
-		 e.finish IS NOT NULL AND
-	 	(
-	 		(e.finish ` + off + ` >= %s AND e.finish ` + off + `  < %s) OR
-	 		(e.start  ` + off + `  < %s AND e.finish ` + off + ` >= %s)
-	 	)
- 	)
- 	OR
- 	(
- 		-- For recurring events.
-		e.weekly AND
- 		(
- 			(e.finish IS NOT NULL AND now > e.finish ` + off + `) OR
- 			(e.finish IS NULL     AND now > e.start  ` + off + `)
+		(
+			o.start ` + off + ` >= %s AND o.start ` + off + ` < %s
 		)
-		AND
+		OR
 		(
-	 		(now + (wk - ((now - (e.start ` + off + `)) %% wk))  >= %s AND
-	 		 now + (wk - ((now - (e.start ` + off + `)) %% wk))   < %s) OR
-
-	 		(e.finish IS NOT NULL AND
-			 now + (wk - ((now - (e.finish ` + off + `)) %% wk)) >= %s AND
-	 		 now + (wk - ((now - (e.finish ` + off + `)) %% wk))  < %s)
- 		)
- 	)
+			 o.finish IS NOT NULL AND
+			(
+				(o.finish ` + off + ` >= %s AND o.finish ` + off + `  < %s) OR
+				(o.start  ` + off + `  < %s AND o.finish ` + off + ` >= %s)
+			)
+		)
+	)
 )`
 				return fmt.Sprintf(s,
-					arg.Next(), arg.Next(),
-					arg.Next(), arg.Next(),
 					arg.Next(), arg.Next(),
 					arg.Next(), arg.Next(),
 					arg.Next(), arg.Next())
@@ -547,13 +623,10 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 			var ss []string
 			ss = append(ss, qMaker(`e.timezone  = 'local'`, offClient))
 			ss = append(ss, qMaker(`e.timezone != 'local'`, 0))
-			where = append(where, "("+strings.Join(ss, " OR ")+")")
+			occ = append(occ, "("+strings.Join(ss, " OR ")+")")
 			args = append(args,
-				s, f, s, f,
-				s, f, s, f,
-				s, f, s, f,
-				s, f, s, f,
-				s, f, s, f)
+				s, f, s, f, s, f,
+				s, f, s, f, s, f)
 		} else {
 			qMaker := func(opening string, offset int) string {
 				off := ""
@@ -562,43 +635,23 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 				}
 				s := `
 (
-	` + opening + `AND
-	(
-		e.start ` + off + ` >= %s AND
-	 	(
-		 	(e.finish ` + off + ` IS NULL AND e.start ` + off + ` < %s)
-	 		OR
-		 	(e.finish ` + off + ` IS NOT NULL AND e.finish ` + off + ` < %s)
-		)
-	)
-	OR
+	` + opening + ` AND
+	o.start ` + off + ` >= %s AND
 	(
- 		-- For recurring events.
-		e.weekly AND
-		now + (wk - ((now - e.start ` + off + `) %% wk)) >= %s AND
- 		(
-			(e.finish IS NOT NULL AND
-			 now > e.finish ` + off + ` AND
-			 now + (wk - ((now - e.finish ` + off + `) %% wk)) < %s) OR
-
-			(e.finish IS NULL AND
-			 now > e.start ` + off + ` AND
-			 now + (wk - ((now - e.start ` + off + `) %% wk)) < %s)
-		)
+		(o.finish IS NULL AND o.start ` + off + ` < %s)
+		OR
+		(o.finish IS NOT NULL AND o.finish ` + off + ` < %s)
 	)
 )`
 				return fmt.Sprintf(s,
 					arg.Next(), arg.Next(), arg.Next(),
-					arg.Next(), arg.Next(), arg.Next(),
 				)
 			}
 			var ss []string
 			ss = append(ss, qMaker(`e.timezone  = 'local'`, offClient))
 			ss = append(ss, qMaker(`e.timezone != 'local'`, 0))
-			where = append(where, "("+strings.Join(ss, " OR ")+")")
+			occ = append(occ, "("+strings.Join(ss, " OR ")+")")
 			args = append(args,
-				s, f, f,
-				s, f, f,
 				s, f, f,
 				s, f, f)
 		}
@@ -608,7 +661,7 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 
 		instCount, err := strconv.Atoi(vv[0])
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		var or []string
@@ -650,13 +703,13 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 			if okS {
 				hrS, err = strconv.Atoi(vvS[0])
 				if err != nil {
-					return nil, err
+					return nil, "", err
 				}
 			}
 			if okF {
 				hrF, err = strconv.Atoi(vvF[0])
 				if err != nil {
-					return nil, err
+					return nil, "", err
 				}
 			} else {
 				hrF = day
@@ -674,13 +727,13 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 	` + opening + ` AND
 	(
 		(
-			(e.start  + %d ` + off + `) %% %d >= %s AND
-			(e.start  + %d ` + off + `) %% %d  < %s)
+			(o.start  + %d ` + off + `) %% %d >= %s AND
+			(o.start  + %d ` + off + `) %% %d  < %s)
 		OR
 		(
-		 	 e.finish IS NOT NULL AND
-		 	(e.finish + %d ` + off + `) %% %d  > %s AND
-		 	(e.finish + %d ` + off + `) %% %d <= %s
+		 	 o.finish IS NOT NULL AND
+		 	(o.finish + %d ` + off + `) %% %d  > %s AND
+		 	(o.finish + %d ` + off + `) %% %d <= %s
 		)
 	)
 )`
@@ -710,16 +763,16 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 						s := `
 (
 	` + opening + `AND
-	(e.start  + %d ` + off + `) %% %d >= %s AND
+	(o.start  + %d ` + off + `) %% %d >= %s AND
 	(
 		(
-			 e.finish IS NULL AND
-			(e.start  + %d  ` + off + `) %% %d <= %s
+			 o.finish IS NULL AND
+			(o.start  + %d  ` + off + `) %% %d <= %s
 		)
 		OR
 		(
-			 e.finish IS NOT NULL AND
-		 	(e.finish + %d  ` + off + `) %% %d <= %s
+			 o.finish IS NOT NULL AND
+		 	(o.finish + %d  ` + off + `) %% %d <= %s
 		)
 	)
 )`
@@ -744,10 +797,28 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 		}
 
 		if len(or) > 0 {
-			where = append(where, "("+strings.Join(or, " OR ")+")")
+			occ = append(occ, "("+strings.Join(or, " OR ")+")")
 		}
 	}
 
+	/*
+		Recurring events match when any of their occurrences
+		do. Those of events recurring forever are only stored
+		as far ahead as Config.Event.Horizon.
+	*/
+	if len(occ) > 0 {
+		where = append(where, `
+EXISTS (
+	SELECT
+		1
+	FROM
+		event_occurrence AS o
+	WHERE
+		o.ref_id = e.id AND
+		`+strings.Join(occ, " AND \n")+`
+)`)
+	}
+
 	if vv, ok := filter["category"]; ok {
 		where = append(where, buildExistsOr(arg, "e", len(vv), "event", "category"))
 		for _, v := range vv {
@@ -764,6 +835,38 @@ func (es Event) Filter(reqId string, admin bool, filter map[string][]string) ([]
 
 skip:
 
+	/*
+		Searches are ordered by relevance rather than by
+		when the event starts.
+	*/
+	text := searchText(filter)
+	rank := "0"
+	order := " ORDER BY e.start ASC, e.id ASC"
+	if text != "" {
+		ph := arg.Next()
+		args = append(args, text)
+		where = append(where, searchMatch("e.search", ph))
+		rank = searchRank("e.search", ph)
+		order = " ORDER BY rank DESC, e.id DESC"
+	}
+	q = fmt.Sprintf(q, rank)
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		var w string
+		var a []interface{}
+		if text != "" {
+			w, a = cur.after(arg, true, rank, "e.id")
+		} else {
+			w, a = cur.after(arg, false, "e.start", "e.id")
+		}
+		where = append(where, w)
+		args = append(args, a...)
+	}
+
 	if len(from) > 0 {
 		for tbl := range from {
 			q += ",\n" + tbl + "\n"
@@ -774,15 +877,20 @@ skip:
 		q += "WHERE " + strings.Join(where, " AND \n")
 	}
 
-	q += " ORDER BY start ASC"
+	q += order + limit(p)
 
-	var slugs []string
+	var rows []struct {
+		Slug  string
+		Start int64
+		Id    int64
+		Rank  int64
+	}
 	errs, err := es.TryerTx.Try(func() error {
 		tx, err := es.Db.Begin()
 		if err != nil {
 			return err
 		}
-		if err := tx.Select(&slugs, q, args...); err != nil {
+		if err := tx.Select(&rows, q, args...); err != nil {
 			return tx.Rollback(err)
 		}
 		return tx.Commit()
@@ -793,32 +901,61 @@ skip:
 	if err != nil {
 		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
 			Data(sd.LK_RetryAttemptsTx, len(errs))
-		return nil, err
+		return nil, "", err
 	}
 
-	if len(slugs) == 0 {
-		return nil, nil
+	if len(rows) == 0 {
+		return nil, "", nil
 	}
 
-	var rr []sd.Resource
-	for _, slug := range slugs {
-		r, err := es.Retrieve(reqId, slug, sd.ResOpts{
-			GetPrivate:       true,
-			CalledFromFilter: true,
-		})
+	n, more := nextPage(p, len(rows))
+	rows = rows[:n]
+	var next string
+	if more {
+		last := rows[n-1]
+		next = cursor{last.Start, last.Id}.String()
+		if text != "" {
+			next = cursor{last.Rank, last.Id}.String()
+		}
+	}
+
+	slugs := make([]string, len(rows))
+	for i := range rows {
+		slugs[i] = rows[i].Slug
+	}
+	rr, err := es.RetrieveMany(reqId, slugs, sd.ResOpts{
+		GetPrivate:       true,
+		CalledFromFilter: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if text != "" {
+		ids := make([]int64, len(rows))
+		for i := range rows {
+			ids[i] = rows[i].Id
+		}
+		m, err := snippets(es.Db, "event", "id", "", ids, text)
 		if err != nil {
-			return nil, err
+			log.Error(reqId, err.Error())
 		}
+		addSnippets(rr, m)
+	}
+	if attending != 0 {
+		if err := es.setRSVPs(reqId, rr, attending); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, r := range rr {
 		if e := r.(*sd.Event); e.Timezone == "local" {
 			e.Start.DateTime -= int64(offClient)
 			if !e.Finish.Null {
 				e.Finish.DateTime -= int64(offClient)
 			}
 		}
-		rr = append(rr, r)
 	}
 
-	return rr, nil
+	return rr, next, nil
 }
 
 func (es Event) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
@@ -875,6 +1012,109 @@ func (es Event) Delete(reqId, slug string, persId int64) (sd.Feedback, []string,
 	return nil, toRemove, nil
 }
 
+// setRSVPs sets how persId responded to each of the events rr.
+func (es Event) setRSVPs(reqId string, rr []sd.Resource, persId int64) error {
+
+	ids := make([]int64, len(rr))
+	for i, r := range rr {
+		ids[i] = r.GetId()
+	}
+
+	var m map[int64]sd.RSVP
+	errs, err := es.TryerTx.Try(func() error {
+		tx, err := es.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		if m, err = rsvpsOf(tx, persId, ids); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		es.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId)
+		return err
+	}
+
+	for _, r := range rr {
+		e := r.(*sd.Event)
+		e.RSVP = m[e.Id]
+	}
+	return nil
+}
+
+/*
+Extend works out more occurrences of the events recurring forever
+whose stored occurrences end within half of Config.Event.Horizon
+days from now. It returns how many events were extended.
+*/
+func (es Event) Extend(reqId string) (int, error) {
+
+	var ee []sd.Event
+	half := time.Now().AddDate(0, 0, es.Config.Event.Horizon/2).Unix()
+
+	errs, err := es.TryerTx.Try(func() error {
+
+		tx, err := es.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		ee = nil
+		err = tx.Select(&ee, `
+			SELECT
+				id,
+				timezone,
+				start,
+				finish,
+				recur,
+				every,
+				monthby,
+				until,
+				times
+			FROM
+				event
+			WHERE
+				recur != 'never' AND
+				occurs_until < $1`,
+			half)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if len(ee) == 0 {
+			return tx.Commit()
+		}
+
+		ids := make([]int64, len(ee))
+		for i := range ee {
+			ids[i] = ee[i].Id
+		}
+		skips, err := childInts(tx, "event_skip", "skip", ids)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		for _, e := range ee {
+			e.Skip = skips[e.Id]
+			err := es.storeOccurrences(tx, e, e.Id, e.Start.DateTime, e.Finish.DateTime)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		es.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return 0, err
+	}
+
+	return len(ee), nil
+}
+
 func offFromUTC(tzName string, at int64) (int, error) {
 	if in(sd.TzSkip, tzName) {
 		return 0, nil
@@ -887,20 +1127,124 @@ func offFromUTC(tzName string, at int64) (int, error) {
 	return off, nil
 }
 
-func dateTimeToUTC(e *sd.Event, tbl *sd.DbTable) error {
+/*
+dateTimeToUTC converts e's start and finish to UTC in tbl and
+returns them. Finish is zero if e has none.
+*/
+func dateTimeToUTC(e *sd.Event, tbl *sd.DbTable) (start, finish int64, err error) {
 	off, err := offFromUTC(e.Timezone, e.Start.DateTime)
 	if err != nil {
-		return err
+		return 0, 0, err
 	}
-	tbl.SafeAdd("start", e.Start.DateTime-int64(off))
+	start = e.Start.DateTime - int64(off)
+	tbl.SafeAdd("start", start)
 	if !e.Finish.Null {
 		off, err := offFromUTC(e.Timezone, e.Finish.DateTime)
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
-		tbl.SafeAdd("finish", e.Finish.DateTime-int64(off))
+		finish = e.Finish.DateTime - int64(off)
+		tbl.SafeAdd("finish", finish)
 	}
-	return nil
+	return start, finish, nil
+}
+
+/*
+recurToTable stores e's unset recurrence fields as NULL rather than
+zero and clears those that don't apply to how it recurs.
+*/
+func recurToTable(e *sd.Event, tbl *sd.DbTable) {
+
+	if e.Recur == "" {
+		e.Recur = sd.RecurNever
+		tbl.SafeAdd("recur", e.Recur)
+	}
+	if e.Recur == sd.RecurNever {
+		e.Every = sd.NullInt64{Null: true}
+		e.Until = sd.NullInt64{Null: true}
+		e.Times = sd.NullInt64{Null: true}
+		e.Skip = nil
+		var tt []*sd.DbTable
+		for _, t := range tbl.Tables {
+			if t.Name != "event_skip" {
+				tt = append(tt, t)
+			}
+		}
+		tbl.Tables = tt
+	}
+	if e.Recur != sd.RecurMonthly {
+		e.MonthBy = sd.NullString{Null: true}
+	} else if e.MonthBy.String == "" {
+		e.MonthBy = sd.NullString{String: sd.MonthByDay}
+	}
+
+	nullIntToTable(tbl, "every", e.Every)
+	nullIntToTable(tbl, "until", e.Until)
+	nullIntToTable(tbl, "times", e.Times)
+	if e.MonthBy.String == "" {
+		tbl.SafeAdd("monthby", nil)
+	} else {
+		tbl.SafeAdd("monthby", e.MonthBy.String)
+	}
+}
+
+// nullIntToTable stores n in col of tbl, or NULL if it's zero.
+func nullIntToTable(tbl *sd.DbTable, col string, n sd.NullInt64) {
+	if n.Int64 == 0 {
+		tbl.SafeAdd(col, nil)
+		return
+	}
+	tbl.SafeAdd(col, n.Int64)
+}
+
+/*
+storeOccurrences replaces the occurrences of the event with id by
+those of e from its first at start, which along with finish is in
+UTC as stored. Those of events recurring forever are stored up to
+Config.Event.Horizon days from now.
+*/
+func (es Event) storeOccurrences(tx sd.Tx, e sd.Event, id, start, finish int64) error {
+
+	if _, err := tx.Exec(`DELETE FROM event_occurrence WHERE ref_id = $1`, id); err != nil {
+		return err
+	}
+
+	starts := []int64{start}
+	until := int64(math.MaxInt64)
+
+	r, ok, err := e.Rule(start)
+	if err != nil {
+		return err
+	}
+	if ok {
+		horizon := time.Now().AddDate(0, 0, es.Config.Event.Horizon)
+		if last, ends := r.Ends(); !ends || !last.Before(horizon) {
+			until = horizon.Unix()
+		}
+		starts = nil
+		for _, t := range r.Between(r.Start, horizon) {
+			starts = append(starts, t.Unix())
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO event_occurrence (ref_id, start, finish)
+		SELECT
+			$1,
+			s,
+			CASE WHEN $3 THEN NULL ELSE s + $4 END
+		FROM
+			unnest($2::bigint[]) AS s`,
+		id,
+		pq.Array(starts),
+		e.Finish.Null,
+		finish-start)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE event SET occurs_until = $1 WHERE id = $2`, until, id)
+	return err
 }
 
 func buildDayRangeOr(arg *argCount, n, offClient int, overlaps bool) string {
@@ -916,14 +1260,14 @@ func buildDayRangeOr(arg *argCount, n, offClient int, overlaps bool) string {
 	` + opening + ` AND
 	(
 		(
-			(e.start  + %d ` + off + `) %% wk >= %s AND
-			(e.start  + %d ` + off + `) %% wk  < %s
+			(o.start  + %d ` + off + `) %% wk >= %s AND
+			(o.start  + %d ` + off + `) %% wk  < %s
 		)
 		OR
 		(
-			e.finish IS NOT NULL AND
-			(e.finish + %d ` + off + `) %% wk >= %s AND
-			(e.finish + %d ` + off + `) %% wk  < %s
+			o.finish IS NOT NULL AND
+			(o.finish + %d ` + off + `) %% wk >= %s AND
+			(o.finish + %d ` + off + `) %% wk  < %s
 		)
 	)
 )`
@@ -947,10 +1291,10 @@ func buildDayRangeOr(arg *argCount, n, offClient int, overlaps bool) string {
 			s := `
 (
 	` + opening + ` AND
-	(e.start + %d ` + off + `) %% wk >= %s AND
+	(o.start + %d ` + off + `) %% wk >= %s AND
 	(
-	 	(e.finish IS NULL AND     (e.start  + %d ` + off + `) %% wk < %s) OR
-	 	(e.finish IS NOT NULL AND (e.finish + %d ` + off + `) %% wk < %s)
+	 	(o.finish IS NULL AND     (o.start  + %d ` + off + `) %% wk < %s) OR
+	 	(o.finish IS NOT NULL AND (o.finish + %d ` + off + `) %% wk < %s)
 	)
 )`
 			return fmt.Sprintf(s,
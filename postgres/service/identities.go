@@ -0,0 +1,741 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jakebowkett/go-gen/gen"
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+const (
+	fbIdentityUnverified  = "Your email at %s must be verified before you can sign up with it."
+	fbIdentityEmailInUse  = "An account already uses the email you have at %s. Log in and link it from your account's settings instead."
+	fbIdentityLinked      = "That %s identity is already linked to an account."
+	fbIdentityHasProvider = "Your account is already linked to a %s identity."
+	fbIdentityLast        = "This is how you log in. Set a password with the forgot password form before unlinking it."
+)
+
+/*
+identityFlowExpiry is how long someone has to log in at a provider
+before they must start again.
+*/
+const identityFlowExpiry = time.Minute * 10
+
+var errIdentitiesReadOnly = errors.New("identities cannot be changed through the account mode")
+
+/*
+Identities logs in with and links identities at OpenID Connect
+providers. It lists them for the identities submode of the account
+mode but they're only linked by returning from their provider.
+*/
+type Identities struct {
+	*sd.Dependencies
+}
+
+/*
+Identities belong to accounts rather than personas so they're
+given the account's default persona as their owner.
+*/
+const identitySelect = `
+			SELECT
+				i.id,
+				CAST(i.id AS text)     AS slug,
+				i.linked               AS created,
+				i.acc_id               AS accid,
+				p.id                   AS persid,
+				i.provider,
+				i.subject,
+				COALESCE(i.email, '')  AS email
+			FROM
+				identities i
+			JOIN
+				personas p ON p.acc_id = i.acc_id AND p.default_p`
+
+func (ids Identities) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errIdentitiesReadOnly
+}
+func (ids Identities) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errIdentitiesReadOnly
+}
+func (ids Identities) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errIdentitiesReadOnly
+}
+
+func (ids Identities) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(ids.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany returns the identities matching slugs in the same
+order. Slugs that don't match one are skipped.
+*/
+func (ids Identities) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var want []int64
+	for _, slug := range slugs {
+		if id, err := strconv.ParseInt(slug, 10, 64); err == nil {
+			want = append(want, id)
+		}
+	}
+	if len(want) == 0 {
+		return nil, nil
+	}
+
+	var ii []sd.Identity
+
+	errs, err := ids.TryerTx.Try(func() error {
+
+		tx, err := ids.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ii = nil
+		err = tx.Select(&ii, identitySelect+`
+			WHERE
+				i.id = ANY($1)`,
+			pq.Array(want))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ids.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ResourceSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.Identity, len(ii))
+	for i := range ii {
+		bySlug[ii[i].Slug] = &ii[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if i, ok := bySlug[slug]; ok {
+			rr = append(rr, i)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter lists the identities linked to the account owning the
+"persona" key's value, which the account mode always sets. The
+most recently linked are listed first.
+*/
+func (ids Identities) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	vv := filter["persona"]
+	if len(vv) != 1 {
+		return nil, "", errors.New("expected exactly 1 persona while listing identities")
+	}
+	persId, err := strconv.ParseInt(vv[0], 10, 64)
+	if err != nil {
+		return nil, "", err
+	}
+
+	args := []interface{}{persId}
+	arg := argCount(len(args))
+
+	where := `i.acc_id = (SELECT acc_id FROM personas WHERE id = $1)`
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		w, a := cur.after(&arg, true, "i.linked", "i.id")
+		where += " AND\n\t\t\t\t" + w
+		args = append(args, a...)
+	}
+
+	q := identitySelect + `
+			WHERE
+				` + where + `
+			ORDER BY
+				i.linked DESC,
+				i.id DESC` + limit(p)
+
+	var ii []sd.Identity
+
+	errs, err := ids.TryerTx.Try(func() error {
+
+		tx, err := ids.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ii = nil
+		if err := tx.Select(&ii, q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ids.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId)
+		return nil, "", err
+	}
+
+	keep, more := nextPage(p, len(ii))
+	ii = ii[:keep]
+
+	var next string
+	if more {
+		last := ii[len(ii)-1]
+		next = cursor{last.Created, last.Id}.String()
+	}
+
+	rr := make([]sd.Resource, len(ii))
+	for i := range ii {
+		rr[i] = &ii[i]
+	}
+	return rr, next, nil
+}
+
+/*
+Begin keeps f until the client returns from its provider with
+state. Expired logins are cleared out at the same time.
+*/
+func (ids Identities) Begin(reqId, state string, f sd.IdentityFlow) error {
+
+	now := time.Now()
+
+	var accId interface{}
+	if f.AccId != 0 {
+		accId = f.AccId
+	}
+
+	errs, err := ids.TryerTx.Try(func() error {
+
+		tx, err := ids.Db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			DELETE FROM
+				oidc_flows
+			WHERE
+				expires <= $1`,
+			now.Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO oidc_flows (
+				state,
+				provider,
+				verifier,
+				nonce,
+				acc_id,
+				expires
+			)
+			VALUES
+				($1, $2, $3, $4, $5, $6)`,
+			sd.HashToken(state),
+			f.Provider,
+			f.Verifier,
+			f.Nonce,
+			accId,
+			now.Add(identityFlowExpiry).Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ids.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_IdentityProvider, f.Provider)
+		return err
+	}
+
+	return nil
+}
+
+/*
+End removes and returns the unexpired login with provider that
+state was issued to. It returns sql.ErrNoRows if there isn't one
+so that each state may only be used once.
+*/
+func (ids Identities) End(reqId, provider, state string) (*sd.IdentityFlow, error) {
+
+	f := struct {
+		Verifier string
+		Nonce    string
+		AccId    sql.NullInt64
+	}{}
+
+	errs, err := ids.TryerTx.Try(func() error {
+
+		tx, err := ids.Db.Begin()
+		if err != nil {
+			return err
+		}
+		err = tx.Get(&f, `
+			DELETE FROM
+				oidc_flows
+			WHERE
+				state = $1 AND
+				provider = $2 AND
+				expires > $3
+			RETURNING
+				verifier,
+				nonce,
+				acc_id AS accid`,
+			sd.HashToken(state),
+			provider,
+			time.Now().Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if lastErrSqlNoRows(errs) {
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		ids.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_IdentityProvider, provider)
+		return nil, err
+	}
+
+	return &sd.IdentityFlow{
+		Provider: provider,
+		Verifier: f.Verifier,
+		Nonce:    f.Nonce,
+		AccId:    f.AccId.Int64,
+	}, nil
+}
+
+/*
+Login logs in the account ei is linked to. If it isn't linked to
+one an account is made for it, along with a default persona named
+after ei.Handle, as long as its email is verified, no other
+account uses it, and invites aren't required. Accounts using
+two-factor authentication are challenged for a code as when
+logging in with a password.
+*/
+func (ids Identities) Login(reqId string, ei sd.ExternalIdentity, ip, userAgent string) (*sd.AuthedUser, sd.Feedback, error) {
+
+	as := &Account{Dependencies: ids.Dependencies}
+	fb := make(sd.Feedback)
+
+	var accId int64
+	var created bool
+	var handle string
+
+	errs, err := ids.TryerTx.Try(func() error {
+
+		accId = 0
+		created = false
+
+		tx, err := ids.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Get(&accId, `
+			SELECT
+				acc_id
+			FROM
+				identities
+			WHERE
+				provider = $1 AND
+				subject = $2`,
+			ei.Provider,
+			ei.Subject)
+		if err == nil {
+			return tx.Commit()
+		}
+		if err != sql.ErrNoRows {
+			return tx.Rollback(err)
+		}
+
+		// There's no invite code to redeem when signing up this way.
+		if ids.Config.Invite.Gate() == sd.InviteRequired {
+			fb.Add("general", fmt.Sprintf(fbInviteClosed, ids.providerTitle(ei.Provider)))
+			return tx.Rollback(nil)
+		}
+
+		if ei.Email == "" || !ei.EmailVerified {
+			fb.Add("general", fmt.Sprintf(fbIdentityUnverified, ids.providerTitle(ei.Provider)))
+			return tx.Rollback(nil)
+		}
+
+		var inUse bool
+		handle, inUse, err = as.freeHandle(tx, ei.Handle, ei.Email)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if inUse {
+			fb.Add("general", fmt.Sprintf(fbIdentityEmailInUse, ids.providerTitle(ei.Provider)))
+			return tx.Rollback(nil)
+		}
+
+		now := time.Now().Unix()
+
+		// Identities can't be used to log in until one is set.
+		err = tx.Get(&accId, `
+			INSERT INTO accounts (
+				email,
+				pass,
+				created,
+				updated
+			)
+			VALUES
+				($1, '', $2, $2)
+			RETURNING
+				id`,
+			ei.Email,
+			now)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		full, err := as.personasFull(tx, accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if full {
+			msg := "accounts may not have any personas"
+			return tx.Rollback(errors.New(msg))
+		}
+
+		slug, _ := gen.AlphaNum(11)
+		_, err = tx.Exec(`
+			INSERT INTO personas (
+				acc_id,
+				slug,
+				handle,
+				name,
+				default_p,
+				created,
+				updated,
+				visibility
+			)
+			VALUES
+				($1, $2, $3, $4, $5, $6, $7, $8)`,
+			accId,
+			slug,
+			handle,
+			handle,
+			true,
+			now,
+			now,
+			sd.VisibilityPublic,
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		if err := insertIdentity(tx, accId, ei, now); err != nil {
+			return tx.Rollback(err)
+		}
+
+		created = true
+		return tx.Commit()
+	})
+	if err != nil {
+		ids.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_IdentityProvider, ei.Provider).
+			Data(sd.LK_IdentitySubject, ei.Subject)
+		return nil, nil, err
+	}
+	if len(fb) > 0 {
+		return nil, fb, nil
+	}
+
+	if created {
+		ids.Logger.Info(reqId, "Created account from identity.").
+			Data(sd.LK_AccId, accId).
+			Data(sd.LK_AccEmail, ei.Email).
+			Data(sd.LK_PersHandle, handle).
+			Data(sd.LK_IdentityProvider, ei.Provider).
+			Data(sd.LK_IdentitySubject, ei.Subject)
+	}
+
+	account, err := as.RetrieveById(reqId, accId, sd.AccOptRetrieve{
+		Confirmed: true,
+	})
+	if err == sql.ErrNoRows {
+		fb.Add("general", fbLoginInvalid)
+		return nil, fb, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if account.TwoFactor {
+		challenge, err := as.loginChallenge(reqId, account.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &sd.AuthedUser{
+			Challenge: challenge,
+			Account:   account,
+		}, nil, nil
+	}
+
+	// Failed attempts with a password aren't forgotten.
+	auth, err := as.createLogin(reqId, account, nil, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return auth, nil, nil
+}
+
+/*
+freeHandle returns handle, or handle followed by a number, such
+that it isn't used by another persona or reserved. If email is in
+use instead no handle is returned.
+*/
+func (as *Account) freeHandle(tx sd.Tx, handle, email string) (free string, emailInUse bool, err error) {
+
+	handle = sanitiseHandle(handle, as.Config.MinHandle, as.Config.MaxHandle)
+
+	for n := 1; ; n++ {
+
+		try := handle
+		if n > 1 {
+			suffix := strconv.Itoa(n)
+			try = truncate(handle, as.Config.MaxHandle-len(suffix)) + suffix
+		}
+
+		fb := make(sd.Feedback)
+		if err := as.handleAndEmailFree(tx, fb, try, email, true); err != nil {
+			return "", false, err
+		}
+		if _, ok := fb["email"]; ok {
+			return "", true, nil
+		}
+		if _, ok := fb["handle"]; !ok {
+			return try, false, nil
+		}
+	}
+}
+
+/*
+sanitiseHandle keeps the letters, digits, hyphens and underscores
+of handle, up to max of them. Handles left shorter than min are
+replaced.
+*/
+func sanitiseHandle(handle string, min, max int) string {
+	handle = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, handle)
+	handle = truncate(handle, max)
+	if len([]rune(handle)) < min || handle == "" {
+		handle = truncate("storydev", max)
+	}
+	return handle
+}
+
+func truncate(s string, max int) string {
+	rr := []rune(s)
+	if len(rr) > max {
+		rr = rr[:max]
+	}
+	return string(rr)
+}
+
+func insertIdentity(tx sd.Tx, accId int64, ei sd.ExternalIdentity, now int64) error {
+	var email interface{}
+	if ei.Email != "" {
+		email = ei.Email
+	}
+	_, err := tx.Exec(`
+		INSERT INTO identities (
+			acc_id,
+			provider,
+			subject,
+			email,
+			linked
+		)
+		VALUES
+			($1, $2, $3, $4, $5)`,
+		accId,
+		ei.Provider,
+		ei.Subject,
+		email,
+		now)
+	return err
+}
+
+/*
+Link links ei to accId. Feedback is given if ei is linked to any
+account already or if accId has an identity with its provider.
+*/
+func (ids Identities) Link(reqId string, accId int64, ei sd.ExternalIdentity) (sd.Feedback, error) {
+
+	fb := make(sd.Feedback)
+	title := ids.providerTitle(ei.Provider)
+
+	errs, err := ids.TryerTx.Try(func() error {
+
+		tx, err := ids.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		exists, err := tx.Exists(`
+			FROM
+				identities
+			WHERE
+				provider = $1 AND
+				subject = $2`,
+			ei.Provider,
+			ei.Subject)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if exists {
+			fb.Add("general", fmt.Sprintf(fbIdentityLinked, title))
+			return tx.Rollback(nil)
+		}
+
+		exists, err = tx.Exists(`
+			FROM
+				identities
+			WHERE
+				acc_id = $1 AND
+				provider = $2`,
+			accId,
+			ei.Provider)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if exists {
+			fb.Add("general", fmt.Sprintf(fbIdentityHasProvider, title))
+			return tx.Rollback(nil)
+		}
+
+		if err := insertIdentity(tx, accId, ei, time.Now().Unix()); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ids.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId).
+			Data(sd.LK_IdentityProvider, ei.Provider)
+		return nil, err
+	}
+	if len(fb) > 0 {
+		return fb, nil
+	}
+
+	ids.Logger.Info(reqId, "Linked identity.").
+		Data(sd.LK_AccId, accId).
+		Data(sd.LK_IdentityProvider, ei.Provider).
+		Data(sd.LK_IdentitySubject, ei.Subject)
+
+	return nil, nil
+}
+
+/*
+Unlink unlinks the identity of accId whose slug is slug. It's not
+ok if accId has no such identity. Feedback is given rather than
+unlinking the last identity of an account without a password.
+*/
+func (ids Identities) Unlink(reqId string, accId int64, slug string) (bool, sd.Feedback, error) {
+
+	id, err := strconv.ParseInt(slug, 10, 64)
+	if err != nil {
+		return false, nil, nil
+	}
+
+	fb := make(sd.Feedback)
+	var n int64
+
+	errs, err := ids.TryerTx.Try(func() error {
+
+		tx, err := ids.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		var last bool
+		err = tx.Get(&last, `
+			SELECT
+				accounts.pass = '' AND (
+					SELECT
+						COUNT(*)
+					FROM
+						identities
+					WHERE
+						acc_id = $1
+				) = 1
+			FROM
+				accounts
+			WHERE
+				id = $1`,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if last {
+			fb.Add("general", fbIdentityLast)
+			return tx.Rollback(nil)
+		}
+
+		result, err := tx.Exec(`
+			DELETE FROM
+				identities
+			WHERE
+				id = $1 AND
+				acc_id = $2`,
+			id,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		n, err = result.RowsAffected()
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ids.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId).
+			Data(sd.LK_IdentityId, id)
+		return false, nil, err
+	}
+	if len(fb) > 0 {
+		return false, fb, nil
+	}
+	if n == 0 {
+		return false, nil, nil
+	}
+
+	ids.Logger.Info(reqId, "Unlinked identity.").
+		Data(sd.LK_AccId, accId).
+		Data(sd.LK_IdentityId, id)
+
+	return true, nil, nil
+}
+
+// providerTitle is what provider is called in feedback.
+func (ids Identities) providerTitle(provider string) string {
+	if p, ok := ids.Config.OIDC[provider]; ok && p.Title != "" {
+		return p.Title
+	}
+	return provider
+}
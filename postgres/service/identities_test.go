@@ -0,0 +1,27 @@
+package service
+
+import "testing"
+
+func TestSanitiseHandle(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ada_lovelace", "ada_lovelace"},
+		{"Ada Lovelace!", "AdaLovelace"},
+		{"émile-zola", "émile-zola"},
+		{"a.b@c", "abc"},
+		{"averyveryverylonghandle", "averyveryverylon"},
+		{"ab", "storydev"},
+		{"!!!", "storydev"},
+		{"", "storydev"},
+	}
+	for _, tt := range tests {
+		if got := sanitiseHandle(tt.in, 3, 16); got != tt.want {
+			t.Errorf("sanitiseHandle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := sanitiseHandle("", 1, 5); got != "story" {
+		t.Errorf("replacement handle is %q, want it truncated to %q", got, "story")
+	}
+}
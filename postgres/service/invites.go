@@ -0,0 +1,497 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+const (
+	fbInviteRequired = "an invite code is needed to register"
+	fbInviteInvalid  = "invite code is invalid, expired, or used up"
+	fbInviteLimit    = "You already have as many active invites as you may. Revoke one or wait for one to run out before making another."
+	fbInviteClosed   = "Signing up needs an invite code. Register with one and then link your %s identity from your account."
+)
+
+var errInvitesReadOnly = errors.New("invites cannot be changed through the account mode")
+
+/*
+Invites makes and revokes the invite codes needed to register.
+It lists them for the invites submode of the account mode but
+they're only made through the invite modal.
+*/
+type Invites struct {
+	*sd.Dependencies
+}
+
+/*
+inviteSelect takes the time as $1 to work out whether invites
+are active. Those whose persona has been deleted aren't.
+*/
+const inviteSelect = `
+			SELECT
+				i.id,
+				CAST(i.id AS text)  AS slug,
+				i.created,
+				i.updated,
+				p.acc_id            AS accid,
+				i.p_id              AS persid,
+				i.code,
+				i.note,
+				i.uses,
+				i.max_uses,
+				i.expires,
+				i.revoked,
+				(
+					i.revoked IS NULL AND
+					i.uses < i.max_uses AND
+					(i.expires IS NULL OR i.expires > $1) AND
+					p.deleted IS NULL
+				)                   AS active
+			FROM
+				invites i
+			JOIN
+				personas p ON p.id = i.p_id`
+
+func (is Invites) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errInvitesReadOnly
+}
+func (is Invites) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errInvitesReadOnly
+}
+func (is Invites) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errInvitesReadOnly
+}
+
+func (is Invites) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(is.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany returns the invites matching slugs in the same
+order. Slugs that don't match one are skipped.
+*/
+func (is Invites) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var ids []int64
+	for _, slug := range slugs {
+		if id, err := strconv.ParseInt(slug, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var ii []sd.Invite
+
+	errs, err := is.TryerTx.Try(func() error {
+
+		tx, err := is.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ii = nil
+		err = tx.Select(&ii, inviteSelect+`
+			WHERE
+				i.id = ANY($2)`,
+			time.Now().Unix(),
+			pq.Array(ids))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if err := inviteAccounts(tx, ii); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		is.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_InviteSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.Invite, len(ii))
+	for i := range ii {
+		bySlug[ii[i].Slug] = &ii[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if i, ok := bySlug[slug]; ok {
+			rr = append(rr, i)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter lists the invites made by any persona of the account
+owning the "persona" key's value, which the account mode always
+sets. The most recently made are listed first.
+*/
+func (is Invites) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	vv := filter["persona"]
+	if len(vv) != 1 {
+		return nil, "", errors.New("expected exactly 1 persona while listing invites")
+	}
+	persId, err := strconv.ParseInt(vv[0], 10, 64)
+	if err != nil {
+		return nil, "", err
+	}
+
+	args := []interface{}{time.Now().Unix(), persId}
+	arg := argCount(len(args))
+
+	where := `p.acc_id = (SELECT acc_id FROM personas WHERE id = $2)`
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		w, a := cur.after(&arg, true, "i.created", "i.id")
+		where += " AND\n\t\t\t\t" + w
+		args = append(args, a...)
+	}
+
+	q := inviteSelect + `
+			WHERE
+				` + where + `
+			ORDER BY
+				i.created DESC,
+				i.id DESC` + limit(p)
+
+	var ii []sd.Invite
+
+	errs, err := is.TryerTx.Try(func() error {
+
+		tx, err := is.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ii = nil
+		if err := tx.Select(&ii, q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := inviteAccounts(tx, ii); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		is.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId)
+		return nil, "", err
+	}
+
+	keep, more := nextPage(p, len(ii))
+	ii = ii[:keep]
+
+	var next string
+	if more {
+		last := ii[len(ii)-1]
+		next = cursor{last.Created, last.Id}.String()
+	}
+
+	rr := make([]sd.Resource, len(ii))
+	for i := range ii {
+		rr[i] = &ii[i]
+	}
+	return rr, next, nil
+}
+
+// inviteAccounts fills in the accounts that registered with ii.
+func inviteAccounts(tx sd.Tx, ii []sd.Invite) error {
+
+	if len(ii) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(ii))
+	for i := range ii {
+		ids[i] = ii[i].Id
+		ii[i].Accounts = nil
+	}
+
+	var rows []struct {
+		InviteId int64 `db:"invite_id"`
+		AccId    int64 `db:"acc_id"`
+		Redeemed int64
+		Handle   sql.NullString
+	}
+	err := tx.Select(&rows, `
+		SELECT
+			r.invite_id,
+			r.acc_id,
+			r.redeemed,
+			p.handle
+		FROM
+			invite_redemptions r
+		LEFT JOIN
+			personas p ON p.acc_id = r.acc_id AND p.deleted IS NULL
+		WHERE
+			r.invite_id = ANY($1)
+		ORDER BY
+			r.redeemed,
+			r.acc_id,
+			p.default_p DESC NULLS LAST,
+			p.id`,
+		pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	byId := make(map[int64]*sd.Invite, len(ii))
+	for i := range ii {
+		byId[ii[i].Id] = &ii[i]
+	}
+	var lastInvite, lastAcc int64
+	for _, row := range rows {
+		inv := byId[row.InviteId]
+		if row.InviteId != lastInvite || row.AccId != lastAcc {
+			inv.Accounts = append(inv.Accounts, sd.InviteAccount{Redeemed: row.Redeemed})
+			lastInvite, lastAcc = row.InviteId, row.AccId
+		}
+		if row.Handle.Valid {
+			ia := &inv.Accounts[len(inv.Accounts)-1]
+			ia.Handles = append(ia.Handles, row.Handle.String)
+		}
+	}
+	return nil
+}
+
+/*
+New makes an invite for persId noted with note. It may be used
+Config.Invite.Uses times and expires after Config.Invite.Days.
+Personas other than admins may only have Config.Invite.Limit
+invites active at once.
+*/
+func (is Invites) New(reqId string, persId int64, admin bool, note string) (*sd.Invite, sd.Feedback, error) {
+
+	ic := is.Config.Invite
+	fb := make(sd.Feedback)
+
+	var noteArg interface{}
+	if note = strings.TrimSpace(note); note != "" {
+		noteArg = note
+	}
+
+	var inv sd.Invite
+
+	errs, err := is.TryerTx.Try(func() error {
+
+		tx, err := is.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		if !admin && ic.Limit > 0 {
+			var active int
+			err = tx.Get(&active, `
+				SELECT
+					COUNT(*)
+				FROM
+					invites
+				WHERE
+					p_id = $1 AND
+					revoked IS NULL AND
+					uses < max_uses AND
+					(expires IS NULL OR expires > $2)`,
+				persId,
+				now.Unix())
+			if err != nil {
+				return tx.Rollback(err)
+			}
+			if active >= ic.Limit {
+				fb.Add("general", fbInviteLimit)
+				return tx.Rollback(nil)
+			}
+		}
+
+		uses, expires := inviteTerms(ic, now)
+
+		code, err := newInviteCode()
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		inv = sd.Invite{
+			Code:    code,
+			MaxUses: uses,
+			Active:  true,
+		}
+		inv.PersId = persId
+		inv.Created = now.Unix()
+		inv.Updated = now.Unix()
+
+		err = tx.Get(&inv.Id, `
+			INSERT INTO invites (
+				p_id,
+				code,
+				note,
+				max_uses,
+				expires,
+				created,
+				updated
+			)
+			VALUES
+				($1, $2, $3, $4, $5, $6, $6)
+			RETURNING
+				id`,
+			persId,
+			code,
+			noteArg,
+			uses,
+			expires,
+			now.Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		is.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId)
+		return nil, nil, errors.New("Unable to make invite.")
+	}
+
+	if len(fb) > 0 {
+		return nil, fb, nil
+	}
+
+	inv.Slug = strconv.FormatInt(inv.Id, 10)
+
+	is.Logger.Info(reqId, "Made invite.").
+		Data(sd.LK_PersId, persId).
+		Data(sd.LK_InviteId, inv.Id)
+
+	return &inv, nil, nil
+}
+
+/*
+inviteTerms returns how many times an invite made at now may be
+used and when it expires, which is nil if it doesn't. Invites may
+always be used at least once.
+*/
+func inviteTerms(ic sd.InviteConfig, now time.Time) (uses int, expires interface{}) {
+	uses = ic.Uses
+	if uses < 1 {
+		uses = 1
+	}
+	if ic.Days > 0 {
+		expires = now.AddDate(0, 0, ic.Days).Unix()
+	}
+	return uses, expires
+}
+
+/*
+Revoke stops the invite with slug, which must have been made by
+one of accId's personas, from being registered with. It reports
+false if there's no such invite or it was already revoked.
+*/
+func (is Invites) Revoke(reqId string, accId int64, slug string) (bool, error) {
+
+	id, err := strconv.ParseInt(slug, 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	var n int64
+
+	errs, err := is.TryerTx.Try(func() error {
+
+		tx, err := is.Db.Begin()
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`
+			UPDATE
+				invites
+			SET
+				revoked = $1,
+				updated = $1
+			WHERE
+				id = $2 AND
+				revoked IS NULL AND
+				p_id IN (SELECT id FROM personas WHERE acc_id = $3)`,
+			time.Now().Unix(),
+			id,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		is.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId).
+			Data(sd.LK_InviteSlug, slug)
+		return false, err
+	}
+
+	if n > 0 {
+		is.Logger.Info(reqId, "Revoked invite.").
+			Data(sd.LK_AccId, accId).
+			Data(sd.LK_InviteId, id)
+	}
+
+	return n > 0, nil
+}
+
+/*
+redeemInvite uses up one of the uses of the active invite with
+code. It returns the invite's id or zero if there's no such
+invite. Checking and using it in one statement means the last
+use can't be taken twice by concurrent registrations.
+*/
+func redeemInvite(tx sd.Tx, code string, now int64) (int64, error) {
+	var id int64
+	err := tx.Get(&id, `
+		UPDATE
+			invites i
+		SET
+			uses = i.uses + 1,
+			updated = $2
+		FROM
+			personas p
+		WHERE
+			p.id = i.p_id AND
+			p.deleted IS NULL AND
+			i.code = $1 AND
+			i.revoked IS NULL AND
+			i.uses < i.max_uses AND
+			(i.expires IS NULL OR i.expires > $2)
+		RETURNING
+			i.id`,
+		code,
+		now)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+/*
+newInviteCode returns a random code short enough to be typed in.
+Unlike confirmation codes it's stored as is so its owner can see
+it again.
+*/
+func newInviteCode() (string, error) {
+	return sd.Base62(9)
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+func TestInviteTerms(t *testing.T) {
+
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		ic      sd.InviteConfig
+		uses    int
+		expires interface{}
+	}{
+		{"unconfigured", sd.InviteConfig{}, 1, nil},
+		{"negative uses", sd.InviteConfig{Uses: -3}, 1, nil},
+		{"several uses", sd.InviteConfig{Uses: 5}, 5, nil},
+		{"expires", sd.InviteConfig{Uses: 1, Days: 7}, 1, now.AddDate(0, 0, 7).Unix()},
+		{"negative days", sd.InviteConfig{Uses: 1, Days: -7}, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uses, expires := inviteTerms(tt.ic, now)
+			if uses != tt.uses {
+				t.Errorf("got %d uses, want %d", uses, tt.uses)
+			}
+			if expires != tt.expires {
+				t.Errorf("expires at %v, want %v", expires, tt.expires)
+			}
+		})
+	}
+}
+
+/*
+testTx is a stand-in for a transaction whose Get calls get. Its
+other methods aren't expected to be used.
+*/
+type testTx struct {
+	sd.Tx
+	get func(dest interface{}, q string, args ...interface{}) error
+}
+
+func (tx testTx) Get(dest interface{}, q string, args ...interface{}) error {
+	return tx.get(dest, q, args...)
+}
+
+func TestRedeemInvite(t *testing.T) {
+
+	now := time.Now().Unix()
+	failed := errors.New("connection lost")
+
+	tests := []struct {
+		name string
+		id   int64
+		err  error
+		want int64
+		fail error
+	}{
+		{"usable", 12, nil, 12, nil},
+		{"unusable", 0, sql.ErrNoRows, 0, nil},
+		{"failed", 0, failed, 0, failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q string
+			var args []interface{}
+			tx := testTx{get: func(dest interface{}, query string, a ...interface{}) error {
+				q, args = query, a
+				if tt.err != nil {
+					return tt.err
+				}
+				*dest.(*int64) = tt.id
+				return nil
+			}}
+
+			id, err := redeemInvite(tx, "abc", now)
+			if id != tt.want || err != tt.fail {
+				t.Errorf("got %d, %v, want %d, %v", id, err, tt.want, tt.fail)
+			}
+
+			if len(args) != 2 || args[0] != "abc" || args[1] != now {
+				t.Errorf("called with %v, want the code and the time", args)
+			}
+			for _, cond := range []string{
+				"i.revoked IS NULL",
+				"i.uses < i.max_uses",
+				"(i.expires IS NULL OR i.expires > $2)",
+				"p.deleted IS NULL",
+				"uses = i.uses + 1",
+			} {
+				if !strings.Contains(q, cond) {
+					t.Errorf("statement doesn't contain %q", cond)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+/*
+jobsLock is the key of the advisory lock held by the server
+scheduling jobs. It spells "jobs" so it's recognisable among
+any other locks in pg_locks.
+*/
+const jobsLock = 0x6a6f6273
+
+/*
+jobLease is how long a job being run is left alone by other
+workers. If the worker running it stops before recording the
+outcome the job is attempted again once it has passed.
+*/
+const jobLease = time.Minute * 5
+
+/*
+jobLookahead is how far ahead jobs are scheduled so that they're
+stored before they're due, even if no server is running then.
+*/
+const jobLookahead = time.Hour * 24
+
+/*
+Jobs schedules and runs jobs. Reminders of events are given as
+notifications so they're emailed by Digests according to each
+persona's settings.
+*/
+type Jobs struct {
+	*sd.Dependencies
+}
+
+type jobClaim struct {
+	Id       int64
+	Kind     string
+	RefId    int64
+	Occurs   int64
+	Due      int64
+	Attempts int
+}
+
+/*
+Schedule holds a transaction level advisory lock while it
+schedules so that only one server does so at a time. Those that
+can't take the lock leave it to the one that did.
+*/
+func (jb Jobs) Schedule(reqId string) (int, error) {
+
+	now := time.Now()
+	grace := time.Second * time.Duration(jb.Config.Jobs.Interval) * 2
+
+	before := make([]int64, len(jb.Config.Reminder.Before))
+	for i, m := range jb.Config.Reminder.Before {
+		before[i] = int64(m) * 60
+	}
+
+	var n int64
+
+	errs, err := jb.TryerTx.Try(func() error {
+
+		tx, err := jb.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		n = 0
+		var leader bool
+		err = tx.Get(&leader, `SELECT pg_try_advisory_xact_lock($1)`, jobsLock)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !leader {
+			return tx.Rollback(nil)
+		}
+
+		_, err = tx.Exec(`
+			DELETE FROM
+				job
+			WHERE
+				status = $1 AND
+				occurs < $2`,
+			sd.JobDone,
+			now.Unix(),
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		if len(before) > 0 {
+			if n, err = scheduleReminders(tx, now, grace, before); err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		jb.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+/*
+scheduleReminders adds a reminder before each occurrence that
+starts within jobLookahead of now for each of before, in seconds,
+if anyone is going to or interested in its event. Occurrences are
+stored in UTC as worked out from their event's timezone so each
+reminder is the same time before them whatever the timezone.
+Those that became due within grace of now are still added so that
+personas who responded since the last call are reminded.
+*/
+func scheduleReminders(tx sd.Tx, now time.Time, grace time.Duration, before []int64) (int64, error) {
+	res, err := tx.Exec(`
+		INSERT INTO job (
+			created,
+			kind,
+			ref_id,
+			occurs,
+			due,
+			next_attempt
+		)
+		SELECT
+			$1,
+			$2,
+			o.ref_id,
+			o.start,
+			o.start - b,
+			o.start - b
+		FROM
+			event_occurrence AS o
+		CROSS JOIN
+			unnest($3::bigint[]) AS b
+		WHERE
+			o.start > $1 AND
+			o.start - b > $4 AND
+			o.start - b <= $5 AND
+			EXISTS (
+				SELECT
+					1
+				FROM
+					event_rsvp AS r
+				WHERE
+					r.e_id = o.ref_id AND
+					r.status IN ($6, $7)
+			)
+		ON CONFLICT DO NOTHING`,
+		now.Unix(),
+		sd.JobReminder,
+		pq.Array(before),
+		now.Add(-grace).Unix(),
+		now.Add(jobLookahead).Unix(),
+		sd.RSVPGoing,
+		sd.RSVPInterested,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+/*
+Run attempts each job once. Failed jobs wait according to the
+"email" retry config, like emails in the outbox, rather than
+holding up the rest of the batch and outliving their lease.
+*/
+func (jb Jobs) Run(reqId string) (done, failed int, err error) {
+
+	for i := 0; i < jb.Config.Jobs.Batch; i++ {
+
+		j, err := jb.claim(reqId)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return done, failed, err
+		}
+
+		runErr := jb.run(j)
+		if runErr != nil {
+			jb.Logger.Error(reqId, runErr.Error()).
+				Data(sd.LK_JobId, j.Id).
+				Data(sd.LK_JobKind, j.Kind).
+				Data(sd.LK_JobAttempt, j.Attempts)
+		}
+
+		if err := jb.settle(reqId, j, runErr); err != nil {
+			return done, failed, err
+		}
+		if runErr != nil {
+			failed++
+		} else {
+			done++
+		}
+	}
+
+	return done, failed, nil
+}
+
+/*
+claim takes the job that has been due the longest and pushes
+back its next attempt by jobLease. It returns sql.ErrNoRows when
+no job is due.
+*/
+func (jb Jobs) claim(reqId string) (*jobClaim, error) {
+
+	var j jobClaim
+	var none bool
+
+	errs, err := jb.TryerTx.Try(func() error {
+
+		tx, err := jb.Db.Begin()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		j = jobClaim{}
+		err = tx.Get(&j, `
+			UPDATE
+				job
+			SET
+				attempts = attempts + 1,
+				next_attempt = $1
+			WHERE
+				id = (
+					SELECT
+						id
+					FROM
+						job
+					WHERE
+						status = $2 AND
+						next_attempt <= $3
+					ORDER BY
+						next_attempt,
+						id
+					LIMIT 1
+					FOR UPDATE SKIP LOCKED
+				)
+			RETURNING
+				id,
+				kind,
+				ref_id AS refid,
+				occurs,
+				due,
+				attempts`,
+			now.Add(jobLease).Unix(),
+			sd.JobPending,
+			now.Unix(),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			none = true
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		jb.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return nil, err
+	}
+	if none {
+		return nil, sql.ErrNoRows
+	}
+
+	return &j, nil
+}
+
+// run does the work of j in its own transaction.
+func (jb Jobs) run(j *jobClaim) error {
+
+	tx, err := jb.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	switch j.Kind {
+	case sd.JobReminder:
+		err = remind(tx, j)
+	default:
+		err = fmt.Errorf("unknown kind of job %q", j.Kind)
+	}
+	if err != nil {
+		return tx.Rollback(err)
+	}
+
+	return tx.Commit()
+}
+
+/*
+remind notifies the personas with a place at the event of j or
+interested in it that the occurrence starting at j.Occurs is soon.
+Nobody is reminded if the event was deleted or hidden, if it no
+longer occurs then, or if the occurrence has already started.
+Reminders made late by the server not running are skipped in
+favour of any later one that's also due so that personas aren't
+reminded twice at once.
+*/
+func remind(tx sd.Tx, j *jobClaim) error {
+
+	now := time.Now().Unix()
+
+	var e struct {
+		Id   int64
+		Slug string
+		Name sd.NullString
+	}
+	err := tx.Get(&e, `
+		SELECT
+			e.id,
+			e.slug,
+			e.name
+		FROM
+			event AS e
+		WHERE
+			e.id = $1 AND
+			e.hidden IS NULL AND
+			$2 > $3 AND
+			EXISTS (
+				SELECT
+					1
+				FROM
+					event_occurrence AS o
+				WHERE
+					o.ref_id = e.id AND
+					o.start = $2
+			) AND
+			NOT EXISTS (
+				SELECT
+					1
+				FROM
+					job
+				WHERE
+					kind = $4 AND
+					ref_id = e.id AND
+					occurs = $2 AND
+					due > $5 AND
+					due <= $3
+			)`,
+		j.RefId,
+		j.Occurs,
+		now,
+		sd.JobReminder,
+		j.Due,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ids, err := placed(tx, e.Id)
+	if err != nil {
+		return err
+	}
+	var interested []int64
+	err = tx.Select(&interested, `
+		SELECT
+			r.p_id
+		FROM
+			event_rsvp AS r
+		JOIN
+			personas AS p ON p.id = r.p_id
+		WHERE
+			r.e_id = $1 AND
+			r.status = $2 AND
+			p.deleted IS NULL`,
+		e.Id,
+		sd.RSVPInterested,
+	)
+	if err != nil {
+		return err
+	}
+
+	var nn []sd.Notification
+	for _, id := range append(ids, interested...) {
+		n := sd.Notification{
+			Kind:       sd.NotifyEvent,
+			TargetKind: "event",
+			TargetSlug: e.Slug,
+			TargetName: e.Name,
+			Detail:     sd.NullString{String: sd.EventReminder},
+		}
+		n.PersId = id
+		nn = append(nn, n)
+	}
+
+	return notify(tx, nn...)
+}
+
+/*
+settle marks j as done if it ran. Otherwise its next attempt is
+scheduled according to the "email" retry config or, if it has
+used up its attempts, it's marked dead.
+*/
+func (jb Jobs) settle(reqId string, j *jobClaim, runErr error) error {
+
+	status := sd.JobDone
+	next := time.Now().Unix()
+	var lastErr interface{}
+
+	if runErr != nil {
+		rc := jb.Config.Retry["email"]
+		status = sd.JobPending
+		if j.Attempts > rc.Retries {
+			status = sd.JobDead
+		}
+		next = time.Now().Add(rc.Backoff(j.Attempts)).Unix()
+		lastErr = runErr.Error()
+	}
+
+	errs, err := jb.TryerTx.Try(func() error {
+
+		tx, err := jb.Db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE
+				job
+			SET
+				status = $2,
+				next_attempt = $3,
+				last_error = $4
+			WHERE
+				id = $1`,
+			j.Id,
+			status,
+			next,
+			lastErr,
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		jb.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_JobId, j.Id).
+			Data(sd.LK_JobKind, j.Kind)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+const (
+	fbLoginInvalid = "unable to log in, please check your details"
+	fbLoginWait    = "too many failed attempts, please try again in %s"
+)
+
+/*
+loginKey is something failed log in attempts are counted
+against. See the login_failures table for how keys are named.
+When acc is non-nil its owner is emailed if it's locked out.
+*/
+type loginKey struct {
+	key   string
+	limit int
+	acc   *sd.Account
+}
+
+/*
+loginKeys returns the keys an attempt to log in as identity
+from ip counts against. Attempts against an account count
+against it whether identity was its handle or email.
+*/
+func (as *Account) loginKeys(acc *sd.Account, identity, ip string) []loginKey {
+	c := as.Config.Login
+	kk := []loginKey{{key: "ip:" + ip, limit: c.IPLockout}}
+	if acc != nil {
+		kk = append(kk, loginKey{
+			key:   "acc:" + strconv.FormatInt(acc.Id, 10),
+			limit: c.Lockout,
+			acc:   acc,
+		})
+	} else {
+		kk = append(kk, loginKey{
+			key:   "id:" + strings.ToLower(identity),
+			limit: c.Lockout,
+		})
+	}
+	return kk
+}
+
+/*
+loginWait returns how long must pass before any of kk may
+attempt to log in again.
+*/
+func (as *Account) loginWait(reqId string, kk []loginKey) (time.Duration, error) {
+
+	var keys []string
+	for _, k := range kk {
+		keys = append(keys, k.key)
+	}
+
+	var ff []struct {
+		Failures    int
+		LastFailure int64
+		LockedUntil int64
+	}
+
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ff = nil
+		err = tx.Select(&ff, `
+			SELECT
+				failures,
+				last_failure AS lastfailure,
+				locked_until AS lockeduntil
+			FROM
+				login_failures
+			WHERE
+				key = ANY($1)`,
+			pq.Array(keys))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return 0, err
+	}
+
+	c := as.Config.Login
+	now := time.Now()
+	forget := now.Add(-time.Minute * time.Duration(c.Window))
+
+	var until time.Time
+	for _, f := range ff {
+		if locked := time.Unix(f.LockedUntil, 0); locked.After(until) {
+			until = locked
+		}
+		last := time.Unix(f.LastFailure, 0)
+		if last.Before(forget) {
+			continue
+		}
+		if next := last.Add(c.Wait(f.Failures)); next.After(until) {
+			until = next
+		}
+	}
+
+	if !until.After(now) {
+		return 0, nil
+	}
+	return until.Sub(now), nil
+}
+
+/*
+loginFailed counts a failed attempt to log in against each of
+kk. Those that reach their limit are locked out and, for
+accounts, their owner is emailed the first time it happens.
+*/
+func (as *Account) loginFailed(reqId, ip string, kk []loginKey) error {
+
+	c := as.Config.Login
+	var locked []loginKey
+
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		forget := now.Add(-time.Minute * time.Duration(c.Window))
+		lockUntil := now.Add(time.Minute * time.Duration(c.LockoutFor))
+		locked = nil
+
+		_, err = tx.Exec(`
+			DELETE FROM
+				login_failures
+			WHERE
+				last_failure < $1 AND
+				locked_until < $2`,
+			forget.Unix(),
+			now.Unix(),
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		for _, k := range kk {
+
+			var f struct {
+				Failures    int
+				LockedUntil int64
+			}
+			err := tx.Get(&f, `
+				INSERT INTO login_failures (
+					key,
+					failures,
+					last_failure
+				)
+				VALUES
+					($1, 1, $2)
+				ON CONFLICT (key) DO UPDATE SET
+					failures = login_failures.failures + 1,
+					last_failure = $2
+				RETURNING
+					failures,
+					locked_until AS lockeduntil`,
+				k.key,
+				now.Unix(),
+			)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+
+			if k.limit <= 0 || f.Failures < k.limit {
+				continue
+			}
+			if f.LockedUntil > now.Unix() {
+				continue
+			}
+
+			_, err = tx.Exec(`
+				UPDATE
+					login_failures
+				SET
+					locked_until = $2
+				WHERE
+					key = $1`,
+				k.key,
+				lockUntil.Unix(),
+			)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+			locked = append(locked, k)
+
+			if k.acc == nil {
+				continue
+			}
+			e, err := renderEmail(as.EmailView, "lockout", k.acc.Email, "Your StoryDevs account has been locked", struct {
+				Subject string
+				Handle  string
+				Until   string
+				Forgot  string
+			}{
+				Subject: "Your StoryDevs account has been locked",
+				Handle:  k.acc.ActivePersona().Handle,
+				Until:   lockUntil.UTC().Format("15:04 MST on 2 January 2006"),
+				Forgot:  as.Config.SiteURL() + "/forgot",
+			})
+			if err != nil {
+				return tx.Rollback(err)
+			}
+			if err := queueEmail(tx, e); err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_IP, ip)
+		return err
+	}
+
+	for _, k := range locked {
+		entry := as.Logger.Info(reqId, "Locked out of logging in.").
+			Data(sd.LK_IP, ip).
+			Data(sd.LK_LoginFailures, k.limit)
+		if k.acc != nil {
+			entry.Data(sd.LK_AccId, k.acc.Id)
+		}
+	}
+
+	return nil
+}
+
+// loginWaitText describes d rounded up to seconds or minutes.
+func loginWaitText(d time.Duration) string {
+	n, unit := int(math.Ceil(d.Seconds())), "second"
+	if d > time.Minute {
+		n, unit = int(math.Ceil(d.Minutes())), "minute"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
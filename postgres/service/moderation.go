@@ -0,0 +1,639 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+/*
+privColumns selects every column of the privileges table
+so that it can be scanned into an embedded sd.Privileges.
+*/
+var privColumns = func() string {
+	ss := make([]string, len(sd.PrivNames))
+	for i, priv := range sd.PrivNames {
+		ss[i] = "privileges." + priv
+	}
+	return strings.Join(ss, ",\n\t\t\t\t")
+}()
+
+/*
+modeTables maps each mode to the table holding its resources,
+whose tags are in the table of the same name suffixed with
+"_tag". Modes sharing a table are told apart by their kind.
+*/
+var modeTables = map[string]string{
+	"talent":  "profile",
+	"event":   "event",
+	"forums":  "post",
+	"library": "post",
+}
+
+type Moderation struct {
+	*sd.Dependencies
+}
+
+func (m Moderation) HidePost(reqId string, mod sd.Persona, slug string, hide bool, reason string) error {
+	return m.setFlag(reqId, mod, "post", slug, "hidden", hide, false, reason)
+}
+func (m Moderation) LockThread(reqId string, mod sd.Persona, slug string, lock bool, reason string) error {
+	return m.setFlag(reqId, mod, "post", slug, "locked", lock, true, reason)
+}
+func (m Moderation) PinThread(reqId string, mod sd.Persona, slug string, pin bool, reason string) error {
+	return m.setFlag(reqId, mod, "post", slug, "pinned", pin, true, reason)
+}
+func (m Moderation) HideResource(reqId string, mod sd.Persona, mode, slug string, hide bool, reason string) error {
+	tblName, ok := modeTables[mode]
+	if !ok {
+		return fmt.Errorf("mode %q has no resources to hide", mode)
+	}
+	return m.setFlag(reqId, mod, tblName, slug, "hidden", hide, false, reason)
+}
+
+/*
+setFlag sets or clears the flag col on the resource in tblName
+with the given slug. Flags are cleared by setting them to NULL
+rather than false to match how they're written elsewhere. If
+thread is true the post must be the first in its thread, since
+only those carry locked and pinned flags.
+*/
+func (m Moderation) setFlag(reqId string, mod sd.Persona, tblName, slug, col string, on, thread bool, reason string) error {
+
+	var val interface{}
+	if on {
+		val = true
+	}
+
+	cond := ""
+	if thread {
+		cond = " AND\n\t\t\t\tid = thread"
+	}
+
+	var notFound bool
+
+	errs, err := m.TryerTx.Try(func() error {
+
+		tx, err := m.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		var was sd.NullBool
+		err = tx.Get(&was, fmt.Sprintf(`
+			SELECT
+				%s
+			FROM
+				%s
+			WHERE
+				slug = $1%s
+			FOR UPDATE`, col, tblName, cond),
+			slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound = true
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(`
+			UPDATE
+				%s
+			SET
+				%s = $2
+			WHERE
+				slug = $1`, tblName, col),
+			slug, val)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		e := modLogEntry(mod, modAction(col, on), tblName, slug, reason)
+		if err := flagSnapshots(e, col, was.Bool, on); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := recordModLog(tx, e); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+
+	log := m.Logger
+
+	if err != nil {
+		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ResourceSlug, slug)
+		return err
+	}
+	if notFound {
+		return sql.ErrNoRows
+	}
+
+	log.InfoF(reqId, "Moderated %s.", tblName).
+		Data(sd.LK_ModId, mod.Id).
+		Data(sd.LK_ModAction, modAction(col, on)).
+		Data(sd.LK_ResourceSlug, slug)
+
+	return nil
+}
+
+func (m Moderation) MutePersona(reqId string, mod sd.Persona, slug string, mute bool, reason string) error {
+	return m.setPersona(reqId, mod, slug, "muted", mute, reason)
+}
+func (m Moderation) BanPersona(reqId string, mod sd.Persona, slug string, ban bool, reason string) error {
+	return m.setPersona(reqId, mod, slug, "banned", ban, reason)
+}
+
+/*
+setPersona sets or clears the flag col on the persona with the
+given slug. Admins and the moderator themselves are exempt. When
+a persona is banned every session of its account is ended.
+*/
+func (m Moderation) setPersona(reqId string, mod sd.Persona, slug, col string, on bool, reason string) error {
+
+	var val interface{}
+	if on {
+		val = true
+	}
+
+	var notFound bool
+	var denied bool
+
+	errs, err := m.TryerTx.Try(func() error {
+
+		tx, err := m.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		target, err := personaBySlug(tx, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound = true
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if target.Admin.Bool || target.Id == mod.Id {
+			denied = true
+			return tx.Rollback(nil)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(`
+			UPDATE
+				personas
+			SET
+				%s = $2
+			WHERE
+				id = $1`, col),
+			target.Id, val)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		if col == "banned" && on {
+			_, err = tx.Exec(`
+				DELETE FROM
+					logins
+				WHERE
+					acc_id = $1`,
+				target.AccId)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		was := target.Muted.Bool
+		if col == "banned" {
+			was = target.Banned.Bool
+		}
+		e := modLogEntry(mod, modAction(col, on), "persona", slug, reason)
+		if err := flagSnapshots(e, col, was, on); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := recordModLog(tx, e); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+
+	log := m.Logger
+
+	if err != nil {
+		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersSlug, slug)
+		return err
+	}
+	if notFound {
+		return sql.ErrNoRows
+	}
+	if denied {
+		return sd.ErrPrivilege
+	}
+
+	log.Info(reqId, "Moderated persona.").
+		Data(sd.LK_ModId, mod.Id).
+		Data(sd.LK_ModAction, modAction(col, on)).
+		Data(sd.LK_PersSlug, slug)
+
+	return nil
+}
+
+func (m Moderation) MergeTags(reqId string, mod sd.Persona, mode string, from []string, to, reason string) (int64, error) {
+
+	tblName, ok := modeTables[mode]
+	if !ok {
+		return 0, fmt.Errorf("mode %q has no tags to merge", mode)
+	}
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return 0, errors.New("cannot merge tags into an empty tag")
+	}
+	if len(from) == 0 {
+		return 0, errors.New("no tags to merge")
+	}
+
+	scope := ""
+	args := []interface{}{pq.Array(from)}
+	if tblName == "post" {
+		scope = ` AND
+				ref_id IN (
+					SELECT
+						ref_id
+					FROM
+						post_kind
+					WHERE
+						kind = $2
+				)`
+		args = append(args, mode)
+	}
+
+	var ids []int64
+
+	errs, err := m.TryerTx.Try(func() error {
+
+		tx, err := m.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		ids = nil
+		err = tx.Select(&ids, fmt.Sprintf(`
+			SELECT DISTINCT
+				ref_id
+			FROM
+				%s_tag
+			WHERE
+				tag = ANY($1)%s`, tblName, scope),
+			args...)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if len(ids) == 0 {
+			return tx.Rollback(nil)
+		}
+
+		/*
+			A resource may already have the new tag or have several
+			of the old ones, so rather than renaming in place we
+			remove them all and add the new tag back once.
+		*/
+		_, err = tx.Exec(fmt.Sprintf(`
+			DELETE FROM
+				%s_tag
+			WHERE
+				ref_id = ANY($1) AND
+				(
+					tag = ANY($2) OR
+					tag = $3
+				)`, tblName),
+			pq.Array(ids), pq.Array(from), to)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO %s_tag (
+				ref_id,
+				tag
+			)
+			SELECT
+				unnest($1::bigint[]),
+				$2`, tblName),
+			pq.Array(ids), to)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		// Profiles are searched by their tags.
+		if tblName == "profile" {
+			for _, id := range ids {
+				if err := updateSearch(tx, tblName, id); err != nil {
+					return tx.Rollback(err)
+				}
+			}
+		}
+
+		e := modLogEntry(mod, sd.ModMergeTags, mode, to, reason)
+		if e.Before, err = sd.Snapshot(map[string][]string{"tags": from}); err != nil {
+			return tx.Rollback(err)
+		}
+		if e.After, err = sd.Snapshot(map[string]interface{}{"tags": []string{to}, "merged": len(ids)}); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := recordModLog(tx, e); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+
+	log := m.Logger
+
+	if err != nil {
+		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_Mode, mode)
+		return 0, err
+	}
+
+	log.InfoF(reqId, "Merged %s tags.", mode).
+		Data(sd.LK_ModId, mod.Id).
+		Data(sd.LK_TagsFrom, strings.Join(from, ", ")).
+		Data(sd.LK_TagsTo, to)
+
+	return int64(len(ids)), nil
+}
+
+/*
+Grant gives priv to the persona with the given slug. Apart from
+admins, moderators may only grant privileges they hold.
+*/
+func (m Moderation) Grant(reqId string, mod sd.Persona, slug, priv, reason string) error {
+	return m.setPrivilege(reqId, mod, slug, priv, true, reason)
+}
+
+func (m Moderation) Revoke(reqId string, mod sd.Persona, slug, priv, reason string) error {
+	return m.setPrivilege(reqId, mod, slug, priv, false, reason)
+}
+
+/*
+setPrivilege gives or takes priv from the persona with the given
+slug. Admins implicitly hold every privilege so theirs can't be
+changed. Rows left without any privileges are removed so that
+the table only ever lists staff.
+
+Whether mod may do so is checked against the privileges they
+hold when the transaction runs rather than those they held when
+the request began, so one just taken from them can't be given.
+*/
+func (m Moderation) setPrivilege(reqId string, mod sd.Persona, slug, priv string, on bool, reason string) error {
+
+	if !sd.IsPriv(priv) {
+		return fmt.Errorf("unknown privilege %q", priv)
+	}
+
+	var notFound bool
+	var denied bool
+
+	errs, err := m.TryerTx.Try(func() error {
+
+		tx, err := m.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		need := []string{sd.PrivTakePriv}
+		if on {
+			need = []string{sd.PrivGivePriv, priv}
+		}
+		ok, err := holdsPrivs(tx, mod.Id, need...)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !ok {
+			denied = true
+			return tx.Rollback(nil)
+		}
+
+		target, err := personaBySlug(tx, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound = true
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if target.Admin.Bool {
+			denied = true
+			return tx.Rollback(nil)
+		}
+
+		action := sd.ModRevoke
+		if on {
+			action = sd.ModGrant
+		}
+		e := modLogEntry(mod, action, "persona", slug, reason)
+		if e.Before, err = privSnapshot(tx, target.Id); err != nil {
+			return tx.Rollback(err)
+		}
+
+		if on {
+			_, err = tx.Exec(fmt.Sprintf(`
+				INSERT INTO privileges (
+					ref_id,
+					%[1]s
+				)
+				VALUES
+					($1, true)
+				ON CONFLICT (ref_id) DO UPDATE SET
+					%[1]s = true`, priv),
+				target.Id)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+			if e.After, err = privSnapshot(tx, target.Id); err != nil {
+				return tx.Rollback(err)
+			}
+			if err := recordModLog(tx, e); err != nil {
+				return tx.Rollback(err)
+			}
+			return tx.Commit()
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(`
+			UPDATE
+				privileges
+			SET
+				%s = NULL
+			WHERE
+				ref_id = $1`, priv),
+			target.Id)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(`
+			DELETE FROM
+				privileges
+			WHERE
+				ref_id = $1 AND
+				%s IS NULL`,
+			strings.Join(sd.PrivNames, " IS NULL AND\n\t\t\t\t")),
+			target.Id)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		if e.After, err = privSnapshot(tx, target.Id); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := recordModLog(tx, e); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+
+	log := m.Logger
+
+	if err != nil {
+		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersSlug, slug).
+			Data(sd.LK_Privilege, priv)
+		return err
+	}
+	if notFound {
+		return sql.ErrNoRows
+	}
+	if denied {
+		return sd.ErrPrivilege
+	}
+
+	msg := "Granted privilege."
+	if !on {
+		msg = "Revoked privilege."
+	}
+	log.Info(reqId, msg).
+		Data(sd.LK_ModId, mod.Id).
+		Data(sd.LK_PersSlug, slug).
+		Data(sd.LK_Privilege, priv)
+
+	return nil
+}
+
+func personaBySlug(tx sd.Tx, slug string) (sd.Persona, error) {
+	var p sd.Persona
+	err := tx.Get(&p, `
+		SELECT
+			acc_id AS accid,
+			id,
+			handle,
+			admin,
+			muted,
+			banned
+		FROM
+			personas
+		WHERE
+			slug = $1 AND
+			deleted IS NULL`,
+		slug)
+	return p, err
+}
+
+/*
+holdsPrivs reports whether the persona with the given id is an
+admin or holds every one of privs.
+*/
+func holdsPrivs(tx sd.Tx, id int64, privs ...string) (bool, error) {
+	var p struct {
+		Admin sd.NullBool
+		sd.Privileges
+	}
+	err := tx.Get(&p, `
+		SELECT
+			personas.admin,
+			`+privColumns+`
+		FROM
+			personas
+		LEFT JOIN
+			privileges ON privileges.ref_id = personas.id
+		WHERE
+			personas.id = $1 AND
+			personas.deleted IS NULL`,
+		id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if p.Admin.Bool {
+		return true, nil
+	}
+	for _, priv := range privs {
+		if !p.Has(priv) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+/*
+modAction describes setting or clearing a moderation flag
+for the logs, e.g., "hidden" and "unhidden".
+*/
+func modAction(col string, on bool) string {
+	if on {
+		return col
+	}
+	return "un" + col
+}
+
+/*
+flagSnapshots records the value of the flag col before and
+after it was changed.
+*/
+func flagSnapshots(e *sd.ModLogEntry, col string, was, is bool) (err error) {
+	if e.Before, err = sd.Snapshot(map[string]bool{col: was}); err != nil {
+		return err
+	}
+	e.After, err = sd.Snapshot(map[string]bool{col: is})
+	return err
+}
+
+/*
+privSnapshot lists the privileges held by the persona with
+the given id.
+*/
+func privSnapshot(tx sd.Tx, id int64) (sd.NullString, error) {
+	var p sd.Privileges
+	err := tx.Get(&p, `
+		SELECT
+			`+privColumns+`
+		FROM
+			privileges
+		WHERE
+			ref_id = $1`,
+		id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return sd.NullString{}, err
+	}
+	held := []string{}
+	for _, priv := range sd.PrivNames {
+		if p.Has(priv) {
+			held = append(held, priv)
+		}
+	}
+	return sd.Snapshot(map[string][]string{"privileges": held})
+}
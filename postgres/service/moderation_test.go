@@ -0,0 +1,58 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+func TestHoldsPrivs(t *testing.T) {
+
+	yes := sd.NullBool{Bool: true}
+	failed := errors.New("connection lost")
+
+	tests := []struct {
+		name  string
+		admin bool
+		held  sd.Privileges
+		err   error
+		privs []string
+		want  bool
+		fail  error
+	}{
+		{"holds all", false, sd.Privileges{GivePriv: yes, Ban: yes}, nil, []string{sd.PrivGivePriv, sd.PrivBan}, true, nil},
+		{"holds some", false, sd.Privileges{GivePriv: yes}, nil, []string{sd.PrivGivePriv, sd.PrivBan}, false, nil},
+		{"holds none", false, sd.Privileges{}, nil, []string{sd.PrivTakePriv}, false, nil},
+		{"admin", true, sd.Privileges{}, nil, []string{sd.PrivGivePriv, sd.PrivBan}, true, nil},
+		{"no such persona", false, sd.Privileges{}, sql.ErrNoRows, []string{sd.PrivTakePriv}, false, nil},
+		{"failed", true, sd.Privileges{}, failed, []string{sd.PrivTakePriv}, false, failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []interface{}
+			tx := testTx{get: func(dest interface{}, q string, a ...interface{}) error {
+				args = a
+				if tt.err != nil {
+					return tt.err
+				}
+				p := dest.(*struct {
+					Admin sd.NullBool
+					sd.Privileges
+				})
+				p.Admin.Bool = tt.admin
+				p.Privileges = tt.held
+				return nil
+			}}
+			got, err := holdsPrivs(tx, 7, tt.privs...)
+			if got != tt.want || err != tt.fail {
+				t.Errorf("got %v, %v, want %v, %v", got, err, tt.want, tt.fail)
+			}
+			if len(args) != 1 || args[0] != int64(7) {
+				t.Errorf("called with %v, want the persona's id", args)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+var errModLogReadOnly = errors.New("the moderator log cannot be changed")
+
+/*
+ModLog records moderator actions and backs the modlog submode
+of the admin mode. Entries are never updated or deleted, which
+the database enforces as well.
+*/
+type ModLog struct {
+	*sd.Dependencies
+}
+
+/*
+Record appends e to the log. Actions carried out by the
+Moderation service are recorded in the same transaction as
+the action itself. Record is for those that aren't.
+*/
+func (ml ModLog) Record(reqId string, e *sd.ModLogEntry) error {
+
+	errs, err := ml.TryerTx.Try(func() error {
+
+		tx, err := ml.Db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := recordModLog(tx, e); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ml.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ModId, e.ActorId).
+			Data(sd.LK_ModAction, e.Action).
+			Data(sd.LK_ResourceSlug, e.TargetSlug)
+		return err
+	}
+
+	return nil
+}
+
+func recordModLog(tx sd.Tx, e *sd.ModLogEntry) error {
+
+	if e.Created == 0 {
+		e.Created = time.Now().Unix()
+	}
+	if e.Reason.String == "" {
+		e.Reason.Null = true
+	}
+
+	var id int64
+	err := tx.Get(&id, `
+		INSERT INTO mod_log (
+			created,
+			actor_id,
+			actor_handle,
+			action,
+			target_kind,
+			target_slug,
+			before,
+			after,
+			reason
+		)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING
+			id`,
+		e.Created,
+		e.ActorId,
+		e.ActorHandle,
+		e.Action,
+		e.TargetKind,
+		e.TargetSlug,
+		nullable(e.Before),
+		nullable(e.After),
+		nullable(e.Reason),
+	)
+	if err != nil {
+		return err
+	}
+	e.Id = id
+	e.Slug = strconv.FormatInt(id, 10)
+
+	return nil
+}
+
+func nullable(ns sd.NullString) interface{} {
+	if ns.Null {
+		return nil
+	}
+	return ns.String
+}
+
+/*
+modLogEntry returns an entry for an action taken by mod against
+the target of the given kind and slug.
+*/
+func modLogEntry(mod sd.Persona, action, kind, slug, reason string) *sd.ModLogEntry {
+	return &sd.ModLogEntry{
+		ActorId:     mod.Id,
+		ActorHandle: mod.Handle,
+		Action:      action,
+		TargetKind:  kind,
+		TargetSlug:  slug,
+		Before:      sd.NullString{Null: true},
+		After:       sd.NullString{Null: true},
+		Reason:      sd.NullString{String: reason},
+	}
+}
+
+func (ml ModLog) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errModLogReadOnly
+}
+func (ml ModLog) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errModLogReadOnly
+}
+func (ml ModLog) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errModLogReadOnly
+}
+
+const modLogSelect = `
+			SELECT
+				id,
+				CAST(id AS text) AS slug,
+				created,
+				actor_id     AS actorid,
+				actor_handle AS actorhandle,
+				action,
+				target_kind  AS targetkind,
+				target_slug  AS targetslug,
+				CAST(before AS text) AS before,
+				CAST(after AS text)  AS after,
+				reason
+			FROM
+				mod_log`
+
+func (ml ModLog) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(ml.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany returns the entries matching slugs in the same
+order. Slugs that don't match one are skipped.
+*/
+func (ml ModLog) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var ids []int64
+	for _, slug := range slugs {
+		if id, err := strconv.ParseInt(slug, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var ee []sd.ModLogEntry
+
+	errs, err := ml.TryerTx.Try(func() error {
+
+		tx, err := ml.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ee = nil
+		err = tx.Select(&ee, modLogSelect+`
+			WHERE
+				id = ANY($1)`,
+			pq.Array(ids))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ml.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ResourceSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.ModLogEntry, len(ee))
+	for i := range ee {
+		bySlug[ee[i].Slug] = &ee[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if e, ok := bySlug[slug]; ok {
+			rr = append(rr, e)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter returns the newest entries first. Entries may be
+narrowed by "actor" handle, "action", "kind" and "target"
+slug. The "menu" key set for admin submodes is ignored.
+*/
+func (ml ModLog) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	var where []string
+	var args []interface{}
+	arg := new(argCount)
+
+	cols := map[string]string{
+		"actor":  "actor_handle ILIKE %s",
+		"action": "action = %s",
+		"kind":   "target_kind = %s",
+		"target": "target_slug = %s",
+	}
+	for k, cond := range cols {
+		vv, ok := filter[k]
+		if !ok || len(vv) == 0 || vv[0] == "" {
+			continue
+		}
+		if len(vv) > 1 {
+			return nil, "", fmt.Errorf("expected exactly 1 value for %s while filtering the moderator log", k)
+		}
+		where = append(where, fmt.Sprintf(cond, arg.Next()))
+		args = append(args, vv[0])
+	}
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		w, a := cur.after(arg, true, "created", "id")
+		where = append(where, w)
+		args = append(args, a...)
+	}
+
+	q := modLogSelect
+	if len(where) > 0 {
+		q += `
+			WHERE
+				` + strings.Join(where, " AND\n\t\t\t\t")
+	}
+	q += `
+			ORDER BY
+				created DESC,
+				id DESC` + limit(p)
+
+	var ee []sd.ModLogEntry
+
+	errs, err := ml.TryerTx.Try(func() error {
+
+		tx, err := ml.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ee = nil
+		if err := tx.Select(&ee, q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ml.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return nil, "", err
+	}
+
+	keep, more := nextPage(p, len(ee))
+	ee = ee[:keep]
+
+	var next string
+	if more {
+		last := ee[len(ee)-1]
+		next = cursor{last.Created, last.Id}.String()
+	}
+
+	rr := make([]sd.Resource, len(ee))
+	for i := range ee {
+		rr[i] = &ee[i]
+	}
+	return rr, next, nil
+}
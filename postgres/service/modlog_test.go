@@ -0,0 +1,59 @@
+package service
+
+import (
+	"testing"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+func TestRecordModLog(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		reason string
+		want   interface{}
+	}{
+		{"with a reason", "spam", "spam"},
+		{"without a reason", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []interface{}
+			tx := testTx{get: func(dest interface{}, q string, a ...interface{}) error {
+				args = a
+				*dest.(*int64) = 31
+				return nil
+			}}
+
+			mod := sd.Persona{Id: 4, Handle: "mod"}
+			e := modLogEntry(mod, "hidden", "event", "abc", tt.reason)
+			e.After = sd.NullString{String: `{"Hidden":true}`}
+			if err := recordModLog(tx, e); err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Created == 0 {
+				t.Error("entry wasn't given a time")
+			}
+			if e.Id != 31 || e.Slug != "31" {
+				t.Errorf("got id %d and slug %q, want 31", e.Id, e.Slug)
+			}
+			if len(args) != 9 {
+				t.Fatalf("called with %d arguments, want 9", len(args))
+			}
+			want := []interface{}{e.Created, int64(4), "mod", "hidden", "event", "abc"}
+			for i, a := range want {
+				if args[i] != a {
+					t.Errorf("argument %d is %v, want %v", i+1, args[i], a)
+				}
+			}
+			if args[6] != nil || args[7] != `{"Hidden":true}` {
+				t.Errorf("got snapshots %v and %v", args[6], args[7])
+			}
+			if args[8] != tt.want {
+				t.Errorf("got reason %v, want %v", args[8], tt.want)
+			}
+		})
+	}
+}
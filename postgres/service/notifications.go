@@ -0,0 +1,462 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+var errNotificationsReadOnly = errors.New("notifications cannot be changed through the account mode")
+
+/*
+Notifications creates notifications and lists them for their
+recipients. See NotificationList for the notifications submode
+of the account mode.
+*/
+type Notifications struct {
+	*sd.Dependencies
+}
+
+func (ns Notifications) Create(reqId string, nn ...sd.Notification) error {
+
+	errs, err := ns.TryerTx.Try(func() error {
+
+		tx, err := ns.Db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := notify(tx, nn...); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ns.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_NotificationCount, len(nn))
+		return err
+	}
+
+	return nil
+}
+
+/*
+notify adds nn within tx. Notifications addressed to the
+persona that caused them are skipped.
+*/
+func notify(tx sd.Tx, nn ...sd.Notification) error {
+
+	now := time.Now().Unix()
+
+	for _, n := range nn {
+
+		var actor interface{}
+		if n.ActorId.Int64 != 0 {
+			if n.ActorId.Int64 == n.PersId {
+				continue
+			}
+			actor = n.ActorId.Int64
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO notifications (
+				created,
+				recipient,
+				kind,
+				actor_id,
+				actor_handle,
+				target_kind,
+				target_slug,
+				target_name,
+				target_post,
+				detail
+			)
+			VALUES
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			now,
+			n.PersId,
+			n.Kind,
+			actor,
+			nullIfEmpty(n.ActorHandle),
+			n.TargetKind,
+			n.TargetSlug,
+			nullIfEmpty(n.TargetName),
+			nullIfEmpty(n.TargetPost),
+			nullIfEmpty(n.Detail),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func nullIfEmpty(ns sd.NullString) interface{} {
+	if ns.String == "" {
+		return nil
+	}
+	return ns.String
+}
+
+/*
+notifyReply notifies the owner of the thread with the given id
+that p has replied to it, and any personas p mentioned that
+they were. The reply's slug is taken from p.
+*/
+func notifyReply(tx sd.Tx, mode string, p *sd.Post, threadId int64, threadSlug string, threadName sd.NullString) error {
+
+	base := sd.Notification{
+		ActorId:     sd.NullInt64{Int64: p.PersId},
+		ActorHandle: sd.NullString{String: p.PersHandle},
+		TargetKind:  mode,
+		TargetSlug:  threadSlug,
+		TargetName:  threadName,
+		TargetPost:  sd.NullString{String: p.Slug},
+	}
+
+	var owner int64
+	err := tx.Get(&owner, `
+		SELECT
+			ref_id
+		FROM
+			post
+		WHERE
+			id = $1`,
+		threadId)
+	if err != nil {
+		return err
+	}
+
+	reply := base
+	reply.Kind = sd.NotifyReply
+	reply.PersId = owner
+	nn := []sd.Notification{reply}
+
+	/*
+		The thread's owner is left out of the personas mentioned
+		since they're already notified of the reply itself.
+	*/
+	if handles := p.Body.Mentions(); len(handles) > 0 {
+		var mentioned []int64
+		err := tx.Select(&mentioned, `
+			SELECT
+				id
+			FROM
+				personas
+			WHERE
+				handle = ANY($1) AND
+				deleted IS NULL AND
+				id != $2`,
+			pq.Array(handles),
+			owner)
+		if err != nil {
+			return err
+		}
+		for _, id := range mentioned {
+			m := base
+			m.Kind = sd.NotifyMention
+			m.PersId = id
+			nn = append(nn, m)
+		}
+	}
+
+	return notify(tx, nn...)
+}
+
+/*
+notifyEventUpdate notifies the owner of the event with the given
+id that e has updated it, along with the personas going or
+interested in it. Owners aren't notified of their own updates
+so for them this only concerns updates made by admins.
+*/
+func notifyEventUpdate(tx sd.Tx, e *sd.Event, id int64) error {
+
+	var owner int64
+	err := tx.Get(&owner, `
+		SELECT
+			ref_id
+		FROM
+			event
+		WHERE
+			id = $1`,
+		id)
+	if err != nil {
+		return err
+	}
+
+	n := sd.Notification{
+		Kind:        sd.NotifyEvent,
+		ActorId:     sd.NullInt64{Int64: e.PersId},
+		ActorHandle: sd.NullString{String: e.PersHandle},
+		TargetKind:  "event",
+		TargetSlug:  e.Slug,
+		TargetName:  e.Name,
+	}
+	n.PersId = owner
+	nn := []sd.Notification{n}
+
+	var attending []int64
+	err = tx.Select(&attending, `
+		SELECT
+			p_id
+		FROM
+			event_rsvp
+		WHERE
+			e_id = $1 AND
+			p_id != $2 AND
+			status != $3`,
+		id,
+		owner,
+		sd.RSVPNotGoing)
+	if err != nil {
+		return err
+	}
+	for _, persId := range attending {
+		a := n
+		a.PersId = persId
+		a.Detail = sd.NullString{String: sd.EventAttending}
+		nn = append(nn, a)
+	}
+
+	return notify(tx, nn...)
+}
+
+const notificationSelect = `
+			SELECT
+				id,
+				CAST(id AS text) AS slug,
+				created,
+				recipient    AS persid,
+				kind,
+				actor_id     AS actorid,
+				actor_handle AS actorhandle,
+				target_kind  AS targetkind,
+				target_slug  AS targetslug,
+				target_name  AS targetname,
+				target_post  AS targetpost,
+				detail,
+				read
+			FROM
+				notifications`
+
+func (ns Notifications) List(reqId string, recipient int64, unread bool, p sd.PageOpts) ([]sd.Notification, string, error) {
+
+	args := []interface{}{recipient}
+	arg := new(argCount)
+
+	where := "recipient = " + arg.Next()
+	if unread {
+		where += " AND\n\t\t\t\tread IS NULL"
+	}
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		w, a := cur.after(arg, true, "created", "id")
+		where += " AND\n\t\t\t\t" + w
+		args = append(args, a...)
+	}
+
+	q := notificationSelect + `
+			WHERE
+				` + where + `
+			ORDER BY
+				created DESC,
+				id DESC` + limit(p)
+
+	var nn []sd.Notification
+
+	errs, err := ns.TryerTx.Try(func() error {
+
+		tx, err := ns.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		nn = nil
+		if err := tx.Select(&nn, q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ns.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, recipient)
+		return nil, "", err
+	}
+
+	keep, more := nextPage(p, len(nn))
+	nn = nn[:keep]
+
+	var next string
+	if more {
+		last := nn[len(nn)-1]
+		next = cursor{last.Created, last.Id}.String()
+	}
+
+	return nn, next, nil
+}
+
+func (ns Notifications) MarkRead(reqId string, recipient int64, slugs ...string) error {
+
+	var ids []int64
+	for _, s := range slugs {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid notification slug %q", s)
+		}
+		ids = append(ids, id)
+	}
+
+	args := []interface{}{time.Now().Unix(), recipient}
+	only := ""
+	if len(ids) > 0 {
+		only = " AND\n\t\t\t\tid = ANY($3)"
+		args = append(args, pq.Array(ids))
+	}
+
+	errs, err := ns.TryerTx.Try(func() error {
+
+		tx, err := ns.Db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE
+				notifications
+			SET
+				read = $1
+			WHERE
+				recipient = $2 AND
+				read IS NULL`+only,
+			args...)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ns.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, recipient)
+		return err
+	}
+
+	return nil
+}
+
+/*
+NotificationList backs the notifications submode of the account
+mode, which only lists them. Notifications are created by the
+services that cause them and never changed besides being marked
+as read.
+*/
+type NotificationList struct {
+	Notifications
+}
+
+func (nl NotificationList) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errNotificationsReadOnly
+}
+func (nl NotificationList) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errNotificationsReadOnly
+}
+func (nl NotificationList) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errNotificationsReadOnly
+}
+
+func (nl NotificationList) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(nl.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany returns the notifications matching slugs in the same
+order. Slugs that don't match one are skipped.
+*/
+func (nl NotificationList) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var ids []int64
+	for _, slug := range slugs {
+		if id, err := strconv.ParseInt(slug, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var nn []sd.Notification
+
+	errs, err := nl.TryerTx.Try(func() error {
+
+		tx, err := nl.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		nn = nil
+		err = tx.Select(&nn, notificationSelect+`
+			WHERE
+				id = ANY($1)`,
+			pq.Array(ids))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		nl.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ResourceSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.Notification, len(nn))
+	for i := range nn {
+		bySlug[nn[i].Slug] = &nn[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if n, ok := bySlug[slug]; ok {
+			rr = append(rr, n)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter lists the notifications of the "persona" key's value,
+which the account mode always sets. If "unread" is "true" read
+notifications are left out.
+*/
+func (nl NotificationList) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	vv := filter["persona"]
+	if len(vv) != 1 {
+		return nil, "", errors.New("expected exactly 1 persona while listing notifications")
+	}
+	recipient, err := strconv.ParseInt(vv[0], 10, 64)
+	if err != nil {
+		return nil, "", err
+	}
+	unread := len(filter["unread"]) > 0 && filter["unread"][0] == "true"
+
+	nn, next, err := nl.List(reqId, recipient, unread, p)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rr := make([]sd.Resource, len(nn))
+	for i := range nn {
+		rr[i] = &nn[i]
+	}
+	return rr, next, nil
+}
@@ -0,0 +1,486 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+/*
+outboxLease is how long an email being sent is left alone by
+other workers. If the worker sending it stops before recording
+the outcome the email is attempted again once it has passed.
+*/
+const outboxLease = time.Minute * 5
+
+/*
+Outbox sends queued emails and backs the outbox submode of the
+admin mode, which lists emails that have failed at least once.
+*/
+type Outbox struct {
+	*sd.Dependencies
+}
+
+/*
+queueEmail adds e to the outbox within tx. It's due immediately
+and sent once tx commits.
+*/
+func queueEmail(tx sd.Tx, e sd.Email) error {
+	now := time.Now().Unix()
+	_, err := tx.Exec(`
+		INSERT INTO email_outbox (
+			created,
+			recipient,
+			subject,
+			body,
+			html,
+			next_attempt
+		)
+		VALUES
+			($1, $2, $3, $4, $5, $6)`,
+		now,
+		e.To,
+		e.Subject,
+		e.Text,
+		e.HTML,
+		now,
+	)
+	return err
+}
+
+/*
+renderEmail renders the email called name from the templates in
+DirEmail with data.
+*/
+func renderEmail(ev sd.EmailView, name, to, subject string, data interface{}) (sd.Email, error) {
+	text, html, err := ev.Render(name, data)
+	if err != nil {
+		return sd.Email{}, err
+	}
+	return sd.Email{
+		To:      to,
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	}, nil
+}
+
+type outboxClaim struct {
+	Id        int64
+	Recipient string
+	Subject   string
+	Body      string
+	HTML      string
+	Attempts  int
+}
+
+func (ob Outbox) Send(reqId string) (sent, failed int, err error) {
+
+	for i := 0; i < ob.Config.Outbox.Batch; i++ {
+
+		e, err := ob.claim(reqId)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return sent, failed, err
+		}
+
+		sendErr := ob.Emailer.Send(sd.Email{
+			To:      e.Recipient,
+			Subject: e.Subject,
+			Text:    e.Body,
+			HTML:    e.HTML,
+		})
+		if sendErr != nil {
+			ob.Logger.Error(reqId, sendErr.Error()).
+				Data(sd.LK_EmailAttempt, e.Attempts).
+				Data(sd.LK_EmailRcpt, e.Recipient).
+				Data(sd.LK_EmailSubject, e.Subject)
+		}
+
+		if err := ob.settle(reqId, e, sendErr); err != nil {
+			return sent, failed, err
+		}
+		if sendErr != nil {
+			failed++
+		} else {
+			sent++
+		}
+	}
+
+	return sent, failed, nil
+}
+
+/*
+claim takes the email that has been due the longest and
+pushes back its next attempt by outboxLease. It returns
+sql.ErrNoRows when no email is due.
+*/
+func (ob Outbox) claim(reqId string) (*outboxClaim, error) {
+
+	var e outboxClaim
+	var none bool
+
+	errs, err := ob.TryerTx.Try(func() error {
+
+		tx, err := ob.Db.Begin()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		e = outboxClaim{}
+		err = tx.Get(&e, `
+			UPDATE
+				email_outbox
+			SET
+				attempts = attempts + 1,
+				next_attempt = $1
+			WHERE
+				id = (
+					SELECT
+						id
+					FROM
+						email_outbox
+					WHERE
+						status = $2 AND
+						next_attempt <= $3
+					ORDER BY
+						next_attempt,
+						id
+					LIMIT 1
+					FOR UPDATE SKIP LOCKED
+				)
+			RETURNING
+				id,
+				recipient,
+				subject,
+				body,
+				html,
+				attempts`,
+			now.Add(outboxLease).Unix(),
+			sd.OutboxPending,
+			now.Unix(),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			none = true
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ob.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return nil, err
+	}
+	if none {
+		return nil, sql.ErrNoRows
+	}
+
+	return &e, nil
+}
+
+/*
+settle removes e from the outbox if it was sent. Otherwise its
+next attempt is scheduled according to the "email" retry config
+or, if it has used up its attempts, it's marked dead.
+*/
+func (ob Outbox) settle(reqId string, e *outboxClaim, sendErr error) error {
+
+	var q string
+	var args []interface{}
+
+	if sendErr == nil {
+		q = `
+			DELETE FROM
+				email_outbox
+			WHERE
+				id = $1`
+		args = []interface{}{e.Id}
+	} else {
+		rc := ob.Config.Retry["email"]
+		status := sd.OutboxPending
+		if e.Attempts > rc.Retries {
+			status = sd.OutboxDead
+		}
+		next := time.Now().Add(rc.Backoff(e.Attempts)).Unix()
+		q = `
+			UPDATE
+				email_outbox
+			SET
+				status = $2,
+				next_attempt = $3,
+				last_error = $4
+			WHERE
+				id = $1`
+		args = []interface{}{e.Id, status, next, sendErr.Error()}
+	}
+
+	errs, err := ob.TryerTx.Try(func() error {
+
+		tx, err := ob.Db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ob.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_EmailRcpt, e.Recipient).
+			Data(sd.LK_EmailSubject, e.Subject)
+		return err
+	}
+
+	return nil
+}
+
+func (ob Outbox) Requeue(reqId string, mod sd.Persona, slug, reason string) error {
+
+	id, err := strconv.ParseInt(slug, 10, 64)
+	if err != nil {
+		return sql.ErrNoRows
+	}
+
+	var notFound bool
+
+	errs, err := ob.TryerTx.Try(func() error {
+
+		tx, err := ob.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		var was struct {
+			Status   string `json:"status"`
+			Attempts int    `json:"attempts"`
+		}
+		err = tx.Get(&was, `
+			SELECT
+				status,
+				attempts
+			FROM
+				email_outbox
+			WHERE
+				id = $1
+			FOR UPDATE`,
+			id)
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound = true
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE
+				email_outbox
+			SET
+				status = $2,
+				attempts = 0,
+				next_attempt = $3
+			WHERE
+				id = $1`,
+			id,
+			sd.OutboxPending,
+			time.Now().Unix(),
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		e := modLogEntry(mod, sd.ModRequeue, "email", slug, reason)
+		if e.Before, err = sd.Snapshot(was); err != nil {
+			return tx.Rollback(err)
+		}
+		was.Status, was.Attempts = sd.OutboxPending, 0
+		if e.After, err = sd.Snapshot(was); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := recordModLog(tx, e); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		ob.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ModId, mod.Id).
+			Data(sd.LK_ResourceSlug, slug)
+		return err
+	}
+	if notFound {
+		return sql.ErrNoRows
+	}
+
+	ob.Logger.Info(reqId, "Requeued email.").
+		Data(sd.LK_ModId, mod.Id).
+		Data(sd.LK_ResourceSlug, slug)
+
+	return nil
+}
+
+func (ob Outbox) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errors.New("emails are queued by the services sending them")
+}
+func (ob Outbox) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errors.New("emails are only requeued through Requeue")
+}
+func (ob Outbox) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errors.New("emails are removed from the outbox once sent")
+}
+
+const outboxSelect = `
+			SELECT
+				id,
+				CAST(id AS text) AS slug,
+				created,
+				recipient,
+				subject,
+				status,
+				attempts,
+				next_attempt AS nextattempt,
+				last_error   AS lasterror
+			FROM
+				email_outbox`
+
+func (ob Outbox) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(ob.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany returns the emails matching slugs in the same
+order. Slugs that don't match one are skipped.
+*/
+func (ob Outbox) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var ids []int64
+	for _, slug := range slugs {
+		if id, err := strconv.ParseInt(slug, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var ee []sd.OutboxEmail
+
+	errs, err := ob.TryerTx.Try(func() error {
+
+		tx, err := ob.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ee = nil
+		err = tx.Select(&ee, outboxSelect+`
+			WHERE
+				id = ANY($1)`,
+			pq.Array(ids))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ob.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ResourceSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.OutboxEmail, len(ee))
+	for i := range ee {
+		bySlug[ee[i].Slug] = &ee[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if e, ok := bySlug[slug]; ok {
+			rr = append(rr, e)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter returns emails that have failed at least once, newest
+first. They may be narrowed to dead or pending emails with the
+"status" key. The "menu" key set for admin submodes is ignored.
+*/
+func (ob Outbox) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	var args []interface{}
+	arg := new(argCount)
+
+	where := "attempts > 0"
+	if vv := filter["status"]; len(vv) == 1 && vv[0] != "" {
+		where += " AND\n\t\t\t\tstatus = " + arg.Next()
+		args = append(args, vv[0])
+	}
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		w, a := cur.after(arg, true, "created", "id")
+		where += " AND\n\t\t\t\t" + w
+		args = append(args, a...)
+	}
+
+	q := outboxSelect + `
+			WHERE
+				` + where + `
+			ORDER BY
+				created DESC,
+				id DESC` + limit(p)
+
+	var ee []sd.OutboxEmail
+
+	errs, err := ob.TryerTx.Try(func() error {
+
+		tx, err := ob.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		ee = nil
+		if err := tx.Select(&ee, q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ob.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return nil, "", err
+	}
+
+	keep, more := nextPage(p, len(ee))
+	ee = ee[:keep]
+
+	var next string
+	if more {
+		last := ee[len(ee)-1]
+		next = cursor{last.Created, last.Id}.String()
+	}
+
+	rr := make([]sd.Resource, len(ee))
+	for i := range ee {
+		rr[i] = &ee[i]
+	}
+	return rr, next, nil
+}
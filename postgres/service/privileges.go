@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+/*
+Privileges backs the privileges submode of the admin mode.
+Its resources are personas and the privileges they hold, so
+they're never created, updated or deleted through it. See
+Moderation.Grant and Moderation.Revoke instead.
+*/
+type Privileges struct {
+	*sd.Dependencies
+}
+
+const privSelect = `
+			SELECT
+				personas.id         AS persid,
+				personas.slug,
+				personas.handle     AS pershandle,
+				personas.name       AS persname,
+				personas.avatar     AS persavatar,
+				personas.visibility AS persvis,
+				personas.admin,
+				personas.muted,
+				personas.banned,
+				%s
+			FROM
+				personas
+			LEFT JOIN
+				privileges ON privileges.ref_id = personas.id
+			WHERE
+				personas.deleted IS NULL AND
+				%s`
+
+func (ps Privileges) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errors.New("privileges cannot be created as resources")
+}
+func (ps Privileges) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errors.New("privileges cannot be updated as resources")
+}
+func (ps Privileges) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errors.New("privileges cannot be deleted as resources")
+}
+
+/*
+Retrieve looks up a persona by its slug or, failing that, its
+handle. The latter lets staff find personas that don't hold
+any privileges yet.
+*/
+func (ps Privileges) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(ps.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany looks up personas as Retrieve does and returns them
+in the same order as slugs. Slugs that match neither a persona's
+slug nor its handle are skipped.
+*/
+func (ps Privileges) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+	handles := make([]string, len(slugs))
+	for i, slug := range slugs {
+		handles[i] = strings.ToLower(slug)
+	}
+
+	var pp []sd.PersonaPrivileges
+
+	errs, err := ps.TryerTx.Try(func() error {
+
+		tx, err := ps.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+
+		pp = nil
+		err = tx.Select(&pp, fmt.Sprintf(privSelect,
+			privColumns, `(
+					personas.slug = ANY($1) OR
+					lower(personas.handle) = ANY($2)
+				)`),
+			pq.Array(slugs),
+			pq.Array(handles))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		ps.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.PersonaPrivileges, len(pp))
+	byHandle := make(map[string]*sd.PersonaPrivileges, len(pp))
+	for i := range pp {
+		bySlug[pp[i].Slug] = &pp[i]
+		byHandle[strings.ToLower(pp[i].PersHandle)] = &pp[i]
+	}
+
+	var rr []sd.Resource
+	for i, slug := range slugs {
+		if p, ok := bySlug[slug]; ok {
+			rr = append(rr, p)
+		} else if p, ok := byHandle[handles[i]]; ok {
+			rr = append(rr, p)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter lists admins and personas holding at least one privilege.
+Staff are few so, like settings, paging is ignored and they're
+always returned together.
+*/
+func (ps Privileges) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	var pp []sd.PersonaPrivileges
+
+	errs, err := ps.TryerTx.Try(func() error {
+
+		tx, err := ps.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+
+		pp = nil
+		err = tx.Select(&pp, fmt.Sprintf(privSelect+`
+			ORDER BY
+				personas.admin IS NULL,
+				personas.handle ASC`,
+			privColumns, `(
+					personas.admin = true OR
+					privileges.ref_id IS NOT NULL
+				)`))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		ps.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return nil, "", err
+	}
+
+	rr := make([]sd.Resource, len(pp))
+	for i := range pp {
+		rr[i] = &pp[i]
+	}
+	return rr, "", nil
+}
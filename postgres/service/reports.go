@@ -0,0 +1,579 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+const (
+	fbReportOwn     = "you cannot report your own work"
+	fbReportTwice   = "you have already reported this and it is awaiting review"
+	fbReportTooMany = "you have made too many reports recently, please try again later"
+)
+
+/*
+Reports stores reports filed by users and backs the reports
+submode of the admin mode, which lists open reports grouped
+by their target.
+*/
+type Reports struct {
+	*sd.Dependencies
+}
+
+func (rs Reports) Report(reqId string, p sd.Persona, mode, slug string, f *sd.ReportForm) (sd.Feedback, error) {
+
+	tblName, ok := modeTables[mode]
+	if !ok || !in(sd.ReportModes, mode) {
+		return nil, fmt.Errorf("mode %q can't be reported", mode)
+	}
+	if !sd.IsReportCategory(f.Category) {
+		return nil, fmt.Errorf("unknown report category %q", f.Category)
+	}
+
+	c := rs.Config.Report
+	now := time.Now().Unix()
+	since := now - int64(c.Window)*60
+
+	fb := make(sd.Feedback)
+	var notFound bool
+	var id int64
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		var owner int64
+		err = tx.Get(&owner, fmt.Sprintf(`
+			SELECT
+				ref_id
+			FROM
+				%s
+			WHERE
+				slug = $1 AND
+				deleted IS NULL`, tblName),
+			slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound = true
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if owner == p.Id {
+			fb.Add("general", fbReportOwn)
+			return tx.Rollback(nil)
+		}
+
+		exists, err := tx.Exists(`
+			FROM
+				reports
+			WHERE
+				reporter = $1 AND
+				target_kind = $2 AND
+				target_slug = $3 AND
+				resolved IS NULL`,
+			p.Id, mode, slug)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if exists {
+			fb.Add("general", fbReportTwice)
+			return tx.Rollback(nil)
+		}
+
+		// Limits apply to the account rather than each persona.
+		var n int
+		err = tx.Get(&n, `
+			SELECT
+				count(*)
+			FROM
+				reports,
+				personas
+			WHERE
+				reports.reporter = personas.id AND
+				personas.acc_id = $1 AND
+				reports.created > $2`,
+			p.AccId, since)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if n >= c.Limit {
+			fb.Add("general", fbReportTooMany)
+			return tx.Rollback(nil)
+		}
+
+		var detail interface{}
+		if f.Detail.String != "" {
+			detail = f.Detail.String
+		}
+		err = tx.Get(&id, `
+			INSERT INTO reports (
+				created,
+				reporter,
+				target_kind,
+				target_slug,
+				category,
+				detail
+			)
+			VALUES
+				($1, $2, $3, $4, $5, $6)
+			RETURNING
+				id`,
+			now, p.Id, mode, slug, f.Category, detail)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+
+	log := rs.Logger
+
+	if err != nil {
+		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, p.Id).
+			Data(sd.LK_Mode, mode).
+			Data(sd.LK_ResourceSlug, slug)
+		return nil, err
+	}
+	if notFound {
+		return nil, sql.ErrNoRows
+	}
+	if len(fb) > 0 {
+		return fb, nil
+	}
+
+	log.Info(reqId, "Filed report.").
+		Data(sd.LK_ReportId, id).
+		Data(sd.LK_ReportCategory, f.Category).
+		Data(sd.LK_PersId, p.Id).
+		Data(sd.LK_Mode, mode).
+		Data(sd.LK_ResourceSlug, slug)
+
+	return nil, nil
+}
+
+/*
+resolutionDetail completes the sentence describing report
+notifications. See sd.Notification.Text.
+*/
+var resolutionDetail = map[string]string{
+	sd.ResolveDismiss: "no action was needed",
+	sd.ResolveHide:    "it has been hidden",
+	sd.ResolveDestroy: "it has been removed",
+}
+
+func (rs Reports) Resolve(reqId string, mod sd.Persona, mode, slug, resolution, reason string) error {
+
+	detail, ok := resolutionDetail[resolution]
+	if !ok {
+		return fmt.Errorf("unknown report resolution %q", resolution)
+	}
+
+	var reporters []int64
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		reporters = nil
+		err = tx.Select(&reporters, `
+			SELECT DISTINCT
+				reporter
+			FROM
+				reports
+			WHERE
+				target_kind = $1 AND
+				target_slug = $2 AND
+				resolved IS NULL`,
+			mode, slug)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if len(reporters) == 0 {
+			return tx.Rollback(sql.ErrNoRows)
+		}
+
+		names, err := targetNames(tx, mode, []string{slug})
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		res, err := tx.Exec(`
+			UPDATE
+				reports
+			SET
+				resolved = $3,
+				resolution = $4,
+				resolver = $5
+			WHERE
+				target_kind = $1 AND
+				target_slug = $2 AND
+				resolved IS NULL`,
+			mode, slug, time.Now().Unix(), resolution, mod.Id)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		/*
+			Hiding and destroying are recorded when they're
+			carried out. Dismissals don't touch the target so
+			they're recorded here instead.
+		*/
+		if resolution == sd.ResolveDismiss {
+			n, err := res.RowsAffected()
+			if err != nil {
+				return tx.Rollback(err)
+			}
+			e := modLogEntry(mod, resolution, mode, slug, reason)
+			e.Before, err = sd.Snapshot(map[string]int64{"open reports": n})
+			if err != nil {
+				return tx.Rollback(err)
+			}
+			if err := recordModLog(tx, e); err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		nn := make([]sd.Notification, len(reporters))
+		for i, id := range reporters {
+			nn[i] = sd.Notification{
+				Kind:       sd.NotifyReport,
+				TargetKind: mode,
+				TargetSlug: slug,
+				TargetName: sd.NullString{String: names[slug]},
+				Detail:     sd.NullString{String: detail},
+			}
+			nn[i].PersId = id
+		}
+		if err := notify(tx, nn...); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+
+	log := rs.Logger
+
+	if lastErrSqlNoRows(errs) {
+		return sql.ErrNoRows
+	}
+	if err != nil {
+		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_Mode, mode).
+			Data(sd.LK_ResourceSlug, slug)
+		return err
+	}
+
+	log.Info(reqId, "Resolved reports.").
+		Data(sd.LK_ModId, mod.Id).
+		Data(sd.LK_Resolution, resolution).
+		Data(sd.LK_ReportCount, len(reporters)).
+		Data(sd.LK_Mode, mode).
+		Data(sd.LK_ResourceSlug, slug)
+
+	return nil
+}
+
+/*
+targetNames returns the names of the resources in mode with the
+given slugs, keyed by slug. Replies take the name of their
+thread since they don't have their own.
+*/
+func targetNames(tx sd.Tx, mode string, slugs []string) (map[string]string, error) {
+
+	tblName, ok := modeTables[mode]
+	if !ok {
+		return nil, fmt.Errorf("mode %q has no resources", mode)
+	}
+
+	q := fmt.Sprintf(`
+		SELECT
+			slug,
+			COALESCE(name, '') AS name
+		FROM
+			%s
+		WHERE
+			slug = ANY($1)`, tblName)
+	if tblName == "post" {
+		q = `
+		SELECT
+			post.slug,
+			COALESCE(post.name, thread.name, '') AS name
+		FROM
+			post,
+			post AS thread
+		WHERE
+			post.thread = thread.id AND
+			post.slug = ANY($1)`
+	}
+
+	var rows []struct {
+		Slug string
+		Name string
+	}
+	if err := tx.Select(&rows, q, pq.Array(slugs)); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(rows))
+	for _, r := range rows {
+		m[r.Slug] = r.Name
+	}
+	return m, nil
+}
+
+func (rs Reports) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errors.New("reports are filed through Report")
+}
+func (rs Reports) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errors.New("reports are resolved through Resolve")
+}
+func (rs Reports) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errors.New("reports are resolved through Resolve")
+}
+
+const reportGroupSelect = `
+			SELECT
+				target_kind  AS targetkind,
+				target_slug  AS targetslug,
+				count(*)     AS count,
+				max(created) AS latest,
+				max(id)      AS latestid
+			FROM
+				reports
+			WHERE
+				resolved IS NULL`
+
+const reportGroupBy = `
+			GROUP BY
+				target_kind,
+				target_slug`
+
+/*
+Retrieve returns the open reports of a single target. The
+slug is that of the group, see sd.ReportGroupSlug.
+*/
+func (rs Reports) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(rs.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany returns the groups of open reports matching slugs in
+the same order. Slugs that don't match a group are skipped.
+*/
+func (rs Reports) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var gg []sd.ReportGroup
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+
+		gg = nil
+		err = tx.Select(&gg, reportGroupSelect+` AND
+				target_kind || '.' || target_slug = ANY($1)`+reportGroupBy,
+			pq.Array(slugs))
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if err := fillReportGroups(tx, gg); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		rs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ResourceSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.ReportGroup, len(gg))
+	for i := range gg {
+		bySlug[sd.ReportGroupSlug(gg[i].TargetKind, gg[i].TargetSlug)] = &gg[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if g, ok := bySlug[slug]; ok {
+			rr = append(rr, g)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter returns targets with open reports, those most recently
+reported first. They may be narrowed to a single mode with the
+"kind" key. The "menu" key set for admin submodes is ignored.
+*/
+func (rs Reports) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	var where []string
+	var having []string
+	var args []interface{}
+	arg := new(argCount)
+
+	if vv, ok := filter["kind"]; ok && len(vv) > 0 && vv[0] != "" {
+		if len(vv) > 1 {
+			return nil, "", errors.New("expected exactly 1 kind while filtering reports")
+		}
+		where = append(where, "target_kind = "+arg.Next())
+		args = append(args, vv[0])
+	}
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		h, a := cur.after(arg, true, "max(created)", "max(id)")
+		having = append(having, h)
+		args = append(args, a...)
+	}
+
+	q := reportGroupSelect
+	for _, w := range where {
+		q += " AND\n\t\t\t\t" + w
+	}
+	q += reportGroupBy
+	if len(having) > 0 {
+		q += `
+			HAVING
+				` + strings.Join(having, " AND\n\t\t\t\t")
+	}
+	q += `
+			ORDER BY
+				latest DESC,
+				latestid DESC` + limit(p)
+
+	var gg []sd.ReportGroup
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+
+		gg = nil
+		if err := tx.Select(&gg, q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		if err := fillReportGroups(tx, gg); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		rs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return nil, "", err
+	}
+
+	keep, more := nextPage(p, len(gg))
+	gg = gg[:keep]
+
+	var next string
+	if more {
+		last := gg[len(gg)-1]
+		next = cursor{last.Latest, last.LatestId}.String()
+	}
+
+	rr := make([]sd.Resource, len(gg))
+	for i := range gg {
+		rr[i] = &gg[i]
+	}
+	return rr, next, nil
+}
+
+/*
+fillReportGroups sets the slug and target name of each group
+and adds its open reports, newest first.
+*/
+func fillReportGroups(tx sd.Tx, gg []sd.ReportGroup) error {
+
+	if len(gg) == 0 {
+		return nil
+	}
+
+	byMode := make(map[string][]string)
+	keys := make([]string, len(gg))
+	for i, g := range gg {
+		byMode[g.TargetKind] = append(byMode[g.TargetKind], g.TargetSlug)
+		keys[i] = sd.ReportGroupSlug(g.TargetKind, g.TargetSlug)
+	}
+
+	names := make(map[string]string)
+	for mode, slugs := range byMode {
+		m, err := targetNames(tx, mode, slugs)
+		if err != nil {
+			return err
+		}
+		for slug, name := range m {
+			names[sd.ReportGroupSlug(mode, slug)] = name
+		}
+	}
+
+	var rows []struct {
+		sd.Report
+		Key string
+	}
+	err := tx.Select(&rows, `
+		SELECT
+			reports.id,
+			reports.created,
+			reports.reporter AS reporterid,
+			personas.handle  AS reporterhandle,
+			reports.category,
+			reports.detail,
+			reports.target_kind || '.' || reports.target_slug AS key
+		FROM
+			reports,
+			personas
+		WHERE
+			reports.reporter = personas.id AND
+			reports.resolved IS NULL AND
+			reports.target_kind || '.' || reports.target_slug = ANY($1)
+		ORDER BY
+			reports.created DESC`,
+		pq.Array(keys))
+	if err != nil {
+		return err
+	}
+
+	reports := make(map[string][]sd.Report)
+	for _, r := range rows {
+		reports[r.Key] = append(reports[r.Key], r.Report)
+	}
+
+	for i := range gg {
+		g := &gg[i]
+		g.Slug = keys[i]
+		g.Created = g.Latest
+		g.TargetName = sd.NullString{String: names[g.Slug], Null: names[g.Slug] == ""}
+		g.Reports = reports[g.Slug]
+	}
+
+	return nil
+}
@@ -0,0 +1,579 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+var errRSVPClosed = errors.New("this event has finished or is no longer available")
+var errUpcomingReadOnly = errors.New("upcoming events cannot be changed through the account mode")
+
+/*
+RSVPs records how personas respond to events. The waitlist isn't
+stored, those going beyond an event's capacity are waitlisted in
+the order they said they were going.
+*/
+type RSVPs struct {
+	*sd.Dependencies
+}
+
+/*
+Respond sets how persId responds to the event named by slug and
+returns its RSVP. Personas waitlisted before the response that
+now have a place are notified.
+*/
+func (rs RSVPs) Respond(reqId string, persId int64, slug, status string) (sd.RSVP, error) {
+
+	if !in(sd.RSVPStatuses, status) {
+		return sd.RSVP{}, fmt.Errorf("unknown RSVP status %q", status)
+	}
+
+	var r sd.RSVP
+	now := time.Now().Unix()
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		/*
+			Personas may only respond to events they can see
+			that haven't finished every occurrence yet.
+		*/
+		var e sd.Event
+		err = tx.Get(&e, `
+			SELECT
+				e.id,
+				e.slug,
+				e.name
+			FROM
+				event AS e
+			WHERE
+				e.slug = $1 AND
+				(
+					e.ref_id = $2 OR
+					(e.visibility != 'private' AND e.hidden IS NULL)
+				) AND
+				EXISTS (
+					SELECT
+						1
+					FROM
+						event_occurrence AS o
+					WHERE
+						o.ref_id = e.id AND
+						COALESCE(o.finish, o.start) > $3
+				)`,
+			slug,
+			persId,
+			now)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		before, err := lockPlaces(tx, e.Id)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		/*
+			Since only changes when the response does so those
+			going keep their place when responding again.
+		*/
+		_, err = tx.Exec(`
+			INSERT INTO event_rsvp (e_id, p_id, status, since, created, updated)
+			VALUES ($1, $2, $3, $4, $4, $4)
+			ON CONFLICT (e_id, p_id) DO UPDATE SET
+				since = CASE
+					WHEN event_rsvp.status = EXCLUDED.status THEN event_rsvp.since
+					ELSE EXCLUDED.since
+				END,
+				status = EXCLUDED.status,
+				updated = EXCLUDED.updated`,
+			e.Id,
+			persId,
+			status,
+			now)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		if err = notifyPromoted(tx, e, before, persId); err != nil {
+			return tx.Rollback(err)
+		}
+
+		rr, err := rsvpsOf(tx, persId, []int64{e.Id})
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		r = rr[e.Id]
+
+		return tx.Commit()
+	})
+	if lastErrSqlNoRows(errs) {
+		return sd.RSVP{}, errRSVPClosed
+	}
+	if err != nil {
+		rs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId).
+			Data(sd.LK_EventSlug, slug)
+		return sd.RSVP{}, err
+	}
+
+	rs.Logger.Info(reqId, "Responded to event.").
+		Data(sd.LK_PersId, persId).
+		Data(sd.LK_EventSlug, slug).
+		Data(sd.LK_RSVPStatus, status)
+
+	return r, nil
+}
+
+/*
+Withdraw removes persId's response to the event named by slug.
+It reports whether there was one to remove.
+*/
+func (rs RSVPs) Withdraw(reqId string, persId int64, slug string) (bool, error) {
+
+	var n int64
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		var e sd.Event
+		err = tx.Get(&e, `
+			SELECT
+				id,
+				slug,
+				name
+			FROM
+				event
+			WHERE
+				slug = $1`,
+			slug)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		before, err := lockPlaces(tx, e.Id)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		res, err := tx.Exec(`
+			DELETE FROM event_rsvp WHERE e_id = $1 AND p_id = $2`,
+			e.Id,
+			persId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return tx.Rollback(err)
+		}
+		if err = notifyPromoted(tx, e, before, persId); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if lastErrSqlNoRows(errs) {
+		return false, nil
+	}
+	if err != nil {
+		rs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId).
+			Data(sd.LK_EventSlug, slug)
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	rs.Logger.Info(reqId, "Withdrew response to event.").
+		Data(sd.LK_PersId, persId).
+		Data(sd.LK_EventSlug, slug)
+
+	return true, nil
+}
+
+/*
+Attendance counts those going to, waitlisted for, and interested
+in e, lists those among them whose visibility is public, and sets
+how persId responded.
+*/
+func (rs RSVPs) Attendance(reqId string, e *sd.Event, persId int64) error {
+
+	var aa []sd.Attendee
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		if aa, err = attendance(tx, e.Id, e.Capacity); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		rs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_EventSlug, e.Slug)
+		return err
+	}
+
+	e.Going = 0
+	e.Waitlisted = 0
+	e.Interested = 0
+	e.Attendees = nil
+	e.RSVP = sd.RSVP{}
+
+	for _, a := range aa {
+		if a.PersId == persId {
+			e.RSVP = a.RSVP
+		}
+		switch {
+		case a.Going():
+			e.Going++
+		case a.Status == sd.RSVPGoing:
+			e.Waitlisted++
+		case a.Status == sd.RSVPInterested:
+			e.Interested++
+		default:
+			continue
+		}
+		if a.Visibility == sd.VisibilityPublic {
+			e.Attendees = append(e.Attendees, a)
+		}
+	}
+
+	return nil
+}
+
+/*
+Attendees lists everyone who responded to the event named by
+slug, including those who aren't going, for its owner persId.
+Admins may list those of any event.
+*/
+func (rs RSVPs) Attendees(reqId string, persId int64, slug string) ([]sd.Attendee, error) {
+
+	var aa []sd.Attendee
+
+	errs, err := rs.TryerTx.Try(func() error {
+
+		tx, err := rs.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		if err = personaOwnsResource(tx, "event", slug, persId); err != nil {
+			return tx.Rollback(err)
+		}
+
+		var e sd.Event
+		err = tx.Get(&e, `
+			SELECT
+				id,
+				capacity
+			FROM
+				event
+			WHERE
+				slug = $1`,
+			slug)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if aa, err = attendance(tx, e.Id, e.Capacity); err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+	if lastErrSqlNoRows(errs) {
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		rs.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId).
+			Data(sd.LK_EventSlug, slug)
+		return nil, err
+	}
+
+	rs.Logger.Info(reqId, "Listed event attendees.").
+		Data(sd.LK_PersId, persId).
+		Data(sd.LK_EventSlug, slug)
+
+	return aa, nil
+}
+
+/*
+attendance returns the responses to the event with id of personas
+that haven't been deleted in the order they were given, with the
+waitlist worked out from capacity.
+*/
+func attendance(tx sd.Tx, id int64, capacity sd.NullInt64) ([]sd.Attendee, error) {
+
+	var aa []sd.Attendee
+	err := tx.Select(&aa, `
+		SELECT
+			r.p_id      AS persid,
+			r.status,
+			r.since,
+			p.handle,
+			p.name,
+			p.visibility
+		FROM
+			event_rsvp AS r
+		JOIN
+			personas AS p ON p.id = r.p_id
+		WHERE
+			r.e_id = $1 AND
+			p.deleted IS NULL
+		ORDER BY
+			r.since,
+			r.p_id`,
+		id)
+	if err != nil {
+		return nil, err
+	}
+	if len(aa) == 0 {
+		return nil, nil
+	}
+
+	persIds := make([]int64, len(aa))
+	for i := range aa {
+		persIds[i] = aa[i].PersId
+	}
+	profiles, err := ownerProfiles(tx, persIds)
+	if err != nil {
+		return nil, err
+	}
+
+	going := 0
+	for i := range aa {
+		a := &aa[i]
+		a.Profile = profiles[a.PersId]
+		if a.Status == sd.RSVPGoing {
+			going++
+			a.Waitlist = waitlisted(going, capacity)
+		}
+	}
+
+	return aa, nil
+}
+
+/*
+rsvpsOf returns how persId responded to each of the events with
+ids that it did, by event id.
+*/
+func rsvpsOf(tx sd.Tx, persId int64, ids []int64) (map[int64]sd.RSVP, error) {
+
+	var rows []struct {
+		EId      int64 `db:"e_id"`
+		Status   string
+		Place    int
+		Capacity sd.NullInt64
+	}
+	err := tx.Select(&rows, `
+		SELECT
+			ranked.e_id,
+			ranked.status,
+			ranked.place,
+			event.capacity
+		FROM
+			(
+				SELECT
+					r.e_id,
+					r.p_id,
+					r.status,
+					row_number() OVER (
+						PARTITION BY r.e_id, r.status
+						ORDER BY r.since, r.p_id
+					) AS place
+				FROM
+					event_rsvp AS r
+				JOIN
+					personas AS p ON p.id = r.p_id
+				WHERE
+					r.e_id = ANY($2) AND
+					p.deleted IS NULL
+			) AS ranked
+		JOIN
+			event ON event.id = ranked.e_id
+		WHERE
+			ranked.p_id = $1`,
+		persId,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int64]sd.RSVP, len(rows))
+	for _, row := range rows {
+		r := sd.RSVP{Status: row.Status}
+		if r.Status == sd.RSVPGoing {
+			r.Waitlist = waitlisted(row.Place, row.Capacity)
+		}
+		m[row.EId] = r
+	}
+	return m, nil
+}
+
+/*
+waitlisted returns the place on the waitlist of the persona that
+was nth to say they're going to an event with capacity, or zero if
+they have a place at it.
+*/
+func waitlisted(nth int, capacity sd.NullInt64) int {
+	c := int(capacity.Int64)
+	if c == 0 || nth <= c {
+		return 0
+	}
+	return nth - c
+}
+
+/*
+placed returns the personas with a place at the event with id,
+that is those going that aren't waitlisted.
+*/
+func placed(tx sd.Tx, id int64) ([]int64, error) {
+
+	var capacity sd.NullInt64
+	err := tx.Get(&capacity, `SELECT capacity FROM event WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+		SELECT
+			r.p_id
+		FROM
+			event_rsvp AS r
+		JOIN
+			personas AS p ON p.id = r.p_id
+		WHERE
+			r.e_id = $1 AND
+			r.status = $2 AND
+			p.deleted IS NULL
+		ORDER BY
+			r.since,
+			r.p_id`
+	if n := capacity.Int64; n > 0 {
+		q += "\n\t\tLIMIT " + strconv.FormatInt(n, 10)
+	}
+
+	var ids []int64
+	if err := tx.Select(&ids, q, id, sd.RSVPGoing); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+/*
+lockPlaces locks the event with id until tx ends and returns the
+personas with a place at it. Changes that may give others a place
+take it before they're made so that concurrent ones see each
+other's outcome and each promotion is notified exactly once.
+*/
+func lockPlaces(tx sd.Tx, id int64) ([]int64, error) {
+	if _, err := tx.Exec(`SELECT id FROM event WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return nil, err
+	}
+	return placed(tx, id)
+}
+
+/*
+notifyPromoted notifies the personas with a place at e that didn't
+have one before, besides actor whose change gave them one.
+*/
+func notifyPromoted(tx sd.Tx, e sd.Event, before []int64, actor int64) error {
+
+	after, err := placed(tx, e.Id)
+	if err != nil {
+		return err
+	}
+
+	var nn []sd.Notification
+	for _, id := range promoted(before, after, actor) {
+		n := sd.Notification{
+			Kind:       sd.NotifyEvent,
+			TargetKind: "event",
+			TargetSlug: e.Slug,
+			TargetName: e.Name,
+			Detail:     sd.NullString{String: sd.EventPromoted},
+		}
+		n.PersId = id
+		nn = append(nn, n)
+	}
+
+	return notify(tx, nn...)
+}
+
+/*
+promoted returns the personas in after that aren't in before,
+besides actor, in the order they're in after.
+*/
+func promoted(before, after []int64, actor int64) []int64 {
+	had := make(map[int64]bool, len(before))
+	for _, id := range before {
+		had[id] = true
+	}
+	var ids []int64
+	for _, id := range after {
+		if !had[id] && id != actor {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+/*
+Upcoming backs the upcoming submode of the account mode, which
+lists the events the active persona is going to or interested in
+that haven't finished yet. Responses are changed through RSVPs.
+*/
+type Upcoming struct {
+	Event
+}
+
+func (u Upcoming) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errUpcomingReadOnly
+}
+func (u Upcoming) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errUpcomingReadOnly
+}
+func (u Upcoming) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errUpcomingReadOnly
+}
+
+/*
+Filter lists the upcoming events of the "persona" key's value,
+which the account mode always sets, soonest first. Private events
+are left out unless they belong to the persona.
+*/
+func (u Upcoming) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	vv := filter["persona"]
+	if len(vv) != 1 {
+		return nil, "", errors.New("expected exactly 1 persona while listing upcoming events")
+	}
+
+	return u.Event.Filter(reqId, admin, map[string][]string{
+		"attending": vv,
+		"hidden":    {"false"},
+		"start":     {"Present"},
+		"overlap":   {"overlap"},
+	}, p)
+}
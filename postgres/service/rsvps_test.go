@@ -0,0 +1,149 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+func capacity(n int64) sd.NullInt64 {
+	if n == 0 {
+		return sd.NullInt64{Null: true}
+	}
+	return sd.NullInt64{Int64: n}
+}
+
+func TestWaitlisted(t *testing.T) {
+
+	tests := []struct {
+		nth      int
+		capacity sd.NullInt64
+		want     int
+	}{
+		{1, capacity(0), 0},
+		{500, capacity(0), 0},
+		{1, capacity(2), 0},
+		{2, capacity(2), 0},
+		{3, capacity(2), 1},
+		{7, capacity(2), 5},
+		{1, capacity(1), 0},
+		{2, capacity(1), 1},
+	}
+
+	for _, tt := range tests {
+		if got := waitlisted(tt.nth, tt.capacity); got != tt.want {
+			t.Errorf("waitlisted(%d, %d) = %d, want %d", tt.nth, tt.capacity.Int64, got, tt.want)
+		}
+	}
+}
+
+/*
+places works out who has a place among going, those going to an
+event in the order they said so, the same way placed does.
+*/
+func places(going []int64, c sd.NullInt64) []int64 {
+	var ids []int64
+	for i, id := range going {
+		if waitlisted(i+1, c) == 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestPromoted(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		going    []int64
+		capacity int64
+		after    []int64
+		newCap   int64
+		actor    int64
+		want     []int64
+	}{
+		{
+			name:     "one with a place withdraws",
+			going:    []int64{1, 2, 3, 4},
+			capacity: 2,
+			after:    []int64{2, 3, 4},
+			actor:    1,
+			want:     []int64{3},
+		},
+		{
+			name:     "one on the waitlist withdraws",
+			going:    []int64{1, 2, 3, 4},
+			capacity: 2,
+			after:    []int64{1, 2, 4},
+			actor:    3,
+		},
+		{
+			name:     "the last with a place withdraws",
+			going:    []int64{1, 2, 3},
+			capacity: 2,
+			after:    []int64{1, 3},
+			actor:    2,
+			want:     []int64{3},
+		},
+		{
+			name:     "capacity raised",
+			going:    []int64{1, 2, 3, 4, 5},
+			capacity: 2,
+			newCap:   4,
+			actor:    99,
+			want:     []int64{3, 4},
+		},
+		{
+			name:     "capacity removed",
+			going:    []int64{1, 2, 3},
+			capacity: 1,
+			newCap:   -1,
+			actor:    99,
+			want:     []int64{2, 3},
+		},
+		{
+			name:     "capacity lowered",
+			going:    []int64{1, 2, 3},
+			capacity: 3,
+			newCap:   1,
+			actor:    99,
+		},
+		{
+			name:     "event isn't full",
+			going:    []int64{1, 2},
+			capacity: 5,
+			after:    []int64{2},
+			actor:    1,
+		},
+		{
+			name:     "the actor gets a place",
+			going:    []int64{1, 2, 3},
+			capacity: 1,
+			newCap:   3,
+			actor:    2,
+			want:     []int64{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := places(tt.going, capacity(tt.capacity))
+			after := tt.after
+			if after == nil {
+				after = tt.going
+			}
+			c := capacity(tt.capacity)
+			switch {
+			case tt.newCap < 0:
+				c = capacity(0)
+			case tt.newCap > 0:
+				c = capacity(tt.newCap)
+			}
+			got := promoted(before, places(after, c), tt.actor)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+/*
+Text search configuration used to build every search column
+and parse every query. If this changes the search columns
+must be rebuilt with a migration.
+*/
+const tsConfig = "english"
+
+/*
+ts_rank returns a float while cursors hold integers, so ranks
+are scaled and truncated. Results whose ranks only differ
+beyond this precision are ordered by id instead.
+*/
+const rankScale = 1000000
+
+/*
+Private use characters that ts_headline wraps around matches.
+They're swapped for <mark> tags once the rest of the snippet
+has been escaped so a user's own markup is never rendered.
+*/
+const (
+	markStart = "\ue000"
+	markStop  = "\ue001"
+)
+
+var headlineOpts = fmt.Sprintf(
+	`StartSel="%s", StopSel="%s", MaxWords=30, MinWords=12, MaxFragments=2, FragmentDelimiter=" … "`,
+	markStart, markStop)
+
+/*
+searchBody returns an expression for the text of tblName's
+resources beyond their name and summary. Profiles have no
+body so their tags are used instead.
+*/
+func searchBody(tblName string) string {
+	if tblName == "profile" {
+		return `(
+			SELECT
+				string_agg(tag, ' ')
+			FROM
+				profile_tag
+			WHERE
+				ref_id = profile.id
+		)`
+	}
+	return fmt.Sprintf(`(
+			SELECT
+				string_agg(text, ' ' ORDER BY p, span)
+			FROM
+				%[1]s_span
+			WHERE
+				ref_id = %[1]s.id
+		)`, tblName)
+}
+
+/*
+updateSearch rebuilds the search column of the resource in
+tblName with the given id. It must be called in the same
+transaction that writes the resource and its spans. Matches
+in the name outrank the summary which outranks the body.
+*/
+func updateSearch(tx sd.Tx, tblName string, id int64) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+		UPDATE
+			%[1]s
+		SET
+			search =
+				setweight(to_tsvector('%[2]s', coalesce(name, '')), 'A') ||
+				setweight(to_tsvector('%[2]s', coalesce(summary, '')), 'B') ||
+				setweight(to_tsvector('%[2]s', coalesce(%[3]s, '')), 'C')
+		WHERE
+			id = $1`,
+		tblName, tsConfig, searchBody(tblName)),
+		id,
+	)
+	return err
+}
+
+/*
+searchText returns the full-text query in filter, if any.
+*/
+func searchText(filter map[string][]string) string {
+	vv, ok := filter["q"]
+	if !ok || len(vv) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(vv[0])
+}
+
+/*
+searchMatch and searchRank return SQL comparing col to the
+query bound to the placeholder ph.
+*/
+func searchMatch(col, ph string) string {
+	return fmt.Sprintf("%s @@ websearch_to_tsquery('%s', %s)", col, tsConfig, ph)
+}
+
+func searchRank(col, ph string) string {
+	return fmt.Sprintf(
+		"CAST(ts_rank(%s, websearch_to_tsquery('%s', %s)) * %d AS bigint)",
+		col, tsConfig, ph, rankScale)
+}
+
+/*
+snippets returns highlighted excerpts of the resources in
+tblName matching text, keyed by the value of keyCol. When
+several rows share a key, e.g. posts in the same thread, the
+excerpt comes from the best ranked row. Rows must also meet
+cond if it isn't empty.
+*/
+func snippets(db sd.DB, tblName, keyCol, cond string, keys []int64, text string) (map[int64]template.HTML, error) {
+
+	if cond != "" {
+		cond = " AND\n" + cond
+	}
+
+	var rows []struct {
+		Key     int64
+		Snippet string
+	}
+	err := db.Select(&rows, fmt.Sprintf(`
+		SELECT DISTINCT ON (%[1]s.%[2]s)
+			%[1]s.%[2]s AS key,
+			ts_headline(
+				'%[3]s',
+				concat_ws(' ', %[1]s.summary, %[4]s),
+				websearch_to_tsquery('%[3]s', $2),
+				$3
+			) AS snippet
+		FROM
+			%[1]s
+		WHERE
+			%[1]s.%[2]s = ANY($1) AND
+			%[5]s%[6]s
+		ORDER BY
+			%[1]s.%[2]s,
+			ts_rank(%[1]s.search, websearch_to_tsquery('%[3]s', $2)) DESC`,
+		tblName, keyCol, tsConfig, searchBody(tblName),
+		searchMatch(tblName+".search", "$2"), cond),
+		pq.Array(keys),
+		text,
+		headlineOpts,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int64]template.HTML, len(rows))
+	for _, r := range rows {
+		m[r.Key] = highlight(r.Snippet)
+	}
+	return m, nil
+}
+
+/*
+highlight escapes s and replaces each pair of markers around a
+match with a <mark> element. Unpaired markers are dropped.
+*/
+func highlight(s string) template.HTML {
+	s = html.EscapeString(s)
+	var b strings.Builder
+	open := false
+	for {
+		if !open {
+			i := strings.Index(s, markStart)
+			if i == -1 {
+				break
+			}
+			b.WriteString(strings.ReplaceAll(s[:i], markStop, ""))
+			s = s[i+len(markStart):]
+			b.WriteString("<mark>")
+			open = true
+			continue
+		}
+		i := strings.Index(s, markStop)
+		if i == -1 {
+			break
+		}
+		b.WriteString(strings.ReplaceAll(s[:i], markStart, ""))
+		s = s[i+len(markStop):]
+		b.WriteString("</mark>")
+		open = false
+	}
+	s = strings.ReplaceAll(s, markStart, "")
+	s = strings.ReplaceAll(s, markStop, "")
+	b.WriteString(s)
+	if open {
+		b.WriteString("</mark>")
+	}
+	return template.HTML(b.String())
+}
+
+/*
+addSnippets sets the snippet of each resource in rr, keyed by
+id, that has one.
+*/
+func addSnippets(rr []sd.Resource, m map[int64]template.HTML) {
+	for _, r := range rr {
+		if s, ok := m[r.GetId()]; ok {
+			r.SetSnippet(s)
+		}
+	}
+}
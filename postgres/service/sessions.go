@@ -0,0 +1,281 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
+)
+
+var errSessionsReadOnly = errors.New("sessions cannot be changed through the account mode")
+
+/*
+Sessions lists the logins of an account for the sessions submode
+of the account mode and signs them out. Logins are only created
+by logging in.
+*/
+type Sessions struct {
+	*sd.Dependencies
+}
+
+const sessionSelect = `
+			SELECT
+				id,
+				CAST(id AS text) AS slug,
+				since            AS created,
+				acc_id           AS accid,
+				p_id             AS persid,
+				user_agent       AS useragent,
+				ip,
+				last_seen        AS lastseen
+			FROM
+				logins`
+
+func (ss Sessions) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, error) {
+	return nil, errSessionsReadOnly
+}
+func (ss Sessions) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
+	return nil, nil, errSessionsReadOnly
+}
+func (ss Sessions) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
+	return nil, nil, errSessionsReadOnly
+}
+
+func (ss Sessions) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(ss.RetrieveMany(reqId, []string{slug}, o))
+}
+
+/*
+RetrieveMany returns the unexpired logins matching slugs in the
+same order. Slugs that don't match one are skipped.
+*/
+func (ss Sessions) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var ids []int64
+	for _, slug := range slugs {
+		if id, err := strconv.ParseInt(slug, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var sessions []sd.Session
+	began, seen := ss.Config.Session.Cutoffs(time.Now())
+
+	errs, err := ss.TryerTx.Try(func() error {
+
+		tx, err := ss.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		sessions = nil
+		err = tx.Select(&sessions, sessionSelect+`
+			WHERE
+				id = ANY($1) AND
+				since > $2 AND
+				last_seen > $3`,
+			pq.Array(ids),
+			began,
+			seen)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ss.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ResourceSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
+
+	bySlug := make(map[string]*sd.Session, len(sessions))
+	for i := range sessions {
+		bySlug[sessions[i].Slug] = &sessions[i]
+	}
+
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if s, ok := bySlug[slug]; ok {
+			rr = append(rr, s)
+		}
+	}
+	return rr, nil
+}
+
+/*
+Filter lists the unexpired logins of the account owning the
+"persona" key's value, which the account mode always sets. The
+most recently seen are listed first.
+*/
+func (ss Sessions) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
+
+	vv := filter["persona"]
+	if len(vv) != 1 {
+		return nil, "", errors.New("expected exactly 1 persona while listing sessions")
+	}
+	persId, err := strconv.ParseInt(vv[0], 10, 64)
+	if err != nil {
+		return nil, "", err
+	}
+
+	began, seen := ss.Config.Session.Cutoffs(time.Now())
+	args := []interface{}{persId, began, seen}
+	arg := argCount(len(args))
+
+	where := `acc_id = (SELECT acc_id FROM personas WHERE id = $1) AND
+				since > $2 AND
+				last_seen > $3`
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		w, a := cur.after(&arg, true, "last_seen", "id")
+		where += " AND\n\t\t\t\t" + w
+		args = append(args, a...)
+	}
+
+	q := sessionSelect + `
+			WHERE
+				` + where + `
+			ORDER BY
+				last_seen DESC,
+				id DESC` + limit(p)
+
+	var s []sd.Session
+
+	errs, err := ss.TryerTx.Try(func() error {
+
+		tx, err := ss.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		s = nil
+		if err := tx.Select(&s, q, args...); err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ss.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_PersId, persId)
+		return nil, "", err
+	}
+
+	keep, more := nextPage(p, len(s))
+	s = s[:keep]
+
+	var next string
+	if more {
+		last := s[len(s)-1]
+		next = cursor{last.LastSeen, last.Id}.String()
+	}
+
+	rr := make([]sd.Resource, len(s))
+	for i := range s {
+		rr[i] = &s[i]
+	}
+	return rr, next, nil
+}
+
+/*
+Revoke signs out the login of accId whose slug is slug. It's not
+ok if accId has no such login.
+*/
+func (ss Sessions) Revoke(reqId string, accId int64, slug string) (bool, error) {
+
+	id, err := strconv.ParseInt(slug, 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	var n int64
+
+	errs, err := ss.TryerTx.Try(func() error {
+
+		tx, err := ss.Db.Begin()
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(`
+			DELETE FROM
+				logins
+			WHERE
+				id = $1 AND
+				acc_id = $2`,
+			id,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		n, err = result.RowsAffected()
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ss.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId).
+			Data(sd.LK_SessionId, id)
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	ss.Logger.Info(reqId, "Signed out session.").
+		Data(sd.LK_AccId, accId).
+		Data(sd.LK_SessionId, id)
+
+	return true, nil
+}
+
+// RevokeAll signs out every login of accId.
+func (ss Sessions) RevokeAll(reqId string, accId int64) (int64, error) {
+
+	var n int64
+
+	errs, err := ss.TryerTx.Try(func() error {
+
+		tx, err := ss.Db.Begin()
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(`
+			DELETE FROM
+				logins
+			WHERE
+				acc_id = $1`,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		n, err = result.RowsAffected()
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		ss.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId)
+		return 0, err
+	}
+
+	ss.Logger.Info(reqId, "Signed out all sessions.").
+		Data(sd.LK_AccId, accId).
+		Data(sd.LK_Sessions, n)
+
+	return n, nil
+}
@@ -22,13 +22,17 @@ func (s Settings) Delete(reqId, slug string, persId int64) (sd.Feedback, []strin
 	return nil, nil, nil
 }
 
-func (s Settings) Filter(reqId string, admin bool, filter map[string][]string) ([]sd.Resource, error) {
+/*
+There are only ever a handful of settings so we ignore
+paging and always return all of them.
+*/
+func (s Settings) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
 	var rr []sd.Resource
 	for _, f := range s.ViewData.Mode["settings"].Editor {
 		base := sd.ResourceBase{Slug: f.Name}
 		rr = append(rr, &sd.Settings{ResourceBase: base, Field: f})
 	}
-	return rr, nil
+	return rr, "", nil
 }
 
 /*
@@ -39,6 +43,29 @@ func (s Settings) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error
 	return nil, nil
 }
 
+/*
+RetrieveMany returns the settings named by slugs in the same
+order, as Filter lists them. Slugs that don't name one are
+skipped.
+*/
+func (s Settings) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+	all, _, err := s.Filter(reqId, false, nil, sd.PageOpts{})
+	if err != nil {
+		return nil, err
+	}
+	bySlug := make(map[string]sd.Resource, len(all))
+	for _, r := range all {
+		bySlug[r.GetSlug()] = r
+	}
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		if r, ok := bySlug[slug]; ok {
+			rr = append(rr, r)
+		}
+	}
+	return rr, nil
+}
+
 func (s Settings) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedback, []string, error) {
 
 	fb := make(sd.Feedback)
@@ -187,6 +214,26 @@ func (s Settings) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedb
 				return err
 			}
 
+		case "notifications":
+
+			_, err := tx.Exec(`
+				UPDATE
+					personas
+				SET
+					email_reply = $1,
+					email_mention = $2,
+					email_event = $3
+				WHERE
+					id = $4`,
+				m["reply"],
+				m["mention"],
+				m["event"],
+				pId,
+			)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+
 		default:
 			return tx.Rollback(fmt.Errorf("service: unknown settings category %q", cat))
 		}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+func TestSettingsRetrieveMany(t *testing.T) {
+
+	s := Settings{&sd.Dependencies{ViewData: &sd.ViewData{Mode: sd.Mode{
+		"settings": sd.ModeData{Editor: sd.Fields{
+			{Name: "theme"},
+			{Name: "timezone"},
+			{Name: "digest"},
+		}},
+	}}}}
+
+	tests := []struct {
+		name  string
+		slugs []string
+		want  []string
+	}{
+		{"none", nil, nil},
+		{"in the order asked", []string{"digest", "theme"}, []string{"digest", "theme"}},
+		{"unknown skipped", []string{"nope", "timezone"}, []string{"timezone"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, err := s.RetrieveMany("", tt.slugs, sd.ResOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range rr {
+				got = append(got, r.GetSlug())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
 )
 
 type Talent struct {
@@ -34,6 +35,9 @@ func (ts Talent) Create(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedba
 		if rId, err = insertTables(tx, tbl, tbl.Refs, false); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = updateSearch(tx, "profile", rId); err != nil {
+			return tx.Rollback(err)
+		}
 		if err = addFiles(tx, tbl, rId, "profile"); err != nil {
 			return tx.Rollback(err)
 		}
@@ -111,6 +115,9 @@ func (ts Talent) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedba
 		if _, err = insertTables(tx, tbl, tbl.Refs, true); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = updateSearch(tx, "profile", rId); err != nil {
+			return tx.Rollback(err)
+		}
 
 		err = tx.Get(&p.Created, `
 			SELECT
@@ -165,8 +172,16 @@ func (ts Talent) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedba
 }
 
 func (ts Talent) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
+	return first(ts.RetrieveMany(reqId, []string{slug}, o))
+}
 
-	var p sd.Profile
+/*
+RetrieveMany returns the profiles matching slugs in the same
+order. Slugs that don't match a profile are skipped.
+*/
+func (ts Talent) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	var pp []sd.Profile
 
 	var private string
 	if !o.GetPrivate {
@@ -180,16 +195,19 @@ func (ts Talent) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error)
 			return err
 		}
 
-		err = tx.Get(&p, fmt.Sprintf(`
+		pp = nil
+		err = tx.Select(&pp, fmt.Sprintf(`
 			SELECT
 				personas.id     AS persId,
 				personas.name   AS persName,
 				personas.handle AS persHandle,
+				personas.slug   AS persSlug,
 				
 				profile.id,
 				profile.slug,
 				profile.created,
 				profile.updated,
+				profile.hidden,
 				
 				profile.available,
 				profile.visibility,
@@ -199,260 +217,290 @@ func (ts Talent) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error)
 				
 				profile.website,
 				profile.email,
-				profile.discord
+				profile.discord,
+				
+				profile.duration_start AS "duration.start",
+				profile.duration_end   AS "duration.end"
 			FROM
 				profile,
 				personas
 			WHERE
 				%s
 				personas.id = profile.ref_id AND
-				profile.slug = $1`, private),
-			slug,
+				profile.slug = ANY($1)`, private),
+			pq.Array(slugs),
 		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
+		if len(pp) == 0 {
+			return tx.Commit()
+		}
 
-		// No need to do a query here since we already have the slug.
-		p.PersProfile = p.Slug
+		ids := make([]int64, len(pp))
+		persIds := make([]int64, len(pp))
+		for i := range pp {
+			ids[i] = pp[i].Id
+			persIds[i] = pp[i].PersId
+		}
 
-		err = tx.Select(&p.PersPronouns, `
-			SELECT
-				pronoun
-			FROM
-				pronouns
-			WHERE
-				ref_id=$1
-		`, p.PersId)
+		pronouns, err := childStrings(tx, "pronouns", "pronoun", persIds)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		err = tx.Get(&p.Duration, `
-			SELECT
-				duration_start AS start,
-				duration_end AS end
-			FROM
-				profile
-			WHERE
-				slug = $1`,
-			slug,
-		)
+		compensation, err := childStrings(tx, "profile_compensation", "compensation", ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		err = tx.Select(&p.Compensation, `
-			SELECT
-				compensation
-			FROM
-				profile_compensation
-			WHERE
-				ref_id = $1`,
-			p.Id,
-		)
+		medium, err := childStrings(tx, "profile_medium", "medium", ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		err = tx.Select(&p.Medium, `
-			SELECT
-				medium
-			FROM
-				profile_medium
-			WHERE
-				ref_id = $1`,
-			p.Id,
-		)
+		language, err := childStrings(tx, "profile_language", "language", ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		err = tx.Select(&p.Language, `
-			SELECT
-				language
-			FROM
-				profile_language
-			WHERE
-				ref_id = $1`,
-			p.Id,
-		)
+		tags, err := childStrings(tx, "profile_tag", "tag", ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
-		err = tx.Select(&p.Tag, `
-			SELECT
-				tag
-			FROM
-				profile_tag
-			WHERE
-				ref_id = $1`,
-			p.Id,
-		)
+		projects, err := retrieveProjects(tx, ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
-
-		type pjt struct {
-			Id int64
-			sd.Project
-		}
-		var pjts []pjt
-		err = tx.Select(&pjts, `
-			SELECT
-				id,
-				name,
-				link,
-				teamname,
-				teamlink,
-				start,
-				finish
-			FROM
-				profile_project
-			WHERE
-				ref_id = $1
-			ORDER BY
-				finish DESC`,
-			p.Id,
-		)
+		advertised, err := retrieveAdvertised(tx, ids)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
-		for _, pjt := range pjts {
+		for i := range pp {
+			p := &pp[i]
+			// No need to do a query here since we already have the slug.
+			p.PersProfile = p.Slug
+			p.PersPronouns = pronouns[p.PersId]
+			p.Compensation = compensation[p.Id]
+			p.Medium = medium[p.Id]
+			p.Language = language[p.Id]
+			p.Tag = tags[p.Id]
+			p.Project = projects[p.Id]
+			p.Advertised = advertised[p.Id]
+		}
 
-			type role struct {
-				Id int64
-				sd.Role
-			}
-			var roles []role
-			err = tx.Select(&roles, `
-				SELECT
-					id,
-					name,
-					comment
-				FROM
-					profile_project_role
-				WHERE
-					ref_id = $1`,
-				pjt.Id,
-			)
-			if err != nil {
-				return tx.Rollback(err)
-			}
+		return tx.Commit()
+	})
 
-			for _, role := range roles {
+	log := ts.Logger
 
-				err = tx.Select(&role.Skill, `
-					SELECT
-						skill
-					FROM
-						profile_project_role_skill
-					WHERE
-						ref_id = $1`,
-					role.Id,
-				)
-				if err != nil {
-					return tx.Rollback(err)
-				}
-
-				err = tx.Select(&role.Duty, `
-					SELECT
-						duty
-					FROM
-						profile_project_role_duty
-					WHERE
-						ref_id = $1`,
-					role.Id,
-				)
-				if err != nil {
-					return tx.Rollback(err)
-				}
+	if err != nil {
+		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_ProfileSlug, strings.Join(slugs, ", "))
+		return nil, err
+	}
 
-				pjt.Role = append(pjt.Role, role.Role)
-			}
+	bySlug := make(map[string]*sd.Profile, len(pp))
+	for i := range pp {
+		bySlug[pp[i].Slug] = &pp[i]
+	}
 
-			p.Project = append(p.Project, pjt.Project)
-		}
+	var rr []sd.Resource
+	for _, slug := range slugs {
+		p, ok := bySlug[slug]
+		if !ok {
+			continue
+		}
+		log.Info(reqId, "Retrieved talent profile.").
+			Data(sd.LK_ProfileSlug, slug).
+			Data(sd.LK_ProfileId, p.Id).
+			Data(sd.LK_ProfileName, p.Name.String).
+			Data(sd.LK_PersId, p.PersId).
+			Data(sd.LK_PersHandle, p.PersHandle)
+		rr = append(rr, p)
+	}
 
-		type ad struct {
-			Id int64
-			sd.Advertised
-		}
-		var ads []ad
-		err = tx.Select(&ads, `
-			SELECT
-				id,
-				skill
-			FROM
-				profile_advertised
-			WHERE
-				ref_id = $1`,
-			p.Id,
-		)
-		if err != nil {
-			return tx.Rollback(err)
-		}
+	return rr, nil
+}
 
-		for _, ad := range ads {
+/*
+retrieveProjects loads the projects of every profile in ids,
+along with their roles, keyed by profile id. Each level of
+the tree is loaded with a single query.
+*/
+func retrieveProjects(tx sd.Tx, ids []int64) (map[int64][]sd.Project, error) {
 
-			type ex struct {
-				Id int64
-				sd.Media
-			}
-			var exs []ex
-			err = tx.Select(&exs, `
-				SELECT
-					id,
-					alttext,
-					title,
-					project,
-					info,
-					kind,
-					format,
-					aspect,
-					filename AS file
-				FROM
-					profile_advertised_example
-				WHERE
-					ref_id = $1`,
-				ad.Id,
-			)
-			if err != nil {
-				return tx.Rollback(err)
-			}
+	type pjt struct {
+		Id    int64
+		RefId int64 `db:"ref_id"`
+		sd.Project
+	}
+	var pjts []pjt
+	err := tx.Select(&pjts, `
+		SELECT
+			id,
+			ref_id,
+			name,
+			link,
+			teamname,
+			teamlink,
+			start,
+			finish
+		FROM
+			profile_project
+		WHERE
+			ref_id = ANY($1)
+		ORDER BY
+			finish DESC`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(pjts) == 0 {
+		return nil, nil
+	}
 
-			for _, ex := range exs {
-				ad.Example = append(ad.Example, ex.Media)
-			}
+	pjtIds := make([]int64, len(pjts))
+	for i := range pjts {
+		pjtIds[i] = pjts[i].Id
+	}
 
-			p.Advertised = append(p.Advertised, ad.Advertised)
-		}
+	type role struct {
+		Id    int64
+		RefId int64 `db:"ref_id"`
+		sd.Role
+	}
+	var roles []role
+	err = tx.Select(&roles, `
+		SELECT
+			id,
+			ref_id,
+			name,
+			comment
+		FROM
+			profile_project_role
+		WHERE
+			ref_id = ANY($1)
+		ORDER BY
+			id ASC`,
+		pq.Array(pjtIds),
+	)
+	if err != nil {
+		return nil, err
+	}
 
-		return tx.Commit()
-	})
+	roleIds := make([]int64, len(roles))
+	for i := range roles {
+		roleIds[i] = roles[i].Id
+	}
+	skills, err := childStrings(tx, "profile_project_role_skill", "skill", roleIds)
+	if err != nil {
+		return nil, err
+	}
+	duties, err := childStrings(tx, "profile_project_role_duty", "duty", roleIds)
+	if err != nil {
+		return nil, err
+	}
 
-	log := ts.Logger
+	byProject := make(map[int64][]sd.Role)
+	for _, r := range roles {
+		r.Skill = skills[r.Id]
+		r.Duty = duties[r.Id]
+		byProject[r.RefId] = append(byProject[r.RefId], r.Role)
+	}
+
+	m := make(map[int64][]sd.Project)
+	for _, pjt := range pjts {
+		pjt.Role = byProject[pjt.Id]
+		m[pjt.RefId] = append(m[pjt.RefId], pjt.Project)
+	}
+
+	return m, nil
+}
 
+/*
+retrieveAdvertised loads the advertised skills of every
+profile in ids, along with their examples, keyed by profile
+id.
+*/
+func retrieveAdvertised(tx sd.Tx, ids []int64) (map[int64][]sd.Advertised, error) {
+
+	type ad struct {
+		Id    int64
+		RefId int64 `db:"ref_id"`
+		sd.Advertised
+	}
+	var ads []ad
+	err := tx.Select(&ads, `
+		SELECT
+			id,
+			ref_id,
+			skill
+		FROM
+			profile_advertised
+		WHERE
+			ref_id = ANY($1)
+		ORDER BY
+			id ASC`,
+		pq.Array(ids),
+	)
 	if err != nil {
-		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
-			Data(sd.LK_RetryAttemptsTx, len(errs)).
-			Data(sd.LK_ProfileSlug, slug)
 		return nil, err
 	}
+	if len(ads) == 0 {
+		return nil, nil
+	}
 
-	log.Info(reqId, "Retrieved talent profile.").
-		Data(sd.LK_ProfileSlug, slug).
-		Data(sd.LK_ProfileId, p.Id).
-		Data(sd.LK_ProfileName, p.Name.String).
-		Data(sd.LK_PersId, p.PersId).
-		Data(sd.LK_PersHandle, p.PersHandle)
+	adIds := make([]int64, len(ads))
+	for i := range ads {
+		adIds[i] = ads[i].Id
+	}
+
+	type ex struct {
+		Id    int64
+		RefId int64 `db:"ref_id"`
+		sd.Media
+	}
+	var exs []ex
+	err = tx.Select(&exs, `
+		SELECT
+			id,
+			ref_id,
+			alttext,
+			title,
+			project,
+			info,
+			kind,
+			format,
+			aspect,
+			filename AS file
+		FROM
+			profile_advertised_example
+		WHERE
+			ref_id = ANY($1)
+		ORDER BY
+			id ASC`,
+		pq.Array(adIds),
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	return &p, nil
+	byAd := make(map[int64][]sd.Media)
+	for _, ex := range exs {
+		byAd[ex.RefId] = append(byAd[ex.RefId], ex.Media)
+	}
+
+	m := make(map[int64][]sd.Advertised)
+	for _, ad := range ads {
+		ad.Example = byAd[ad.Id]
+		m[ad.RefId] = append(m[ad.RefId], ad.Advertised)
+	}
+
+	return m, nil
 }
 
-func (ts Talent) Filter(reqId string, admin bool, filter map[string][]string) ([]sd.Resource, error) {
+func (ts Talent) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
 
 	var where []string
 	var args []interface{}
@@ -461,7 +509,10 @@ func (ts Talent) Filter(reqId string, admin bool, filter map[string][]string) ([
 
 	q := `
 		SELECT
-			profile.slug
+			profile.slug,
+			profile.created,
+			profile.id,
+			%s AS rank
 		FROM
 			profile
 		`
@@ -490,14 +541,18 @@ func (ts Talent) Filter(reqId string, admin bool, filter map[string][]string) ([
 		where = append(where, "("+strings.Join(w, " OR ")+")")
 	}
 
+	if _, ok := filter["hidden"]; ok {
+		where = append(where, "profile.hidden IS NULL")
+	}
+
 	if vv, ok := filter["persona"]; ok {
 		where = append(where, "profile.ref_id = "+arg.Next())
 		if len(vv) < 1 {
-			return nil, errors.New("expected persona id while filtering talent db")
+			return nil, "", errors.New("expected persona id while filtering talent db")
 		}
 		persId, err := strconv.ParseInt(vv[0], 10, 64)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		args = append(args, persId)
 		goto skip
@@ -510,7 +565,7 @@ func (ts Talent) Filter(reqId string, admin bool, filter map[string][]string) ([
 	if vv, ok := filter["duration"]; ok {
 
 		if len(vv) != 2 {
-			return nil, errors.New("expected duration to have a start and end value")
+			return nil, "", errors.New("expected duration to have a start and end value")
 		}
 
 		start := vv[0]
@@ -573,25 +628,48 @@ func (ts Talent) Filter(reqId string, admin bool, filter map[string][]string) ([
 				profile_project.ref_id = profile.id
 		) >= `+arg.Next())
 		if len(vv) != 1 {
-			return nil, errors.New("expected exactly one argument for projects")
+			return nil, "", errors.New("expected exactly one argument for projects")
 		}
 		v := vv[0]
 		if len(v) == 0 {
-			return nil, errors.New("expected project string to be longer")
+			return nil, "", errors.New("expected project string to be longer")
 		}
 		v = string(v[0])
 		n, err := strconv.Atoi(v)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		if n < 1 || n > 4 {
-			return nil, errors.New("num of projects out of expected range")
+			return nil, "", errors.New("num of projects out of expected range")
 		}
 		args = append(args, n)
 	}
 
 skip:
 
+	// Searches are ordered by relevance rather than recency.
+	text := searchText(filter)
+	rank := "0"
+	sortKey := "profile.created"
+	if text != "" {
+		ph := arg.Next()
+		args = append(args, text)
+		where = append(where, searchMatch("profile.search", ph))
+		rank = searchRank("profile.search", ph)
+		sortKey = rank
+	}
+	q = fmt.Sprintf(q, rank)
+
+	cur, err := parseCursor(p.Cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if cur != nil {
+		w, a := cur.after(arg, true, sortKey, "profile.id")
+		where = append(where, w)
+		args = append(args, a...)
+	}
+
 	if len(from) > 0 {
 		for tbl := range from {
 			q += ",\n" + tbl + "\n"
@@ -602,9 +680,16 @@ skip:
 		q += "WHERE " + strings.Join(where, " AND \n")
 	}
 
-	var slugs []string
+	q += fmt.Sprintf(" ORDER BY %s DESC, profile.id DESC", sortKey) + limit(p)
+
+	var rows []struct {
+		Slug    string
+		Created int64
+		Id      int64
+		Rank    int64
+	}
 	errs, err := ts.TryerTx.Try(func() error {
-		return ts.Db.Select(&slugs, q, args...)
+		return ts.Db.Select(&rows, q, args...)
 	})
 
 	log := ts.Logger
@@ -612,26 +697,49 @@ skip:
 	if err != nil {
 		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
 			Data(sd.LK_RetryAttemptsTx, len(errs))
-		return nil, err
+		return nil, "", err
 	}
 
-	if len(slugs) == 0 {
-		return nil, nil
+	if len(rows) == 0 {
+		return nil, "", nil
 	}
 
-	var rr []sd.Resource
-	for _, slug := range slugs {
-		r, err := ts.Retrieve(reqId, slug, sd.ResOpts{
-			GetPrivate:       true,
-			CalledFromFilter: true,
-		})
+	n, more := nextPage(p, len(rows))
+	rows = rows[:n]
+	var next string
+	if more {
+		last := rows[n-1]
+		next = cursor{last.Created, last.Id}.String()
+		if text != "" {
+			next = cursor{last.Rank, last.Id}.String()
+		}
+	}
+
+	slugs := make([]string, len(rows))
+	for i := range rows {
+		slugs[i] = rows[i].Slug
+	}
+	rr, err := ts.RetrieveMany(reqId, slugs, sd.ResOpts{
+		GetPrivate:       true,
+		CalledFromFilter: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if text != "" {
+		ids := make([]int64, len(rows))
+		for i := range rows {
+			ids[i] = rows[i].Id
+		}
+		m, err := snippets(ts.Db, "profile", "id", "", ids, text)
 		if err != nil {
-			return nil, err
+			log.Error(reqId, err.Error())
 		}
-		rr = append(rr, r)
+		addSnippets(rr, m)
 	}
 
-	return rr, nil
+	return rr, next, nil
 }
 
 func (ts Talent) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
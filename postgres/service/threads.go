@@ -8,10 +8,27 @@ import (
 	"time"
 
 	sd "github.com/jakebowkett/storydevs"
+	"github.com/lib/pq"
 )
 
 const fbThreadLocked = "Thread locked. No replies, edits, or deletions are possible."
 
+/*
+Condition for posts regular users may see. Used where the
+personas table can't simply be joined.
+*/
+const visiblePost = `post.deleted IS NULL AND
+						post.hidden IS NULL AND
+						EXISTS (
+							SELECT
+								1
+							FROM
+								personas
+							WHERE
+								personas.id = post.ref_id AND
+								personas.visibility != 'private'
+						)`
+
 type Thread struct {
 	*sd.Dependencies
 	Mode string
@@ -74,6 +91,10 @@ func (t Thread) createThread(reqId string, p *sd.Post, tbl *sd.DbTable) (sd.Feed
 			return tx.Rollback(err)
 		}
 
+		if err = updateSearch(tx, "post", tId); err != nil {
+			return tx.Rollback(err)
+		}
+
 		if err = addFiles(tx, tbl, tId, "post"); err != nil {
 			return tx.Rollback(err)
 		}
@@ -173,9 +194,15 @@ func (t Thread) createReply(reqId string, p *sd.Post, tbl *sd.DbTable) (sd.Feedb
 		if rId, err = insertTables(tx, tbl, tbl.Refs, false); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = updateSearch(tx, "post", rId); err != nil {
+			return tx.Rollback(err)
+		}
 		if err = addFiles(tx, tbl, rId, "post"); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = notifyReply(tx, t.Mode, p, tmp.Thread, tmp.Slug, tmp.Name); err != nil {
+			return tx.Rollback(err)
+		}
 		return tx.Commit()
 	})
 
@@ -281,6 +308,9 @@ func (t Thread) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 		if _, err = insertTables(tx, tbl, tbl.Refs, true); err != nil {
 			return tx.Rollback(err)
 		}
+		if err = updateSearch(tx, "post", rId); err != nil {
+			return tx.Rollback(err)
+		}
 
 		err = tx.Get(&thread, `
 			SELECT
@@ -366,18 +396,35 @@ func (t Thread) Update(reqId string, r sd.Resource, tbl *sd.DbTable) (sd.Feedbac
 	return nil, toRemove, nil
 }
 
-func (t Thread) RetrieveById(reqId string, id int64, o sd.ResOpts) (sd.Resource, error) {
-	return t.retrieve(reqId, "id", id, o)
-}
-
 func (t Thread) Retrieve(reqId, slug string, o sd.ResOpts) (sd.Resource, error) {
-	return t.retrieve(reqId, "slug", slug, o)
+	return first(t.RetrieveMany(reqId, []string{slug}, o))
 }
 
-func (t Thread) retrieve(reqId, kind string, arg interface{}, o sd.ResOpts) (sd.Resource, error) {
+/*
+RetrieveMany returns the posts matching slugs in the same order.
+A slug belonging to a thread's opening post retrieves the whole
+thread with the remaining posts as its replies. A slug belonging
+to a reply retrieves only that reply. Slugs that don't match a
+post are skipped.
+*/
+func (t Thread) RetrieveMany(reqId string, slugs []string, o sd.ResOpts) ([]sd.Resource, error) {
+
+	type target struct {
+		Id     int64
+		Thread int64
+		Slug   string
+	}
+	type threadInfo struct {
+		Id     int64
+		Name   string
+		Slug   string
+		Locked sd.NullBool
+	}
+
+	var targets []target
+	var pp []sd.Post
+	var threads []threadInfo
 
-	var p sd.Post
-	var slug string
 	var private string
 	if !o.GetPrivate {
 		private = "post.visibility != 'private' AND"
@@ -390,107 +437,135 @@ func (t Thread) retrieve(reqId, kind string, arg interface{}, o sd.ResOpts) (sd.
 			return err
 		}
 
-		switch kind {
-		case "slug":
-			slug = arg.(string)
-		case "id":
-			err := tx.Get(&slug, `
-				SELECT
-					slug
-				FROM
-					post
-				WHERE
-					id = $1`,
-				arg)
-			if err != nil {
-				return tx.Rollback(err)
+		targets = nil
+		err = tx.Select(&targets, fmt.Sprintf(`
+			SELECT
+				id,
+				thread,
+				slug
+			FROM
+				post
+			WHERE
+				%s
+				slug = ANY($1)`, private),
+			pq.Array(slugs),
+		)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if len(targets) == 0 {
+			return tx.Commit()
+		}
+
+		var rootIds []int64
+		var replyIds []int64
+		var replyThreadIds []int64
+		for _, tg := range targets {
+			if tg.Id == tg.Thread {
+				rootIds = append(rootIds, tg.Id)
+			} else {
+				replyIds = append(replyIds, tg.Id)
+				replyThreadIds = append(replyThreadIds, tg.Thread)
 			}
 		}
 
-		isThreadRoot, err := tx.Exists(`
+		pp = nil
+		err = tx.Select(&pp, `
+			SELECT
+				personas.id         AS persId,
+				personas.slug       AS persSlug,
+				personas.handle     AS persHandle,
+				personas.name       AS persName,
+				personas.avatar     AS persAvatar,
+				personas.visibility AS persVis,
+				personas.admin,
+				
+				post.thread AS threadid,
+				post.id,
+				post.slug,
+				post.created,
+				post.updated,
+				
+				post.pinned,
+				post.locked,
+				post.deleted,
+				post.hidden,
+				post.visibility,
+				post.name,
+				post.summary,
+				post.words
 			FROM
-				post
+				post,
+				personas
 			WHERE
-				slug = $1 AND
-				id = thread
-		`, slug)
+				(
+					post.thread = ANY($1) OR
+					post.id = ANY($2)
+				) AND
+				personas.id = post.ref_id
+			ORDER BY
+				post.thread ASC,
+				post.idx ASC`,
+			pq.Array(rootIds),
+			pq.Array(replyIds),
+		)
 		if err != nil {
-			return err
+			return tx.Rollback(err)
 		}
 
-		var pp []sd.Post
-		if isThreadRoot {
-			pp, err = thread(tx, slug, private)
-		} else {
-			pp, err = post(tx, slug, private)
-		}
+		threads = nil
+		err = tx.Select(&threads, `
+			SELECT
+				id,
+				name,
+				slug,
+				locked
+			FROM
+				post
+			WHERE
+				id = ANY($1)`,
+			pq.Array(replyThreadIds),
+		)
 		if err != nil {
 			return tx.Rollback(err)
 		}
 
+		ids := make([]int64, len(pp))
+		persIds := make([]int64, len(pp))
 		for i := range pp {
+			ids[i] = pp[i].Id
+			persIds[i] = pp[i].PersId
+		}
 
-			err = tx.Select(&pp[i].PersPronouns, `
-				SELECT
-					pronoun
-				FROM
- 					pronouns
-				WHERE
-					ref_id = $1`,
-				pp[i].PersId)
-			if err != nil {
-				return tx.Rollback(err)
-			}
-
-			/*
-				Transaction is rolled back inside
-				retrieveSpans if there's an error.
-			*/
-			rt, err := retrieveSpans(tx, "post", pp[i].Id)
-			if err != nil {
-				return err
-			}
-			pp[i].Body = rt
-
-			err = tx.Select(&pp[i].Kind, `
-				SELECT
-					kind
-				FROM
-					post_kind
-				WHERE
-					ref_id = $1`,
-				pp[i].Id)
-			if err != nil {
-				return tx.Rollback(err)
-			}
-
-			err = tx.Select(&pp[i].Category, `
-				SELECT
-					category
-				FROM
-					post_category
-				WHERE
-					ref_id = $1`,
-				pp[i].Id)
-			if err != nil {
-				return tx.Rollback(err)
-			}
-
-			err = tx.Select(&pp[i].Tag, `
-				SELECT
-					tag
-				FROM
-					post_tag
-				WHERE
-					ref_id = $1`,
-				pp[i].Id)
-			if err != nil {
-				return tx.Rollback(err)
-			}
+		pronouns, err := childStrings(tx, "pronouns", "pronoun", persIds)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		bodies, err := retrieveSpans(tx, "post", ids)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		kinds, err := childStrings(tx, "post_kind", "kind", ids)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		categories, err := childStrings(tx, "post_category", "category", ids)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		tags, err := childStrings(tx, "post_tag", "tag", ids)
+		if err != nil {
+			return tx.Rollback(err)
 		}
 
-		p = pp[0]
-		p.Reply = pp[1:]
+		for i := range pp {
+			p := &pp[i]
+			p.PersPronouns = pronouns[p.PersId]
+			p.Body = bodies[p.Id]
+			p.Kind = kinds[p.Id]
+			p.Category = categories[p.Id]
+			p.Tag = tags[p.Id]
+		}
 
 		return tx.Commit()
 	})
@@ -500,140 +575,73 @@ func (t Thread) retrieve(reqId, kind string, arg interface{}, o sd.ResOpts) (sd.
 	if err != nil {
 		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
 			Data(sd.LK_RetryAttemptsTx, len(errs)).
-			Data(sd.LK_ThreadSlug, slug)
+			Data(sd.LK_ThreadSlug, strings.Join(slugs, ", "))
 		return nil, err
 	}
 
-	var resourceKind string
-	if p.IsReply() {
-		resourceKind = "reply"
-	} else {
-		resourceKind = "thread"
+	byId := make(map[int64]sd.Post, len(pp))
+	byThread := make(map[int64][]sd.Post)
+	for _, p := range pp {
+		byId[p.Id] = p
+		byThread[p.ThreadId] = append(byThread[p.ThreadId], p)
+	}
+	threadById := make(map[int64]threadInfo, len(threads))
+	for _, th := range threads {
+		threadById[th.Id] = th
+	}
+	bySlug := make(map[string]target, len(targets))
+	for _, tg := range targets {
+		bySlug[tg.Slug] = tg
 	}
 
-	log.InfoF(reqId, "Retrieved %s %s.", t.Mode, resourceKind).
-		Data(sd.LK_ThreadSlug, slug).
-		Data(sd.LK_ThreadId, p.Id).
-		Data(sd.LK_ThreadTitle, p.Name.String).
-		Data(sd.LK_PersId, p.PersId).
-		Data(sd.LK_PersHandle, p.PersHandle)
+	var rr []sd.Resource
+	for _, slug := range slugs {
 
-	return &p, nil
-}
+		tg, ok := bySlug[slug]
+		if !ok {
+			continue
+		}
 
-func thread(tx sd.Tx, slug, private string) ([]sd.Post, error) {
-	var pp []sd.Post
-	err := tx.Select(&pp, fmt.Sprintf(`
-		SELECT
-			personas.id         AS persId,
-			personas.handle     AS persHandle,
-			personas.name       AS persName,
-			personas.avatar     AS persAvatar,
-			personas.visibility AS persVis,
-			personas.admin,
-			
-			post.thread AS threadid,
-			post.id,
-			post.slug,
-			post.created,
-			post.updated,
-			
-			post.pinned,
-			post.locked,
-			post.deleted,
-			post.visibility,
-			post.name,
-			post.summary,
-			post.words
-		FROM
-			post,
-			personas
-		WHERE
-			(
-				SELECT
-					id
-				FROM
-					post
-				WHERE
-					%s
-					slug = $1
-			) = post.thread AND
-			personas.id = post.ref_id
-		ORDER BY
-			post.idx ASC`, private),
-		slug,
-	)
-	return pp, err
-}
+		var p sd.Post
+		if tg.Id == tg.Thread {
+			posts := byThread[tg.Id]
+			if len(posts) == 0 {
+				continue
+			}
+			p = posts[0]
+			p.Reply = posts[1:]
+		} else {
+			p, ok = byId[tg.Id]
+			if !ok {
+				continue
+			}
+			th := threadById[tg.Thread]
+			p.Locked.Bool = th.Locked.Bool
+			p.Name.String = "Re: " + th.Name
+			p.ThreadSlug = th.Slug
+		}
 
-func post(tx sd.Tx, slug, private string) ([]sd.Post, error) {
-	var p sd.Post
-	err := tx.Get(&p, fmt.Sprintf(`
-		SELECT
-			personas.id         AS persId,
-			personas.handle     AS persHandle,
-			personas.name       AS persName,
-			personas.avatar     AS persAvatar,
-			personas.visibility AS persVis,
-			personas.admin,
-			
-			post.thread AS threadid,
-			post.id,
-			post.slug,
-			post.created,
-			post.updated,
-			
-			post.pinned,
-			post.locked,
-			post.deleted,
-			post.visibility,
-			post.name,
-			post.summary,
-			post.words
-		FROM
-			post,
-			personas
-		WHERE
-			%s
-			post.slug = $1 AND
-			personas.id = post.ref_id`, private),
-		slug,
-	)
-	if err != nil {
-		return nil, err
-	}
-	tmp := struct {
-		Name   string
-		Slug   string
-		Locked sd.NullBool
-	}{}
-	err = tx.Get(&tmp, `
-		SELECT
-			name,
-			slug,
-			locked
-		FROM
-			post
-		WHERE
-			id = (
-				SELECT
-					thread
-				FROM
-					post
-				WHERE
-					slug = $1
-			)`,
-		slug)
-	if err != nil {
-		return nil, err
+		var resourceKind string
+		if p.IsReply() {
+			resourceKind = "reply"
+		} else {
+			resourceKind = "thread"
+		}
+
+		log.InfoF(reqId, "Retrieved %s %s.", t.Mode, resourceKind).
+			Data(sd.LK_ThreadSlug, slug).
+			Data(sd.LK_ThreadId, p.Id).
+			Data(sd.LK_ThreadTitle, p.Name.String).
+			Data(sd.LK_PersId, p.PersId).
+			Data(sd.LK_PersHandle, p.PersHandle)
+
+		rr = append(rr, &p)
 	}
-	p.Locked.Bool = tmp.Locked.Bool
-	p.Name.String = "Re: " + tmp.Name
-	p.ThreadSlug = tmp.Slug
-	return []sd.Post{p}, nil
+
+	return rr, nil
 }
 
-func (t Thread) Filter(reqId string, admin bool, filter map[string][]string) ([]sd.Resource, error) {
+func (t Thread) Filter(reqId string, admin bool, filter map[string][]string, p sd.PageOpts) ([]sd.Resource, string, error) {
 
 	var where []string
 	var args []interface{}
@@ -645,7 +653,7 @@ func (t Thread) Filter(reqId string, admin bool, filter map[string][]string) ([]
 
 	if vv, ok := filter["deleted"]; ok {
 		if len(vv) == 0 || len(vv) > 1 {
-			return nil, fmt.Errorf("expected exactly 1 value for deleted while filtering %s", t.Mode)
+			return nil, "", fmt.Errorf("expected exactly 1 value for deleted while filtering %s", t.Mode)
 		}
 		if vv[0] == "false" {
 			where = append(where, "post.deleted IS NULL")
@@ -662,11 +670,11 @@ func (t Thread) Filter(reqId string, admin bool, filter map[string][]string) ([]
 	if vv, ok := filter["persona"]; ok {
 		where = append(where, "post.ref_id = "+arg.Next())
 		if len(vv) < 1 {
-			return nil, fmt.Errorf("expected persona id while filtering %s", t.Mode)
+			return nil, "", fmt.Errorf("expected persona id while filtering %s", t.Mode)
 		}
 		persId, err := strconv.ParseInt(vv[0], 10, 64)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		args = append(args, persId)
 		goto skip
@@ -684,6 +692,25 @@ skip:
 	where = append(where, buildExistsOr(arg, "", 1, "post", "kind"))
 	args = append(args, t.Mode)
 
+	/*
+		Searches are ordered by relevance alone, so pinned
+		threads don't float to the top and the cursor holds
+		the rank and id instead.
+	*/
+	text := searchText(filter)
+	var ph string
+	keyLen := 3
+	if text != "" {
+		ph = arg.Next()
+		args = append(args, text)
+		keyLen = 2
+	}
+
+	cur, err := parseCursor(p.Cursor, keyLen)
+	if err != nil {
+		return nil, "", err
+	}
+
 	q := ""
 	if _, ok := filter["thread"]; ok {
 
@@ -697,12 +724,21 @@ skip:
 		_, okDeleted := filter["deleted"]
 		_, okPersVis := filter["persona_visibility"]
 		if okDeleted || okPersVis {
-			return nil, fmt.Errorf(`incompatible use of filter "thread" with "deleted" and/or "persona_visibility" while filtering %s`, t.Mode)
+			return nil, "", fmt.Errorf(`incompatible use of filter "thread" with "deleted" and/or "persona_visibility" while filtering %s`, t.Mode)
 		}
 
+		/*
+			Unless searching, pinned threads come first. Its CASE
+			expression is repeated verbatim in the cursor
+			comparison below.
+		*/
 		q = `
 			SELECT
-				post.thread
+				post.thread AS id,
+				p2.slug,
+				CASE WHEN p2.pinned THEN 1 ELSE 0 END AS pinned,
+				post.created,
+				%s AS rank
 			FROM
 				/*
 					Create a table containing the thread IDs and
@@ -727,6 +763,7 @@ skip:
 					SELECT
 						post.id,
 						post.thread,
+						post.slug,
 						post.pinned
 					FROM
 						post
@@ -746,48 +783,134 @@ skip:
 				post
 				ON
 					post.idx = p1.idx AND
-					post.thread = p1.thread
+					post.thread = p1.thread%s
 			WHERE
 				%s
 			ORDER BY
-				p2.pinned ASC,
-				post.created DESC
+				%s
 		`
+		rank := "0"
+		join := ""
+		order := `
+				pinned DESC,
+				post.created DESC,
+				post.thread DESC`
+		if text != "" {
+			vis := ""
+			if !admin {
+				vis = " AND\n" + visiblePost
+			}
+			rank = "s.rank"
+			join = fmt.Sprintf(`
+			INNER JOIN
+				/*
+					Create a table containing the IDs of threads
+					with at least one post matching the search
+					and the rank of each thread's best match.
+				*/
+				(
+					SELECT
+						post.thread,
+						MAX(%s) AS rank
+					FROM
+						post
+					WHERE
+						%s%s
+					GROUP BY
+						post.thread
+				) s
+				ON s.thread = p1.thread`,
+				searchRank("post.search", ph),
+				searchMatch("post.search", ph),
+				vis)
+			order = `
+				rank DESC,
+				post.thread DESC`
+		}
+		if cur != nil {
+			var w string
+			var a []interface{}
+			if text != "" {
+				w, a = cur.after(arg, true, "s.rank", "post.thread")
+			} else {
+				w, a = cur.after(arg, true, "CASE WHEN p2.pinned THEN 1 ELSE 0 END", "post.created", "post.thread")
+			}
+			where = append(where, w)
+			args = append(args, a...)
+		}
 		if admin {
-			q = fmt.Sprintf(q, "", "%s")
+			q = fmt.Sprintf(q, rank, "", join, "%s", order)
 		} else {
 			// We filter out deleted/hidden posts for regular users.
 			q = fmt.Sprintf(
 				q,
+				rank,
 				`,
 						personas
 					WHERE
 						post.deleted IS NULL AND
+						post.hidden IS NULL AND
 						personas.id = post.ref_id AND
 						personas.visibility != 'private'`,
-				`%s`)
+				join,
+				`%s`,
+				order)
 		}
 	} else {
 		q = `
 			SELECT
-				post.id
+				post.id,
+				post.slug,
+				CASE WHEN post.pinned THEN 1 ELSE 0 END AS pinned,
+				post.created,
+				%s AS rank
 			FROM
 				post
 			WHERE
-				%s
+				%%s
 			ORDER BY
-				post.pinned ASC,
-				post.created DESC
+				%s
 		`
+		rank := "0"
+		order := `
+				pinned DESC,
+				post.created DESC,
+				post.id DESC`
+		if text != "" {
+			rank = searchRank("post.search", ph)
+			where = append(where, searchMatch("post.search", ph))
+			order = `
+				rank DESC,
+				post.id DESC`
+		}
+		if cur != nil {
+			var w string
+			var a []interface{}
+			if text != "" {
+				w, a = cur.after(arg, true, rank, "post.id")
+			} else {
+				w, a = cur.after(arg, true, "CASE WHEN post.pinned THEN 1 ELSE 0 END", "post.created", "post.id")
+			}
+			where = append(where, w)
+			args = append(args, a...)
+		}
+		q = fmt.Sprintf(q, rank, order)
 	}
 
 	if len(where) > 0 {
 		q = fmt.Sprintf(q, strings.Join(where, " AND \n"))
 	}
-
-	var ids []int64
+	q += limit(p)
+
+	var rows []struct {
+		Id      int64
+		Slug    string
+		Pinned  int64
+		Created int64
+		Rank    int64
+	}
 	errs, err := t.TryerTx.Try(func() error {
-		return t.Db.Select(&ids, q, args...)
+		return t.Db.Select(&rows, q, args...)
 	})
 
 	log := t.Logger
@@ -795,26 +918,61 @@ skip:
 	if err != nil {
 		log.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
 			Data(sd.LK_RetryAttemptsTx, len(errs))
-		return nil, err
+		return nil, "", err
 	}
 
-	if len(ids) == 0 {
-		return nil, nil
+	if len(rows) == 0 {
+		return nil, "", nil
 	}
 
-	var rr []sd.Resource
-	for _, id := range ids {
-		r, err := t.RetrieveById(reqId, id, sd.ResOpts{
-			GetPrivate:       true,
-			CalledFromFilter: true,
-		})
+	n, more := nextPage(p, len(rows))
+	rows = rows[:n]
+	var next string
+	if more {
+		last := rows[n-1]
+		next = cursor{last.Pinned, last.Created, last.Id}.String()
+		if text != "" {
+			next = cursor{last.Rank, last.Id}.String()
+		}
+	}
+
+	slugs := make([]string, len(rows))
+	for i := range rows {
+		slugs[i] = rows[i].Slug
+	}
+	rr, err := t.RetrieveMany(reqId, slugs, sd.ResOpts{
+		GetPrivate:       true,
+		CalledFromFilter: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if text != "" {
+		ids := make([]int64, len(rows))
+		for i := range rows {
+			ids[i] = rows[i].Id
+		}
+		/*
+			In thread mode rows are keyed by thread and the
+			snippet comes from its best matching post.
+		*/
+		key := "id"
+		if _, ok := filter["thread"]; ok {
+			key = "thread"
+		}
+		cond := ""
+		if !admin {
+			cond = visiblePost
+		}
+		m, err := snippets(t.Db, "post", key, cond, ids, text)
 		if err != nil {
-			return nil, err
+			log.Error(reqId, err.Error())
 		}
-		rr = append(rr, r)
+		addSnippets(rr, m)
 	}
 
-	return rr, nil
+	return rr, next, nil
 }
 
 func (t Thread) Delete(reqId, slug string, persId int64) (sd.Feedback, []string, error) {
@@ -931,6 +1089,7 @@ func (t Thread) Meta(reqId, kind string, search sd.Fields) error {
 		WHERE
 			post.created > $1 AND
 			post.deleted IS NULL AND
+			post.hidden IS NULL AND
 			k.kind = $2 AND
 			personas.visibility = 'public'
 		GROUP BY
@@ -0,0 +1,588 @@
+package service
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/internal/totp"
+)
+
+const (
+	fbTwoFactorInvalid  = "Invalid code."
+	fbTwoFactorExpired  = "Your log in has expired, please start again."
+	fbTwoFactorEnabled  = "Two-factor authentication is already enabled."
+	fbTwoFactorDisabled = "Two-factor authentication isn't enabled."
+	fbTwoFactorStart    = "Please start setting up two-factor authentication again."
+)
+
+/*
+normaliseCode removes the spaces and hyphens people add when
+copying a code, as well as any capitals in recovery codes.
+*/
+func normaliseCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+newRecoveryCodes returns n recovery codes. Each has 50 random
+bits written as ten base32 characters, hyphenated in the middle.
+*/
+func newRecoveryCodes(n int) ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	var cc []string
+	for i := 0; i < n; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(enc.EncodeToString(b))[:10]
+		cc = append(cc, c[:5]+"-"+c[5:])
+	}
+	return cc, nil
+}
+
+/*
+useTwoFactor reports whether code is a TOTP code or unused
+recovery code for accId. Either is spent by being used.
+*/
+func (as *Account) useTwoFactor(tx sd.Tx, accId int64, code string) (bool, error) {
+
+	code = normaliseCode(code)
+
+	if !isTOTPCode(code) {
+		result, err := tx.Exec(`
+			DELETE FROM
+				recovery_codes
+			WHERE
+				acc_id = $1 AND
+				code = $2`,
+			accId,
+			sd.HashToken(code))
+		if err != nil {
+			return false, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		return n == 1, nil
+	}
+
+	var secret string
+	err := tx.Get(&secret, `
+		SELECT
+			totp_secret
+		FROM
+			accounts
+		WHERE
+			id = $1 AND
+			totp_enabled IS NOT NULL`,
+		accId)
+	if err != nil {
+		return false, err
+	}
+	step, ok, err := totp.Verify(secret, code, time.Now(), as.Config.TwoFactor.Skew)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// Codes for the step of the last one used or earlier are spent.
+	result, err := tx.Exec(`
+		UPDATE
+			accounts
+		SET
+			totp_step = $2
+		WHERE
+			id = $1 AND
+			totp_step < $2`,
+		accId,
+		step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+/*
+loginChallenge returns a token standing in for having entered
+the password of accId correctly until a code is given with it.
+*/
+func (as *Account) loginChallenge(reqId string, accId int64) (string, error) {
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	expires := now.Add(time.Minute * time.Duration(as.Config.TwoFactor.Challenge))
+
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			DELETE FROM
+				login_challenges
+			WHERE
+				expires <= $1`,
+			now.Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO login_challenges (
+				token,
+				acc_id,
+				expires
+			)
+			VALUES
+				($1, $2, $3)`,
+			sd.HashToken(token),
+			accId,
+			expires.Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId)
+		return "", err
+	}
+
+	return token, nil
+}
+
+/*
+LoginTwoFactor finishes logging in the account challenge was
+issued to once it's given a code. Wrong codes count as failed
+attempts to log in.
+*/
+func (as *Account) LoginTwoFactor(reqId, challenge, ip, userAgent, code string) (*sd.AuthedUser, sd.Feedback, error) {
+
+	fb := make(sd.Feedback)
+	hash := sd.HashToken(challenge)
+
+	var accId int64
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.BeginRead()
+		if err != nil {
+			return err
+		}
+		err = tx.Get(&accId, `
+			SELECT
+				acc_id
+			FROM
+				login_challenges
+			WHERE
+				token = $1 AND
+				expires > $2`,
+			hash,
+			time.Now().Unix())
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if lastErrSqlNoRows(errs) {
+		fb.Add("general", fbTwoFactorExpired)
+		return nil, fb, nil
+	}
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs))
+		return nil, nil, err
+	}
+
+	account, err := as.RetrieveById(reqId, accId, sd.AccOptRetrieve{
+		Confirmed: true,
+	})
+	if err == sql.ErrNoRows {
+		fb.Add("general", fbTwoFactorExpired)
+		return nil, fb, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := as.loginKeys(account, "", ip)
+	wait, err := as.loginWait(reqId, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if wait > 0 {
+		as.Logger.Info(reqId, "Throttled log in attempt.").
+			Data(sd.LK_IP, ip).
+			Data(sd.LK_LoginWait, wait.String())
+		fb.Add("general", fmt.Sprintf(fbLoginWait, loginWaitText(wait)))
+		return nil, fb, nil
+	}
+
+	var ok bool
+	errs, err = as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.Begin()
+		if err != nil {
+			return err
+		}
+		ok, err = as.useTwoFactor(tx, accId, code)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !ok {
+			return tx.Rollback(nil)
+		}
+		_, err = tx.Exec(`
+			DELETE FROM
+				login_challenges
+			WHERE
+				token = $1`,
+			hash)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId)
+		return nil, nil, err
+	}
+	if !ok {
+		if err := as.loginFailed(reqId, ip, keys); err != nil {
+			return nil, nil, err
+		}
+		fb.Add("code", fbTwoFactorInvalid)
+		return nil, fb, nil
+	}
+
+	auth, err := as.createLogin(reqId, account, keys, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return auth, nil, nil
+}
+
+/*
+BeginTwoFactor gives accId a new TOTP secret once its password
+is confirmed. It's not used until a code for it is confirmed by
+EnableTwoFactor.
+*/
+func (as *Account) BeginTwoFactor(reqId string, accId int64, pass string) (string, string, sd.Feedback, error) {
+
+	fb := make(sd.Feedback)
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	current := struct {
+		Pass    string
+		Email   string
+		Enabled bool
+	}{}
+
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Get(&current, `
+			SELECT
+				pass,
+				email,
+				totp_enabled IS NOT NULL AS enabled
+			FROM
+				accounts
+			WHERE
+				id = $1`,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if current.Enabled {
+			fb.Add("general", fbTwoFactorEnabled)
+			return tx.Rollback(nil)
+		}
+		ok, _, err := as.Password.Compare(pass, current.Pass)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !ok {
+			fb.Add("password", "Incorrect password.")
+			return tx.Rollback(nil)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE
+				accounts
+			SET
+				totp_secret = $2
+			WHERE
+				id = $1`,
+			accId,
+			secret)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		return tx.Commit()
+	})
+
+	if len(fb) > 0 {
+		return "", "", fb, nil
+	}
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId)
+		return "", "", nil, errors.New("Unable to begin two-factor authentication.")
+	}
+
+	as.Logger.Info(reqId, "Began enabling two-factor authentication.").
+		Data(sd.LK_AccId, accId)
+
+	uri := totp.URI(as.Config.TwoFactor.Issuer, current.Email, secret)
+	return secret, uri, nil, nil
+}
+
+/*
+EnableTwoFactor turns on two-factor authentication for accId
+once code shows its authenticator has the secret given to it by
+BeginTwoFactor. It returns new recovery codes, replacing any the
+account had before.
+*/
+func (as *Account) EnableTwoFactor(reqId string, accId int64, code string) ([]string, sd.Feedback, error) {
+
+	fb := make(sd.Feedback)
+
+	recovery, err := newRecoveryCodes(as.Config.TwoFactor.Recovery)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		var secret sd.NullString
+		err = tx.Get(&secret, `
+			SELECT
+				totp_secret
+			FROM
+				accounts
+			WHERE
+				id = $1 AND
+				totp_enabled IS NULL`,
+			accId)
+		if errors.Is(err, sql.ErrNoRows) {
+			fb.Add("general", fbTwoFactorEnabled)
+			return tx.Rollback(nil)
+		}
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if secret.Null {
+			fb.Add("general", fbTwoFactorStart)
+			return tx.Rollback(nil)
+		}
+
+		now := time.Now()
+		step, ok, err := totp.Verify(secret.String, normaliseCode(code), now, as.Config.TwoFactor.Skew)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !ok {
+			fb.Add("code", fbTwoFactorInvalid)
+			return tx.Rollback(nil)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE
+				accounts
+			SET
+				totp_enabled = $2,
+				totp_step = $3
+			WHERE
+				id = $1`,
+			accId,
+			now.Unix(),
+			step)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+
+		_, err = tx.Exec(`
+			DELETE FROM
+				recovery_codes
+			WHERE
+				acc_id = $1`,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		for _, c := range recovery {
+			_, err = tx.Exec(`
+				INSERT INTO recovery_codes (
+					acc_id,
+					code
+				)
+				VALUES
+					($1, $2)`,
+				accId,
+				sd.HashToken(normaliseCode(c)))
+			if err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		return tx.Commit()
+	})
+
+	if len(fb) > 0 {
+		return nil, fb, nil
+	}
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId)
+		return nil, nil, errors.New("Unable to enable two-factor authentication.")
+	}
+
+	as.Logger.Info(reqId, "Enabled two-factor authentication.").
+		Data(sd.LK_AccId, accId)
+
+	return recovery, nil, nil
+}
+
+/*
+DisableTwoFactor turns off two-factor authentication for accId.
+Its password and a code, which may be a recovery code, must be
+given again even though it's logged in.
+*/
+func (as *Account) DisableTwoFactor(reqId string, accId int64, pass, code string) (sd.Feedback, error) {
+
+	fb := make(sd.Feedback)
+
+	errs, err := as.TryerTx.Try(func() error {
+
+		tx, err := as.Db.Begin()
+		if err != nil {
+			return err
+		}
+
+		current := struct {
+			Pass    string
+			Enabled bool
+		}{}
+		err = tx.Get(&current, `
+			SELECT
+				pass,
+				totp_enabled IS NOT NULL AS enabled
+			FROM
+				accounts
+			WHERE
+				id = $1`,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !current.Enabled {
+			fb.Add("general", fbTwoFactorDisabled)
+			return tx.Rollback(nil)
+		}
+		ok, _, err := as.Password.Compare(pass, current.Pass)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !ok {
+			fb.Add("password", "Incorrect password.")
+			return tx.Rollback(nil)
+		}
+		ok, err = as.useTwoFactor(tx, accId, code)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		if !ok {
+			fb.Add("code", fbTwoFactorInvalid)
+			return tx.Rollback(nil)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE
+				accounts
+			SET
+				totp_secret = NULL,
+				totp_enabled = NULL,
+				totp_step = 0
+			WHERE
+				id = $1`,
+			accId)
+		if err != nil {
+			return tx.Rollback(err)
+		}
+		for _, table := range []string{"recovery_codes", "login_challenges"} {
+			_, err = tx.Exec(`
+				DELETE FROM
+					`+table+`
+				WHERE
+					acc_id = $1`,
+				accId)
+			if err != nil {
+				return tx.Rollback(err)
+			}
+		}
+
+		return tx.Commit()
+	})
+
+	if len(fb) > 0 {
+		return fb, nil
+	}
+	if err != nil {
+		as.Logger.ErrorMulti(reqId, err.Error(), sd.LK_Err, errs).
+			Data(sd.LK_RetryAttemptsTx, len(errs)).
+			Data(sd.LK_AccId, accId)
+		return nil, errors.New("Unable to disable two-factor authentication.")
+	}
+
+	as.Logger.Info(reqId, "Disabled two-factor authentication.").
+		Data(sd.LK_AccId, accId)
+
+	return nil, nil
+}
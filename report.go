@@ -0,0 +1,193 @@
+package storydevs
+
+import "strings"
+
+// Categories a report must be filed under.
+const (
+	ReportSpam       = "spam"
+	ReportAbuse      = "abuse"
+	ReportExplicit   = "explicit"
+	ReportInfringing = "infringing"
+	ReportOther      = "other"
+)
+
+var ReportCategories = []string{
+	ReportSpam,
+	ReportAbuse,
+	ReportExplicit,
+	ReportInfringing,
+	ReportOther,
+}
+
+var reportDesc = map[string]string{
+	ReportSpam:       "Spam or advertising",
+	ReportAbuse:      "Harassment or abuse",
+	ReportExplicit:   "Explicit or graphic content",
+	ReportInfringing: "Plagiarism or infringement",
+	ReportOther:      "Something else",
+}
+
+func IsReportCategory(s string) bool {
+	_, ok := reportDesc[s]
+	return ok
+}
+
+func ReportCategoryDesc(category string) string {
+	return reportDesc[category]
+}
+
+/*
+Ways a report can be resolved. They double as the actions
+recorded in the moderator log for dismissals, while hiding
+and destroying are recorded by the actions themselves.
+*/
+const (
+	ResolveDismiss = "dismissed"
+	ResolveHide    = "hidden"
+	ResolveDestroy = "destroyed"
+)
+
+/*
+ReportModes lists the modes whose resources may be reported.
+Threads and their replies are both reported under the mode
+of the thread.
+*/
+var ReportModes = []string{
+	"talent",
+	"event",
+	"forums",
+	"library",
+}
+
+/*
+ReportForm is what's submitted through the report modal. The
+target's mode and slug are taken from the route.
+*/
+type ReportForm struct {
+	ResourceBase
+	Category string
+	Detail   NullString
+}
+
+func (rf ReportForm) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (rf ReportForm) GetName() string {
+	return "Report"
+}
+
+/*
+Report is a single report of a resource. Reporters only ever
+see their own reports so Detail is shown to moderators as
+written.
+*/
+type Report struct {
+	Id             int64
+	Created        int64
+	ReporterId     int64
+	ReporterHandle string
+	Category       string
+	Detail         NullString
+}
+
+func (r Report) CategoryDesc() string {
+	return reportDesc[r.Category]
+}
+
+/*
+ReportGroup is what the reports submode of the admin mode
+browses and displays: every unresolved report of a single
+resource. Its slug is the target's mode and slug joined by
+a period since resource slugs never contain one.
+*/
+type ReportGroup struct {
+	ResourceBase
+	TargetKind string
+	TargetSlug string
+	TargetName NullString
+	Count      int
+	Reports    []Report `db:"-"`
+
+	// Most recent report, used for ordering the queue.
+	Latest   int64
+	LatestId int64
+}
+
+func ReportGroupSlug(kind, slug string) string {
+	return kind + "." + slug
+}
+
+/*
+SplitReportGroupSlug undoes ReportGroupSlug, reporting false
+if the slug isn't one.
+*/
+func SplitReportGroupSlug(s string) (kind, slug string, ok bool) {
+	i := strings.Index(s, ".")
+	if i < 1 || i == len(s)-1 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func (rg ReportGroup) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (rg ReportGroup) GetName() string {
+	if rg.TargetName.String != "" {
+		return rg.TargetName.String
+	}
+	return "Untitled " + rg.TargetKind
+}
+
+/*
+TargetPath is where the reported resource can be viewed.
+Replies are linked to via their thread.
+*/
+func (rg ReportGroup) TargetPath() string {
+	return "/" + rg.TargetKind + "/" + rg.TargetSlug
+}
+
+/*
+Categories summarises the reports in rg by category in the
+order of ReportCategories.
+*/
+func (rg ReportGroup) Categories() []ReportCount {
+	n := make(map[string]int)
+	for _, r := range rg.Reports {
+		n[r.Category]++
+	}
+	var cc []ReportCount
+	for _, c := range ReportCategories {
+		if n[c] > 0 {
+			cc = append(cc, ReportCount{Desc: reportDesc[c], Count: n[c]})
+		}
+	}
+	return cc
+}
+
+type ReportCount struct {
+	Desc  string
+	Count int
+}
+
+/*
+Reports files reports on behalf of users and resolves them
+on behalf of moderators. Resolving a report doesn't act on
+its target; callers hide or destroy it first.
+*/
+type Reports interface {
+
+	/*
+		Report files a report by p against the resource of the
+		given mode and slug. Feedback is returned when p may
+		not report it, e.g., they've reported too much lately.
+	*/
+	Report(reqId string, p Persona, mode, slug string, f *ReportForm) (Feedback, error)
+
+	/*
+		Resolve closes every open report of the given target and
+		notifies the reporters. Dismissals are recorded in the
+		moderator log along with the reason.
+	*/
+	Resolve(reqId string, mod Persona, mode, slug, resolution, reason string) error
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/jakebowkett/go-gen/gen"
+	"github.com/jakebowkett/storydevs/internal/recur"
 )
 
 /*
@@ -83,11 +84,24 @@ type ResOpts struct {
 	CalledFromFilter bool
 }
 
+/*
+PageOpts limits the results returned by Filter. Cursor should
+be empty for the first page and otherwise the next cursor
+returned by the previous call. Callers should treat it as
+opaque. A Limit of zero returns every result.
+*/
+type PageOpts struct {
+	Limit  int
+	Cursor string
+}
+
 type ResourceService interface {
 	Create(reqId string, r Resource, tt *DbTable) (Feedback, error)
 	Retrieve(reqId, slug string, o ResOpts) (Resource, error)
-	Filter(reqId string, admin bool, filter map[string][]string) (
+	RetrieveMany(reqId string, slugs []string, o ResOpts) ([]Resource, error)
+	Filter(reqId string, admin bool, filter map[string][]string, p PageOpts) (
 		results []Resource,
+		next string,
 		err error,
 	)
 	Update(reqId string, r Resource, tt *DbTable) (
@@ -126,6 +140,7 @@ type Resource interface {
 	GetVisibility() string
 
 	SetHyphenator(h hyphenator)
+	SetSnippet(s template.HTML)
 
 	IsReply() bool
 	IsLocked() bool
@@ -141,7 +156,7 @@ type ResourceBase struct {
 	Updated int64  `ed:"ignore" validate:"ignore"`
 	Id      int64  `ed:"ignore" validate:"ignore" database:"ignore"`
 
-	Hyphenate hyphenator `ed:"ignore" validate:"ignore" database:"ignore"`
+	Hyphenate hyphenator `ed:"ignore" validate:"ignore" database:"ignore" json:"-"`
 
 	Admin        NullBool `ed:"ignore" validate:"ignore" database:"ignore"`
 	AccId        int64    `ed:"ignore" validate:"ignore" database:"ignore"`
@@ -155,6 +170,9 @@ type ResourceBase struct {
 
 	// Slug of a talent profile, if any exist for persona.
 	PersProfile string `ed:"ignore" validate:"ignore" database:"ignore"`
+
+	// Highlighted excerpt matching a full-text search, if any.
+	Snippet template.HTML `ed:"ignore" validate:"ignore" database:"ignore"`
 }
 type hyphenator = func(string) string
 
@@ -220,6 +238,9 @@ func (rb *ResourceBase) SetCreated(unixSeconds int64) {
 func (rb *ResourceBase) SetUpdated(unixSeconds int64) {
 	rb.Updated = unixSeconds
 }
+func (rb *ResourceBase) SetSnippet(s template.HTML) {
+	rb.Snippet = s
+}
 
 var (
 	VisibilityPublic   = "public"
@@ -229,9 +250,25 @@ var (
 
 var TzSkip = []string{"utc", "local"}
 
+// How events recur, see Event.Recur.
+const (
+	RecurNever   = "never"
+	RecurDaily   = recur.Daily
+	RecurWeekly  = recur.Weekly
+	RecurMonthly = recur.Monthly
+)
+
+// Which day monthly events recur on, see Event.MonthBy.
+const (
+	MonthByDay     = "day"
+	MonthByWeekday = "weekday"
+)
+
 type Event struct {
 	ResourceBase
 
+	Hidden NullBool `validate:"ignore" database:"ignore" ed:"ignore"`
+
 	Visibility string
 
 	Name    NullString
@@ -243,7 +280,45 @@ type Event struct {
 	Timezone string
 	Start    DateTime
 	Finish   DateTime
-	Weekly   NullBool
+
+	/*
+		Recur is one of the Recur constants. Events that recur do
+		so every Every days, weeks, or months, on the day given by
+		MonthBy if monthly. They end after Times occurrences or
+		with the last on the date Until, and skip those on the
+		dates in Skip. Dates are midnight UTC of the day in the
+		event's timezone. Zero means unset. See recur.Rule.
+	*/
+	Recur   string
+	Every   NullInt64
+	MonthBy NullString
+	Until   NullInt64
+	Times   NullInt64
+	Skip    []int64
+
+	/*
+		Start and Finish as they're stored, in UTC, before recurring
+		events are moved to their next occurrence.
+	*/
+	FirstStart  int64 `validate:"ignore" database:"ignore" ed:"ignore"`
+	FirstFinish int64 `validate:"ignore" database:"ignore" ed:"ignore"`
+
+	/*
+		Capacity is how many personas may be going before the
+		rest are waitlisted. Zero means there's no limit.
+	*/
+	Capacity NullInt64
+
+	/*
+		Who's attending, see RSVPs.Attendance. The counts are of
+		everyone but Attendees only holds those whose visibility
+		is public. RSVP is how the active persona responded.
+	*/
+	Going      int        `validate:"ignore" database:"ignore" ed:"ignore"`
+	Waitlisted int        `validate:"ignore" database:"ignore" ed:"ignore"`
+	Interested int        `validate:"ignore" database:"ignore" ed:"ignore"`
+	Attendees  []Attendee `validate:"ignore" database:"ignore" ed:"ignore"`
+	RSVP       RSVP       `validate:"ignore" database:"ignore" ed:"ignore"`
 
 	Category []string
 	Setting  []string
@@ -258,6 +333,121 @@ func (e Event) BodyHTML() template.HTML {
 	return richTextToHTML(e.Body, e.Hyphenate, false)
 }
 
+// Location returns where e's times are. Floating times are in UTC.
+func (e Event) Location() (*time.Location, error) {
+	if in(TzSkip, e.Timezone) {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(e.Timezone)
+}
+
+/*
+Rule returns how e recurs from its first occurrence at start,
+which is in UTC as stored. It's false if e doesn't recur.
+*/
+func (e Event) Rule(start int64) (recur.Rule, bool, error) {
+
+	if e.Recur == "" || e.Recur == RecurNever {
+		return recur.Rule{}, false, nil
+	}
+	loc, err := e.Location()
+	if err != nil {
+		return recur.Rule{}, false, err
+	}
+
+	r := recur.Rule{
+		Start:   time.Unix(start, 0).In(loc),
+		Freq:    e.Recur,
+		Every:   int(e.Every.Int64),
+		Weekday: e.MonthBy.String == MonthByWeekday,
+		Count:   int(e.Times.Int64),
+	}
+	if e.Until.Int64 != 0 {
+		r.Until = UTC(e.Until.Int64)
+	}
+	for _, d := range e.Skip {
+		r.Except = append(r.Except, UTC(d))
+	}
+	return r, true, nil
+}
+
+/*
+RecurText describes how e recurs, e.g., "Every 2 Weeks", or
+returns the empty string if it doesn't.
+*/
+func (e Event) RecurText() string {
+
+	unit := map[string]string{
+		RecurDaily:   "Day",
+		RecurWeekly:  "Week",
+		RecurMonthly: "Month",
+	}[e.Recur]
+	if unit == "" {
+		return ""
+	}
+
+	var s string
+	switch n := e.Every.Int64; {
+	case n > 1:
+		s = fmt.Sprintf("Every %d %ss", n, unit)
+	case e.Recur == RecurDaily:
+		s = "Daily"
+	default:
+		s = unit + "ly"
+	}
+
+	if e.Recur == RecurMonthly && e.MonthBy.String == MonthByWeekday {
+		if r, ok, err := e.Rule(e.FirstStart); ok && err == nil {
+			_, _, d := r.Start.Date()
+			ord := []string{"First", "Second", "Third", "Fourth", "Last"}[(d-1)/7]
+			s += fmt.Sprintf(" on the %s %s", ord, r.Start.Weekday())
+		}
+	}
+	if n := e.Times.Int64; n > 0 {
+		s += fmt.Sprintf(", %d Times", n)
+	}
+	if e.Until.Int64 != 0 {
+		s += ", Until " + UTC(e.Until.Int64).Format("January 2, 2006")
+	}
+	return s
+}
+
+/*
+PlacesLeft is how many more personas may go to e before they're
+waitlisted. It's -1 if e has no capacity.
+*/
+func (e Event) PlacesLeft() int {
+	if e.Capacity.Int64 == 0 {
+		return -1
+	}
+	if n := int(e.Capacity.Int64) - e.Going; n > 0 {
+		return n
+	}
+	return 0
+}
+
+/*
+AttendeesWith returns those of e.Attendees that responded with
+status and have a place if they're going.
+*/
+func (e Event) AttendeesWith(status string) (aa []Attendee) {
+	for _, a := range e.Attendees {
+		if a.Status == status && a.Waitlist == 0 {
+			aa = append(aa, a)
+		}
+	}
+	return aa
+}
+
+/*
+Occurrences keeps when events occur searchable. Extend works out
+more occurrences of events recurring forever as time passes and
+returns how many events it extended.
+*/
+type Occurrences interface {
+	Extend(reqId string) (n int, err error)
+}
+
 func (e Event) Deltas() (*EventDeltas, error) {
 
 	now := time.Now()
@@ -423,6 +613,8 @@ func (e Event) GetName() string {
 type Profile struct {
 	ResourceBase
 
+	Hidden NullBool `validate:"ignore" database:"ignore" ed:"ignore"`
+
 	Available  bool
 	Visibility string
 
@@ -589,6 +781,7 @@ type Post struct {
 	ResourceBase
 
 	Deleted NullBool `validate:"ignore" database:"ignore" ed:"ignore"`
+	Hidden  NullBool `validate:"ignore" database:"ignore" ed:"ignore"`
 
 	Pinned NullBool
 	Locked NullBool
@@ -634,9 +827,36 @@ type Post struct {
 	Reply []Post `validate:"ignore" database:"ignore" ed:"ignore"`
 }
 
+/*
+Removed reports whether p was deleted by its author
+or hidden by a moderator. Either way regular users
+shouldn't see it.
+*/
+func (p Post) Removed() bool {
+	return p.Deleted.Bool || p.Hidden.Bool
+}
+
+/*
+Hider is implemented by resources that moderators may hide.
+Hidden resources are only visible to their owners and staff.
+*/
+type Hider interface {
+	IsHidden() bool
+}
+
+func (p Post) IsHidden() bool {
+	return p.Hidden.Bool
+}
+func (e Event) IsHidden() bool {
+	return e.Hidden.Bool
+}
+func (p Profile) IsHidden() bool {
+	return p.Hidden.Bool
+}
+
 /*
 For non-admin users this is the number of public
-replies to p - it excludes deleted and posts by
+replies to p - it excludes removed and posts by
 non-public personas. For admins it is the total
 number of replies ever made to this thread.
 */
@@ -647,8 +867,7 @@ func (p Post) ReplyCount(admin bool) int {
 	n := 0
 	for _, r := range p.Reply {
 		isPublic := r.PersVis == VisibilityPublic
-		deleted := r.Deleted.Bool
-		if !deleted && isPublic {
+		if !r.Removed() && isPublic {
 			n++
 		}
 	}
@@ -666,8 +885,7 @@ func (p Post) LastReply() *Post {
 	i := len(p.Reply) - 1
 	for ; i >= 0; i-- {
 		isPublic := p.Reply[i].PersVis == VisibilityPublic
-		deleted := p.Reply[i].Deleted.Bool
-		if !deleted && isPublic {
+		if !p.Reply[i].Removed() && isPublic {
 			break
 		}
 	}
@@ -776,6 +994,26 @@ func (rt RichText) HTML() template.HTML {
 	return richTextToHTML(rt, nil, true)
 }
 
+var mention = regexp.MustCompile(`(?:^|\W)@(\w+)`)
+
+/*
+Mentions returns the handles mentioned in rt, e.g., "@someone",
+without the @ and without duplicates.
+*/
+func (rt RichText) Mentions() []string {
+	var hh []string
+	for _, para := range rt {
+		for _, span := range para.Span {
+			for _, m := range mention.FindAllStringSubmatch(span.Text, -1) {
+				if !in(hh, m[1]) {
+					hh = append(hh, m[1])
+				}
+			}
+		}
+	}
+	return hh
+}
+
 func makeAnchor(s string) string {
 
 	var ss []string
@@ -820,7 +1058,9 @@ an injection attack.
 Both links and text are escaped while formats and paragraph
 kinds are validated against a whitelist. So it should be safe
 at the moment but it may be tempting to extend this in the
-future and carelessly introduce a serious bug.
+future and carelessly introduce a serious bug. Should that
+happen the Content-Security-Policy set by handler/security still
+refuses to run scripts that weren't given the response's nonce.
 */
 func richTextToHTML(rt RichText, h hyphenator, inEditor bool) template.HTML {
 
@@ -909,6 +1149,30 @@ func neighbourParas(rt RichText, i int) (string, string) {
 	return prevKind, nextKind
 }
 
+/*
+Text returns rt as plain text with a blank line between each
+paragraph and list items on their own lines.
+*/
+func (rt RichText) Text() string {
+	s := ""
+	for i, p := range rt {
+		if i > 0 {
+			if in([]string{"ul", "ol"}, p.Kind) && p.Kind == rt[i-1].Kind {
+				s += "\n"
+			} else {
+				s += "\n\n"
+			}
+		}
+		if in([]string{"ul", "ol"}, p.Kind) {
+			s += "- "
+		}
+		for _, span := range p.Span {
+			s += span.Text
+		}
+	}
+	return s
+}
+
 func (rt RichText) Words() int {
 	s := ""
 	for i, p := range rt {
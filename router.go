@@ -1,6 +1,7 @@
 package storydevs
 
 import (
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -14,11 +15,21 @@ type Request = struct {
 	Request *http.Request
 	Vars    Vars
 	User    interface{}
+	Nonce   string
 	Status  int
 	Error   error
 	Began   time.Time
 }
 
+// ClientIP returns the IP address r was made from without its port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 type TimedSet interface {
 	Add(string)
 	Has(string) bool
@@ -27,7 +38,8 @@ type TimedSet interface {
 type timedSet struct {
 	delay time.Duration
 	rwMu  sync.RWMutex
-	set   map[string]int
+	set   map[string]time.Time
+	swept time.Time
 }
 
 /*
@@ -38,38 +50,112 @@ d is zero the value will never be removed.
 func NewTimedSet(d time.Duration) TimedSet {
 	return &timedSet{
 		delay: d,
-		set:   make(map[string]int),
+		set:   make(map[string]time.Time),
+		swept: time.Now(),
 	}
 }
 
+/*
+Add adds v or, if it's already present, restarts the
+time until it's removed. Expired values are swept
+from the set at most once every delay.
+*/
 func (ts *timedSet) Add(v string) {
+	now := time.Now()
 	ts.rwMu.Lock()
-	ts.set[v]++
-	id := ts.set[v]
-	ts.rwMu.Unlock()
-	if ts.delay == 0 {
+	defer ts.rwMu.Unlock()
+	ts.set[v] = now.Add(ts.delay)
+	if ts.delay == 0 || now.Sub(ts.swept) < ts.delay {
 		return
 	}
-	go func() {
-		time.Sleep(ts.delay)
-		ts.rwMu.Lock()
-		defer ts.rwMu.Unlock()
-		/*
-			Return if the Id has been incremented,
-			indicating the value has been added again.
-		*/
-		if id != ts.set[v] {
-			return
+	for k, expires := range ts.set {
+		if !expires.After(now) {
+			delete(ts.set, k)
 		}
-		delete(ts.set, v)
-	}()
+	}
+	ts.swept = now
 }
 
 func (ts *timedSet) Has(v string) bool {
 	ts.rwMu.RLock()
-	_, ok := ts.set[v]
+	expires, ok := ts.set[v]
 	ts.rwMu.RUnlock()
-	return ok
+	if !ok {
+		return false
+	}
+	return ts.delay == 0 || expires.After(time.Now())
+}
+
+/*
+RateLimiter keeps a token bucket for each key it's given.
+Allow takes a token from the bucket for key. If it's empty
+Allow reports how long until a token is available instead.
+*/
+type RateLimiter interface {
+	Allow(key string) (ok bool, retry time.Duration)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+/*
+NewRateLimiter returns a RateLimiter whose buckets hold burst
+tokens and refill at perMinute tokens each minute. Buckets that
+have refilled are forgotten since a new bucket is equivalent.
+*/
+func NewRateLimiter(perMinute, burst int) RateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+func (rl *rateLimiter) Allow(key string) (bool, time.Duration) {
+
+	now := time.Now()
+	full := time.Duration(rl.burst / rl.rate * float64(time.Second))
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.swept) >= full {
+		for k, b := range rl.buckets {
+			if now.Sub(b.last) >= full {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.swept = now
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / rl.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
 }
 
 type IdGenerator interface {
@@ -81,6 +167,7 @@ type Dependencies struct {
 	Logger          Logger
 	Password        Password
 	Emailer         Emailer
+	EmailView       EmailView
 	TryerTx         Tryer
 	TryerEmail      Tryer
 	TryerDisk       Tryer
@@ -92,6 +179,18 @@ type Dependencies struct {
 	TimeMapping     map[string]string
 	Templates       View
 	Accounts        Accounts
+	Moderation      Moderation
+	ModLog          ModLog
+	Reports         Reports
+	Notifications   NotificationService
+	Digests         Digests
+	Outbox          Outbox
+	Sessions        Sessions
+	Identities      Identities
+	Invites         Invites
+	Calendars       Calendars
+	RSVPs           RSVPs
+	Jobs            Jobs
 	Resources       Resources
 	Modals          Modals
 	FieldUpdaters   map[string]FieldUpdateFunc
@@ -0,0 +1,101 @@
+package storydevs
+
+import "fmt"
+
+// How personas respond to events.
+const (
+	RSVPGoing      = "going"
+	RSVPInterested = "interested"
+	RSVPNotGoing   = "not_going"
+)
+
+// RSVPStatuses lists every response in the order they're offered.
+var RSVPStatuses = []string{
+	RSVPGoing,
+	RSVPInterested,
+	RSVPNotGoing,
+}
+
+/*
+RSVP is how a persona responded to an event. Personas going to
+an event that has a capacity are waitlisted in the order they
+said so once it's full. Waitlist is their place on it, or zero
+if they aren't on it.
+*/
+type RSVP struct {
+	Status   string
+	Waitlist int
+}
+
+// Going reports whether r has a place at the event.
+func (r RSVP) Going() bool {
+	return r.Status == RSVPGoing && r.Waitlist == 0
+}
+
+/*
+Text describes r for the persona who responded, e.g., "You're on
+the waitlist at #3." It's the empty string if they haven't.
+*/
+func (r RSVP) Text() string {
+	switch r.Status {
+	case RSVPGoing:
+		if r.Waitlist > 0 {
+			return fmt.Sprintf("You're on the waitlist at #%d.", r.Waitlist)
+		}
+		return "You're going."
+	case RSVPInterested:
+		return "You're interested."
+	case RSVPNotGoing:
+		return "You're not going."
+	}
+	return ""
+}
+
+/*
+Label names r briefly for lists of events, e.g., "Waitlisted #3".
+It's the empty string if the persona hasn't responded.
+*/
+func (r RSVP) Label() string {
+	switch r.Status {
+	case RSVPGoing:
+		if r.Waitlist > 0 {
+			return fmt.Sprintf("Waitlisted #%d", r.Waitlist)
+		}
+		return "Going"
+	case RSVPInterested:
+		return "Interested"
+	case RSVPNotGoing:
+		return "Not Going"
+	}
+	return ""
+}
+
+/*
+Attendee is a persona that responded to an event. Profile is the
+slug of their talent profile, if they have one. Since is when
+they first gave their current response.
+*/
+type Attendee struct {
+	RSVP
+	PersId     int64
+	Handle     string
+	Name       string
+	Profile    string
+	Visibility string
+	Since      int64
+}
+
+/*
+RSVPs records how personas respond to events. Respond fails for
+events that have finished or that persId may not see. Attendance
+fills in who's attending e and how persId responded, which may be
+zero for logged out clients. Attendees lists everyone who responded
+to the event named by slug for its owner, persId, in the order
+they did so.
+*/
+type RSVPs interface {
+	Respond(reqId string, persId int64, slug, status string) (r RSVP, err error)
+	Withdraw(reqId string, persId int64, slug string) (ok bool, err error)
+	Attendance(reqId string, e *Event, persId int64) error
+	Attendees(reqId string, persId int64, slug string) (aa []Attendee, err error)
+}
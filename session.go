@@ -0,0 +1,65 @@
+package storydevs
+
+import "strings"
+
+/*
+Session is a login to an account from some device. Its slug is
+the login's id so that its token is never shown.
+*/
+type Session struct {
+	ResourceBase
+	UserAgent string
+	IP        string
+	LastSeen  int64
+}
+
+func (s Session) GetVisibility() string {
+	return VisibilityPrivate
+}
+func (s Session) GetName() string {
+	return s.Device()
+}
+
+/*
+Device roughly describes the browser and operating system
+named by UserAgent, e.g., "Firefox on Windows".
+*/
+func (s Session) Device() string {
+	ua := s.UserAgent
+	browser := "Unknown browser"
+	for _, b := range [][2]string{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(ua, b[0]) {
+			browser = b[1]
+			break
+		}
+	}
+	for _, os := range [][2]string{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, os[0]) {
+			return browser + " on " + os[1]
+		}
+	}
+	return browser
+}
+
+/*
+Sessions signs accounts out of their logins. It also backs the
+sessions submode of the account mode which lists them.
+*/
+type Sessions interface {
+	Revoke(reqId string, accId int64, slug string) (ok bool, err error)
+	RevokeAll(reqId string, accId int64) (n int64, err error)
+}
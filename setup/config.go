@@ -52,6 +52,10 @@ func mustConfig(path string) *sd.Config {
 	}
 	c.Credentials = cred
 
+	if c.Invite.Gate() < 0 {
+		panic(fmt.Sprintf("setup: unknown Invite.Mode %q", c.Invite.Mode))
+	}
+
 	// Create any directories referenced by the config.
 	rv := reflect.ValueOf(*c)
 	rt := rv.Type()
@@ -25,13 +25,13 @@ func Dependencies(path string) (dep *sd.Dependencies, openHandles io.Closer) {
 
 	pw := password(c)
 	em := mustEmailer(c)
+	ev := mustEmailView(c)
 	tryTx := mustTryer("tx", c, postgres.Retry)
 	tryEm := mustTryer("email", c, sd.RetryDefault)
 	tryDisk := mustTryer("disk", c, sd.RetryDisk)
 
 	db := dbConnect(c)
-	dbTypes(c, db)
-	dbTables(c, db)
+	dbMigrate(c, db, tryTx)
 
 	cache := mustCache(c)
 	h := mustHyphenator(c)
@@ -46,6 +46,7 @@ func Dependencies(path string) (dep *sd.Dependencies, openHandles io.Closer) {
 		Logger:          log,
 		Password:        pw,
 		Emailer:         em,
+		EmailView:       ev,
 		TryerTx:         tryTx,
 		TryerEmail:      tryEm,
 		TryerDisk:       tryDisk,
@@ -61,19 +62,55 @@ func Dependencies(path string) (dep *sd.Dependencies, openHandles io.Closer) {
 
 	as := &service.Account{Dependencies: dep}
 	ms := &modal.Service{Dependencies: dep}
+	mod := service.Moderation{Dependencies: dep}
+	ml := service.ModLog{Dependencies: dep}
+	rep := service.Reports{Dependencies: dep}
+	ns := service.Notifications{Dependencies: dep}
+	dg := service.Digests{Dependencies: dep}
+	ob := service.Outbox{Dependencies: dep}
+	ss := service.Sessions{Dependencies: dep}
+	ids := service.Identities{Dependencies: dep}
+	inv := service.Invites{Dependencies: dep}
+	evs := service.Event{Dependencies: dep}
+	jb := service.Jobs{Dependencies: dep}
 	rs := sd.Resources{
-		"settings": service.Settings{Dependencies: dep},
-		"talent":   service.Talent{Dependencies: dep},
-		"library":  service.Thread{Dependencies: dep, Mode: "library"},
-		"forums":   service.Thread{Dependencies: dep, Mode: "forums"},
-		"event":    service.Event{Dependencies: dep},
+		"settings":      service.Settings{Dependencies: dep},
+		"notifications": service.NotificationList{Notifications: ns},
+		"sessions":      ss,
+		"identities":    ids,
+		"invites":       inv,
+		"upcoming":      service.Upcoming{Event: evs},
+		"privileges":    service.Privileges{Dependencies: dep},
+		"modlog":        ml,
+		"reports":       rep,
+		"outbox":        ob,
+		"talent":        service.Talent{Dependencies: dep},
+		"library":       service.Thread{Dependencies: dep, Mode: "library"},
+		"forums":        service.Thread{Dependencies: dep, Mode: "forums"},
+		"event":         evs,
 	}
 
 	dep.Accounts = as
+	dep.Moderation = mod
+	dep.ModLog = ml
+	dep.Reports = rep
+	dep.Notifications = ns
+	dep.Digests = dg
+	dep.Outbox = ob
+	dep.Sessions = ss
+	dep.Identities = ids
+	dep.Invites = inv
+	dep.Calendars = service.Calendars{Dependencies: dep}
+	dep.RSVPs = service.RSVPs{Dependencies: dep}
+	dep.Jobs = jb
 	dep.Resources = rs
 	dep.Modals = ms
 
 	firstAccount(c, log, db, as)
+	startOutbox(c, log, ob)
+	startDigests(c, log, dg)
+	startOccurrences(c, log, evs)
+	startJobs(c, log, jb)
 
 	return dep, multiCloser{
 		logFile,
@@ -0,0 +1,50 @@
+package setup
+
+import (
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+startDigests emails notifications that are due every interval
+set in the config until the process exits. Unsubscribe links
+can't be signed without a key so one is required.
+*/
+func startDigests(c *sd.Config, log sd.Logger, dg sd.Digests) {
+
+	if c.Digest.Interval <= 0 {
+		return
+	}
+	if c.Credentials.SigningKey == "" {
+		panic("setup: SigningKey must be set in credentials to email notifications, or Digest.Interval set to 0")
+	}
+
+	interval := time.Minute * time.Duration(c.Digest.Interval)
+
+	go func() {
+		for range time.Tick(interval) {
+			sendDigests(log, dg)
+		}
+	}()
+}
+
+/*
+sendDigests only ends its log when something was queued or went
+wrong so that the log isn't filled with empty entries every
+interval.
+*/
+func sendDigests(log sd.Logger, dg sd.Digests) {
+	rId := "DIGEST"
+	n, err := dg.Send(rId)
+	if err != nil {
+		log.Error(rId, err.Error())
+	}
+	if n > 0 {
+		log.Info(rId, "Queued notification emails.").
+			Data(sd.LK_EmailsQueued, n)
+	}
+	if err != nil || n > 0 {
+		log.End(rId, "", rId, "/", 0)
+	}
+}
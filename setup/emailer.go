@@ -1,20 +1,37 @@
 package setup
 
 import (
-	"github.com/jakebowkett/go-emailer/emailer"
+	"fmt"
+
 	sd "github.com/jakebowkett/storydevs"
+	"github.com/jakebowkett/storydevs/internal/email"
 )
 
 func mustEmailer(c *sd.Config) sd.Emailer {
-	em := &emailer.Emailer{
-		Host:     c.Credentials.EmailHost,
-		Port:     c.Credentials.EmailPort,
-		User:     c.Credentials.EmailUser,
-		Pass:     c.Credentials.EmailPass,
-		From:     c.Credentials.EmailFrom,
-		Name:     c.Credentials.EmailName,
-		Timeout:  c.EmailTimeout,
-		Disabled: !c.EmailEnabled,
+	cred := c.Credentials
+	switch c.EmailBackend {
+	case sd.EmailSMTP:
+		return &email.SMTP{
+			Host:    cred.EmailHost,
+			Port:    cred.EmailPort,
+			User:    cred.EmailUser,
+			Pass:    cred.EmailPass,
+			From:    cred.EmailFrom,
+			Name:    cred.EmailName,
+			Timeout: c.EmailTimeout,
+		}
+	case sd.EmailMaildir:
+		return &email.Maildir{
+			Dir:  c.DirMaildir,
+			From: cred.EmailFrom,
+			Name: cred.EmailName,
+		}
+	case sd.EmailMemory:
+		return &email.Memory{}
 	}
-	return em
+	panic(fmt.Sprintf("setup: unknown EmailBackend %q", c.EmailBackend))
+}
+
+func mustEmailView(c *sd.Config) sd.EmailView {
+	return email.MustTemplates(c.DirEmail)
 }
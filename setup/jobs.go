@@ -0,0 +1,62 @@
+package setup
+
+import (
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+startJobs schedules jobs and runs those that are due every
+interval set in the config until the process exits. Jobs that
+became due while the process wasn't running are run on the
+first tick.
+*/
+func startJobs(c *sd.Config, log sd.Logger, jb sd.Jobs) {
+
+	if c.Jobs.Interval <= 0 || c.Jobs.Batch <= 0 {
+		panic("setup: Jobs.Interval and Jobs.Batch must be greater than 0")
+	}
+	if _, ok := c.Retry["email"]; !ok {
+		panic(`setup: config.Retry["email"] doesn't exist`)
+	}
+	for _, m := range c.Reminder.Before {
+		if m <= 0 {
+			panic("setup: Reminder.Before must only contain numbers greater than 0")
+		}
+	}
+
+	interval := time.Second * time.Duration(c.Jobs.Interval)
+
+	go func() {
+		for range time.Tick(interval) {
+			runJobs(log, jb)
+		}
+	}()
+}
+
+/*
+runJobs only ends its log when something was scheduled or
+attempted so that the log isn't filled with empty entries
+every interval.
+*/
+func runJobs(log sd.Logger, jb sd.Jobs) {
+	rId := "JOBS"
+	n, err := jb.Schedule(rId)
+	if err != nil {
+		log.Error(rId, err.Error())
+	}
+	done, failed, runErr := jb.Run(rId)
+	if runErr != nil {
+		log.Error(rId, runErr.Error())
+	}
+	if n > 0 || done > 0 || failed > 0 {
+		log.Info(rId, "Ran jobs.").
+			Data(sd.LK_JobsScheduled, n).
+			Data(sd.LK_JobsDone, done).
+			Data(sd.LK_JobsFailed, failed)
+	}
+	if err != nil || runErr != nil || n > 0 || done > 0 || failed > 0 {
+		log.End(rId, "", rId, "/", 0)
+	}
+}
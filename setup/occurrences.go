@@ -0,0 +1,42 @@
+package setup
+
+import (
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+startOccurrences extends the occurrences of events recurring
+forever once at startup and then every interval set in the config
+until the process exits.
+*/
+func startOccurrences(c *sd.Config, log sd.Logger, oc sd.Occurrences) {
+
+	if c.Event.Horizon <= 0 || c.Event.Interval <= 0 {
+		panic("setup: Event.Horizon and Event.Interval must be greater than 0")
+	}
+
+	interval := time.Hour * time.Duration(c.Event.Interval)
+
+	go func() {
+		extendOccurrences(log, oc)
+		for range time.Tick(interval) {
+			extendOccurrences(log, oc)
+		}
+	}()
+}
+
+func extendOccurrences(log sd.Logger, oc sd.Occurrences) {
+	rId := "OCCURRENCES"
+	defer log.End(rId, "", rId, "/", 0)
+	n, err := oc.Extend(rId)
+	if err != nil {
+		log.Error(rId, err.Error())
+		return
+	}
+	if n > 0 {
+		log.Info(rId, "Extended event occurrences.").
+			Data(sd.LK_EventsExtended, n)
+	}
+}
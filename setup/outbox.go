@@ -0,0 +1,55 @@
+package setup
+
+import (
+	"time"
+
+	sd "github.com/jakebowkett/storydevs"
+)
+
+/*
+startOutbox sends emails from the outbox every interval set in
+the config until the process exits. Emails queued while the
+process wasn't running are sent on the first tick. If emails
+aren't enabled they're left in the outbox.
+*/
+func startOutbox(c *sd.Config, log sd.Logger, ob sd.Outbox) {
+
+	if !c.EmailEnabled {
+		return
+	}
+
+	if c.Outbox.Interval <= 0 || c.Outbox.Batch <= 0 {
+		panic("setup: Outbox.Interval and Outbox.Batch must be greater than 0")
+	}
+	if _, ok := c.Retry["email"]; !ok {
+		panic(`setup: config.Retry["email"] doesn't exist`)
+	}
+
+	interval := time.Second * time.Duration(c.Outbox.Interval)
+
+	go func() {
+		for range time.Tick(interval) {
+			sendOutbox(log, ob)
+		}
+	}()
+}
+
+/*
+sendOutbox only ends its log when something was attempted so
+that the log isn't filled with empty entries every interval.
+*/
+func sendOutbox(log sd.Logger, ob sd.Outbox) {
+	rId := "OUTBOX"
+	sent, failed, err := ob.Send(rId)
+	if err != nil {
+		log.Error(rId, err.Error())
+	}
+	if sent > 0 || failed > 0 {
+		log.Info(rId, "Attempted emails.").
+			Data(sd.LK_EmailsSent, sent).
+			Data(sd.LK_EmailsFailed, failed)
+	}
+	if err != nil || sent > 0 || failed > 0 {
+		log.End(rId, "", rId, "/", 0)
+	}
+}
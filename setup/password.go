@@ -1,45 +1,188 @@
 package setup
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 
 	sd "github.com/jakebowkett/storydevs"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var (
+	argon2Encoding = base64.RawStdEncoding
+	errHashFormat  = errors.New("password hash is not in a known format")
+)
+
 func password(c *sd.Config) sd.Password {
-	return &pw{cost: bcrypt.MinCost}
+	switch c.PasswordHash {
+	case sd.HashBcrypt, sd.HashArgon2id:
+	default:
+		panic(fmt.Sprintf("setup: unknown PasswordHash %q", c.PasswordHash))
+	}
+	if !validArgon2(c.Argon2) {
+		panic(fmt.Sprintf("setup: invalid Argon2 parameters %+v", c.Argon2))
+	}
+	p := &pw{
+		alg:    c.PasswordHash,
+		argon2: c.Argon2,
+	}
+	p.SetCost(c.BcryptCost)
+	return p
 }
 
+/*
+pw hashes new passwords with alg. Hashes are stored in formats
+that carry their algorithm and parameters so that ones made with
+other settings can still be compared, and upgraded once they are.
+Bcrypt hashes are in the usual modular crypt format while Argon2id
+ones are PHC strings:
+
+	$argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+*/
 type pw struct {
+	alg    string
+	argon2 sd.Argon2Config
 	cost   int
 	costMu sync.Mutex
 }
 
 func (p *pw) SetCost(cost int) {
+	if cost < bcrypt.MinCost {
+		cost = bcrypt.MinCost
+	}
+	if cost > bcrypt.MaxCost {
+		cost = bcrypt.MaxCost
+	}
 	p.costMu.Lock()
 	p.cost = cost
 	p.costMu.Unlock()
 }
 
+func (p *pw) bcryptCost() int {
+	p.costMu.Lock()
+	defer p.costMu.Unlock()
+	return p.cost
+}
+
 func (p *pw) Hash(pass string) (hash string, err error) {
-	if p.cost < bcrypt.MinCost {
-		p.SetCost(bcrypt.MinCost)
+	if p.alg == sd.HashArgon2id {
+		return p.hashArgon2id(pass)
 	}
-	bb, err := bcrypt.GenerateFromPassword([]byte(pass), p.cost)
+	bb, err := bcrypt.GenerateFromPassword([]byte(pass), p.bcryptCost())
 	if err != nil {
 		return hash, err
 	}
 	return string(bb), err
 }
 
-func (p *pw) Compare(pass, hash string) (ok bool, err error) {
+func (p *pw) Compare(pass, hash string) (ok, rehash bool, err error) {
+	// Accounts made by logging in with a provider have no password.
+	if hash == "" {
+		return false, false, nil
+	}
+	if strings.HasPrefix(hash, "$"+sd.HashArgon2id+"$") {
+		return p.compareArgon2id(pass, hash)
+	}
 	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
 	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return false, nil
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, err
+	}
+	rehash = p.alg != sd.HashBcrypt || cost != p.bcryptCost()
+	return true, rehash, nil
+}
+
+func validArgon2(a sd.Argon2Config) bool {
+	return a.Time > 0 && a.Memory > 0 && a.Threads > 0 && a.Threads < 256
+}
+
+func (p *pw) hashArgon2id(pass string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	a := p.argon2
+	key := argon2.IDKey(
+		[]byte(pass),
+		salt,
+		uint32(a.Time),
+		uint32(a.Memory),
+		uint8(a.Threads),
+		argon2KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		sd.HashArgon2id,
+		argon2.Version,
+		a.Memory,
+		a.Time,
+		a.Threads,
+		argon2Encoding.EncodeToString(salt),
+		argon2Encoding.EncodeToString(key)), nil
+}
+
+func (p *pw) compareArgon2id(pass, hash string) (ok, rehash bool, err error) {
+
+	// Leading "$" gives an empty first part.
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, errHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, errHashFormat
 	}
+	if version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var a sd.Argon2Config
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Time, &a.Threads)
+	if err != nil {
+		return false, false, errHashFormat
+	}
+	if !validArgon2(a) {
+		return false, false, errHashFormat
+	}
+	salt, err := argon2Encoding.DecodeString(parts[4])
 	if err != nil {
-		return false, err
+		return false, false, errHashFormat
 	}
-	return true, nil
+	want, err := argon2Encoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, errHashFormat
+	}
+
+	key := argon2.IDKey(
+		[]byte(pass),
+		salt,
+		uint32(a.Time),
+		uint32(a.Memory),
+		uint8(a.Threads),
+		uint32(len(want)))
+	if subtle.ConstantTimeCompare(key, want) != 1 {
+		return false, false, nil
+	}
+
+	rehash = p.alg != sd.HashArgon2id ||
+		a != p.argon2 ||
+		len(salt) != argon2SaltLen ||
+		len(want) != argon2KeyLen
+	return true, rehash, nil
 }
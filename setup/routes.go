@@ -10,10 +10,16 @@ import (
 	sd "github.com/jakebowkett/storydevs"
 	"github.com/jakebowkett/storydevs/handler/account"
 	"github.com/jakebowkett/storydevs/handler/api"
+	"github.com/jakebowkett/storydevs/handler/csrf"
 	"github.com/jakebowkett/storydevs/handler/httperr"
+	"github.com/jakebowkett/storydevs/handler/identity"
+	"github.com/jakebowkett/storydevs/handler/limit"
 	"github.com/jakebowkett/storydevs/handler/modal"
 	"github.com/jakebowkett/storydevs/handler/mode"
+	"github.com/jakebowkett/storydevs/handler/mode/populate"
+	"github.com/jakebowkett/storydevs/handler/moderate"
 	"github.com/jakebowkett/storydevs/handler/page"
+	"github.com/jakebowkett/storydevs/handler/security"
 	"github.com/jakebowkett/storydevs/handler/static"
 	"github.com/jakebowkett/storydevs/internal/router"
 )
@@ -31,12 +37,24 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	}
 	rt := router.New(o)
 
+	rt.Use(security.Headers(dep))
 	rt.Use(beforeHandler(dep))
 
 	/* =================================================
 	   | API                                           |
 	   ============================================== */
 	rt.Get("/api/event/:resource", api.Event(dep))
+	rt.Get("/api/event/:resource/ics", api.EventICS(dep))
+	rt.Get("/api/calendar", api.Calendar(dep))
+	rt.Get("/api/calendar/:token", api.PersonaCalendar(dep))
+
+	/*
+		Browsers can't send a CSRF token with their reports so
+		they're handled before that middleware.
+	*/
+	cspReports := rt.Group("", outside("csp-report"), nil)
+	cspReports.Use(limit.Limit(dep, "csp"))
+	cspReports.Pst("/csp-report", security.Report(dep))
 
 	mediaHandler := static.Media(dep)
 	rt.Get("/favicon.ico", mediaHandler)
@@ -51,6 +69,7 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	rt.Get("/user/:file", static.User(dep))
 
 	mm := "talent,forums,event"
+	reportable := strings.Join(sd.ReportModes, ",")
 	mmAcc := "settings"
 
 	// For gzipped site content.
@@ -59,6 +78,17 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	// Middleware that adds user to request object.
 	rt.Use(account.Add(dep))
 
+	// Requests that change state after this point need a CSRF token.
+	rt.Use(csrf.Check(dep))
+
+	/*
+		Rate limits are applied by middleware in groups. Groups
+		that only hold middleware apply it to whichever requests
+		their skip guard lets through.
+	*/
+	partials := rt.Group("", notPartial, nil)
+	partials.Use(limit.Limit(dep, "partials"))
+
 	/* =================================================
 	   | Pages                                         |
 	   ============================================== */
@@ -78,19 +108,23 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	rt.Get(modals, modalFull)
 	rt.Get(modals+"/partial", modalPartial)
 
-	rt.Pst("/register", ms.Register)
-	rt.Pst("/login", ms.Login)
-	rt.Pst("/forgot", ms.ForgotPassword)
-	rt.Pst("/mailing", ms.Mailing)
-	rt.Pst("/reserve", ms.Reserve)
-
 	confirms := "/:kind[register,forgot,reserve,mailing,email,password]"
-	rt.Pst(confirms+"/confirm", ms.ConfirmPartial)
+
+	// Changing email or password posts to the same first segments.
+	forms := rt.Group("", outside("register", "login", "forgot", "mailing", "reserve", "email", "password"), nil)
+	forms.Use(limit.Limit(dep, "forms"))
+	forms.Pst("/register", ms.Register)
+	forms.Pst("/login", ms.Login)
+	forms.Pst("/login/totp", ms.LoginTwoFactor)
+	forms.Pst("/forgot", ms.ForgotPassword)
+	forms.Pst("/mailing", ms.Mailing)
+	forms.Pst("/reserve", ms.Reserve)
+	forms.Pst(confirms+"/confirm", ms.ConfirmPartial)
 
 	// Technically the modals directly under this comment should only be
 	// accessible with an account. However, because of the "/:code" below
 	// we cannot put them any lower.
-	accModals := "/:modal[delete,email,password,persona,delete_account]"
+	accModals := "/:modal[delete,email,password,persona,delete_account,report,totp,totp_disable,invite,calendar]"
 	rt.Get(accModals, modalFull)
 	rt.Get(accModals+"/partial", modalPartial)
 
@@ -100,6 +134,28 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	rt.Get(confirms+"/:code", ms.ConfirmFull)
 	rt.Get("/:kind[reserve_handle]/:code", ms.ConfirmFull) // legacy handle reservations
 
+	// Unsubscribe links at the bottom of notification emails.
+	kinds := strings.Join(append(sd.EmailKinds, sd.UnsubscribeAll), ",")
+	rt.Get("/unsubscribe/:persona/:kind["+kinds+"]/:sig", ms.Unsubscribe)
+
+	/* =================================================
+	   | Identity Providers                            |
+	   ============================================== */
+
+	/*
+		Logging in with a provider begins and ends with a GET
+		so these count against their own limit. Linking needs
+		an account when it begins but not when the provider
+		sends the client back.
+	*/
+	idp := &identity.Service{Dependencies: dep}
+	oidc := rt.Group("", outside("oidc"), nil)
+	oidc.Use(limit.Limit(dep, "oidc"))
+	oidc.Get("/oidc/:provider/login", idp.Login)
+	oidc.Get("/oidc/:provider/callback", idp.Callback)
+	oidcAcc := oidc.Group("", nil, account.HasNone)
+	oidcAcc.Get("/oidc/:provider/link", idp.Link)
+
 	/* =================================================
 	   | Modes                                         |
 	   ============================================== */
@@ -120,6 +176,13 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 
 	acc := rt.Group("", nil, account.HasNone)
 
+	/*
+		Writes after this point count against the limit, including
+		those of moderators and admins. Logged out clients reaching
+		it are limited by IP address before being refused.
+	*/
+	acc.Use(limit.Limit(dep, "writes"))
+
 	acc.Put("/switch/:persona", account.Switch(dep))
 	acc.Del("/persona/:persona", account.DeletePersona(dep))
 
@@ -127,8 +190,32 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	acc.Del("/logout", account.Logout(dep))
 	acc.Pst("/email", ms.Email)
 	acc.Pst("/password", ms.Password)
+	acc.Pst("/totp", ms.TwoFactor)
+	acc.Pst("/totp/confirm", ms.ConfirmTwoFactor)
+	acc.Pst("/totp/disable", ms.DisableTwoFactor)
 	acc.Pst("/persona", ms.Persona)
 	acc.Del("/delete_account", ms.DeleteAccount)
+	acc.Pst("/report/:mode["+reportable+"]/:resource", ms.Report)
+	acc.Pst("/invite", ms.Invite)
+	acc.Pst("/calendar", ms.Calendar)
+	acc.Pst("/calendar/revoke", ms.RevokeCalendar)
+
+	readNotifs := account.ReadNotifications(dep)
+	acc.Put("/notifications/read", readNotifs)
+	acc.Put("/notifications/:resource/read", readNotifs)
+
+	revokeSessions := account.RevokeSessions(dep)
+	acc.Del("/sessions", revokeSessions)
+	acc.Del("/sessions/:resource", revokeSessions)
+	acc.Del("/identities/:resource", idp.Unlink)
+	acc.Del("/invites/:resource", account.RevokeInvite(dep))
+
+	// Responding to an event and listing those who did.
+	rsvp := account.RSVP(dep)
+	statuses := strings.Join(sd.RSVPStatuses, ",")
+	acc.Put("/rsvp/:resource/:status["+statuses+"]", rsvp)
+	acc.Del("/rsvp/:resource", rsvp)
+	acc.Get("/api/event/:resource/attendees", api.EventAttendees(dep))
 
 	modeCreate := mode.Create(dep)
 	modeUpdate := mode.Update(dep)
@@ -143,11 +230,14 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	acc.Get(modes+"/:resource/edit/partial", modePartial)
 	acc.Get(repliers+"/:resource/reply", modeFull)
 	acc.Get(repliers+"/:resource/reply/partial", modePartial)
-	acc.Put(modes, modeCreate)
-	acc.Put(modes+"/:resource", modeUpdate)
-	acc.Del(modes+"/:resource", modeDelete)
 	acc.Get(modes+"/:section[search,editor]/field/:name/partial", mode.Field(dep))
 
+	// Muted personas may not write resources.
+	unmuted := acc.Group("", nil, account.IsMuted)
+	unmuted.Put(modes, modeCreate)
+	unmuted.Put(modes+"/:resource", modeUpdate)
+	unmuted.Del(modes+"/:resource", modeDelete)
+
 	/*
 		Since account settings are not resources that can
 		be created or deleted we place dummy handlers here
@@ -171,23 +261,150 @@ func MustRoutes(dep *sd.Dependencies) *router.Router {
 	acc.Put("/:mode[account]"+submodes+"/:resource", modeUpdate)
 	acc.Del("/:mode[account]"+submodes+"/:resource", modeDelete)
 
+	// Notifications are only created by the services causing them.
+	notifSubs := "/:submode[notifications]"
+	acc.Get("/:mode[account]"+notifSubs, modeFull)
+	acc.Get("/:mode[account]"+notifSubs+"/partial", modePartial)
+	acc.Get("/:mode[account]"+notifSubs+"/:resource", modeFull)
+	acc.Get("/:mode[account]"+notifSubs+"/:resource/partial", modePartial)
+
+	// Sessions are only created by logging in.
+	sessionSubs := "/:submode[sessions]"
+	acc.Get("/:mode[account]"+sessionSubs, modeFull)
+	acc.Get("/:mode[account]"+sessionSubs+"/partial", modePartial)
+	acc.Get("/:mode[account]"+sessionSubs+"/:resource", modeFull)
+	acc.Get("/:mode[account]"+sessionSubs+"/:resource/partial", modePartial)
+
+	// Identities are only linked by returning from their provider.
+	identitySubs := "/:submode[identities]"
+	acc.Get("/:mode[account]"+identitySubs, modeFull)
+	acc.Get("/:mode[account]"+identitySubs+"/partial", modePartial)
+	acc.Get("/:mode[account]"+identitySubs+"/:resource", modeFull)
+	acc.Get("/:mode[account]"+identitySubs+"/:resource/partial", modePartial)
+
+	// Invites are only made through the invite modal.
+	inviteSubs := "/:submode[invites]"
+	acc.Get("/:mode[account]"+inviteSubs, modeFull)
+	acc.Get("/:mode[account]"+inviteSubs+"/partial", modePartial)
+	acc.Get("/:mode[account]"+inviteSubs+"/:resource", modeFull)
+	acc.Get("/:mode[account]"+inviteSubs+"/:resource/partial", modePartial)
+
+	// Upcoming events are only listed by responding to them.
+	upcomingSubs := "/:submode[upcoming]"
+	acc.Get("/:mode[account]"+upcomingSubs, modeFull)
+	acc.Get("/:mode[account]"+upcomingSubs+"/partial", modePartial)
+	acc.Get("/:mode[account]"+upcomingSubs+"/:resource", modeFull)
+	acc.Get("/:mode[account]"+upcomingSubs+"/:resource/partial", modePartial)
+
+	/* ==============================================
+	   | Moderation                                 |
+	   ============================================== */
+
+	/*
+		Each privilege has its own grouping so that personas
+		lacking it are refused. Subgroups must be filled before
+		the next is created as creating one may move the others.
+	*/
+	modPost := moderate.Post(dep)
+	modPersona := moderate.Persona(dep)
+	modPriv := moderate.Privilege(dep)
+	modHide := moderate.Hide(dep)
+	modResolve := moderate.Resolve(dep)
+	reports := "/reports/:mode[" + reportable + "]/:resource"
+
+	mod := rt.Group("/mod", nil, account.HasNone)
+
+	hide := mod.Group("", nil, account.Lacks(sd.PrivHide))
+	hide.Put("/post/:resource/:flag[hidden]", modPost)
+	hide.Del("/post/:resource/:flag[hidden]", modPost)
+	hide.Put("/hidden/:mode[talent,event]/:resource", modHide)
+	hide.Del("/hidden/:mode[talent,event]/:resource", modHide)
+	hide.Pst(reports+"/:resolution[hidden]", modResolve)
+
+	destroy := mod.Group("", nil, account.Lacks(sd.PrivDestroy))
+	destroy.Pst(reports+"/:resolution[destroyed]", modResolve)
+
+	dismiss := mod.Group("", nil, account.Lacks(populate.ReportsAccess...))
+	dismiss.Pst(reports+"/:resolution[dismissed]", modResolve)
+
+	lock := mod.Group("", nil, account.Lacks(sd.PrivLock))
+	lock.Put("/post/:resource/:flag[locked,pinned]", modPost)
+	lock.Del("/post/:resource/:flag[locked,pinned]", modPost)
+
+	mute := mod.Group("", nil, account.Lacks(sd.PrivMute))
+	mute.Put("/persona/:persona/:flag[muted]", modPersona)
+	mute.Del("/persona/:persona/:flag[muted]", modPersona)
+
+	ban := mod.Group("", nil, account.Lacks(sd.PrivBan))
+	ban.Put("/persona/:persona/:flag[banned]", modPersona)
+	ban.Del("/persona/:persona/:flag[banned]", modPersona)
+
+	tags := mod.Group("", nil, account.Lacks(sd.PrivTags))
+	tags.Pst("/tags/:mode[talent,event,forums,library]", moderate.MergeTags(dep))
+
+	give := mod.Group("", nil, account.Lacks(sd.PrivGivePriv))
+	give.Put("/privileges/:persona/:priv", modPriv)
+
+	take := mod.Group("", nil, account.Lacks(sd.PrivTakePriv))
+	take.Del("/privileges/:persona/:priv", modPriv)
+
+	// Email isn't moderated content so only admins may requeue it.
+	mail := mod.Group("", account.NotAdmin, nil)
+	mail.Put("/outbox/:resource", moderate.Requeue(dep))
+
 	/* ==============================================
 	   | Admin                                      |
 	   ============================================== */
 
-	adm := rt.Group("", account.NotAdmin, nil)
-	adminSubs := "/:submode[" + mm + "]"
+	/*
+		Staff without admin rights may enter the admin mode
+		but only reach the submodes their privileges allow.
+		See populate.Admin for the corresponding menu.
+	*/
+	adm := rt.Group("", account.NotStaff, nil)
 	adm.Get("/:mode[admin]", modeFull)
 	adm.Get("/:mode[admin]/partial", modePartial)
-	adm.Get("/:mode[admin]"+adminSubs, modeFull)
-	adm.Get("/:mode[admin]"+adminSubs+"/partial", modePartial)
-	adm.Get("/:mode[admin]"+adminSubs+"/:resource", modeFull)
-	adm.Get("/:mode[admin]"+adminSubs+"/:resource/partial", modePartial)
-	adm.Get("/:mode[admin]"+adminSubs+"/:resource/edit", modeFull)
-	adm.Get("/:mode[admin]"+adminSubs+"/:resource/edit/partial", modePartial)
-	adm.Put("/:mode[admin]"+adminSubs, modeCreate)
-	adm.Put("/:mode[admin]"+adminSubs+"/:resource", modeUpdate)
-	adm.Del("/:mode[admin]"+adminSubs+"/:resource", modeDelete)
+
+	privSubs := "/:submode[privileges]"
+	privs := adm.Group("", nil, account.Lacks(populate.PrivilegesAccess...))
+	privs.Get("/:mode[admin]"+privSubs, modeFull)
+	privs.Get("/:mode[admin]"+privSubs+"/partial", modePartial)
+	privs.Get("/:mode[admin]"+privSubs+"/:resource", modeFull)
+	privs.Get("/:mode[admin]"+privSubs+"/:resource/partial", modePartial)
+
+	repSubs := "/:submode[reports]"
+	reps := adm.Group("", nil, account.Lacks(populate.ReportsAccess...))
+	reps.Get("/:mode[admin]"+repSubs, modeFull)
+	reps.Get("/:mode[admin]"+repSubs+"/partial", modePartial)
+	reps.Get("/:mode[admin]"+repSubs+"/:resource", modeFull)
+	reps.Get("/:mode[admin]"+repSubs+"/:resource/partial", modePartial)
+
+	// The moderator log is read-only so it has no write routes.
+	logSubs := "/:submode[modlog]"
+	logs := adm.Group("", nil, account.Lacks(sd.PrivLogs))
+	logs.Get("/:mode[admin]"+logSubs, modeFull)
+	logs.Get("/:mode[admin]"+logSubs+"/partial", modePartial)
+	logs.Get("/:mode[admin]"+logSubs+"/:resource", modeFull)
+	logs.Get("/:mode[admin]"+logSubs+"/:resource/partial", modePartial)
+
+	admOnly := adm.Group("", account.NotAdmin, nil)
+	adminSubs := "/:submode[" + mm + "]"
+	admOnly.Get("/:mode[admin]"+adminSubs, modeFull)
+	admOnly.Get("/:mode[admin]"+adminSubs+"/partial", modePartial)
+	admOnly.Get("/:mode[admin]"+adminSubs+"/:resource", modeFull)
+	admOnly.Get("/:mode[admin]"+adminSubs+"/:resource/partial", modePartial)
+	admOnly.Get("/:mode[admin]"+adminSubs+"/:resource/edit", modeFull)
+	admOnly.Get("/:mode[admin]"+adminSubs+"/:resource/edit/partial", modePartial)
+	admOnly.Put("/:mode[admin]"+adminSubs, modeCreate)
+	admOnly.Put("/:mode[admin]"+adminSubs+"/:resource", modeUpdate)
+	admOnly.Del("/:mode[admin]"+adminSubs+"/:resource", modeDelete)
+
+	// Stuck email is only requeued, see the moderation routes.
+	mailSubs := "/:submode[outbox]"
+	admOnly.Get("/:mode[admin]"+mailSubs, modeFull)
+	admOnly.Get("/:mode[admin]"+mailSubs+"/partial", modePartial)
+	admOnly.Get("/:mode[admin]"+mailSubs+"/:resource", modeFull)
+	admOnly.Get("/:mode[admin]"+mailSubs+"/:resource/partial", modePartial)
 
 	sess := log.Sess("Router setup.")
 	for _, err := range rt.Errors {
@@ -311,6 +528,29 @@ func beforeHandler(dep *sd.Dependencies) sd.Handler {
 		}
 		mustPopulateCache(c, dep.Cache)
 		dep.Templates.Refresh()
+		if err := dep.EmailView.Refresh(); err != nil {
+			log.Error(r.Id, err.Error())
+		}
+	}
+}
+
+func notPartial(r *sd.Request) bool {
+	return !strings.HasSuffix(r.Request.URL.Path, "/partial")
+}
+
+/*
+outside returns a guard that skips a group for requests whose
+first path segment isn't one of ss.
+*/
+func outside(ss ...string) sd.Guard {
+	return func(r *sd.Request) bool {
+		seg := strings.SplitN(strings.Trim(r.Request.URL.Path, "/"), "/", 2)[0]
+		for _, s := range ss {
+			if s == seg {
+				return false
+			}
+		}
+		return true
 	}
 }
 
@@ -338,6 +578,7 @@ func couldBeStoryDevsPath() sd.TimedSet {
 	ts.Add("reserve")
 	ts.Add("reserve_handle") // legacy
 	ts.Add("mailing")
+	ts.Add("unsubscribe")
 
 	// account modals
 	ts.Add("delete")
@@ -345,6 +586,11 @@ func couldBeStoryDevsPath() sd.TimedSet {
 	ts.Add("email")
 	ts.Add("password")
 	ts.Add("persona")
+	ts.Add("report")
+	ts.Add("totp")
+	ts.Add("totp_disable")
+	ts.Add("invite")
+	ts.Add("calendar")
 
 	// static
 	ts.Add("user")
@@ -359,6 +605,14 @@ func couldBeStoryDevsPath() sd.TimedSet {
 	// actions
 	ts.Add("switch")
 	ts.Add("logout")
+	ts.Add("mod")
+	ts.Add("notifications")
+	ts.Add("sessions")
+	ts.Add("identities")
+	ts.Add("invites")
+	ts.Add("rsvp")
+	ts.Add("oidc")
+	ts.Add("csp-report")
 
 	return ts
 }
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -155,7 +156,7 @@ func templates(c *sd.Config, h sd.Hyphenator) sd.View {
 			if admin {
 				return false
 			}
-			showOp := !p.Deleted.Bool && p.PersVis == sd.VisibilityPublic
+			showOp := !p.Removed() && p.PersVis == sd.VisibilityPublic
 			if !showOp && len(p.Reply) == 0 {
 				return true
 			}
@@ -168,7 +169,7 @@ func templates(c *sd.Config, h sd.Hyphenator) sd.View {
 			if !op && p1.Created == p2.Created {
 				return false
 			}
-			if p1.Deleted.Bool || p1.PersVis != sd.VisibilityPublic {
+			if p1.Removed() || p1.PersVis != sd.VisibilityPublic {
 				return false
 			}
 			return true
@@ -199,6 +200,26 @@ func templates(c *sd.Config, h sd.Hyphenator) sd.View {
 		"lower": func(s string) string {
 			return strings.ToLower(s)
 		},
+		"providers": func() []sd.Value {
+			var vv []sd.Value
+			for name, p := range c.OIDC {
+				text := p.Title
+				if text == "" {
+					text = name
+				}
+				vv = append(vv, sd.Value{Name: name, Text: text})
+			}
+			sort.Slice(vv, func(i, j int) bool {
+				return vv[i].Text < vv[j].Text
+			})
+			return vv
+		},
+		"providerTitle": func(name string) string {
+			if p, ok := c.OIDC[name]; ok && p.Title != "" {
+				return p.Title
+			}
+			return name
+		},
 		"obfuscate": func(v interface{}) template.HTML {
 			var s string
 			switch v.(type) {
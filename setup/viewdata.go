@@ -65,6 +65,14 @@ func mustViewData(c *sd.Config, cache sd.Cache, h sd.Hyphenator) *sd.ViewData {
 		cache.AddString(editor, buf.String())
 	}
 
+	/*
+		The upcoming submode of the account mode lists events
+		so it needs the event editor to name their values.
+	*/
+	upcoming := modeData["upcoming"]
+	upcoming.Editor = modeData["event"].Editor
+	modeData["upcoming"] = upcoming
+
 	vd := &sd.ViewData{}
 	vd.Page = pageData
 	vd.Mode = modeData
@@ -164,6 +172,10 @@ func mustParseModalData(
 		// of the data rather than modifying the originals.
 		d := *data.(*sd.ModalData)
 
+		if name == "register" {
+			d.Field = inviteField(d.Field, c.Invite.Gate())
+		}
+
 		for i := range d.Field {
 
 			if r := d.Field[i].Replace; r != "" {
@@ -204,6 +216,24 @@ func mustParseModalData(
 	return m
 }
 
+/*
+inviteField removes the "invite" field of the register modal when
+invites are off and makes it required when they're required.
+*/
+func inviteField(ff sd.Fields, gate int) sd.Fields {
+	var kept sd.Fields
+	for _, f := range ff {
+		if f.Name == "invite" {
+			if gate == sd.InviteOff {
+				continue
+			}
+			f.Optional = gate != sd.InviteRequired
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
 type modeParser struct {
 	mode           string
 	modeGroup      string
@@ -0,0 +1,39 @@
+DROP TABLE IF EXISTS file;
+
+DROP TABLE IF EXISTS profile_advertised_example;
+DROP TABLE IF EXISTS profile_advertised;
+DROP TABLE IF EXISTS profile_project_role_duty;
+DROP TABLE IF EXISTS profile_project_role_skill;
+DROP TABLE IF EXISTS profile_project_role;
+DROP TABLE IF EXISTS profile_project;
+DROP TABLE IF EXISTS profile_medium;
+DROP TABLE IF EXISTS profile_compensation;
+DROP TABLE IF EXISTS profile_language;
+DROP TABLE IF EXISTS profile_tag;
+DROP TABLE IF EXISTS profile;
+
+DROP TABLE IF EXISTS post_span;
+DROP TABLE IF EXISTS post_tag;
+DROP TABLE IF EXISTS post_category;
+DROP TABLE IF EXISTS post_kind;
+DROP TABLE IF EXISTS post;
+
+DROP TABLE IF EXISTS event_span;
+DROP TABLE IF EXISTS event_tag;
+DROP TABLE IF EXISTS event_category;
+DROP TABLE IF EXISTS event_setting;
+DROP TABLE IF EXISTS event;
+
+DROP TABLE IF EXISTS logins;
+DROP TABLE IF EXISTS pronouns;
+DROP TABLE IF EXISTS personas;
+DROP TABLE IF EXISTS change_password;
+DROP TABLE IF EXISTS change_email;
+DROP TABLE IF EXISTS accounts;
+DROP TABLE IF EXISTS reserved;
+DROP TABLE IF EXISTS mailing;
+
+DROP TYPE IF EXISTS duration;
+DROP TYPE IF EXISTS paragraph;
+DROP TYPE IF EXISTS postkind;
+DROP TYPE IF EXISTS visibility;
@@ -1,3 +1,50 @@
+/*
+    Initial schema. This was previously applied at start-up
+    from types.sql and tables.sql so every statement here is
+    written to be a no-op against a database that was set up
+    that way. Running it there simply records the version.
+*/
+
+DO $$ BEGIN
+    CREATE TYPE visibility AS ENUM (
+        'public',
+        'unlisted',
+        'private'
+    );
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
+
+DO $$ BEGIN
+    CREATE TYPE postkind AS ENUM (
+        'library',
+        'forums'
+    );
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
+
+DO $$ BEGIN
+    CREATE TYPE paragraph AS ENUM (
+        'p',
+        'ol',
+        'ul',
+        'blockquote',
+        'h2'
+    );
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
+
+DO $$ BEGIN
+    CREATE TYPE duration AS ENUM (
+        'days',
+        'week',
+        'month',
+        'months',
+        'year',
+        'years'
+    );
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
+
 
 CREATE TABLE IF NOT EXISTS mailing (
     id        bigserial PRIMARY KEY,
@@ -278,3 +325,4 @@ CREATE TABLE IF NOT EXISTS file (
     persona  bigint  REFERENCES personas(id) ON DELETE CASCADE,
     file     text    NOT NULL
 );
+
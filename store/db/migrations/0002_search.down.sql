@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS profile_search_idx;
+DROP INDEX IF EXISTS event_search_idx;
+DROP INDEX IF EXISTS post_search_idx;
+
+ALTER TABLE profile DROP COLUMN IF EXISTS search;
+ALTER TABLE event   DROP COLUMN IF EXISTS search;
+ALTER TABLE post    DROP COLUMN IF EXISTS search;
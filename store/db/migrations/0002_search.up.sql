@@ -0,0 +1,51 @@
+/*
+    Full-text search. Each searchable resource gets a tsvector
+    built from its name, summary and body. The service layer
+    rebuilds it whenever a resource is written (see
+    updateSearch in postgres/service/search.go) so the
+    expressions below must be kept in step with it.
+*/
+
+ALTER TABLE post    ADD COLUMN search tsvector;
+ALTER TABLE event   ADD COLUMN search tsvector;
+ALTER TABLE profile ADD COLUMN search tsvector;
+
+UPDATE post SET search =
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(summary, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce((
+        SELECT
+            string_agg(text, ' ' ORDER BY p, span)
+        FROM
+            post_span
+        WHERE
+            ref_id = post.id
+    ), '')), 'C');
+
+UPDATE event SET search =
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(summary, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce((
+        SELECT
+            string_agg(text, ' ' ORDER BY p, span)
+        FROM
+            event_span
+        WHERE
+            ref_id = event.id
+    ), '')), 'C');
+
+UPDATE profile SET search =
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(summary, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce((
+        SELECT
+            string_agg(tag, ' ')
+        FROM
+            profile_tag
+        WHERE
+            ref_id = profile.id
+    ), '')), 'C');
+
+CREATE INDEX post_search_idx    ON post    USING GIN (search);
+CREATE INDEX event_search_idx   ON event   USING GIN (search);
+CREATE INDEX profile_search_idx ON profile USING GIN (search);
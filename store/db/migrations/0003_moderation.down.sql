@@ -0,0 +1,6 @@
+ALTER TABLE post DROP COLUMN IF EXISTS hidden;
+
+ALTER TABLE personas DROP COLUMN IF EXISTS banned;
+ALTER TABLE personas DROP COLUMN IF EXISTS muted;
+
+DROP TABLE IF EXISTS privileges;
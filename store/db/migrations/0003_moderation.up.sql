@@ -0,0 +1,30 @@
+/*
+    Moderation. Each persona may hold any of the privileges
+    listed in sd.Privileges. Admins implicitly hold them all
+    so they never need a row here. Muted personas may not
+    write and banned personas are treated as logged out.
+    Hidden posts remain visible to staff only.
+*/
+
+CREATE TABLE IF NOT EXISTS privileges (
+    
+    ref_id  bigint  PRIMARY KEY REFERENCES personas(id) ON DELETE CASCADE,
+    
+    approve     boolean,
+    lock        boolean,
+    edit        boolean,
+    hide        boolean,
+    destroy     boolean,
+    mute        boolean,
+    ban         boolean,
+    tags        boolean,
+    categories  boolean,
+    logs        boolean,
+    give_priv   boolean,
+    take_priv   boolean
+);
+
+ALTER TABLE personas ADD COLUMN muted  boolean;
+ALTER TABLE personas ADD COLUMN banned boolean;
+
+ALTER TABLE post ADD COLUMN hidden boolean;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS mod_log;
+DROP FUNCTION IF EXISTS mod_log_append_only();
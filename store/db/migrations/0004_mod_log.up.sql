@@ -0,0 +1,44 @@
+/*
+    Moderator action log. Rows are only ever inserted. The
+    trigger below refuses updates and deletes so the log can't
+    be altered through the application even by mistake. The
+    actor and target are recorded by value rather than by
+    reference so that entries outlive what they describe.
+*/
+
+CREATE TABLE IF NOT EXISTS mod_log (
+    
+    id       bigserial  PRIMARY KEY,
+    created  bigint     NOT NULL,
+    
+    actor_id      bigint  NOT NULL,
+    actor_handle  text    NOT NULL,
+    
+    action       text  NOT NULL,
+    target_kind  text  NOT NULL,
+    target_slug  text  NOT NULL,
+    
+    -- JSON snapshots of the target either side of the action.
+    before  jsonb,
+    after   jsonb,
+    
+    reason  text
+);
+
+CREATE INDEX mod_log_created_idx ON mod_log (created DESC, id DESC);
+CREATE INDEX mod_log_target_idx  ON mod_log (target_kind, target_slug);
+CREATE INDEX mod_log_actor_idx   ON mod_log (actor_id);
+
+CREATE OR REPLACE FUNCTION mod_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'mod_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER mod_log_append_only
+    BEFORE UPDATE OR DELETE ON mod_log
+    FOR EACH ROW EXECUTE PROCEDURE mod_log_append_only();
+
+CREATE TRIGGER mod_log_no_truncate
+    BEFORE TRUNCATE ON mod_log
+    FOR EACH STATEMENT EXECUTE PROCEDURE mod_log_append_only();
@@ -0,0 +1,4 @@
+ALTER TABLE profile DROP COLUMN IF EXISTS hidden;
+ALTER TABLE event   DROP COLUMN IF EXISTS hidden;
+
+DROP TABLE IF EXISTS reports;
@@ -0,0 +1,35 @@
+/*
+    Reports filed by users against resources. Targets are
+    recorded by mode and slug since they may be posts, events
+    or profiles. Open reports have no resolution. A persona
+    may only have one open report per target.
+*/
+
+CREATE TABLE IF NOT EXISTS reports (
+    
+    id        bigserial  PRIMARY KEY,
+    created   bigint     NOT NULL,
+    
+    reporter  bigint  NOT NULL REFERENCES personas(id) ON DELETE CASCADE,
+    
+    target_kind  text  NOT NULL,
+    target_slug  text  NOT NULL,
+    
+    category  text  NOT NULL,
+    detail    text,
+    
+    resolved    bigint,
+    resolution  text,
+    resolver    bigint  REFERENCES personas(id) ON DELETE SET NULL
+);
+
+CREATE INDEX reports_open_idx ON reports (target_kind, target_slug) WHERE resolved IS NULL;
+CREATE INDEX reports_reporter_idx ON reports (reporter, created);
+CREATE UNIQUE INDEX reports_once_idx ON reports (reporter, target_kind, target_slug) WHERE resolved IS NULL;
+
+/*
+    Events and profiles may be hidden by moderators in the
+    same way as posts.
+*/
+ALTER TABLE event   ADD COLUMN hidden boolean;
+ALTER TABLE profile ADD COLUMN hidden boolean;
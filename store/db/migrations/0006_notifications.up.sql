@@ -0,0 +1,30 @@
+/*
+    Notifications for personas about replies, mentions and the
+    like. The target is recorded by value in the same way as the
+    moderator log so notifications outlive what they describe.
+    Unread notifications have no read time.
+*/
+
+CREATE TABLE IF NOT EXISTS notifications (
+    
+    id       bigserial  PRIMARY KEY,
+    created  bigint     NOT NULL,
+    
+    recipient  bigint  NOT NULL REFERENCES personas(id) ON DELETE CASCADE,
+    
+    kind  text  NOT NULL,
+    
+    actor_id      bigint  REFERENCES personas(id) ON DELETE SET NULL,
+    actor_handle  text,
+    
+    target_kind  text  NOT NULL,
+    target_slug  text  NOT NULL,
+    target_name  text,
+    target_post  text,
+    
+    detail  text,
+    read    bigint
+);
+
+CREATE INDEX notifications_recipient_idx ON notifications (recipient, created DESC, id DESC);
+CREATE INDEX notifications_unread_idx ON notifications (recipient) WHERE read IS NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS notifications_unemailed_idx;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS emailed;
+
+ALTER TABLE personas DROP COLUMN IF EXISTS email_event;
+ALTER TABLE personas DROP COLUMN IF EXISTS email_mention;
+ALTER TABLE personas DROP COLUMN IF EXISTS email_reply;
@@ -0,0 +1,17 @@
+/*
+    Email delivery of notifications. Each persona chooses how
+    each kind of notification is emailed to them, see the
+    Deliver* constants. A notification's emailed time is set
+    once it has been sent or once it's known it never will be.
+    Notifications that predate this are treated as emailed.
+*/
+
+ALTER TABLE personas ADD COLUMN email_reply   text  NOT NULL DEFAULT 'off';
+ALTER TABLE personas ADD COLUMN email_mention text  NOT NULL DEFAULT 'off';
+ALTER TABLE personas ADD COLUMN email_event   text  NOT NULL DEFAULT 'off';
+
+ALTER TABLE notifications ADD COLUMN emailed bigint;
+
+UPDATE notifications SET emailed = created;
+
+CREATE INDEX notifications_unemailed_idx ON notifications (recipient) WHERE emailed IS NULL;
@@ -0,0 +1,25 @@
+/*
+    Emails waiting to be sent. Services add them within the
+    same transaction as whatever they concern so they're only
+    sent if it commits. Sent emails are removed. Emails that
+    fail too many times are kept as dead for admins to review.
+    An email being sent has its next attempt pushed back so
+    other workers leave it alone.
+*/
+
+CREATE TABLE IF NOT EXISTS email_outbox (
+    
+    id       bigserial  PRIMARY KEY,
+    created  bigint     NOT NULL,
+    
+    recipient  text  NOT NULL,
+    subject    text  NOT NULL,
+    body       text  NOT NULL,
+    
+    status        text    NOT NULL DEFAULT 'pending',
+    attempts      int     NOT NULL DEFAULT 0,
+    next_attempt  bigint  NOT NULL,
+    last_error    text
+);
+
+CREATE INDEX email_outbox_due_idx ON email_outbox (next_attempt) WHERE status = 'pending';
@@ -0,0 +1,7 @@
+/*
+    The HTML body of emails in the outbox. Their existing body
+    is the plaintext one. Emails without an HTML body are sent
+    as plaintext only.
+*/
+
+ALTER TABLE email_outbox ADD COLUMN html text NOT NULL DEFAULT '';
@@ -0,0 +1,18 @@
+/*
+    Recent failed log in attempts. Each key is an account as
+    "acc:<id>", an identity that doesn't match an account as
+    "id:<identity>", or an IP address as "ip:<address>". Rows
+    whose failures have been forgotten are removed as new
+    failures are recorded and accounts are removed when they
+    next log in successfully.
+*/
+
+CREATE TABLE IF NOT EXISTS login_failures (
+    
+    key           text    PRIMARY KEY,
+    failures      int     NOT NULL,
+    last_failure  bigint  NOT NULL,
+    locked_until  bigint  NOT NULL DEFAULT 0
+);
+
+CREATE INDEX login_failures_last_idx ON login_failures (last_failure);
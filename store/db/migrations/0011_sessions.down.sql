@@ -0,0 +1,9 @@
+ALTER TABLE change_password DROP COLUMN IF EXISTS login_id;
+
+DROP INDEX IF EXISTS logins_acc_idx;
+DROP INDEX IF EXISTS logins_token_idx;
+
+ALTER TABLE logins DROP COLUMN IF EXISTS ip;
+ALTER TABLE logins DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE logins DROP COLUMN IF EXISTS last_seen;
+ALTER TABLE logins DROP COLUMN IF EXISTS id;
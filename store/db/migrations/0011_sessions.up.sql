@@ -0,0 +1,20 @@
+/*
+    Sessions. Each login is identified by its id wherever it's
+    shown so that its token never leaves the cookie. Existing
+    logins are treated as last seen when they began and their
+    user agent and IP address are unknown. A password change
+    remembers the login it was requested from so that it's the
+    only one kept once the change is confirmed.
+*/
+
+ALTER TABLE logins ADD COLUMN id          bigserial  PRIMARY KEY;
+ALTER TABLE logins ADD COLUMN last_seen   bigint     NOT NULL DEFAULT 0;
+ALTER TABLE logins ADD COLUMN user_agent  text       NOT NULL DEFAULT '';
+ALTER TABLE logins ADD COLUMN ip          text       NOT NULL DEFAULT '';
+
+UPDATE logins SET last_seen = since;
+
+CREATE INDEX logins_token_idx ON logins (token);
+CREATE INDEX logins_acc_idx   ON logins (acc_id);
+
+ALTER TABLE change_password ADD COLUMN login_id bigint;
@@ -0,0 +1,9 @@
+/*
+    Hashes can't be reversed so logins and outstanding changes
+    are discarded instead. Unconfirmed accounts, subscriptions
+    and reservations can no longer be confirmed by their codes.
+*/
+
+DELETE FROM logins;
+DELETE FROM change_email;
+DELETE FROM change_password;
@@ -0,0 +1,14 @@
+/*
+    Tokens and confirmation codes are stored as the hex encoded
+    SHA-256 hash of the value given to the user, see HashToken
+    in accounts.go. Existing values are hashed in place so that
+    everyone stays logged in and outstanding confirmation emails
+    keep working.
+*/
+
+UPDATE logins          SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+UPDATE accounts        SET code  = encode(sha256(convert_to(code,  'UTF8')), 'hex') WHERE code IS NOT NULL;
+UPDATE change_email    SET code  = encode(sha256(convert_to(code,  'UTF8')), 'hex');
+UPDATE change_password SET code  = encode(sha256(convert_to(code,  'UTF8')), 'hex');
+UPDATE mailing         SET code  = encode(sha256(convert_to(code,  'UTF8')), 'hex') WHERE code IS NOT NULL;
+UPDATE reserved        SET code  = encode(sha256(convert_to(code,  'UTF8')), 'hex') WHERE code IS NOT NULL;
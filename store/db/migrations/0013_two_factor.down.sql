@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS login_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+
+ALTER TABLE accounts DROP COLUMN IF EXISTS totp_step;
+ALTER TABLE accounts DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE accounts DROP COLUMN IF EXISTS totp_secret;
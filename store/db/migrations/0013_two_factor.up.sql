@@ -0,0 +1,24 @@
+/*
+    TOTP two-factor authentication. An account's secret is kept
+    while it's enrolling but totp_enabled is only set once a code
+    has been confirmed. totp_step is the time step of the last
+    code used so that a code can't be used twice. Recovery codes
+    and login challenges are stored hashed like tokens.
+*/
+
+ALTER TABLE accounts ADD COLUMN totp_secret   text;
+ALTER TABLE accounts ADD COLUMN totp_enabled  bigint;
+ALTER TABLE accounts ADD COLUMN totp_step     bigint  NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    acc_id  bigint  REFERENCES accounts(id) ON DELETE CASCADE,
+    code    text    NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_acc_idx ON recovery_codes (acc_id);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+    token    text    PRIMARY KEY,
+    acc_id   bigint  REFERENCES accounts(id) ON DELETE CASCADE,
+    expires  bigint  NOT NULL
+);
@@ -0,0 +1,31 @@
+/*
+    Identities at OpenID Connect providers linked to accounts. An
+    account may link one identity per provider and an identity
+    may only be linked to one account. Accounts created by logging
+    in with an identity have no password until one is set through
+    the forgot password form, so their pass is empty.
+
+    Logins with a provider that haven't returned from it yet are
+    kept in oidc_flows by their hashed state. acc_id is set when
+    the login is to link the identity rather than to log in.
+*/
+
+CREATE TABLE IF NOT EXISTS identities (
+    id        bigserial  PRIMARY KEY,
+    acc_id    bigint     NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    provider  text       NOT NULL,
+    subject   text       NOT NULL,
+    email     text,
+    linked    bigint     NOT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (acc_id, provider)
+);
+
+CREATE TABLE IF NOT EXISTS oidc_flows (
+    state     text    PRIMARY KEY,
+    provider  text    NOT NULL,
+    verifier  text    NOT NULL,
+    nonce     text    NOT NULL,
+    acc_id    bigint  REFERENCES accounts(id) ON DELETE CASCADE,
+    expires   bigint  NOT NULL
+);
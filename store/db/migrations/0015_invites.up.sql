@@ -0,0 +1,31 @@
+/*
+    Invite codes made by personas for others to register with.
+    Each may be redeemed max_uses times until it expires or is
+    revoked. uses counts its redemptions so that it can be checked
+    and incremented in one statement when registering.
+
+    invite_redemptions records which accounts registered with
+    which invite.
+*/
+
+CREATE TABLE IF NOT EXISTS invites (
+    id        bigserial  PRIMARY KEY,
+    p_id      bigint     NOT NULL REFERENCES personas(id) ON DELETE CASCADE,
+    code      text       NOT NULL UNIQUE,
+    note      text,
+    max_uses  int        NOT NULL,
+    uses      int        NOT NULL DEFAULT 0,
+    expires   bigint,
+    revoked   bigint,
+    created   bigint     NOT NULL,
+    updated   bigint     NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS invites_p_id_idx ON invites (p_id);
+
+CREATE TABLE IF NOT EXISTS invite_redemptions (
+    invite_id  bigint  NOT NULL REFERENCES invites(id) ON DELETE CASCADE,
+    acc_id     bigint  NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    redeemed   bigint  NOT NULL,
+    PRIMARY KEY (invite_id, acc_id)
+);
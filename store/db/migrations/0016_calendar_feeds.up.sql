@@ -0,0 +1,11 @@
+/*
+    Secret tokens for each persona's private calendar feed. Only
+    the hash of a token is kept, as with session tokens, and
+    making a new one replaces the old so that it stops working.
+*/
+
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+    p_id     bigint  PRIMARY KEY REFERENCES personas(id) ON DELETE CASCADE,
+    token    text    NOT NULL UNIQUE,
+    created  bigint  NOT NULL
+);
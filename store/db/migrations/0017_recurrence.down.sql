@@ -0,0 +1,16 @@
+/*
+    Only events that recurred every week can be kept as such.
+*/
+
+DROP TABLE IF EXISTS event_occurrence;
+DROP TABLE IF EXISTS event_skip;
+
+ALTER TABLE event ADD COLUMN IF NOT EXISTS weekly bool;
+UPDATE event SET weekly = true WHERE recur = 'weekly' AND COALESCE(every, 1) = 1;
+
+ALTER TABLE event DROP COLUMN IF EXISTS occurs_until;
+ALTER TABLE event DROP COLUMN IF EXISTS times;
+ALTER TABLE event DROP COLUMN IF EXISTS until;
+ALTER TABLE event DROP COLUMN IF EXISTS monthby;
+ALTER TABLE event DROP COLUMN IF EXISTS every;
+ALTER TABLE event DROP COLUMN IF EXISTS recur;
@@ -0,0 +1,40 @@
+/*
+    How events recur, replacing weekly. See sd.Event for the
+    meaning of each column. Dates in until and event_skip are
+    midnight UTC of the day in the event's timezone.
+
+    event_occurrence holds the start and finish of each
+    occurrence of an event, in UTC, so that they can be searched.
+    Those of events that recur forever are only worked out up to
+    occurs_until and are extended as time passes. Recurring
+    events are left at zero here so that they're worked out when
+    the server starts, until then only their first occurrence is
+    known.
+*/
+
+ALTER TABLE event ADD COLUMN IF NOT EXISTS recur        text    NOT NULL DEFAULT 'never';
+ALTER TABLE event ADD COLUMN IF NOT EXISTS every        bigint;
+ALTER TABLE event ADD COLUMN IF NOT EXISTS monthby      text;
+ALTER TABLE event ADD COLUMN IF NOT EXISTS until        bigint;
+ALTER TABLE event ADD COLUMN IF NOT EXISTS times        bigint;
+ALTER TABLE event ADD COLUMN IF NOT EXISTS occurs_until bigint  NOT NULL DEFAULT 0;
+
+UPDATE event SET recur = 'weekly' WHERE weekly;
+ALTER TABLE event DROP COLUMN IF EXISTS weekly;
+
+CREATE TABLE IF NOT EXISTS event_skip (
+    ref_id  bigint  REFERENCES event(id) ON DELETE CASCADE,
+    skip    bigint  NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS event_occurrence (
+    ref_id  bigint  NOT NULL REFERENCES event(id) ON DELETE CASCADE,
+    start   bigint  NOT NULL,
+    finish  bigint
+);
+
+CREATE INDEX IF NOT EXISTS event_occurrence_ref_id_idx ON event_occurrence (ref_id);
+CREATE INDEX IF NOT EXISTS event_occurrence_start_idx ON event_occurrence (start);
+
+INSERT INTO event_occurrence (ref_id, start, finish)
+SELECT id, start, finish FROM event;
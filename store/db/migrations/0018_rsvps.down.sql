@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS event_rsvp;
+
+ALTER TABLE event DROP COLUMN IF EXISTS capacity;
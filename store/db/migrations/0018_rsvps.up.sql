@@ -0,0 +1,20 @@
+/*
+    How personas respond to events. Those going to an event with
+    a capacity beyond it are waitlisted in order of since, which
+    is when they gave their current response, so the waitlist
+    is worked out rather than stored.
+*/
+
+ALTER TABLE event ADD COLUMN IF NOT EXISTS capacity bigint;
+
+CREATE TABLE IF NOT EXISTS event_rsvp (
+    e_id     bigint  NOT NULL REFERENCES event(id) ON DELETE CASCADE,
+    p_id     bigint  NOT NULL REFERENCES personas(id) ON DELETE CASCADE,
+    status   text    NOT NULL,
+    since    bigint  NOT NULL,
+    created  bigint  NOT NULL,
+    updated  bigint  NOT NULL,
+    PRIMARY KEY (e_id, p_id)
+);
+
+CREATE INDEX IF NOT EXISTS event_rsvp_p_id_idx ON event_rsvp (p_id);
@@ -0,0 +1,29 @@
+/*
+    Work that's due at a later time. What ref_id and occurs refer
+    to depends on the kind of job; for reminders they're the event
+    and the start of the occurrence. Jobs are unique by what they
+    concern and when they're due so scheduling one twice has no
+    effect, which is why finished jobs are kept as done until
+    occurs has passed. A job being run has its next attempt
+    pushed back so other workers leave it alone.
+*/
+
+CREATE TABLE IF NOT EXISTS job (
+    
+    id       bigserial  PRIMARY KEY,
+    created  bigint     NOT NULL,
+    
+    kind    text    NOT NULL,
+    ref_id  bigint  NOT NULL,
+    occurs  bigint  NOT NULL,
+    due     bigint  NOT NULL,
+    
+    status        text    NOT NULL DEFAULT 'pending',
+    attempts      int     NOT NULL DEFAULT 0,
+    next_attempt  bigint  NOT NULL,
+    last_error    text,
+    
+    UNIQUE (kind, ref_id, occurs, due)
+);
+
+CREATE INDEX IF NOT EXISTS job_due_idx ON job (next_attempt) WHERE status = 'pending';
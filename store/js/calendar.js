@@ -0,0 +1,9 @@
+/*
+subscribeCalendar opens the feed of the events matching the
+current search in the client's calendar app.
+*/
+function subscribeCalendar(e) {
+    e.preventDefault();
+    const path = e.currentTarget.getAttribute("href");
+    location.href = `webcal://${location.host}${path}${location.search}`;
+}
@@ -0,0 +1,33 @@
+/*
+unlinkIdentity unlinks the identity whose slug is in the clicked
+element's data-slug attribute and removes it from the list. The
+server refuses to unlink the only way an account can log in.
+*/
+function unlinkIdentity(e) {
+    
+    e.preventDefault();
+    
+    const btn = e.currentTarget;
+    const slug = btn.dataset.slug;
+    
+    del(btn.getAttribute("href"), (err, res) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        if (res && res.feedback) {
+            showNotification(
+                "Unable To Unlink",
+                res.feedback.general.join(" "),
+                "warn"
+            );
+            return;
+        }
+        showNotification(
+            "Unlinked",
+            "You can no longer log in with that identity.",
+            "success"
+        );
+        leaveQueued(slug);
+    });
+}
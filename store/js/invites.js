@@ -0,0 +1,47 @@
+/*
+showInviteModal shows the modal for making an invite.
+*/
+function showInviteModal(e) {
+    e.preventDefault();
+    showModal("invite");
+}
+
+/*
+inviteMade reloads the list of invites so that the one just made
+is in it, if the list is being shown.
+*/
+function inviteMade() {
+    const c = context;
+    if (c.view === "account" && c.subView === "invites") {
+        loadColumn("browse", `/${c.view}/${c.subView}`, c.query);
+    }
+}
+
+/*
+revokeInvite revokes the invite whose slug is in the clicked
+element's data-slug attribute. Revoked invites are still listed
+so it and the list are reloaded to show it's inactive.
+*/
+function revokeInvite(e) {
+    
+    e.preventDefault();
+    
+    const btn = e.currentTarget;
+    const slug = btn.dataset.slug;
+    
+    del(btn.getAttribute("href"), (err) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        showNotification(
+            "Revoked",
+            "The invite can no longer be used to register.",
+            "success"
+        );
+        const c = context;
+        const path = `/${c.view}/${c.subView}`;
+        loadColumn("browse", path, c.query);
+        loadColumn("detail", path + "/" + slug);
+    });
+}
@@ -110,14 +110,14 @@ function popStateHandler(e) {
     };
     let path = "/"+s.view;
     loadColumn("search", path);
-    if (s.query) {
+    if (s.query && !s.subView) {
         delete notSeen.browse;
         loadColumn("browse", path, s.query);
     }
     if (s.subView) {
         delete notSeen.browse;
         path += "/"+s.subView;
-        loadColumn("browse", path);
+        loadColumn("browse", path, s.query);
     }
     if (s.resource) {
         delete notSeen.resource;
@@ -0,0 +1,201 @@
+/*
+modToggle switches a moderation flag or privilege on by sending
+a PUT request to the clicked element's href, or off by sending a
+DELETE request if its data-on attribute is "true". On success the
+element's state is flipped in place. If the resource has a reason
+input its value is sent along for the moderator log.
+*/
+function modToggle(e) {
+    
+    e.preventDefault();
+    
+    const btn = e.currentTarget;
+    if (btn.classList.contains("disabled")) {
+        return;
+    }
+    const on = btn.dataset.on === "true";
+    let path = btn.getAttribute("href");
+    
+    const res = findAncestor(".resource", btn);
+    const input = res ? q("[name=reason]", res) : null;
+    const reason = input ? trim(input.value, " ") : "";
+    if (reason) {
+        path += "?reason=" + encodeURIComponent(reason);
+    }
+    
+    const done = (err) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        btn.dataset.on = on ? "false" : "true";
+        if (on) {
+            btn.classList.remove("selected");
+        } else {
+            btn.classList.add("selected");
+        }
+    };
+    
+    if (on) {
+        del(path, done);
+    } else {
+        put(path, null, done);
+    }
+}
+
+/*
+lookupPersona shows the privileges of the persona whose handle
+was entered, whether or not they already hold any.
+*/
+function lookupPersona(e) {
+    
+    e.preventDefault();
+    
+    const handle = trim(q("input", e.currentTarget).value, " ");
+    if (!handle) {
+        return;
+    }
+    
+    const c = context;
+    const href = `/${c.view}/${c.subView}/${encodeURIComponent(handle)}`;
+    c.resource = handle;
+    c.editing = null;
+    
+    history.pushState({
+        kind:     "mode",
+        view:     c.view,
+        subView:  c.subView,
+        resource: c.resource,
+        layout:   "detail",
+    }, "", href);
+    
+    setLayout("detail", c.view, c.subView);
+    loadColumn("detail", href);
+}
+
+
+/*
+filterModLog reloads the moderator log showing only the entries
+matching the non-empty fields of the submitted form.
+*/
+function filterModLog(e) {
+    
+    e.preventDefault();
+    
+    const terms = [];
+    for (const input of qAll("input", e.currentTarget)) {
+        const v = trim(input.value, " ");
+        if (v) {
+            terms.push(input.name + "=" + encodeURIComponent(v));
+        }
+    }
+    
+    const c = context;
+    const path = `/${c.view}/${c.subView}`;
+    const query = terms.length ? "?" + terms.join("&") : "";
+    c.query = query;
+    c.resource = null;
+    
+    history.pushState({
+        kind:    "mode",
+        view:    c.view,
+        subView: c.subView,
+        query:   query,
+        layout:  "browse",
+    }, "", path+query);
+    
+    setLayout("browse", c.view, c.subView);
+    loadColumn("browse", path, query);
+}
+
+/*
+resolveReport resolves every open report of a resource by sending
+a POST request to the clicked element's href. Whether the resource
+is dismissed, hidden or destroyed is decided by the href. On success
+the group of reports is removed from the queue.
+*/
+function resolveReport(e) {
+    
+    e.preventDefault();
+    
+    const btn = e.currentTarget;
+    let path = btn.getAttribute("href");
+    const slug = btn.dataset.slug;
+    
+    const res = findAncestor(".resource", btn);
+    const input = res ? q("[name=reason]", res) : null;
+    const reason = input ? trim(input.value, " ") : "";
+    if (reason) {
+        path += "?reason=" + encodeURIComponent(reason);
+    }
+    
+    post(path, null, (err) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        showNotification(
+            "Resolved",
+            "The reports have been " + btn.dataset.resolution + ".",
+            "success"
+        );
+        leaveQueued(slug);
+    });
+}
+
+/*
+requeueEmail makes a stuck email due again by sending a PUT request
+to the clicked element's href. On success it's removed from the list
+since requeued emails haven't failed yet.
+*/
+function requeueEmail(e) {
+    
+    e.preventDefault();
+    
+    const btn = e.currentTarget;
+    let path = btn.getAttribute("href");
+    const slug = btn.dataset.slug;
+    
+    const res = findAncestor(".resource", btn);
+    const input = res ? q("[name=reason]", res) : null;
+    const reason = input ? trim(input.value, " ") : "";
+    if (reason) {
+        path += "?reason=" + encodeURIComponent(reason);
+    }
+    
+    put(path, null, (err) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        showNotification(
+            "Requeued",
+            "The email will be sent again shortly.",
+            "success"
+        );
+        leaveQueued(slug);
+    });
+}
+
+/*
+leaveQueued returns to the browse column of the current submode and
+removes the result with the given slug, used once an item has been
+dealt with and no longer belongs in the list.
+*/
+function leaveQueued(slug) {
+    const c = context;
+    setLayout("browse", c.view, c.subView);
+    history.pushState({
+        kind:    "mode",
+        view:    c.view,
+        subView: c.subView,
+        query:   c.query,
+        layout:  "browse",
+    }, "", `/${c.view}/${c.subView}`);
+    c.resource = null;
+    setEmpty(c.subView, ["detail"]);
+    const result = q(`#browse a[data-slug="${slug}"]`);
+    if (result) {
+        removeNode(result);
+    }
+}
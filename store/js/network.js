@@ -23,6 +23,11 @@ function request(method, path, data, callback) {
     
     const xhr = new XMLHttpRequest();
     xhr.open(method, path, true);
+    
+    const csrf = q("meta[name=csrf]");
+    if (method !== "GET") {
+        xhr.setRequestHeader("X-CSRF-Token", csrf.content);
+    }
 
     xhr.onreadystatechange = function() {
 
@@ -30,6 +35,15 @@ function request(method, path, data, callback) {
             return;
         }
         
+        /*
+            The token changes when logging in or out so we
+            keep whichever one the server last gave us.
+        */
+        const token = xhr.getResponseHeader("X-CSRF-Token");
+        if (token) {
+            csrf.content = token;
+        }
+        
         if (xhr.status === 0) {
             
             const options = {method: "HEAD", mode: "no-cors"};
@@ -0,0 +1,40 @@
+/*
+readNotifications marks every notification of the active persona
+as read and updates the notifications in the browse column and
+the unread count in the sidebar to match.
+*/
+function readNotifications(e) {
+    
+    e.preventDefault();
+    
+    put(e.currentTarget.getAttribute("href"), null, (err) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        for (const elem of qAll("#browse .results.notifications .unread")) {
+            elem.classList.remove("unread");
+        }
+        const count = q("#persona_switcher .unread_count");
+        if (count) {
+            removeNode(count);
+        }
+    });
+}
+
+/*
+openNotification marks the notification whose slug is in the
+clicked element's data-slug attribute as read before following
+its href to whatever the notification concerns.
+*/
+function openNotification(e) {
+    
+    e.preventDefault();
+    
+    const href = e.currentTarget.getAttribute("href");
+    const slug = e.currentTarget.dataset.slug;
+    
+    put(`/notifications/${slug}/read`, null, () => {
+        document.location = href;
+    });
+}
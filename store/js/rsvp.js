@@ -0,0 +1,39 @@
+/*
+rsvp responds to the event whose slug is in the clicked element's
+data-slug attribute with the status in its href, or withdraws the
+response if it's already selected. The event is reloaded
+so its attendees reflect the change.
+*/
+function rsvp(e) {
+    
+    e.preventDefault();
+    
+    const btn = e.currentTarget;
+    const slug = btn.dataset.slug;
+    const on = btn.classList.contains("selected");
+    
+    const done = (err, res) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        if (on) {
+            showNotification("Withdrawn", "You're no longer responding to this event.", "success");
+        } else {
+            showNotification("Responded", res, "success");
+        }
+        const c = context;
+        let path = `/${c.view}`;
+        if (c.subView) {
+            path += `/${c.subView}`;
+            loadColumn("browse", path, c.query);
+        }
+        loadColumn("detail", path + "/" + slug);
+    };
+    
+    if (on) {
+        del(`/rsvp/${slug}`, done);
+    } else {
+        put(btn.getAttribute("href"), null, done);
+    }
+}
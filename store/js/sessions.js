@@ -0,0 +1,47 @@
+/*
+revokeSessions signs out every session of the account, including
+this one, so the client is logged out once it succeeds.
+*/
+function revokeSessions(e) {
+    
+    e.preventDefault();
+    
+    del(e.currentTarget.getAttribute("href"), (err) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        clientSideLogout();
+    });
+}
+
+/*
+revokeSession signs out the session whose slug is in the clicked
+element's data-slug attribute. If it's this session the client
+is logged out, otherwise the session is removed from the list.
+*/
+function revokeSession(e) {
+    
+    e.preventDefault();
+    
+    const btn = e.currentTarget;
+    const slug = btn.dataset.slug;
+    const current = btn.dataset.current === "true";
+    
+    del(btn.getAttribute("href"), (err) => {
+        // The request function has already notified the user.
+        if (err) {
+            return;
+        }
+        if (current) {
+            clientSideLogout();
+            return;
+        }
+        showNotification(
+            "Signed Out",
+            "The device has been signed out.",
+            "success"
+        );
+        leaveQueued(slug);
+    });
+}
@@ -31,7 +31,7 @@ function submitModal(e) {
     const form = findAncestor("form", e.target);
     const footer = q(".footer", form);
     const dest = findAncestor(".btn", e.target).dataset.dest;
-    const login = dest === "login";
+    const login = dest === "login" || dest === "login/totp";
     const pf = parseForm(form, false);
     
     let callback = e.target.dataset.callback;
@@ -78,7 +78,8 @@ function submitModal(e) {
             callback(res);
         }
         
-        if (login) {
+        // Accounts using two-factor authentication are asked for a code.
+        if (login && !res.modal) {
             
             const tmp = document.createElement("div");
             const locSect = q("#sidebar .section.location");
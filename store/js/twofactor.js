@@ -0,0 +1,28 @@
+/*
+showTwoFactorModal shows the modal for enabling or disabling
+two-factor authentication depending on which the account
+settings say is the case.
+*/
+function showTwoFactorModal(e) {
+    const text = q(".text", e.currentTarget).textContent.trim();
+    if (text === "Enabled") {
+        showModal("totp_disable");
+    } else {
+        showModal("totp");
+    }
+}
+
+function twoFactorEnabled() {
+    setTwoFactorText("Enabled");
+}
+
+function twoFactorDisabled() {
+    setTwoFactorText("Disabled");
+}
+
+function setTwoFactorText(text) {
+    const elem = q(`.wdgt-btn[name="twofactor"] > .text`);
+    if (elem) {
+        elem.textContent = text;
+    }
+}
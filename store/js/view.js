@@ -340,6 +340,54 @@ function loadColumn(col, path, query, anchor) {
     });
 }
 
+/*
+Fetches the next page of results and appends them to those
+already in the browse column. The response is a whole browse
+column so we only take its results, count, and next link.
+*/
+function loadMore(e) {
+
+    e.preventDefault();
+
+    const more = e.currentTarget;
+    if (more.classList.contains("disabled")) {
+        return;
+    }
+    more.classList.add("disabled");
+
+    const browse = findAncestor(".col_inner", more);
+    const parts = more.getAttribute("href").split("?");
+    const path = parts[0] + "/partial?" + parts[1];
+
+    get(path, function(err, res) {
+
+        if (err) {
+            more.classList.remove("disabled");
+            log(err);
+            return;
+        }
+
+        const page = document.createElement("div");
+        page.innerHTML = res.col;
+
+        const results = q(".results", browse);
+        for (const result of Array.from(q(".results", page).children)) {
+            results.appendChild(result);
+        }
+        q("#result_count", browse).replaceWith(q("#result_count", page));
+
+        const next = q(".more", page);
+        if (next) {
+            more.replaceWith(next);
+        } else {
+            more.remove();
+        }
+
+        init(browse);
+        updateScroll(q("#browse > .scroll"), false, true);
+    });
+}
+
 function search(e) {
     
     e.preventDefault();
@@ -353,12 +401,25 @@ function search(e) {
     const path = "/"+context.view;
     let json = pf.get("json");
     json = JSON.parse(json);
+    
+    /*
+        Full-text queries are free text so they're sent as
+        their own parameter rather than compacted.
+    */
+    const text = json.q ? json.q.trim() : "";
+    delete json.q;
     const s = compactQuery(json);
     
     let query = "?";
     if (s !== "") {
         query += "s=" + s;
     }
+    if (text !== "") {
+        if (query !== "?") {
+            query += "&";
+        }
+        query += "q=" + encodeURIComponent(text);
+    }
     if (query === "?") {
         query += "all=true";
     }
@@ -567,6 +628,23 @@ function deleteResourcePrompt(e, nameOverride) {
     });
 }
 
+/*
+reportPrompt shows the report modal for the resource whose path,
+suffixed with "/report", is the clicked element's href.
+*/
+function reportPrompt(e) {
+    
+    e.preventDefault();
+    
+    const parts = e.currentTarget.getAttribute("href").split("/");
+    const mode = parts[parts.length-3];
+    const slug = parts[parts.length-2];
+    
+    showModal("report", function(s) {
+        return s.replace(/data-dest="report"/, `data-dest="report/${mode}/${slug}"`);
+    });
+}
+
 function deleteResource(e, slug) {
     
     e.preventDefault();
@@ -699,6 +777,14 @@ function showError(name, err) {
     updateScroll(page, true, true);
 }
 
+function singleColumn(e) {
+    setNumOfCols(true);
+}
+
+function dualColumns(e) {
+    setNumOfCols();
+}
+
 function setNumOfCols(single) {
     
     const body = document.body;
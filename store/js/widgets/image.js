@@ -1,6 +1,7 @@
 
-function addImage(input) {
+function addImage(e) {
 
+    const input = e.currentTarget;
     const files = input.files;
     const container = findAncestor(".image", input);
     const preview = q(".preview", container);
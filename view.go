@@ -51,6 +51,12 @@ type Base struct {
 	Account       Account
 
 	PresentThreshold int64
+
+	// Sent in the CSRFHeader of requests that change state.
+	CSRF string
+
+	// Allows inline scripts and styles under the Content-Security-Policy.
+	Nonce string
 }
 type BaseMeta struct {
 	Title    string
@@ -104,6 +110,7 @@ type ModeData struct {
 	Search         Fields
 	Editor         Fields
 	Results        []Resource
+	More           string // URL of the next page of results, if any.
 	Resource       Resource
 }
 
@@ -288,6 +295,14 @@ func (mg Fields) SetWithInt(name string, n int64) error {
 		}
 	case "time":
 		f.Text = strconv.FormatInt(n, 10)
+	case "text":
+		if n != 0 {
+			f.Text = strconv.FormatInt(n, 10)
+		}
+	case "dropdown":
+		if n != 0 {
+			return mg.SetWithString(name, strconv.FormatInt(n, 10))
+		}
 	}
 
 	return nil